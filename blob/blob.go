@@ -0,0 +1,24 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+type Info struct {
+	ContentType  string
+	Size         int64
+	LastModified time.Time
+}
+
+// BlobStore keeps binary objects such as cover images under slash separated
+// keys, e.g. "covers/7/small.jpg".
+type BlobStore interface {
+	Put(ctx context.Context, key string, data io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, Info, error)
+	Delete(ctx context.Context, key string) error
+}
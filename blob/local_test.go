@@ -0,0 +1,38 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalStore(t *testing.T) {
+	store := NewLocalStore(t.TempDir())
+	ctx := context.Background()
+
+	t.Run("round trip blob given valid key", func(t *testing.T) {
+		assert.NoError(t, store.Put(ctx, "covers/7/small.jpg", strings.NewReader("jpeg"), "image/jpeg"))
+
+		reader, info, err := store.Get(ctx, "covers/7/small.jpg")
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+
+		assert.Equal(t, "jpeg", string(data))
+		assert.Equal(t, "image/jpeg", info.ContentType)
+		assert.Equal(t, int64(4), info.Size)
+
+		assert.NoError(t, store.Delete(ctx, "covers/7/small.jpg"))
+		_, _, err = store.Get(ctx, "covers/7/small.jpg")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("reject key given path traversal", func(t *testing.T) {
+		err := store.Put(ctx, "../outside.jpg", strings.NewReader("jpeg"), "image/jpeg")
+
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,380 @@
+package book
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	BatchModeAtomic     = "atomic"
+	BatchModeBestEffort = "best_effort"
+
+	BatchMethodCreate = "create"
+	BatchMethodUpdate = "update"
+	BatchMethodDelete = "delete"
+
+	maxBatchOperations = 500
+	insertBatchSize    = 100
+)
+
+type BatchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+type BatchOperation struct {
+	Method string          `json:"method"`
+	ID     uint            `json:"id,omitempty"`
+	Book   json.RawMessage `json:"book,omitempty"`
+}
+
+type BatchResult struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	ID     uint   `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type batchItem struct {
+	index     int
+	method    string
+	book      Book
+	patch     json.RawMessage
+	restocked bool
+}
+
+var errBookNotFound = errors.New("book not found")
+
+func (handler *handler) Batch(c echo.Context) error {
+	request := BatchRequest{}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&request); err != nil {
+		logger.Error("failed to bind batch request", zap.Error(err))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if request.Mode == "" {
+		request.Mode = BatchModeAtomic
+	}
+	if request.Mode != BatchModeAtomic && request.Mode != BatchModeBestEffort {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Mode must be atomic or best_effort"})
+	}
+	if len(request.Operations) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "At least one operation is required"})
+	}
+	if len(request.Operations) > maxBatchOperations {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "Too many operations"})
+	}
+
+	results, items := handler.prepareBatch(c, request.Operations)
+
+	if request.Mode == BatchModeAtomic {
+		return handler.applyAtomicBatch(c, results, items)
+	}
+
+	handler.applyBestEffortBatch(c, results, items)
+	handler.publishBatch(results, items)
+
+	logger.Info("book batch applied", zap.String("mode", request.Mode), zap.Int("operations", len(results)))
+	return c.JSON(http.StatusOK, results)
+}
+
+// prepareBatch validates every create up front with the same rules as
+// Create. Updates are only checked to be well formed here, as the book they
+// apply to is read and validated when the batch is applied. Operations that
+// fail already carry their final status in results and are not returned as
+// items.
+func (handler *handler) prepareBatch(c echo.Context, operations []BatchOperation) ([]BatchResult, []batchItem) {
+	validator := newValidator()
+	results := make([]BatchResult, len(operations))
+	items := make([]batchItem, 0, len(operations))
+
+	for i, operation := range operations {
+		results[i] = BatchResult{Index: i, ID: operation.ID}
+		book := Book{}
+
+		switch operation.Method {
+		case BatchMethodCreate:
+			if err := json.Unmarshal(operation.Book, &book); err != nil {
+				results[i].Status, results[i].Error = http.StatusBadRequest, "Failed to bind book data"
+				continue
+			}
+			if err := validator.Validate(book); err != nil {
+				results[i].Status, results[i].Error = http.StatusBadRequest, i18n.Message(err, middleware.GetLocales(c))
+				continue
+			}
+		case BatchMethodUpdate:
+			if operation.ID == 0 {
+				results[i].Status, results[i].Error = http.StatusBadRequest, "ID is required"
+				continue
+			}
+			if err := json.Unmarshal(operation.Book, &Book{}); err != nil {
+				results[i].Status, results[i].Error = http.StatusBadRequest, "Failed to bind book data"
+				continue
+			}
+			book.ID = operation.ID
+		case BatchMethodDelete:
+			if operation.ID == 0 {
+				results[i].Status, results[i].Error = http.StatusBadRequest, "ID is required"
+				continue
+			}
+			book.ID = operation.ID
+		default:
+			results[i].Status, results[i].Error = http.StatusBadRequest, "Method must be create, update or delete"
+			continue
+		}
+
+		items = append(items, batchItem{
+			index:  i,
+			method: operation.Method,
+			book:   book,
+			patch:  operation.Book,
+		})
+	}
+
+	return results, items
+}
+
+func (handler *handler) applyAtomicBatch(c echo.Context, results []BatchResult, items []batchItem) error {
+	logger := middleware.GetLogger(c)
+
+	if len(items) != len(results) {
+		abortBatch(results, http.StatusBadRequest, "")
+		return c.JSON(http.StatusBadRequest, results)
+	}
+
+	creates := createItems(items)
+
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := createAtomically(c, tx, results, creates); err != nil {
+			return err
+		}
+		for i := range items {
+			item := &items[i]
+			if item.method == BatchMethodCreate {
+				continue
+			}
+			if err := applyBatchItem(tx, item); err != nil {
+				results[item.index].Status, results[item.index].Error = batchError(c, err)
+				return err
+			}
+			results[item.index].Status = http.StatusOK
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("failed to apply book batch", zap.Error(err))
+		status, message := batchError(c, err)
+		abortBatch(results, status, message)
+		return c.JSON(status, results)
+	}
+
+	handler.publishBatch(results, items)
+
+	logger.Info("book batch applied", zap.String("mode", BatchModeAtomic), zap.Int("operations", len(results)))
+	return c.JSON(http.StatusOK, results)
+}
+
+func (handler *handler) applyBestEffortBatch(c echo.Context, results []BatchResult, items []batchItem) {
+	db := handler.db.WithContext(c.Request().Context())
+	creates := createItems(items)
+
+	for start := 0; start < len(creates); start += insertBatchSize {
+		chunk := creates[start:min(start+insertBatchSize, len(creates))]
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return createInBatches(tx, results, chunk)
+		})
+		if err == nil {
+			continue
+		}
+		// Fall back to one insert per book so a single bad row does not
+		// fail the rest of its chunk.
+		for _, item := range chunk {
+			err := db.Transaction(func(tx *gorm.DB) error {
+				return createBook(tx, &item.book)
+			})
+			if err != nil {
+				results[item.index].Status, results[item.index].Error = batchError(c, err)
+				continue
+			}
+			results[item.index].Status = http.StatusCreated
+			results[item.index].ID = item.book.ID
+		}
+	}
+
+	for i := range items {
+		if items[i].method == BatchMethodCreate {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return applyBatchItem(tx, &items[i])
+		})
+		if err != nil {
+			results[items[i].index].Status, results[items[i].index].Error = batchError(c, err)
+			continue
+		}
+		results[items[i].index].Status = http.StatusOK
+	}
+}
+
+func createItems(items []batchItem) []*batchItem {
+	creates := make([]*batchItem, 0, len(items))
+	for i := range items {
+		if items[i].method == BatchMethodCreate {
+			creates = append(creates, &items[i])
+		}
+	}
+	return creates
+}
+
+func createInBatches(db *gorm.DB, results []BatchResult, items []*batchItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	books := make([]Book, len(items))
+	for i, item := range items {
+		books[i] = item.book
+	}
+
+	if err := db.CreateInBatches(&books, insertBatchSize).Error; err != nil {
+		return err
+	}
+	if err := recordInitialStock(db, books...); err != nil {
+		return err
+	}
+
+	for i, item := range items {
+		item.book = books[i]
+		results[item.index].Status = http.StatusCreated
+		results[item.index].ID = books[i].ID
+	}
+	return nil
+}
+
+// createAtomically inserts the creates of an atomic batch in tx. A failed
+// multi-row insert does not say which book failed, so the books are then
+// inserted one at a time after a savepoint, and the one that fails reports
+// its own error.
+func createAtomically(c echo.Context, tx *gorm.DB, results []BatchResult, creates []*batchItem) error {
+	if len(creates) == 0 {
+		return nil
+	}
+	if err := tx.SavePoint("batch_creates").Error; err != nil {
+		return err
+	}
+	err := createInBatches(tx, results, creates)
+	if err == nil {
+		return nil
+	}
+	if rollbackErr := tx.RollbackTo("batch_creates").Error; rollbackErr != nil {
+		return err
+	}
+	for _, item := range creates {
+		if createErr := createBook(tx, &item.book); createErr != nil {
+			results[item.index].Status, results[item.index].Error = batchError(c, createErr)
+			return createErr
+		}
+	}
+	return err
+}
+
+// createBook inserts a book and records its initial stock in tx.
+func createBook(tx *gorm.DB, book *Book) error {
+	if err := tx.Create(book).Error; err != nil {
+		return err
+	}
+	return recordInitialStock(tx, *book)
+}
+
+// applyBatchItem applies an update or delete in tx. An update locks the book
+// and validates it with the changes applied, so it cannot overwrite a change
+// made since the batch was prepared.
+func applyBatchItem(tx *gorm.DB, item *batchItem) error {
+	switch item.method {
+	case BatchMethodUpdate:
+		book := Book{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&book, item.book.ID).Error; err != nil {
+			return err
+		}
+		stock := book.Stock
+		if err := json.Unmarshal(item.patch, &book); err != nil {
+			return err
+		}
+		if err := newValidator().Validate(book); err != nil {
+			return err
+		}
+		if err := saveBook(tx, &book, stock); err != nil {
+			return err
+		}
+		item.book, item.restocked = book, isRestock(stock, book.Stock)
+	case BatchMethodDelete:
+		result := tx.Delete(&item.book)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errBookNotFound
+		}
+	}
+	return nil
+}
+
+func (handler *handler) publishBatch(results []BatchResult, items []batchItem) {
+	eventTypes := map[string]string{
+		BatchMethodCreate: EventBookCreated,
+		BatchMethodUpdate: EventBookUpdated,
+		BatchMethodDelete: EventBookDeleted,
+	}
+	for _, item := range items {
+		if results[item.index].Error == "" {
+			handler.events.Publish(eventTypes[item.method], item.book)
+			if item.restocked {
+				handler.restocked(item.book)
+			}
+		}
+	}
+}
+
+// abortBatch marks every operation that did not fail on its own as not
+// applied because another operation in the same atomic batch failed. When
+// no operation failed on its own, the batch failed as a whole, and every
+// operation reports status and message.
+func abortBatch(results []BatchResult, status int, message string) {
+	failed := false
+	for _, result := range results {
+		failed = failed || result.Error != ""
+	}
+	for i := range results {
+		switch {
+		case !failed:
+			results[i].Status, results[i].Error = status, message
+		case results[i].Error == "":
+			results[i].Status = http.StatusFailedDependency
+			results[i].Error = "Not applied because another operation failed"
+		}
+	}
+}
+
+func batchError(c echo.Context, err error) (int, string) {
+	var invalid validator.ValidationErrors
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, errBookNotFound):
+		return http.StatusNotFound, "Book not found"
+	case errors.As(err, &invalid):
+		return http.StatusBadRequest, i18n.Message(err, middleware.GetLocales(c))
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return http.StatusConflict, errDuplicateISBN.Error()
+	}
+	return http.StatusInternalServerError, err.Error()
+}
@@ -0,0 +1,339 @@
+package book
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	createTwoBooksQuery = `INSERT INTO "books" ("created_at","updated_at","deleted_at","title","author","isbn","publisher","price","currency","cover_version","stock","category","format","weight_grams","width_mm","height_mm","depth_mm","low_stock_threshold","publication_date","status","subtitle","description","translations","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24),($25,$26,$27,$28,$29,$30,$31,$32,$33,$34,$35,$36,$37,$38,$39,$40,$41,$42,$43,$44,$45,$46,$47,$48) RETURNING "id"`
+	savepointQuery      = `SAVEPOINT batch_creates`
+	rollbackToQuery     = `ROLLBACK TO SAVEPOINT batch_creates`
+	lockBookQuery       = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2 FOR UPDATE`
+)
+
+func TestBatch(t *testing.T) {
+	t.Run("create books in one insert given atomic batch", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"mode": "atomic", "operations": [
+			{"method": "create", "book": {"title": "Atomic Habits", "author": "James Clear", "isbn": "9781847941831"}},
+			{"method": "create", "book": {"title": "Four Thousand Weeks", "author": "Oliver Burkeman", "isbn": "9781785038723"}}
+		]}`
+		request := httptest.NewRequest(http.MethodPost, "/books:batch", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectExec(savepointQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+		rows := sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2)
+		mock.ExpectQuery(createTwoBooksQuery).WillReturnRows(rows)
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		err := handler.Batch(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.JSONEq(t, `[{"index":0,"status":201,"id":1},{"index":1,"status":201,"id":2}]`, response.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("record initial stock of created books given stock", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"mode": "atomic", "operations": [
+			{"method": "create", "book": {"title": "Atomic Habits", "author": "James Clear", "isbn": "9781847941831", "stock": 5}},
+			{"method": "create", "book": {"title": "Four Thousand Weeks", "author": "Oliver Burkeman", "isbn": "9781785038723"}}
+		]}`
+		request := httptest.NewRequest(http.MethodPost, "/books:batch", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectExec(savepointQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(createTwoBooksQuery).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectQuery(createMovementQuery).WithArgs(1, 5, MovementAdjustment, "", sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		err := handler.Batch(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("apply nothing given an invalid operation in atomic batch", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"operations": [
+			{"method": "create", "book": {"title": "Atomic Habits", "author": "James Clear", "isbn": "9781847941831"}},
+			{"method": "create", "book": {"title": "The Alchemist", "author": "Paulo Coelho", "isbn": "007"}}
+		]}`
+		request := httptest.NewRequest(http.MethodPost, "/books:batch", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := handler.Batch(c)
+
+		var results []BatchResult
+		json.Unmarshal(response.Body.Bytes(), &results)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.Equal(t, http.StatusFailedDependency, results[0].Status)
+		assert.Equal(t, http.StatusBadRequest, results[1].Status)
+	})
+
+	t.Run("report the failing create given atomic batch insert error", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"operations": [
+			{"method": "create", "book": {"title": "Atomic Habits", "author": "James Clear", "isbn": "9781847941831"}},
+			{"method": "create", "book": {"title": "Four Thousand Weeks", "author": "Oliver Burkeman", "isbn": "9781785038723"}}
+		]}`
+		request := httptest.NewRequest(http.MethodPost, "/books:batch", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectExec(savepointQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(createTwoBooksQuery).WillReturnError(errors.New("value too long"))
+		mock.ExpectExec(rollbackToQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(createBookQuery).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(createBookQuery).WillReturnError(errors.New("value too long"))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB)
+		err := handler.Batch(c)
+
+		var results []BatchResult
+		json.Unmarshal(response.Body.Bytes(), &results)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+		assert.Equal(t, []int{http.StatusFailedDependency, http.StatusInternalServerError}, []int{results[0].Status, results[1].Status})
+		assert.Equal(t, "value too long", results[1].Error)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("report the error on every operation given atomic batch failing as a whole", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"operations": [{"method": "delete", "id": 3}]}`
+		request := httptest.NewRequest(http.MethodPost, "/books:batch", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteBookQuery).WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit().WillReturnError(errors.New("connection reset"))
+
+		handler := NewHandler(gormDB)
+		err := handler.Batch(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+		assert.JSONEq(t, `[{"index":0,"status":500,"id":3,"error":"connection reset"}]`, response.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("report each failure given best effort batch", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"mode": "best_effort", "operations": [
+			{"method": "create", "book": {"title": "Atomic Habits", "author": "James Clear", "isbn": "9781847941831"}},
+			{"method": "create", "book": {"title": "", "author": "Paulo Coelho", "isbn": "9780062315007"}},
+			{"method": "delete", "id": 3},
+			{"method": "delete", "id": 38}
+		]}`
+		request := httptest.NewRequest(http.MethodPost, "/books:batch", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(createBookQuery).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteBookQuery).WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteBookQuery).WithArgs(sqlmock.AnyArg(), 38).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		err := handler.Batch(c)
+
+		var results []BatchResult
+		json.Unmarshal(response.Body.Bytes(), &results)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, []int{http.StatusCreated, http.StatusBadRequest, http.StatusOK, http.StatusNotFound},
+			[]int{results[0].Status, results[1].Status, results[2].Status, results[3].Status})
+		assert.Equal(t, uint(7), results[0].ID)
+	})
+
+	t.Run("roll back given error during query in atomic batch", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"operations": [{"method": "delete", "id": 3}]}`
+		request := httptest.NewRequest(http.MethodPost, "/books:batch", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteBookQuery).WillReturnError(errors.New("query error"))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB)
+		err := handler.Batch(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("update locked book inside transaction given atomic batch", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"operations": [{"method": "update", "id": 1, "book": {"stock": 5}}]}`
+		request := httptest.NewRequest(http.MethodPost, "/books:batch", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		row := sqlmock.NewRows([]string{"id", "title", "author", "isbn", "stock"}).AddRow(1, "Atomic Habits", "James Clear", "9781847941831", 0)
+		mock.ExpectQuery(lockBookQuery).WithArgs(1, 1).WillReturnRows(row)
+		mock.ExpectExec(updateBookQuery).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMoveStock(mock, 0, 5)
+		mock.ExpectCommit()
+
+		restocked := false
+		handler := NewHandler(gormDB, WithRestockHandler(func(book Book) { restocked = true }))
+		err := handler.Batch(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.JSONEq(t, `[{"index":0,"status":200,"id":1}]`, response.Body.String())
+		assert.True(t, restocked)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return not found given missing book to update in atomic batch", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"operations": [
+			{"method": "delete", "id": 3},
+			{"method": "update", "id": 38, "book": {"stock": 5}}
+		]}`
+		request := httptest.NewRequest(http.MethodPost, "/books:batch", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteBookQuery).WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(lockBookQuery).WithArgs(38, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB)
+		err := handler.Batch(c)
+
+		var results []BatchResult
+		json.Unmarshal(response.Body.Bytes(), &results)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+		assert.Equal(t, []int{http.StatusFailedDependency, http.StatusNotFound}, []int{results[0].Status, results[1].Status})
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given too many operations", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		operations := strings.Repeat(`{"method": "delete", "id": 1},`, maxBatchOperations+1)
+		body := `{"operations": [` + strings.TrimSuffix(operations, ",") + `]}`
+		request := httptest.NewRequest(http.MethodPost, "/books:batch", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := handler.Batch(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+	})
+}
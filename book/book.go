@@ -4,9 +4,15 @@ import (
 	"errors"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/blob"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/metadata"
 	"github.com/phetployst/book-store-api/middleware"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -14,9 +20,73 @@ import (
 
 type Book struct {
 	gorm.Model `json:"-" swaggerignore:"true"`
-	Title      string `json:"title" validate:"required"`
-	Author     string `json:"author" validate:"required"`
-	ISBN       string `json:"isbn" validate:"required,isbn"`
+	Title      string  `json:"title" validate:"required"`
+	Author     string  `json:"author" validate:"required"`
+	ISBN       string  `json:"isbn" validate:"required,isbn" gorm:"uniqueIndex:idx_books_tenant_isbn,where:deleted_at IS NULL"`
+	Publisher  string  `json:"publisher"`
+	Price      float64 `json:"price" validate:"gte=0"`
+	Currency   string  `json:"currency" validate:"omitempty,len=3,uppercase"`
+
+	CoverVersion string            `json:"-"`
+	Covers       map[string]string `json:"covers,omitempty" gorm:"-"`
+
+	// RatingAverage and RatingCount summarise approved reviews. They are
+	// maintained by the review package and never written from a request.
+	RatingAverage float64 `json:"rating_average" gorm:"->;default:0"`
+	RatingCount   int     `json:"rating_count" gorm:"->;default:0"`
+
+	Stock    int    `json:"stock" validate:"gte=0"`
+	Category string `json:"category"`
+
+	// Format decides the tax class of the book. Empty means print.
+	Format string `json:"format" validate:"omitempty,oneof=print ebook audiobook"`
+
+	// WeightGrams and the dimensions in millimetres price shipping.
+	WeightGrams int `json:"weight_grams" validate:"gte=0"`
+	WidthMM     int `json:"width_mm" validate:"gte=0"`
+	HeightMM    int `json:"height_mm" validate:"gte=0"`
+	DepthMM     int `json:"depth_mm" validate:"gte=0"`
+
+	// LowStockThreshold is the stock at or below which the book should be
+	// reordered. Zero leaves reordering to sales alone.
+	LowStockThreshold int `json:"low_stock_threshold" validate:"gte=0"`
+
+	// Status says whether the book can be bought. Empty means available.
+	// Forthcoming books can only be preordered until their publication date.
+	PublicationDate *time.Time `json:"publication_date" validate:"required_if=Status forthcoming"`
+	Status          string     `json:"status" gorm:"not null;default:'';index" validate:"omitempty,oneof=forthcoming available out_of_print"`
+
+	// Subtitle and Description, like Title, are in the catalog's default
+	// locale. Translations holds them in other locales, keyed by language
+	// tag, and responses are localized from them.
+	Subtitle     string                 `json:"subtitle"`
+	Description  string                 `json:"description"`
+	Translations map[string]Translation `json:"translations,omitempty" gorm:"serializer:json" validate:"omitempty,dive,keys,bcp47_language_tag,endkeys"`
+
+	// TenantID is the storefront the book belongs to. It is set by the
+	// tenant plugin from the request.
+	TenantID string `json:"-" swaggerignore:"true" gorm:"not null;default:'default';index;uniqueIndex:idx_books_tenant_isbn,priority:1"`
+}
+
+const (
+	StatusForthcoming = "forthcoming"
+	StatusAvailable   = "available"
+	StatusOutOfPrint  = "out_of_print"
+)
+
+var authorSeparator = regexp.MustCompile(`\s*(?:;|&|\band\b)\s*`)
+
+// Authors splits the Author field into individual names, so "Bill Burnett
+// and Dave Evans" becomes two authors. Commas do not separate authors, as
+// they also separate a surname from the given names in "Tolkien, J.R.R.".
+func (book Book) Authors() []string {
+	authors := []string{}
+	for _, name := range authorSeparator.Split(book.Author, -1) {
+		if name = strings.TrimSpace(name); name != "" {
+			authors = append(authors, name)
+		}
+	}
+	return authors
 }
 
 type CustomValidator struct {
@@ -30,27 +100,48 @@ func (c *CustomValidator) Validate(i interface{}) error {
 	return nil
 }
 
+var isbnPattern = regexp.MustCompile(`^\d{10}(\d{3})?$`)
+
 func validateISBN(fl validator.FieldLevel) bool {
-	isbn := fl.Field().String()
-	re := regexp.MustCompile(`^\d{10}(\d{3})?$`)
-	return re.MatchString(isbn)
+	return isbnPattern.MatchString(fl.Field().String())
+}
+
+func init() {
+	i18n.RegisterValidation("isbn", validateISBN)
+}
+
+// newValidator returns the book validator, which is the shared one with
+// the isbn tag.
+func newValidator() *CustomValidator {
+	return &CustomValidator{validator: i18n.MustValidator()}
 }
 
 type handler struct {
-	db *gorm.DB
+	db       *gorm.DB
+	events   *broker
+	imports  *importStore
+	metadata metadata.MetadataProvider
+	blobs    blob.BlobStore
+
+	onRestock RestockFunc
 }
 
-func NewHandler(db *gorm.DB) *handler {
-	return &handler{db: db}
+type Option func(*handler)
+
+func NewHandler(db *gorm.DB, options ...Option) *handler {
+	handler := &handler{db: db, events: newBroker(replayBufferSize), imports: newImportStore()}
+	for _, option := range options {
+		option(handler)
+	}
+	return handler
 }
 
+var errDuplicateISBN = errors.New("A book with this ISBN already exists")
+
 func (handler *handler) Create(c echo.Context) error {
 	book := Book{}
 
-	validator := validator.New()
-	validator.RegisterValidation("isbn", validateISBN)
-
-	c.Echo().Validator = &CustomValidator{validator: validator}
+	c.Echo().Validator = newValidator()
 	logger := middleware.GetLogger(c)
 
 	if err := c.Bind(&book); err != nil {
@@ -58,28 +149,84 @@ func (handler *handler) Create(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
+	handler.prefillFromProvider(c, &book)
+
 	if err := c.Validate(book); err != nil {
 		logger.Error("failed to validate book", zap.Error(err))
-		return c.JSON(http.StatusBadRequest, err.Error())
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
 	}
 
-	if result := handler.db.Create(&book); result.Error != nil {
-		logger.Error("failed to insert book", zap.Error(result.Error))
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&book).Error; err != nil {
+			return err
+		}
+		return recordInitialStock(tx, book)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": errDuplicateISBN.Error()})
+	}
+	if err != nil {
+		logger.Error("failed to insert book", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
 	logger.Info("book created", zap.Any("book", book))
-	return c.JSON(http.StatusCreated, book)
+	handler.events.Publish(EventBookCreated, book)
+	return c.JSON(http.StatusCreated, localize(c, book)[0])
 
 }
 
+// bookSortColumns maps the sort query parameter to columns. A leading "-"
+// sorts descending, e.g. sort=-rating lists the best rated books first.
+var bookSortColumns = map[string]string{
+	"title":            "title",
+	"price":            "price",
+	"rating":           "rating_average",
+	"created_at":       "created_at",
+	"publication_date": "publication_date",
+}
+
+func sortBooks(sort string) (func(db *gorm.DB) *gorm.DB, error) {
+	direction := "ASC"
+	if strings.HasPrefix(sort, "-") {
+		direction = "DESC"
+		sort = sort[1:]
+	}
+
+	column, ok := bookSortColumns[sort]
+	if !ok {
+		return nil, errors.New("Unsupported sort: " + sort)
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Order(column + " " + direction)
+		if column == "rating_average" {
+			db = db.Order("rating_count " + direction)
+		}
+		return db.Order("id")
+	}, nil
+}
+
 func (handler *handler) GetAll(c echo.Context) error {
 	var books []Book
-	if result := handler.db.Find(&books); result.Error != nil {
+
+	if err := checkFilters(c); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	scopes := []func(*gorm.DB) *gorm.DB{filterBooks(c)}
+	if sort := c.QueryParam("sort"); sort != "" {
+		scope, err := sortBooks(sort)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		scopes = append(scopes, scope)
+	}
+
+	if result := handler.db.WithContext(c.Request().Context()).Scopes(scopes...).Find(&books); result.Error != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
 	}
 
-	return c.JSON(http.StatusOK, books)
+	return c.JSON(http.StatusOK, localize(c, books...))
 
 }
 
@@ -87,7 +234,7 @@ func (handler *handler) GetById(c echo.Context) error {
 	book := Book{}
 	id := c.Param("id")
 
-	result := handler.db.First(&book, id)
+	result := handler.db.WithContext(c.Request().Context()).First(&book, id)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return c.JSON(http.StatusNotFound, map[string]string{
@@ -99,24 +246,22 @@ func (handler *handler) GetById(c echo.Context) error {
 		})
 	}
 
-	return c.JSON(http.StatusOK, book)
+	return c.JSON(http.StatusOK, localize(c, book)[0])
 }
 
 func (handler *handler) Update(c echo.Context) error {
 	book := Book{}
 	id := c.Param("id")
 
-	validator := validator.New()
-	validator.RegisterValidation("isbn", validateISBN)
-
-	c.Echo().Validator = &CustomValidator{validator: validator}
+	c.Echo().Validator = newValidator()
 	logger := middleware.GetLogger(c)
 
-	if err := handler.db.First(&book, id).Error; err != nil {
+	if err := handler.db.WithContext(c.Request().Context()).First(&book, id).Error; err != nil {
 		logger.Error("book not found", zap.String("id", id), zap.Error(err))
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
 	}
 
+	stock := book.Stock
 	if err := c.Bind(&book); err != nil {
 		logger.Error("failed to bind book", zap.String("id", id), zap.Error(err))
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to bind book data"})
@@ -124,23 +269,33 @@ func (handler *handler) Update(c echo.Context) error {
 
 	if err := c.Validate(book); err != nil {
 		logger.Error("failed to validate book", zap.Any("book", book), zap.Error(err))
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Validation failed"})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
 	}
 
-	if result := handler.db.Save(&book); result.Error != nil {
-		logger.Error("failed to update book", zap.Any("book", book), zap.Error(result.Error))
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		return saveBook(tx, &book, stock)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": errDuplicateISBN.Error()})
+	}
+	if err != nil {
+		logger.Error("failed to update book", zap.Any("book", book), zap.Error(err))
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update book"})
 	}
 
 	logger.Info("book updated successfully", zap.Any("book", book))
-	return c.JSON(http.StatusOK, book)
+	handler.events.Publish(EventBookUpdated, book)
+	if isRestock(stock, book.Stock) {
+		handler.restocked(book)
+	}
+	return c.JSON(http.StatusOK, localize(c, book)[0])
 }
 
 func (handler *handler) Delete(c echo.Context) error {
 	book := Book{}
 	id := c.Param("id")
 
-	result := handler.db.Delete(&book, id)
+	result := handler.db.WithContext(c.Request().Context()).Delete(&book, id)
 
 	if result.Error != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
@@ -150,5 +305,10 @@ func (handler *handler) Delete(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
 	}
 
+	if bookID, err := strconv.ParseUint(id, 10, 64); err == nil {
+		book.ID = uint(bookID)
+	}
+	handler.events.Publish(EventBookDeleted, book)
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "Book successfully deleted"})
 }
@@ -1,24 +1,27 @@
 package book
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 const (
-	createBookQuery  = `INSERT INTO "books" ("created_at","updated_at","deleted_at","title","author","isbn") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`
+	createBookQuery  = `INSERT INTO "books" ("created_at","updated_at","deleted_at","title","author","isbn","publisher","price","currency","cover_version","stock","category","format","weight_grams","width_mm","height_mm","depth_mm","low_stock_threshold","publication_date","status","subtitle","description","translations","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24) RETURNING "id"`
 	getAllBookQuery  = `SELECT * FROM "books" WHERE "books"."deleted_at" IS NULL`
 	getBookByIdQuery = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2`
-	updateBookQuery  = `UPDATE "books" SET "created_at"=$1,"updated_at"=$2,"deleted_at"=$3,"title"=$4,"author"=$5,"isbn"=$6 WHERE "books"."deleted_at" IS NULL AND "id" = $7`
+	updateBookQuery  = `UPDATE "books" SET "created_at"=$1,"updated_at"=$2,"deleted_at"=$3,"title"=$4,"author"=$5,"isbn"=$6,"publisher"=$7,"price"=$8,"currency"=$9,"cover_version"=$10,"category"=$11,"format"=$12,"weight_grams"=$13,"width_mm"=$14,"height_mm"=$15,"depth_mm"=$16,"low_stock_threshold"=$17,"publication_date"=$18,"status"=$19,"subtitle"=$20,"description"=$21,"translations"=$22,"tenant_id"=$23 WHERE "books"."deleted_at" IS NULL AND "id" = $24`
 	deleteBookQuery  = `UPDATE "books" SET "deleted_at"=$1 WHERE "books"."id" = $2 AND "books"."deleted_at" IS NULL`
 )
 
@@ -41,7 +44,7 @@ func TestCreateBook(t *testing.T) {
 		mock.ExpectBegin()
 		row := sqlmock.NewRows([]string{"id"}).AddRow(1)
 		mock.ExpectQuery(createBookQuery).
-			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "Designing Your Life", "Bill Burnett and Dave Evans", "9781101875322").
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "Designing Your Life", "Bill Burnett and Dave Evans", "9781101875322", "", 0.0, "", "", 0, "", "", 0, 0, 0, 0, 0, nil, "", "", "", nil, "default").
 			WillReturnRows(row)
 		mock.ExpectCommit()
 
@@ -53,6 +56,68 @@ func TestCreateBook(t *testing.T) {
 
 	})
 
+	t.Run("record initial stock as an adjustment given book with stock", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"title": "Designing Your Life", "author": "Bill Burnett and Dave Evans", "isbn": "9781101875322", "stock": 12}`
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(createBookQuery).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(createMovementQuery).WithArgs(1, 12, MovementAdjustment, "", sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		err := handler.Create(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("create book in Thai given Thai translation", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"title": "The Tree of a Thousand Loves", "author": "Sukanya Kittikhun", "isbn": "9786164453819", "translations": {"th": {"title": "ต้นไม้พันรัก"}}}`
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("Accept-Language", "th-TH")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(createBookQuery).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		err := middleware.Localize([]string{"en", "th"}, "en")(handler.Create)(c)
+
+		got := Book{}
+		json.Unmarshal(response.Body.Bytes(), &got)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, "th", response.Header().Get("Content-Language"))
+		assert.Equal(t, "ต้นไม้พันรัก", got.Title)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
 	t.Run("create book given invalid book", func(t *testing.T) {
 		e := echo.New()
 		defer e.Close()
@@ -87,6 +152,43 @@ func TestCreateBook(t *testing.T) {
 
 	})
 
+	t.Run("create book given forthcoming book without publication date", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"title": "The Alchemist", "author": "Paulo Coelho", "isbn": "9780062315007", "status": "forthcoming"}`
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := handler.Create(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.Contains(t, response.Body.String(), "publication_date is a required field")
+	})
+
+	t.Run("create book given invalid book in Thai", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"title": "The Alchemist", "author": "Paulo Coelho", "isbn": "007"}`
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("Accept-Language", "th-TH")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := middleware.Localize([]string{"en", "th"}, "en")(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.JSONEq(t, `{"error": "isbn ต้องเป็นเลข ISBN ที่ถูกต้อง"}`, response.Body.String())
+	})
+
 	t.Run("create book given error during book binding", func(t *testing.T) {
 		e := echo.New()
 		defer e.Close()
@@ -119,7 +221,7 @@ func TestCreateBook(t *testing.T) {
 
 		mock.ExpectBegin()
 		mock.ExpectQuery(createBookQuery).
-			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "The Happiness of Pursuit", "Chris Guillebeau", "9780385348876").
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "The Happiness of Pursuit", "Chris Guillebeau", "9780385348876", "", 0.0, "", "", 0, "", "", 0, 0, 0, 0, 0, nil, "", "", "", nil, "default").
 			WillReturnError(errors.New("query error"))
 		mock.ExpectRollback()
 
@@ -159,6 +261,87 @@ func TestGetAllBook(t *testing.T) {
 		assert.Equal(t, http.StatusOK, response.Code)
 	})
 
+	t.Run("get all books sorted by rating given sort=-rating", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?sort=-rating", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		rows := sqlmock.NewRows([]string{"id", "title", "rating_average", "rating_count"}).
+			AddRow(2, "Atomic Habits", 4.5, 12).
+			AddRow(1, "Four Thousand Weeks", 4.0, 3)
+		mock.ExpectQuery(getAllBookQuery + ` ORDER BY rating_average DESC,rating_count DESC,id`).WillReturnRows(rows)
+
+		handler := NewHandler(gormDB)
+		err := handler.GetAll(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"rating_average":4.5,"rating_count":12`)
+	})
+
+	t.Run("get coming soon books given status=forthcoming", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?status=forthcoming&sort=publication_date", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		rows := sqlmock.NewRows([]string{"id", "title", "status", "publication_date"}).
+			AddRow(3, "Nexus", "forthcoming", time.Date(2024, 9, 10, 0, 0, 0, 0, time.UTC))
+		mock.ExpectQuery(`SELECT * FROM "books" WHERE status = $1 AND "books"."deleted_at" IS NULL ORDER BY publication_date ASC,id`).
+			WithArgs(StatusForthcoming).
+			WillReturnRows(rows)
+
+		handler := NewHandler(gormDB)
+		err := handler.GetAll(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"publication_date":"2024-09-10T00:00:00Z","status":"forthcoming"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("get all books given unsupported sort", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?sort=isbn", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := handler.GetAll(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("get all books given unknown status", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?status=availble", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := handler.GetAll(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.JSONEq(t, `{"error": "Status must be forthcoming, available or out_of_print"}`, response.Body.String())
+	})
+
 	t.Run("get all books given error during query", func(t *testing.T) {
 		e := echo.New()
 		defer e.Close()
@@ -208,6 +391,39 @@ func TestGetBookById(t *testing.T) {
 		assert.Equal(t, http.StatusOK, response.Code)
 	})
 
+	t.Run("get book by id in Thai given Thai translation", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept-Language", "th-TH,en;q=0.8")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetPath("/books/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("3")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		row := sqlmock.NewRows([]string{"ID", "title", "subtitle", "author", "isbn", "translations"})
+		row.AddRow(3, "The Tree of a Thousand Loves", "A Novel", "Sukanya Kittikhun", "9786164453819", `{"th": {"title": "ต้นไม้พันรัก"}}`)
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("3", 1).WillReturnRows(row)
+
+		handler := NewHandler(gormDB)
+		err := middleware.Localize([]string{"en", "th"}, "en")(handler.GetById)(c)
+
+		got := Book{}
+		json.Unmarshal(response.Body.Bytes(), &got)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "th", response.Header().Get("Content-Language"))
+		assert.Equal(t, "ต้นไม้พันรัก", got.Title)
+		assert.Equal(t, "A Novel", got.Subtitle)
+	})
+
 	t.Run("get book by id given book does not exist", func(t *testing.T) {
 		e := echo.New()
 		defer e.Close()
@@ -285,7 +501,7 @@ func TestUpdateBook(t *testing.T) {
 
 		mock.ExpectBegin()
 		mock.ExpectExec(updateBookQuery).
-			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "The Tree of a Thousand Loves", "Sukanya Kittikhun", "9786164453819", 1).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "The Tree of a Thousand Loves", "Sukanya Kittikhun", "9786164453819", "", 0.0, "", "", "", "", 0, 0, 0, 0, 0, nil, "", "", "", nil, "", 1).
 			WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
 
@@ -380,8 +596,9 @@ func TestUpdateBook(t *testing.T) {
 			WithArgs("29", 1).
 			WillReturnRows(row)
 
+		mock.ExpectBegin()
 		mock.ExpectExec(updateBookQuery).
-			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "The Tree of Loves", "Phetploy", "0781101875322", 1).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "The Tree of Loves", "Phetploy", "0781101875322", "", 0.0, "", "", "", "", 0, 0, 0, 0, 0, nil, "", "", "", nil, "", 1).
 			WillReturnError(errors.New("query error"))
 		mock.ExpectRollback()
 
@@ -468,3 +685,17 @@ func TestDeleteBook(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, response.Code)
 	})
 }
+
+func TestAuthors(t *testing.T) {
+	t.Run("split on and, ampersand and semicolon given several authors", func(t *testing.T) {
+		book := Book{Author: "Bill Burnett and Dave Evans & Jane Doe; John Roe"}
+
+		assert.Equal(t, []string{"Bill Burnett", "Dave Evans", "Jane Doe", "John Roe"}, book.Authors())
+	})
+
+	t.Run("keep one author given surname first", func(t *testing.T) {
+		book := Book{Author: "Tolkien, J.R.R."}
+
+		assert.Equal(t, []string{"Tolkien, J.R.R."}, book.Authors())
+	})
+}
@@ -0,0 +1,350 @@
+package book
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/blob"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"golang.org/x/image/draw"
+	"gorm.io/gorm"
+)
+
+const (
+	maxCoverSize   = 5 << 20
+	maxCoverPixels = 40_000_000
+	coverQuality   = 85
+
+	coverSizeOriginal = "original"
+
+	// Versioned cover URLs never change content, other requests may be
+	// answered from cache for a day.
+	coverCacheImmutable = "public, max-age=31536000, immutable"
+	coverCacheDefault   = "public, max-age=86400"
+)
+
+// coverWidths are the widths thumbnails are scaled down to. The original is
+// capped at its own width so that huge scans do not bloat storage.
+var coverWidths = map[string]int{
+	"small":           150,
+	"medium":          300,
+	"large":           600,
+	coverSizeOriginal: 1600,
+}
+
+var errUnsupportedCover = errors.New("cover must be a JPEG or PNG image")
+
+func WithBlobStore(store blob.BlobStore) Option {
+	return func(handler *handler) {
+		handler.blobs = store
+	}
+}
+
+func coverKey(id uint, size string) string {
+	return fmt.Sprintf("covers/%d/%s.jpg", id, size)
+}
+
+// AfterFind fills in cover URLs for books that have a cover.
+func (book *Book) AfterFind(tx *gorm.DB) error {
+	book.setCovers()
+	return nil
+}
+
+// AfterSave replaces any cover URLs a client sent with the stored ones.
+func (book *Book) AfterSave(tx *gorm.DB) error {
+	book.setCovers()
+	return nil
+}
+
+func (book *Book) setCovers() {
+	if book.CoverVersion == "" {
+		book.Covers = nil
+		return
+	}
+	book.Covers = make(map[string]string, len(coverWidths))
+	for size := range coverWidths {
+		book.Covers[size] = fmt.Sprintf("/books/%d/cover?size=%s&v=%s", book.ID, size, book.CoverVersion)
+	}
+}
+
+func (handler *handler) UploadCover(c echo.Context) error {
+	book := Book{}
+	id := c.Param("id")
+	logger := middleware.GetLogger(c)
+
+	if handler.blobs == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Cover storage is not configured"})
+	}
+
+	if err := handler.db.WithContext(c.Request().Context()).First(&book, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A file field is required"})
+	}
+	if header.Size > maxCoverSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("Cover must not exceed %d bytes", maxCoverSize)})
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxCoverSize+1))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if len(data) > maxCoverSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("Cover must not exceed %d bytes", maxCoverSize)})
+	}
+
+	covers, err := renderCovers(data)
+	if errors.Is(err, errUnsupportedCover) {
+		return c.JSON(http.StatusUnsupportedMediaType, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		logger.Error("failed to process cover", zap.String("id", id), zap.Error(err))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	ctx := c.Request().Context()
+	for size, cover := range covers {
+		if err := handler.blobs.Put(ctx, coverKey(book.ID, size), bytes.NewReader(cover), "image/jpeg"); err != nil {
+			logger.Error("failed to store cover", zap.String("id", id), zap.String("size", size), zap.Error(err))
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store cover"})
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	version := hex.EncodeToString(sum[:8])
+	if err := handler.db.WithContext(c.Request().Context()).Model(&book).Update("cover_version", version).Error; err != nil {
+		logger.Error("failed to update cover version", zap.String("id", id), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update book"})
+	}
+	book.CoverVersion = version
+	book.setCovers()
+
+	logger.Info("cover uploaded", zap.String("id", id), zap.String("version", version))
+	handler.events.Publish(EventBookUpdated, book)
+	return c.JSON(http.StatusOK, book)
+}
+
+func (handler *handler) GetCover(c echo.Context) error {
+	book := Book{}
+	id := c.Param("id")
+
+	if handler.blobs == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Cover storage is not configured"})
+	}
+
+	size := c.QueryParam("size")
+	if size == "" {
+		size = coverSizeOriginal
+	}
+	if _, ok := coverWidths[size]; !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unsupported size: " + size})
+	}
+
+	if err := handler.db.WithContext(c.Request().Context()).First(&book, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if book.CoverVersion == "" {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book has no cover"})
+	}
+
+	etag := strconv.Quote(book.CoverVersion + "-" + size)
+	cacheControl := coverCacheDefault
+	if c.QueryParam("v") == book.CoverVersion {
+		cacheControl = coverCacheImmutable
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, cacheControl)
+	c.Response().Header().Set("ETag", etag)
+
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	reader, info, err := handler.blobs.Get(c.Request().Context(), coverKey(book.ID, size))
+	if errors.Is(err, blob.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book has no cover"})
+	}
+	if err != nil {
+		middleware.GetLogger(c).Error("failed to read cover", zap.String("id", id), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to read cover"})
+	}
+	defer reader.Close()
+
+	return c.Stream(http.StatusOK, info.ContentType, reader)
+}
+
+// renderCovers decodes an uploaded JPEG or PNG and re-encodes it as a JPEG in
+// every cover size. Re-encoding drops EXIF and any other embedded metadata,
+// so the EXIF orientation is applied to the pixels first.
+func renderCovers(data []byte) (map[string][]byte, error) {
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png":
+	default:
+		return nil, errUnsupportedCover
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if config.Width*config.Height > maxCoverPixels {
+		return nil, fmt.Errorf("image must not exceed %d pixels", maxCoverPixels)
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	source := orient(flatten(decoded), jpegOrientation(data))
+
+	covers := make(map[string][]byte, len(coverWidths))
+	for size, width := range coverWidths {
+		out := &bytes.Buffer{}
+		if err := jpeg.Encode(out, scaleToWidth(source, width), &jpeg.Options{Quality: coverQuality}); err != nil {
+			return nil, err
+		}
+		covers[size] = out.Bytes()
+	}
+	return covers, nil
+}
+
+// flatten draws the image onto white, as JPEG has no transparency.
+func flatten(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Over)
+	return dst
+}
+
+func scaleToWidth(src *image.RGBA, width int) image.Image {
+	bounds := src.Bounds()
+	if bounds.Dx() <= width {
+		return src
+	}
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+// orient turns an image stored with an EXIF orientation into one that is
+// upright without it.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := x, y
+			switch orientation {
+			case 2:
+				sx = w - 1 - x
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sy = h - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the orientation tag from a JPEG's EXIF segment. It
+// returns 1, upright, when there is none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for offset := 2; offset+4 <= len(data) && data[offset] == 0xFF; {
+		marker := data[offset+1]
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		end := offset + 2 + length
+		if marker == 0xDA || end > len(data) {
+			return 1
+		}
+		segment := data[offset+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		offset = end
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
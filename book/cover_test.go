@@ -0,0 +1,263 @@
+package book
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/blob"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	updateCoverVersionQuery = `UPDATE "books" SET "cover_version"=$1,"updated_at"=$2 WHERE "books"."deleted_at" IS NULL AND "id" = $3`
+)
+
+func newTestImage(width int, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+// withEXIFOrientation inserts an APP1 segment carrying only an orientation tag
+// right after the JPEG start of image marker.
+func withEXIFOrientation(data []byte, orientation uint16) []byte {
+	tiff := &bytes.Buffer{}
+	tiff.WriteString("MM")
+	binary.Write(tiff, binary.BigEndian, uint16(42))
+	binary.Write(tiff, binary.BigEndian, uint32(8))
+	binary.Write(tiff, binary.BigEndian, uint16(1))
+	binary.Write(tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(tiff, binary.BigEndian, uint32(1))
+	binary.Write(tiff, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(tiff, binary.BigEndian, uint32(0))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func newCoverRequest(t testing.TB, content []byte) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "cover")
+	part.Write(content)
+	writer.Close()
+
+	request := httptest.NewRequest(http.MethodPut, "/books/1/cover", body)
+	request.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	return request
+}
+
+func TestRenderCovers(t *testing.T) {
+	t.Run("scale thumbnails given png", func(t *testing.T) {
+		data := &bytes.Buffer{}
+		png.Encode(data, newTestImage(400, 200))
+
+		covers, err := renderCovers(data.Bytes())
+		assert.NoError(t, err)
+
+		small, _ := jpeg.DecodeConfig(bytes.NewReader(covers["small"]))
+		original, _ := jpeg.DecodeConfig(bytes.NewReader(covers[coverSizeOriginal]))
+		assert.Equal(t, 150, small.Width)
+		assert.Equal(t, 75, small.Height)
+		assert.Equal(t, 400, original.Width)
+	})
+
+	t.Run("apply and strip exif orientation given rotated jpeg", func(t *testing.T) {
+		data := &bytes.Buffer{}
+		jpeg.Encode(data, newTestImage(40, 20), nil)
+		rotated := withEXIFOrientation(data.Bytes(), 6)
+		assert.Equal(t, 6, jpegOrientation(rotated))
+
+		covers, err := renderCovers(rotated)
+		assert.NoError(t, err)
+
+		original, _ := jpeg.DecodeConfig(bytes.NewReader(covers[coverSizeOriginal]))
+		assert.Equal(t, 20, original.Width)
+		assert.Equal(t, 40, original.Height)
+		assert.NotContains(t, string(covers[coverSizeOriginal]), "Exif")
+	})
+
+	t.Run("reject upload given unsupported type", func(t *testing.T) {
+		_, err := renderCovers([]byte("GIF89a"))
+
+		assert.ErrorIs(t, err, errUnsupportedCover)
+	})
+}
+
+func TestUploadCover(t *testing.T) {
+	t.Run("store covers given jpeg upload", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		data := &bytes.Buffer{}
+		jpeg.Encode(data, newTestImage(400, 600), nil)
+		request := newCoverRequest(t, data.Bytes())
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetPath("/books/:id/cover")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "isbn"}).AddRow(1, "Atomic Habits", "James Clear", "9781847941831"))
+		mock.ExpectBegin()
+		mock.ExpectExec(updateCoverVersionQuery).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		store := blob.NewLocalStore(t.TempDir())
+		handler := NewHandler(gormDB, WithBlobStore(store))
+		err := handler.UploadCover(c)
+
+		book := Book{}
+		json.Unmarshal(response.Body.Bytes(), &book)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Len(t, book.Covers, len(coverWidths))
+		assert.True(t, strings.HasPrefix(book.Covers["small"], "/books/1/cover?size=small&v="))
+		_, info, err := store.Get(context.Background(), "covers/1/small.jpg")
+		assert.NoError(t, err)
+		assert.Equal(t, "image/jpeg", info.ContentType)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return unsupported media type given text file", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		request := newCoverRequest(t, []byte("not an image"))
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetPath("/books/:id/cover")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+		handler := NewHandler(gormDB, WithBlobStore(blob.NewLocalStore(t.TempDir())))
+		err := handler.UploadCover(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnsupportedMediaType, response.Code)
+	})
+}
+
+func TestGetCover(t *testing.T) {
+	newCoverContext := func(e *echo.Echo, target string, etag string) (echo.Context, *httptest.ResponseRecorder) {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		if etag != "" {
+			request.Header.Set("If-None-Match", etag)
+		}
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetPath("/books/:id/cover")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		return c, response
+	}
+
+	t.Run("serve thumbnail with cache headers given versioned url", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newCoverContext(e, "/books/1/cover?size=small&v=abc", "")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "cover_version"}).AddRow(1, "abc"))
+
+		store := blob.NewLocalStore(t.TempDir())
+		store.Put(context.Background(), "covers/1/small.jpg", strings.NewReader("jpeg"), "image/jpeg")
+
+		handler := NewHandler(gormDB, WithBlobStore(store))
+		err := handler.GetCover(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "jpeg", response.Body.String())
+		assert.Equal(t, "image/jpeg", response.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, coverCacheImmutable, response.Header().Get(echo.HeaderCacheControl))
+		assert.Equal(t, `"abc-small"`, response.Header().Get("ETag"))
+	})
+
+	t.Run("return not modified given matching etag", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newCoverContext(e, "/books/1/cover?size=small", `"abc-small"`)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "cover_version"}).AddRow(1, "abc"))
+
+		handler := NewHandler(gormDB, WithBlobStore(blob.NewLocalStore(t.TempDir())))
+		err := handler.GetCover(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotModified, response.Code)
+		assert.Equal(t, coverCacheDefault, response.Header().Get(echo.HeaderCacheControl))
+	})
+
+	t.Run("return not found given book without cover", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newCoverContext(e, "/books/1/cover", "")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+		handler := NewHandler(gormDB, WithBlobStore(blob.NewLocalStore(t.TempDir())))
+		err := handler.GetCover(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
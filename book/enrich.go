@@ -0,0 +1,104 @@
+package book
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/metadata"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+)
+
+// createLookupTimeout bounds how long Create waits for the metadata provider
+// before saving the book with whatever the client sent.
+const createLookupTimeout = 300 * time.Millisecond
+
+func WithMetadataProvider(provider metadata.MetadataProvider) Option {
+	return func(handler *handler) {
+		handler.metadata = provider
+	}
+}
+
+// prefill copies metadata into the fields the book does not have yet.
+func prefill(book *Book, record metadata.Record) {
+	if book.ISBN == "" {
+		book.ISBN = record.ISBN
+	}
+	if book.Title == "" {
+		book.Title = record.Title
+	}
+	if book.Author == "" {
+		book.Author = strings.Join(record.Authors, "; ")
+	}
+	if book.Publisher == "" {
+		book.Publisher = record.Publisher
+	}
+}
+
+func needsMetadata(book Book) bool {
+	return book.ISBN != "" && (book.Title == "" || book.Author == "" || book.Publisher == "")
+}
+
+// prefillFromProvider fills missing fields on a best effort basis. Lookup
+// errors are logged and otherwise ignored so that creating a book never
+// depends on the provider.
+func (handler *handler) prefillFromProvider(c echo.Context, book *Book) {
+	if handler.metadata == nil || !needsMetadata(*book) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), createLookupTimeout)
+	defer cancel()
+
+	record, err := handler.metadata.Lookup(ctx, book.ISBN)
+	if err != nil {
+		middleware.GetLogger(c).Warn("failed to look up book metadata", zap.String("isbn", book.ISBN), zap.Error(err))
+		return
+	}
+	prefill(book, record)
+}
+
+// Enrich returns a book pre-filled from the metadata provider. Fields sent in
+// the request body take precedence over the looked up ones.
+func (handler *handler) Enrich(c echo.Context) error {
+	book := Book{}
+	logger := middleware.GetLogger(c)
+
+	if c.Request().ContentLength > 0 {
+		if err := c.Bind(&book); err != nil {
+			logger.Error("failed to bind book", zap.Error(err))
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	if isbn := c.QueryParam("isbn"); isbn != "" {
+		book.ISBN = strings.ReplaceAll(isbn, "-", "")
+	}
+	if !isbnPattern.MatchString(book.ISBN) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A valid isbn is required"})
+	}
+
+	if handler.metadata == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Metadata lookup is not configured"})
+	}
+
+	record, err := handler.metadata.Lookup(c.Request().Context(), book.ISBN)
+	if err != nil {
+		logger.Error("failed to look up book metadata", zap.String("isbn", book.ISBN), zap.Error(err))
+		switch {
+		case errors.Is(err, metadata.ErrNotFound):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "No metadata found for isbn"})
+		case errors.Is(err, metadata.ErrUnavailable):
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Metadata provider is unavailable"})
+		default:
+			return c.JSON(http.StatusBadGateway, map[string]string{"error": "Metadata lookup failed"})
+		}
+	}
+
+	prefill(&book, record)
+	return c.JSON(http.StatusOK, book)
+}
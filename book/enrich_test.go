@@ -0,0 +1,143 @@
+package book
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/metadata"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func newFixtureProvider(t testing.TB) metadata.MetadataProvider {
+	t.Helper()
+	provider, err := metadata.LoadFixtureProvider("../metadata/testdata/openlibrary.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestEnrich(t *testing.T) {
+	t.Run("prefill book given known isbn", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/books/enrich?isbn=978-1101875322", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil, WithMetadataProvider(newFixtureProvider(t)))
+		err := handler.Enrich(c)
+
+		book := Book{}
+		json.Unmarshal(response.Body.Bytes(), &book)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, Book{Title: "Designing Your Life", Author: "Bill Burnett; Dave Evans", ISBN: "9781101875322", Publisher: "Knopf"}, book)
+	})
+
+	t.Run("keep fields from request body given partial book", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		body := `{"title": "Atomic Habits (Paperback)", "isbn": "9781847941831", "price": 12.99, "currency": "GBP"}`
+		request := httptest.NewRequest(http.MethodPost, "/books/enrich", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil, WithMetadataProvider(newFixtureProvider(t)))
+		err := handler.Enrich(c)
+
+		book := Book{}
+		json.Unmarshal(response.Body.Bytes(), &book)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "Atomic Habits (Paperback)", book.Title)
+		assert.Equal(t, "James Clear", book.Author)
+		assert.Equal(t, 12.99, book.Price)
+	})
+
+	t.Run("return not found given unknown isbn", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/books/enrich?isbn=9780062315007", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil, WithMetadataProvider(newFixtureProvider(t)))
+		err := handler.Enrich(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("return bad request given invalid isbn", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/books/enrich?isbn=007", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil, WithMetadataProvider(newFixtureProvider(t)))
+		err := handler.Enrich(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestCreateBookWithMetadata(t *testing.T) {
+	t.Run("fill title and author given only isbn", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"isbn": "9781847941831"}`
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(createBookQuery).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "Atomic Habits", "James Clear", "9781847941831", "Random House Business", 0.0, "", "", 0, "", "", 0, 0, 0, 0, 0, nil, "", "", "", nil, "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, WithMetadataProvider(newFixtureProvider(t)))
+		err := handler.Create(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("reject book given provider has no record", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		body := `{"isbn": "9780062315007"}`
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil, WithMetadataProvider(newFixtureProvider(t)))
+		err := handler.Create(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
@@ -0,0 +1,213 @@
+package book
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+)
+
+const (
+	EventBookCreated = "book.created"
+	EventBookUpdated = "book.updated"
+	EventBookDeleted = "book.deleted"
+	eventReset       = "reset"
+
+	replayBufferSize     = 256
+	subscriberBufferSize = 16
+	heartbeatInterval    = 15 * time.Second
+)
+
+type Event struct {
+	ID   uint64
+	Type string
+	Book Book
+}
+
+// eventPayload exposes the book ID, which Book itself hides from JSON, so
+// clients can tell which book a delete refers to.
+type eventPayload struct {
+	ID uint `json:"id"`
+	Book
+}
+
+type subscriber struct {
+	tenantID string
+	events   chan Event
+}
+
+// broker fans out book events to SSE clients and keeps the most recent
+// events in a ring buffer so reconnecting clients can resume from Last-Event-ID.
+type broker struct {
+	mu          sync.Mutex
+	lastID      uint64
+	replay      []Event
+	subscribers map[*subscriber]struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func newBroker(size int) *broker {
+	return &broker{
+		replay:      make([]Event, 0, size),
+		subscribers: make(map[*subscriber]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (b *broker) Publish(eventType string, book Book) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := Event{ID: b.lastID, Type: eventType, Book: book}
+
+	if len(b.replay) == cap(b.replay) {
+		b.replay = append(b.replay[:0], b.replay[1:]...)
+	}
+	b.replay = append(b.replay, event)
+
+	for sub := range b.subscribers {
+		if sub.tenantID != book.TenantID {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			// The client is not keeping up. Disconnect it rather than block
+			// publishers; it can resume from the replay buffer with Last-Event-ID.
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Subscribe registers a new subscriber to the books of a tenant. When
+// resuming, it also returns the tenant's buffered events newer than lastID;
+// complete is false when lastID is older than the replay buffer, meaning the
+// client has missed events and must refetch the catalog.
+func (b *broker) Subscribe(tenantID string, lastID uint64, resume bool) (sub *subscriber, missed []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &subscriber{tenantID: tenantID, events: make(chan Event, subscriberBufferSize)}
+	b.subscribers[sub] = struct{}{}
+
+	complete = true
+	if !resume {
+		return sub, nil, complete
+	}
+	if len(b.replay) > 0 && lastID+1 < b.replay[0].ID {
+		complete = false
+	}
+	if lastID > b.lastID {
+		complete = false
+		lastID = 0
+	}
+	for _, event := range b.replay {
+		if event.ID > lastID && event.Book.TenantID == tenantID {
+			missed = append(missed, event)
+		}
+	}
+
+	return sub, missed, complete
+}
+
+func (b *broker) Unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+func (b *broker) Close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+}
+
+func (handler *handler) Events(c echo.Context) error {
+	logger := middleware.GetLogger(c)
+
+	var lastID uint64
+	header := c.Request().Header.Get("Last-Event-ID")
+	if header != "" {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid Last-Event-ID"})
+		}
+		lastID = id
+	}
+
+	sub, missed, complete := handler.events.Subscribe(middleware.GetTenantID(c), lastID, header != "")
+	defer handler.events.Unsubscribe(sub)
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set(echo.HeaderCacheControl, "no-cache")
+	response.Header().Set(echo.HeaderConnection, "keep-alive")
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+
+	if !complete {
+		if _, err := fmt.Fprintf(response, "event: %s\ndata: {}\n\n", eventReset); err != nil {
+			return nil
+		}
+	}
+	for _, event := range missed {
+		if err := writeEvent(response, event); err != nil {
+			return nil
+		}
+	}
+	response.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-handler.events.done:
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(response, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			response.Flush()
+		case event, ok := <-sub.events:
+			if !ok {
+				logger.Warn("disconnected slow event stream client")
+				return nil
+			}
+			if err := writeEvent(response, event); err != nil {
+				logger.Error("failed to write event", zap.Error(err))
+				return nil
+			}
+			response.Flush()
+		}
+	}
+}
+
+// Close stops every open event stream so the server can shut down gracefully.
+func (handler *handler) Close() {
+	handler.events.Close()
+}
+
+func writeEvent(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(eventPayload{ID: event.Book.ID, Book: event.Book})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
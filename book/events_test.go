@@ -0,0 +1,120 @@
+package book
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroker(t *testing.T) {
+	t.Run("replay events newer than last event id", func(t *testing.T) {
+		b := newBroker(10)
+		b.Publish(EventBookCreated, Book{Title: "Atomic Habits"})
+		b.Publish(EventBookUpdated, Book{Title: "Atomic Habits"})
+		b.Publish(EventBookDeleted, Book{})
+
+		_, missed, complete := b.Subscribe("", 1, true)
+
+		assert.True(t, complete)
+		assert.Len(t, missed, 2)
+		assert.Equal(t, uint64(2), missed[0].ID)
+		assert.Equal(t, EventBookDeleted, missed[1].Type)
+	})
+
+	t.Run("report incomplete replay given last event id fell out of the buffer", func(t *testing.T) {
+		b := newBroker(2)
+		for i := 0; i < 5; i++ {
+			b.Publish(EventBookCreated, Book{})
+		}
+
+		_, missed, complete := b.Subscribe("", 1, true)
+
+		assert.False(t, complete)
+		assert.Len(t, missed, 2)
+		assert.Equal(t, uint64(4), missed[0].ID)
+	})
+
+	t.Run("skip replay given client does not resume", func(t *testing.T) {
+		b := newBroker(10)
+		b.Publish(EventBookCreated, Book{})
+
+		_, missed, complete := b.Subscribe("", 0, false)
+
+		assert.True(t, complete)
+		assert.Empty(t, missed)
+	})
+
+	t.Run("disconnect subscriber given its buffer is full", func(t *testing.T) {
+		b := newBroker(10)
+		sub, _, _ := b.Subscribe("", 0, false)
+
+		for i := 0; i < subscriberBufferSize+1; i++ {
+			b.Publish(EventBookCreated, Book{})
+		}
+
+		received := 0
+		for range sub.events {
+			received++
+		}
+		assert.Equal(t, subscriberBufferSize, received)
+		assert.Empty(t, b.subscribers)
+	})
+
+	t.Run("only send events of the subscriber's tenant", func(t *testing.T) {
+		b := newBroker(10)
+		b.Publish(EventBookCreated, Book{Title: "Sapiens", TenantID: "uk"})
+		sub, missed, _ := b.Subscribe("th", 0, true)
+
+		b.Publish(EventBookCreated, Book{Title: "Nexus", TenantID: "uk"})
+		b.Publish(EventBookCreated, Book{Title: "Homo Deus", TenantID: "th"})
+		b.Unsubscribe(sub)
+
+		received := []string{}
+		for event := range sub.events {
+			received = append(received, event.Book.Title)
+		}
+		assert.Empty(t, missed)
+		assert.Equal(t, []string{"Homo Deus"}, received)
+	})
+}
+
+func TestEvents(t *testing.T) {
+	t.Run("stream missed events given Last-Event-ID", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/books/events", nil)
+		request.Header.Set("Last-Event-ID", "1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		handler.events.Publish(EventBookCreated, Book{Title: "Four Thousand Weeks"})
+		handler.events.Publish(EventBookCreated, Book{Title: "Atomic Habits"})
+		handler.Close()
+
+		err := handler.Events(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "text/event-stream", response.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, "id: 2\nevent: book.created\ndata: {\"id\":0,\"title\":\"Atomic Habits\",\"author\":\"\",\"isbn\":\"\",\"publisher\":\"\",\"price\":0,\"currency\":\"\",\"rating_average\":0,\"rating_count\":0,\"stock\":0,\"category\":\"\",\"format\":\"\",\"weight_grams\":0,\"width_mm\":0,\"height_mm\":0,\"depth_mm\":0,\"low_stock_threshold\":0,\"publication_date\":null,\"status\":\"\",\"subtitle\":\"\",\"description\":\"\"}\n\n", response.Body.String())
+	})
+
+	t.Run("return bad request given invalid Last-Event-ID", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/books/events", nil)
+		request.Header.Set("Last-Event-ID", "abc")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := handler.Events(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
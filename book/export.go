@@ -0,0 +1,352 @@
+package book
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	ExportFormatCSV    = "csv"
+	ExportFormatNDJSON = "ndjson"
+	ExportFormatXLSX   = "xlsx"
+	ExportFormatONIX   = "onix"
+
+	utf8BOM = "\ufeff"
+)
+
+var exportColumns = map[string]func(book Book) interface{}{
+	"id":           func(book Book) interface{} { return book.ID },
+	"title":        func(book Book) interface{} { return book.Title },
+	"author":       func(book Book) interface{} { return book.Author },
+	"isbn":         func(book Book) interface{} { return book.ISBN },
+	"publisher":    func(book Book) interface{} { return book.Publisher },
+	"price":        func(book Book) interface{} { return book.Price },
+	"currency":     func(book Book) interface{} { return book.Currency },
+	"category":     func(book Book) interface{} { return book.Category },
+	"format":       func(book Book) interface{} { return book.Format },
+	"weight_grams": func(book Book) interface{} { return book.WeightGrams },
+	"status":       func(book Book) interface{} { return book.Status },
+	"created_at":   func(book Book) interface{} { return book.CreatedAt.Format(time.RFC3339) },
+	"updated_at":   func(book Book) interface{} { return book.UpdatedAt.Format(time.RFC3339) },
+}
+
+var defaultExportColumns = []string{"id", "title", "author", "isbn"}
+
+type exportWriter interface {
+	WriteHeader() error
+	WriteBook(book Book) error
+	Close() error
+}
+
+func columnValues(columns []string, book Book) []interface{} {
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = exportColumns[column](book)
+	}
+	return values
+}
+
+var errUnknownStatus = errors.New("Status must be forthcoming, available or out_of_print")
+
+// checkFilters rejects query filters that filterBooks cannot apply.
+func checkFilters(c echo.Context) error {
+	switch c.QueryParam("status") {
+	case "", StatusForthcoming, StatusAvailable, StatusOutOfPrint:
+		return nil
+	}
+	return errUnknownStatus
+}
+
+// filterBooks applies the query filters shared by GetAll and Export. They
+// must have passed checkFilters.
+func filterBooks(c echo.Context) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if title := c.QueryParam("title"); title != "" {
+			db = db.Where("title ILIKE ?", "%"+escapeLike(title)+"%")
+		}
+		if author := c.QueryParam("author"); author != "" {
+			db = db.Where("author ILIKE ?", "%"+escapeLike(author)+"%")
+		}
+		if isbn := c.QueryParam("isbn"); isbn != "" {
+			db = db.Where("isbn = ?", isbn)
+		}
+		if category := c.QueryParam("category"); category != "" {
+			db = db.Where("category = ?", category)
+		}
+		switch status := c.QueryParam("status"); status {
+		case "":
+		case StatusAvailable:
+			db = db.Where("status IN ?", []string{"", StatusAvailable})
+		default:
+			db = db.Where("status = ?", status)
+		}
+		return db
+	}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike escapes the LIKE wildcards in value, so that it only matches
+// itself.
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+func (handler *handler) Export(c echo.Context) error {
+	logger := middleware.GetLogger(c)
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = ExportFormatCSV
+	}
+	if err := checkFilters(c); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	columns := defaultExportColumns
+	if value := c.QueryParam("columns"); value != "" {
+		columns = strings.Split(value, ",")
+		for _, column := range columns {
+			if _, ok := exportColumns[column]; !ok {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unknown column %q", column)})
+			}
+		}
+	}
+	bom, _ := strconv.ParseBool(c.QueryParam("bom"))
+
+	response := c.Response()
+	var writer exportWriter
+	var contentType string
+	switch format {
+	case ExportFormatCSV:
+		contentType = "text/csv; charset=utf-8"
+		writer = &csvExportWriter{writer: csv.NewWriter(response), columns: columns, bom: bom, out: response}
+	case ExportFormatNDJSON:
+		contentType = "application/x-ndjson"
+		writer = &ndjsonExportWriter{encoder: json.NewEncoder(response), columns: columns}
+	case ExportFormatXLSX:
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		writer = &xlsxExportWriter{zip: zip.NewWriter(response), columns: columns}
+	case ExportFormatONIX:
+		contentType = echo.MIMEApplicationXMLCharsetUTF8
+		writer = newONIXExportWriter(response)
+	case ExportFormatMARC:
+		contentType = mimeMARC
+		writer = &marcExportWriter{out: response}
+	case ExportFormatMARCXML:
+		contentType = echo.MIMEApplicationXMLCharsetUTF8
+		writer = newMARCXMLExportWriter(response)
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Format must be csv, ndjson, xlsx, onix, marc or marcxml"})
+	}
+
+	rows, err := handler.db.WithContext(c.Request().Context()).Model(&Book{}).Scopes(filterBooks(c)).Order("id").Rows()
+	if err != nil {
+		logger.Error("failed to query books for export", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	defer rows.Close()
+
+	// The headers are only set once the query has succeeded, so that an
+	// error is not sent as a download.
+	extensions := map[string]string{ExportFormatONIX: "xml", ExportFormatMARC: "mrc", ExportFormatMARCXML: "xml"}
+	extension, ok := extensions[format]
+	if !ok {
+		extension = format
+	}
+	response.Header().Set(echo.HeaderContentType, contentType)
+	response.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="books.%s"`, extension))
+	response.WriteHeader(http.StatusOK)
+	if err := writer.WriteHeader(); err != nil {
+		logger.Error("failed to write export", zap.Error(err))
+		return nil
+	}
+
+	for rows.Next() {
+		book := Book{}
+		if err := handler.db.WithContext(c.Request().Context()).ScanRows(rows, &book); err != nil {
+			logger.Error("failed to scan book for export", zap.Error(err))
+			return nil
+		}
+		if err := writer.WriteBook(book); err != nil {
+			logger.Error("failed to write export", zap.Error(err))
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error("failed to read books for export", zap.Error(err))
+		return nil
+	}
+
+	if err := writer.Close(); err != nil {
+		logger.Error("failed to finish export", zap.Error(err))
+	}
+	return nil
+}
+
+type csvExportWriter struct {
+	writer  *csv.Writer
+	columns []string
+	out     io.Writer
+	bom     bool
+}
+
+func (w *csvExportWriter) WriteHeader() error {
+	if w.bom {
+		if _, err := io.WriteString(w.out, utf8BOM); err != nil {
+			return err
+		}
+	}
+	return w.writer.Write(w.columns)
+}
+
+func (w *csvExportWriter) WriteBook(book Book) error {
+	values := columnValues(w.columns, book)
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = fmt.Sprint(value)
+		if text, ok := value.(string); ok {
+			record[i] = escapeFormula(text)
+		}
+	}
+	return w.writer.Write(record)
+}
+
+// escapeFormula prefixes text that a spreadsheet would run as a formula with
+// a quote, so that a book title such as =HYPERLINK(...) stays text when the
+// export is opened in Excel.
+func escapeFormula(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+func (w *csvExportWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+	columns []string
+}
+
+func (w *ndjsonExportWriter) WriteHeader() error {
+	return nil
+}
+
+func (w *ndjsonExportWriter) WriteBook(book Book) error {
+	record := make(map[string]interface{}, len(w.columns))
+	for i, value := range columnValues(w.columns, book) {
+		record[w.columns[i]] = value
+	}
+	return w.encoder.Encode(record)
+}
+
+func (w *ndjsonExportWriter) Close() error {
+	return nil
+}
+
+// xlsxExportWriter streams a single-sheet workbook. Cells are written as
+// inline strings so no shared string table has to be held in memory.
+type xlsxExportWriter struct {
+	zip     *zip.Writer
+	columns []string
+	sheet   io.Writer
+	row     int
+}
+
+var xlsxParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Books" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+func (w *xlsxExportWriter) WriteHeader() error {
+	for _, part := range xlsxParts {
+		file, err := w.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(file, part.content); err != nil {
+			return err
+		}
+	}
+
+	sheet, err := w.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	w.sheet = sheet
+	if _, err := io.WriteString(w.sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(w.columns))
+	for i, column := range w.columns {
+		values[i] = column
+	}
+	return w.writeRow(values)
+}
+
+func (w *xlsxExportWriter) WriteBook(book Book) error {
+	return w.writeRow(columnValues(w.columns, book))
+}
+
+func (w *xlsxExportWriter) writeRow(values []interface{}) error {
+	w.row++
+	if _, err := fmt.Fprintf(w.sheet, `<row r="%d">`, w.row); err != nil {
+		return err
+	}
+	for _, value := range values {
+		switch value := value.(type) {
+		case uint, float64:
+			if _, err := fmt.Fprintf(w.sheet, `<c><v>%v</v></c>`, value); err != nil {
+				return err
+			}
+		default:
+			if _, err := io.WriteString(w.sheet, `<c t="inlineStr"><is><t>`); err != nil {
+				return err
+			}
+			if err := xml.EscapeText(w.sheet, []byte(fmt.Sprint(value))); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w.sheet, `</t></is></c>`); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := io.WriteString(w.sheet, `</row>`)
+	return err
+}
+
+func (w *xlsxExportWriter) Close() error {
+	if _, err := io.WriteString(w.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return w.zip.Close()
+}
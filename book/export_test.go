@@ -0,0 +1,176 @@
+package book
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	exportBooksQuery         = `SELECT * FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY id`
+	exportBooksByAuthorQuery = `SELECT * FROM "books" WHERE author ILIKE $1 AND "books"."deleted_at" IS NULL ORDER BY id`
+)
+
+func exportRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "title", "author", "isbn"}).
+		AddRow(1, nil, nil, nil, "Four Thousand Weeks", "Oliver Burkeman", "9781785038723").
+		AddRow(2, nil, nil, nil, "Atomic Habits, Tiny Changes", "James Clear", "9781847941831")
+}
+
+func TestExport(t *testing.T) {
+	t.Run("export selected columns as csv with bom given filters", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/books/export?format=csv&columns=title,isbn&bom=true&author=clear", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(exportBooksByAuthorQuery).WithArgs("%clear%").WillReturnRows(exportRows())
+
+		handler := NewHandler(gormDB)
+		err := handler.Export(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, utf8BOM+"title,isbn\nFour Thousand Weeks,9781785038723\n\"Atomic Habits, Tiny Changes\",9781847941831\n", response.Body.String())
+	})
+
+	t.Run("export wildcards and formulas as text given csv", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/books/export?columns=title,author&author=100%25_", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		rows := sqlmock.NewRows([]string{"id", "title", "author"}).AddRow(1, `=HYPERLINK("http://example.com")`, "-100%_")
+		mock.ExpectQuery(exportBooksByAuthorQuery).WithArgs(`%100\%\_%`).WillReturnRows(rows)
+
+		handler := NewHandler(gormDB)
+		err := handler.Export(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "title,author\n\"'=HYPERLINK(\"\"http://example.com\"\")\",'-100%_\n", response.Body.String())
+	})
+
+	t.Run("return error without download given error during query", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/books/export", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(exportBooksQuery).WillReturnError(errors.New("query error"))
+
+		handler := NewHandler(gormDB)
+		err := handler.Export(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+		assert.Empty(t, response.Header().Get(echo.HeaderContentDisposition))
+		assert.Contains(t, response.Header().Get(echo.HeaderContentType), echo.MIMEApplicationJSON)
+	})
+
+	t.Run("export books as ndjson", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/books/export?format=ndjson&columns=id,title", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(exportBooksQuery).WillReturnRows(exportRows())
+
+		handler := NewHandler(gormDB)
+		err := handler.Export(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "{\"id\":1,\"title\":\"Four Thousand Weeks\"}\n{\"id\":2,\"title\":\"Atomic Habits, Tiny Changes\"}\n", response.Body.String())
+	})
+
+	t.Run("export books as xlsx", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/books/export?format=xlsx", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(exportBooksQuery).WillReturnRows(exportRows())
+
+		handler := NewHandler(gormDB)
+		err := handler.Export(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		archive, err := zip.NewReader(bytes.NewReader(response.Body.Bytes()), int64(response.Body.Len()))
+		assert.NoError(t, err)
+		sheet, err := archive.Open("xl/worksheets/sheet1.xml")
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(sheet)
+		assert.Contains(t, string(content), `<row r="3"><c><v>2</v></c><c t="inlineStr"><is><t>Atomic Habits, Tiny Changes</t></is></c>`)
+	})
+
+	t.Run("return bad request given unknown column", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/books/export?columns=title,cost", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := handler.Export(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("return bad request given unknown format", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/books/export?format=pdf", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := handler.Export(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
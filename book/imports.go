@@ -0,0 +1,526 @@
+package book
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+	ImportFormatONIX   = "onix"
+
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+
+	asyncImportThreshold = 1 << 20
+	maxReportedErrors    = 1000
+	maxNDJSONLineSize    = 1 << 20
+	maxFinishedImports   = 100
+	importRetention      = 24 * time.Hour
+)
+
+var defaultImportMapping = map[string]string{
+	"title":        "title",
+	"author":       "author",
+	"isbn":         "isbn",
+	"publisher":    "publisher",
+	"price":        "price",
+	"currency":     "currency",
+	"category":     "category",
+	"format":       "format",
+	"weight_grams": "weight_grams",
+}
+
+type RowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+type ImportJob struct {
+	ID        string         `json:"id"`
+	Status    string         `json:"status"`
+	DryRun    bool           `json:"dry_run"`
+	Progress  int            `json:"progress"`
+	Processed int            `json:"processed"`
+	Created   int            `json:"created"`
+	Updated   int            `json:"updated"`
+	Failed    int            `json:"failed"`
+	Errors    []RowError     `json:"errors"`
+	Unmapped  map[string]int `json:"unmapped,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	TenantID  string         `json:"-"`
+}
+
+// importStore keeps import jobs in memory, so progress of a running job is
+// lost when the server restarts. Finished jobs are kept for importRetention,
+// and only the most recent maxFinishedImports of them.
+type importStore struct {
+	mu       sync.Mutex
+	jobs     map[string]*ImportJob
+	finished []finishedImport
+	now      func() time.Time
+}
+
+type finishedImport struct {
+	id string
+	at time.Time
+}
+
+func newImportStore() *importStore {
+	return &importStore{jobs: make(map[string]*ImportJob), now: time.Now}
+}
+
+func (s *importStore) add(job *ImportJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict()
+	s.jobs[job.ID] = job
+}
+
+func (s *importStore) get(id string) (ImportJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict()
+	job, ok := s.jobs[id]
+	if !ok {
+		return ImportJob{}, false
+	}
+	snapshot := *job
+	snapshot.Errors = append([]RowError(nil), job.Errors...)
+	if job.Unmapped != nil {
+		snapshot.Unmapped = make(map[string]int, len(job.Unmapped))
+		for field, count := range job.Unmapped {
+			snapshot.Unmapped[field] = count
+		}
+	}
+	return snapshot, true
+}
+
+func (s *importStore) update(job *ImportJob, fn func(job *ImportJob)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	running := job.Status == ImportStatusRunning
+	fn(job)
+	if running && job.Status != ImportStatusRunning {
+		s.finished = append(s.finished, finishedImport{id: job.ID, at: s.now()})
+		s.evict()
+	}
+}
+
+// evict removes finished jobs that are past their retention or beyond the
+// most recent maxFinishedImports. Callers hold s.mu.
+func (s *importStore) evict() {
+	expired := 0
+	for expired < len(s.finished) {
+		if len(s.finished)-expired <= maxFinishedImports && s.now().Sub(s.finished[expired].at) < importRetention {
+			break
+		}
+		delete(s.jobs, s.finished[expired].id)
+		expired++
+	}
+	s.finished = s.finished[expired:]
+}
+
+type importRow struct {
+	number   int
+	fields   map[string]string
+	unmapped []string
+	err      error
+	read     int64
+}
+
+type countingReader struct {
+	reader io.Reader
+	read   int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+func (handler *handler) Import(c echo.Context) error {
+	logger := middleware.GetLogger(c)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "File is required"})
+	}
+
+	format := importFormat(c.FormValue("format"), fileHeader.Filename)
+	if format == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Format must be csv, ndjson or onix"})
+	}
+
+	mapping := defaultImportMapping
+	if value := c.FormValue("mapping"); value != "" {
+		if mapping, err = parseImportMapping(value); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	dryRun, _ := strconv.ParseBool(c.FormValue("dry_run"))
+	async, err := strconv.ParseBool(c.FormValue("async"))
+	if err != nil {
+		async = fileHeader.Size > asyncImportThreshold
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		logger.Error("failed to open import file", zap.Error(err))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read file"})
+	}
+	defer file.Close()
+
+	job := &ImportJob{ID: uuid.New().String(), Status: ImportStatusRunning, DryRun: dryRun, Errors: []RowError{}, TenantID: middleware.GetTenantID(c)}
+	handler.imports.add(job)
+
+	locales := middleware.GetLocales(c)
+	if !async {
+		handler.runImport(c.Request().Context(), logger, locales, job, file, fileHeader.Size, format, mapping)
+		snapshot, _ := handler.imports.get(job.ID)
+		if snapshot.Status == ImportStatusFailed {
+			return c.JSON(http.StatusBadRequest, snapshot)
+		}
+		return c.JSON(http.StatusOK, snapshot)
+	}
+
+	// The multipart file is removed when the request ends, so keep a copy
+	// for the background job.
+	tmp, err := os.CreateTemp("", "book-import-*")
+	if err != nil {
+		logger.Error("failed to create import file", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store file"})
+	}
+	if _, err := io.Copy(tmp, file); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		logger.Error("failed to copy import file", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store file"})
+	}
+
+	// The job outlives the request but still imports for its tenant.
+	ctx := context.WithoutCancel(c.Request().Context())
+	go func() {
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			handler.imports.update(job, func(job *ImportJob) {
+				job.Status, job.Error = ImportStatusFailed, err.Error()
+			})
+			return
+		}
+		handler.runImport(ctx, logger, locales, job, tmp, fileHeader.Size, format, mapping)
+	}()
+
+	snapshot, _ := handler.imports.get(job.ID)
+	return c.JSON(http.StatusAccepted, snapshot)
+}
+
+func (handler *handler) GetImport(c echo.Context) error {
+	job, ok := handler.imports.get(c.Param("id"))
+	if !ok || job.TenantID != middleware.GetTenantID(c) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Import not found"})
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+func (handler *handler) runImport(ctx context.Context, logger *zap.Logger, locales []string, job *ImportJob, file io.Reader, size int64, format string, mapping map[string]string) {
+	reader := &countingReader{reader: file}
+	rows := make(chan importRow)
+	parseErr := make(chan error, 1)
+
+	go func() {
+		defer close(rows)
+		switch format {
+		case ImportFormatCSV:
+			parseErr <- readCSV(reader, mapping, rows)
+		case ImportFormatONIX:
+			parseErr <- readONIX(reader, rows)
+		default:
+			parseErr <- readNDJSON(reader, mapping, rows)
+		}
+	}()
+
+	validator := newValidator()
+	seen := make(map[string]bool)
+
+	for row := range rows {
+		created, err := false, row.err
+		if err == nil {
+			var book Book
+			if book, err = bookFromFields(row.fields); err == nil {
+				created, err = handler.importBook(ctx, validator, book, job.DryRun, seen)
+			}
+		}
+
+		handler.imports.update(job, func(job *ImportJob) {
+			job.Processed++
+			for _, field := range row.unmapped {
+				if job.Unmapped == nil {
+					job.Unmapped = make(map[string]int)
+				}
+				job.Unmapped[field]++
+			}
+			if size > 0 {
+				job.Progress = int(min(row.read*100/size, 99))
+			}
+			switch {
+			case err != nil:
+				job.Failed++
+				if len(job.Errors) < maxReportedErrors {
+					job.Errors = append(job.Errors, RowError{Row: row.number, Error: i18n.Message(err, locales)})
+				}
+			case created:
+				job.Created++
+			default:
+				job.Updated++
+			}
+		})
+	}
+
+	err := <-parseErr
+	handler.imports.update(job, func(job *ImportJob) {
+		if err != nil {
+			job.Status, job.Error = ImportStatusFailed, err.Error()
+			return
+		}
+		job.Status, job.Progress = ImportStatusCompleted, 100
+	})
+	if err != nil {
+		logger.Error("failed to import books", zap.String("import", job.ID), zap.Error(err))
+		return
+	}
+	logger.Info("books imported", zap.String("import", job.ID), zap.Bool("dry_run", job.DryRun))
+}
+
+// onISBNConflict skips an insert of a book whose ISBN the tenant already
+// has, using the unique index on tenant_id and isbn.
+var onISBNConflict = clause.OnConflict{
+	Columns:     []clause.Column{{Name: "tenant_id"}, {Name: "isbn"}},
+	TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "deleted_at IS NULL"}}},
+	DoNothing:   true,
+}
+
+// importBook validates a row and upserts it on ISBN. In a dry run nothing is
+// written, and seen tracks ISBNs earlier rows would have created.
+func (handler *handler) importBook(ctx context.Context, validator *CustomValidator, book Book, dryRun bool, seen map[string]bool) (bool, error) {
+	if err := validator.Validate(book); err != nil {
+		return false, err
+	}
+
+	db := handler.db.WithContext(ctx)
+	if dryRun {
+		err := db.Where("isbn = ?", book.ISBN).First(&Book{}).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, err
+		}
+		found := err == nil || seen[book.ISBN]
+		seen[book.ISBN] = true
+		return !found, nil
+	}
+
+	// The insert comes first and is skipped on conflict, so two imports of
+	// the same ISBN cannot both create the book. The one that loses updates
+	// the book the other created.
+	created := false
+	existing := Book{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(onISBNConflict).Create(&book)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			created = true
+			return recordInitialStock(tx, book)
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("isbn = ?", book.ISBN).First(&existing).Error; err != nil {
+			return err
+		}
+		existing.Title, existing.Author = book.Title, book.Author
+		existing.Publisher, existing.Price, existing.Currency = book.Publisher, book.Price, book.Currency
+		if book.Category != "" {
+			existing.Category = book.Category
+		}
+		if book.Format != "" {
+			existing.Format = book.Format
+		}
+		if book.WeightGrams != 0 {
+			existing.WeightGrams = book.WeightGrams
+		}
+		return saveBook(tx, &existing, existing.Stock)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if created {
+		handler.events.Publish(EventBookCreated, book)
+	} else {
+		handler.events.Publish(EventBookUpdated, existing)
+	}
+	return created, nil
+}
+
+func bookFromFields(fields map[string]string) (Book, error) {
+	book := Book{
+		Title:     fields["title"],
+		Author:    fields["author"],
+		ISBN:      fields["isbn"],
+		Publisher: fields["publisher"],
+		Currency:  strings.ToUpper(fields["currency"]),
+		Category:  fields["category"],
+		Format:    strings.ToLower(fields["format"]),
+	}
+	if price := fields["price"]; price != "" {
+		value, err := strconv.ParseFloat(price, 64)
+		if err != nil {
+			return Book{}, fmt.Errorf("Invalid price %q", price)
+		}
+		book.Price = value
+	}
+	if weight := fields["weight_grams"]; weight != "" {
+		value, err := strconv.Atoi(weight)
+		if err != nil {
+			return Book{}, fmt.Errorf("Invalid weight_grams %q", weight)
+		}
+		book.WeightGrams = value
+	}
+	return book, nil
+}
+
+func readCSV(reader *countingReader, mapping map[string]string, rows chan<- importRow) error {
+	records := csv.NewReader(reader)
+	records.FieldsPerRecord = -1
+
+	header, err := records.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read csv header: %w", err)
+	}
+	columns := make(map[int]string)
+	for i, name := range header {
+		if field, ok := mapping[normalizeColumn(name)]; ok {
+			columns[i] = field
+		}
+	}
+
+	for number := 1; ; number++ {
+		record, err := records.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read csv row %d: %w", number, err)
+		}
+
+		fields := make(map[string]string, len(columns))
+		for i, field := range columns {
+			if i < len(record) {
+				fields[field] = strings.TrimSpace(record[i])
+			}
+		}
+		rows <- importRow{number: number, fields: fields, read: reader.read}
+	}
+}
+
+func readNDJSON(reader *countingReader, mapping map[string]string, rows chan<- importRow) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxNDJSONLineSize)
+
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// Numbers are kept as written, so a numeric ISBN is not turned
+		// into a float such as 9.781847941831e+12.
+		values := map[string]interface{}{}
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&values); err != nil || decoder.More() {
+			// A malformed line fails only its own row.
+			rows <- importRow{number: number, err: errors.New("Invalid JSON"), read: reader.read}
+			continue
+		}
+
+		fields := make(map[string]string, len(values))
+		for key, value := range values {
+			if field, ok := mapping[normalizeColumn(key)]; ok && value != nil {
+				fields[field] = strings.TrimSpace(fmt.Sprint(value))
+			}
+		}
+		rows <- importRow{number: number, fields: fields, read: reader.read}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read ndjson: %w", err)
+	}
+	return nil
+}
+
+func parseImportMapping(value string) (map[string]string, error) {
+	raw := map[string]string{}
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, errors.New("Mapping must be a JSON object of column to field")
+	}
+
+	mapping := make(map[string]string, len(raw))
+	for column, field := range raw {
+		if _, ok := defaultImportMapping[field]; !ok {
+			return nil, fmt.Errorf("Unknown book field %q in mapping", field)
+		}
+		mapping[normalizeColumn(column)] = field
+	}
+	return mapping, nil
+}
+
+func importFormat(format string, filename string) string {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".csv":
+			format = ImportFormatCSV
+		case ".ndjson", ".jsonl":
+			format = ImportFormatNDJSON
+		case ".xml", ".onix":
+			format = ImportFormatONIX
+		}
+	}
+	switch strings.ToLower(format) {
+	case ImportFormatCSV:
+		return ImportFormatCSV
+	case ImportFormatNDJSON, "jsonl":
+		return ImportFormatNDJSON
+	case ImportFormatONIX:
+		return ImportFormatONIX
+	}
+	return ""
+}
+
+func normalizeColumn(name string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, utf8BOM)))
+}
@@ -0,0 +1,281 @@
+package book
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	getBookByISBNQuery  = `SELECT * FROM "books" WHERE isbn = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2`
+	lockBookByISBNQuery = `SELECT * FROM "books" WHERE isbn = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2 FOR UPDATE`
+	importBookQuery     = `INSERT INTO "books" ("created_at","updated_at","deleted_at","title","author","isbn","publisher","price","currency","cover_version","stock","category","format","weight_grams","width_mm","height_mm","depth_mm","low_stock_threshold","publication_date","status","subtitle","description","translations","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24) ON CONFLICT ("tenant_id","isbn") WHERE deleted_at IS NULL DO NOTHING RETURNING "id"`
+)
+
+func newImportRequest(t testing.TB, filename string, content string, fields map[string]string) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", filename)
+	part.Write([]byte(content))
+	for key, value := range fields {
+		writer.WriteField(key, value)
+	}
+	writer.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/imports", body)
+	request.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	return request
+}
+
+func TestImport(t *testing.T) {
+	t.Run("report created and updated rows given csv dry run with header mapping", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		content := "Book Title,Writer,EAN\n" +
+			"Atomic Habits,James Clear,9781847941831\n" +
+			"Four Thousand Weeks,Oliver Burkeman,9781785038723\n" +
+			"The Alchemist,Paulo Coelho,007\n"
+		request := newImportRequest(t, "books.csv", content, map[string]string{
+			"dry_run": "true",
+			"mapping": `{"Book Title": "title", "Writer": "author", "EAN": "isbn"}`,
+		})
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByISBNQuery).WithArgs("9781847941831", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "isbn"}).AddRow(1, "Atomic Habit", "James Clear", "9781847941831"))
+		mock.ExpectQuery(getBookByISBNQuery).WithArgs("9781785038723", 1).
+			WillReturnError(gorm.ErrRecordNotFound)
+
+		handler := NewHandler(gormDB)
+		err := handler.Import(c)
+
+		job := ImportJob{}
+		json.Unmarshal(response.Body.Bytes(), &job)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, ImportStatusCompleted, job.Status)
+		assert.True(t, job.DryRun)
+		assert.Equal(t, 3, job.Processed)
+		assert.Equal(t, 1, job.Created)
+		assert.Equal(t, 1, job.Updated)
+		assert.Equal(t, 1, job.Failed)
+		assert.Equal(t, 3, job.Errors[0].Row)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("insert new books given ndjson import", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		content := `{"title": "Atomic Habits", "author": "James Clear", "isbn": 9781847941831}` + "\n" +
+			`{"title": "broken"` + "\n"
+		request := newImportRequest(t, "books.ndjson", content, nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(importBookQuery).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "Atomic Habits", "James Clear", "9781847941831", "", 0.0, "", "", 0, "", "", 0, 0, 0, 0, 0, nil, "", "", "", nil, "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		err := handler.Import(c)
+
+		job := ImportJob{}
+		json.Unmarshal(response.Body.Bytes(), &job)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, 1, job.Created)
+		assert.Equal(t, []RowError{{Row: 2, Error: "Invalid JSON"}}, job.Errors)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("update book created by another import given isbn conflict", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		request := newImportRequest(t, "books.csv", "title,author,isbn,price\nAtomic Habits,James Clear,9781847941831,18.99\n", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(importBookQuery).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(lockBookByISBNQuery).WithArgs("9781847941831", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "isbn", "stock"}).AddRow(1, "Atomic Habit", "James Clear", "9781847941831", 4))
+		mock.ExpectExec(updateBookQuery).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		err := handler.Import(c)
+
+		job := ImportJob{}
+		json.Unmarshal(response.Body.Bytes(), &job)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, 0, job.Created)
+		assert.Equal(t, 1, job.Updated)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("report row error in the request language given invalid row", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		request := newImportRequest(t, "books.csv", "title,author,isbn\n,James Clear,9781847941831\n", map[string]string{"dry_run": "true"})
+		request.Header.Set("Accept-Language", "th")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := middleware.Localize([]string{"en", "th"}, "en")(handler.Import)(c)
+
+		job := ImportJob{}
+		json.Unmarshal(response.Body.Bytes(), &job)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []RowError{{Row: 1, Error: "ต้องระบุ title"}}, job.Errors)
+	})
+
+	t.Run("run import in the background given async", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		request := newImportRequest(t, "books.csv", "title,author,isbn\n,,\n", map[string]string{"async": "true"})
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := handler.Import(c)
+
+		job := ImportJob{}
+		json.Unmarshal(response.Body.Bytes(), &job)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusAccepted, response.Code)
+		assert.Eventually(t, func() bool {
+			job, _ := handler.imports.get(job.ID)
+			return job.Status == ImportStatusCompleted && job.Failed == 1
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("return bad request given unknown format", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		request := newImportRequest(t, "books.txt", "", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := handler.Import(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("return bad request given mapping to unknown field", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		request := newImportRequest(t, "books.csv", "", map[string]string{"mapping": `{"Cost": "cost"}`})
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := handler.Import(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestGetImport(t *testing.T) {
+	t.Run("return not found given unknown import", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetPath("/imports/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("unknown")
+
+		handler := NewHandler(nil)
+		err := handler.GetImport(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
+
+func TestImportStore(t *testing.T) {
+	finish := func(store *importStore, id string) {
+		job := &ImportJob{ID: id, Status: ImportStatusRunning}
+		store.add(job)
+		store.update(job, func(job *ImportJob) { job.Status = ImportStatusCompleted })
+	}
+
+	t.Run("evict finished job given retention has passed", func(t *testing.T) {
+		now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+		store := newImportStore()
+		store.now = func() time.Time { return now }
+		finish(store, "old")
+		running := &ImportJob{ID: "running", Status: ImportStatusRunning}
+		store.add(running)
+
+		now = now.Add(importRetention)
+		_, old := store.get("old")
+		_, stillRunning := store.get("running")
+
+		assert.False(t, old)
+		assert.True(t, stillRunning)
+	})
+
+	t.Run("keep most recent finished jobs given too many", func(t *testing.T) {
+		store := newImportStore()
+		for i := 0; i <= maxFinishedImports; i++ {
+			finish(store, strconv.Itoa(i))
+		}
+
+		_, first := store.get("0")
+		_, last := store.get(strconv.Itoa(maxFinishedImports))
+
+		assert.False(t, first)
+		assert.True(t, last)
+		assert.Len(t, store.jobs, maxFinishedImports)
+	})
+}
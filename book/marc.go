@@ -0,0 +1,186 @@
+package book
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/marc"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	ExportFormatMARC    = "marc"
+	ExportFormatMARCXML = "marcxml"
+
+	mimeMARC = "application/marc"
+)
+
+var nonFilingArticles = []string{"the ", "an ", "a "}
+
+// marcRecord renders a book as a MARC21 bibliographic record with the ISBN
+// in 020, the first author in 100, the title in 245 and further authors in 700.
+func marcRecord(book Book) marc.Record {
+	record := marc.NewBibliographicRecord()
+	record.AddControlField("001", strconv.FormatUint(uint64(book.ID), 10))
+	if !book.UpdatedAt.IsZero() {
+		record.AddControlField("005", book.UpdatedAt.UTC().Format("20060102150405.0"))
+	}
+	record.AddControlField("008", marcFixedData(book))
+
+	isbn := []marc.Subfield{{Code: 'a', Value: book.ISBN}}
+	if book.Currency != "" {
+		isbn = append(isbn, marc.Subfield{Code: 'c', Value: fmt.Sprintf("%s%.2f", book.Currency, book.Price)})
+	}
+	record.AddDataField("020", ' ', ' ', isbn...)
+
+	authors := book.Authors()
+	if len(authors) > 0 {
+		indicator, name := invertName(authors[0])
+		record.AddDataField("100", indicator, ' ', marc.Subfield{Code: 'a', Value: name + ","}, marc.Subfield{Code: 'e', Value: "author."})
+	}
+
+	titleIndicator := byte('0')
+	if len(authors) > 0 {
+		titleIndicator = '1'
+	}
+	title := []marc.Subfield{{Code: 'a', Value: book.Title}}
+	if book.Author != "" {
+		title[0].Value += " /"
+		title = append(title, marc.Subfield{Code: 'c', Value: strings.TrimSuffix(book.Author, ".") + "."})
+	}
+	record.AddDataField("245", titleIndicator, nonFilingCharacters(book.Title), title...)
+
+	if book.Publisher != "" {
+		record.AddDataField("264", ' ', '1', marc.Subfield{Code: 'b', Value: book.Publisher})
+	}
+
+	for _, author := range authors[min(1, len(authors)):] {
+		indicator, name := invertName(author)
+		record.AddDataField("700", indicator, ' ', marc.Subfield{Code: 'a', Value: name + ","}, marc.Subfield{Code: 'e', Value: "author."})
+	}
+
+	return record
+}
+
+// marcFixedData builds the 40 character 008 field. Only the date entered is
+// known; everything else is marked as unknown or left to the fill character.
+func marcFixedData(book Book) string {
+	entered := "000000"
+	if !book.CreatedAt.IsZero() {
+		entered = book.CreatedAt.UTC().Format("060102")
+	}
+	return entered + "nuuuuuuuuxx " + strings.Repeat("|", 17) + "und d"
+}
+
+// invertName turns "Bill Burnett" into "Burnett, Bill" and returns the first
+// indicator for a personal name entry: 1 for surname, 0 for forename only.
+func invertName(name string) (byte, string) {
+	i := strings.LastIndex(name, " ")
+	if i < 0 {
+		return '0', name
+	}
+	return '1', name[i+1:] + ", " + name[:i]
+}
+
+func nonFilingCharacters(title string) byte {
+	lower := strings.ToLower(title)
+	for _, article := range nonFilingArticles {
+		if strings.HasPrefix(lower, article) {
+			return byte('0' + len(article))
+		}
+	}
+	return '0'
+}
+
+func (handler *handler) GetMARC(c echo.Context) error {
+	book := Book{}
+	id := c.Param("id")
+	logger := middleware.GetLogger(c)
+
+	if err := handler.db.WithContext(c.Request().Context()).First(&book, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	record := marcRecord(book)
+	switch c.QueryParam("format") {
+	case "", ExportFormatMARC:
+		data, err := record.MarshalBinary()
+		if err != nil {
+			logger.Error("failed to encode marc record", zap.String("id", id), zap.Error(err))
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.Blob(http.StatusOK, mimeMARC, data)
+	case ExportFormatMARCXML:
+		data, err := xml.Marshal(record)
+		if err != nil {
+			logger.Error("failed to encode marcxml record", zap.String("id", id), zap.Error(err))
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.Blob(http.StatusOK, echo.MIMEApplicationXMLCharsetUTF8, []byte(xml.Header+marcCollectionStart+string(data)+marcCollectionEnd))
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Format must be marc or marcxml"})
+	}
+}
+
+var (
+	marcCollectionStart = fmt.Sprintf("<collection xmlns=%q>", marc.Namespace)
+	marcCollectionEnd   = "</collection>\n"
+)
+
+type marcExportWriter struct {
+	out io.Writer
+}
+
+func (w *marcExportWriter) WriteHeader() error {
+	return nil
+}
+
+func (w *marcExportWriter) WriteBook(book Book) error {
+	data, err := marcRecord(book).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = w.out.Write(data)
+	return err
+}
+
+func (w *marcExportWriter) Close() error {
+	return nil
+}
+
+type marcXMLExportWriter struct {
+	out     io.Writer
+	encoder *xml.Encoder
+}
+
+func newMARCXMLExportWriter(out io.Writer) *marcXMLExportWriter {
+	return &marcXMLExportWriter{out: out, encoder: xml.NewEncoder(out)}
+}
+
+func (w *marcXMLExportWriter) WriteHeader() error {
+	_, err := io.WriteString(w.out, xml.Header+marcCollectionStart)
+	return err
+}
+
+func (w *marcXMLExportWriter) WriteBook(book Book) error {
+	return w.encoder.Encode(marcRecord(book))
+}
+
+func (w *marcXMLExportWriter) Close() error {
+	if err := w.encoder.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.out, marcCollectionEnd)
+	return err
+}
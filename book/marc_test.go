@@ -0,0 +1,118 @@
+package book
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestMARCRecord(t *testing.T) {
+	book := Book{Title: "The Tree of a Thousand Loves", Author: "Bill Burnett and Dave Evans", ISBN: "9781101875322", Publisher: "Knopf", Price: 25, Currency: "USD"}
+	book.ID = 3
+
+	record := marcRecord(book)
+
+	tags := []string{}
+	for _, field := range record.Fields {
+		tags = append(tags, field.Tag)
+	}
+	assert.Equal(t, []string{"001", "008", "020", "100", "245", "264", "700"}, tags)
+	assert.Equal(t, "3", record.Fields[0].Value)
+	assert.Len(t, record.Fields[1].Value, 40)
+	assert.Equal(t, "USD25.00", record.Fields[2].Subfields[1].Value)
+	assert.Equal(t, "Burnett, Bill,", record.Fields[3].Subfields[0].Value)
+	assert.Equal(t, byte('1'), record.Fields[4].Indicator1)
+	assert.Equal(t, byte('4'), record.Fields[4].Indicator2)
+	assert.Equal(t, "The Tree of a Thousand Loves /", record.Fields[4].Subfields[0].Value)
+	assert.Equal(t, "Evans, Dave,", record.Fields[6].Subfields[0].Value)
+}
+
+func TestGetMARC(t *testing.T) {
+	t.Run("get marc21 record given a book exists in the database", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetPath("/books/:id/marc")
+		c.SetParamNames("id")
+		c.SetParamValues("3")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		row := sqlmock.NewRows([]string{"ID", "CreatedAt", "UpdatedAt", "DeletedAt", "title", "author", "isbn"})
+		row.AddRow(3, nil, nil, nil, "The Tree of a Thousand Loves", "Sukanya Kittikhun", "9786164453819")
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("3", 1).WillReturnRows(row)
+
+		handler := NewHandler(gormDB)
+		err := handler.GetMARC(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, mimeMARC, response.Header().Get(echo.HeaderContentType))
+		assert.True(t, strings.HasSuffix(response.Body.String(), "\x1e\x1d"))
+		assert.Contains(t, response.Body.String(), "\x1fa9786164453819\x1e")
+	})
+
+	t.Run("get marcxml record given format", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?format=marcxml", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetPath("/books/:id/marc")
+		c.SetParamNames("id")
+		c.SetParamValues("3")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		row := sqlmock.NewRows([]string{"ID", "CreatedAt", "UpdatedAt", "DeletedAt", "title", "author", "isbn"})
+		row.AddRow(3, nil, nil, nil, "The Tree of a Thousand Loves", "Sukanya Kittikhun", "9786164453819")
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("3", 1).WillReturnRows(row)
+
+		handler := NewHandler(gormDB)
+		err := handler.GetMARC(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `<collection xmlns="http://www.loc.gov/MARC21/slim"><record><leader>`)
+		assert.Contains(t, response.Body.String(), `<datafield tag="100" ind1="1" ind2=" "><subfield code="a">Kittikhun, Sukanya,</subfield>`)
+	})
+
+	t.Run("get marc record given book does not exist", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetPath("/books/:id/marc")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("1", 1).WillReturnError(gorm.ErrRecordNotFound)
+
+		handler := NewHandler(gormDB)
+		err := handler.GetMARC(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
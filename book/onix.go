@@ -0,0 +1,342 @@
+package book
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	onixNamespace  = "http://ns.editeur.org/onix/3.0/reference"
+	onixSenderName = "Book Store API"
+
+	onixIDTypeISBN10       = "02"
+	onixIDTypeGTIN13       = "03"
+	onixIDTypeISBN13       = "15"
+	onixTitleTypeDistinct  = "01"
+	onixTitleLevelProduct  = "01"
+	onixRoleAuthor         = "A01"
+	onixPublishingRoleMain = "01"
+)
+
+// onixNode is a generic ONIX element. Each Product is decoded into a tree of
+// nodes so that every element the mapping does not use can be reported.
+type onixNode struct {
+	XMLName  xml.Name
+	Text     string     `xml:",chardata"`
+	Children []onixNode `xml:",any"`
+}
+
+func (node *onixNode) children(name string) []*onixNode {
+	children := []*onixNode{}
+	if node == nil {
+		return children
+	}
+	for i := range node.Children {
+		if node.Children[i].XMLName.Local == name {
+			children = append(children, &node.Children[i])
+		}
+	}
+	return children
+}
+
+func (node *onixNode) child(name string) *onixNode {
+	if children := node.children(name); len(children) > 0 {
+		return children[0]
+	}
+	return nil
+}
+
+type onixMapper struct {
+	used map[*onixNode]bool
+}
+
+// text returns the trimmed text of the named child and marks it as mapped.
+func (m *onixMapper) text(node *onixNode, name string) string {
+	child := node.child(name)
+	if child == nil {
+		return ""
+	}
+	m.used[child] = true
+	return strings.TrimSpace(child.Text)
+}
+
+// pick returns the first child whose code element has the wanted value,
+// falling back to the first child.
+func (m *onixMapper) pick(node *onixNode, name string, code string, want string) *onixNode {
+	children := node.children(name)
+	for _, child := range children {
+		if m.text(child, code) == want {
+			return child
+		}
+	}
+	if len(children) > 0 {
+		return children[0]
+	}
+	return nil
+}
+
+func (m *onixMapper) unmapped(node *onixNode, path string, report *[]string) {
+	if len(node.Children) == 0 {
+		if !m.used[node] && strings.TrimSpace(node.Text) != "" {
+			*report = append(*report, path)
+		}
+		return
+	}
+	for i := range node.Children {
+		child := &node.Children[i]
+		m.unmapped(child, path+"/"+child.XMLName.Local, report)
+	}
+}
+
+func mapONIXProduct(product *onixNode) (map[string]string, []string) {
+	m := &onixMapper{used: make(map[*onixNode]bool)}
+	fields := map[string]string{}
+
+	m.text(product, "RecordReference")
+	m.text(product, "NotificationType")
+
+	isbns := map[string]string{}
+	for _, identifier := range product.children("ProductIdentifier") {
+		idType := m.text(identifier, "ProductIDType")
+		if idType == onixIDTypeISBN13 || idType == onixIDTypeGTIN13 || idType == onixIDTypeISBN10 {
+			isbns[idType] = strings.ReplaceAll(m.text(identifier, "IDValue"), "-", "")
+		}
+	}
+	for _, idType := range []string{onixIDTypeISBN13, onixIDTypeGTIN13, onixIDTypeISBN10} {
+		if isbn, ok := isbns[idType]; ok {
+			fields["isbn"] = isbn
+			break
+		}
+	}
+
+	detail := product.child("DescriptiveDetail")
+	title := m.pick(detail, "TitleDetail", "TitleType", onixTitleTypeDistinct)
+	element := m.pick(title, "TitleElement", "TitleElementLevel", onixTitleLevelProduct)
+	if text := m.text(element, "TitleText"); text != "" {
+		fields["title"] = text
+	} else {
+		fields["title"] = strings.TrimSpace(m.text(element, "TitlePrefix") + " " + m.text(element, "TitleWithoutPrefix"))
+	}
+
+	contributors := detail.children("Contributor")
+	authors := []string{}
+	for _, contributor := range contributors {
+		if m.text(contributor, "ContributorRole") == onixRoleAuthor {
+			authors = append(authors, m.contributorName(contributor))
+		}
+	}
+	if len(authors) == 0 {
+		for _, contributor := range contributors {
+			authors = append(authors, m.contributorName(contributor))
+		}
+	}
+	fields["author"] = strings.Join(authors, "; ")
+
+	publisher := m.pick(product.child("PublishingDetail"), "Publisher", "PublishingRole", onixPublishingRoleMain)
+	fields["publisher"] = m.text(publisher, "PublisherName")
+
+	if price := product.child("ProductSupply").child("SupplyDetail").child("Price"); price != nil {
+		m.text(price, "PriceType")
+		fields["price"] = m.text(price, "PriceAmount")
+		fields["currency"] = m.text(price, "CurrencyCode")
+	}
+
+	report := []string{}
+	m.unmapped(product, "Product", &report)
+	return fields, report
+}
+
+func (m *onixMapper) contributorName(contributor *onixNode) string {
+	m.text(contributor, "SequenceNumber")
+	m.text(contributor, "ContributorRole")
+	if name := m.text(contributor, "PersonName"); name != "" {
+		return name
+	}
+	if keyNames := m.text(contributor, "KeyNames"); keyNames != "" {
+		return strings.TrimSpace(m.text(contributor, "NamesBeforeKey") + " " + keyNames)
+	}
+	return m.text(contributor, "CorporateName")
+}
+
+// readONIX streams Product records out of an ONIX 3.0 message with reference
+// tags. Only one Product is held in memory at a time.
+func readONIX(reader *countingReader, rows chan<- importRow) error {
+	decoder := xml.NewDecoder(reader)
+
+	for number := 1; ; {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read onix: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "product":
+			return errors.New("ONIX short tags are not supported, use reference tags")
+		case "Product":
+			product := onixNode{}
+			if err := decoder.DecodeElement(&product, &start); err != nil {
+				return fmt.Errorf("failed to read onix product %d: %w", number, err)
+			}
+			fields, unmapped := mapONIXProduct(&product)
+			rows <- importRow{number: number, fields: fields, unmapped: unmapped, read: reader.read}
+			number++
+		}
+	}
+}
+
+type onixExportWriter struct {
+	out     io.Writer
+	encoder *xml.Encoder
+	now     func() time.Time
+}
+
+type onixHeader struct {
+	XMLName      xml.Name `xml:"Header"`
+	SenderName   string   `xml:"Sender>SenderName"`
+	SentDateTime string   `xml:"SentDateTime"`
+}
+
+type onixProductIdentifier struct {
+	ProductIDType string
+	IDValue       string
+}
+
+type onixTitleDetail struct {
+	TitleType    string
+	TitleElement struct {
+		TitleElementLevel string
+		TitleText         string
+	}
+}
+
+type onixContributor struct {
+	SequenceNumber  int
+	ContributorRole string
+	PersonName      string
+}
+
+type onixPublisher struct {
+	PublishingRole string
+	PublisherName  string
+}
+
+type onixPrice struct {
+	PriceType    string
+	PriceAmount  string
+	CurrencyCode string
+}
+
+type onixSupplyDetail struct {
+	Supplier struct {
+		SupplierRole string
+		SupplierName string
+	}
+	ProductAvailability string
+	Price               onixPrice
+}
+
+type onixProduct struct {
+	XMLName           xml.Name `xml:"Product"`
+	RecordReference   string
+	NotificationType  string
+	ProductIdentifier onixProductIdentifier
+	DescriptiveDetail struct {
+		ProductComposition string
+		ProductForm        string
+		TitleDetail        onixTitleDetail
+		Contributor        []onixContributor
+	}
+	PublishingDetail *struct {
+		Publisher onixPublisher
+	} `xml:",omitempty"`
+	ProductSupply *struct {
+		SupplyDetail onixSupplyDetail
+	} `xml:",omitempty"`
+}
+
+func newONIXExportWriter(out io.Writer) *onixExportWriter {
+	return &onixExportWriter{out: out, encoder: xml.NewEncoder(out), now: time.Now}
+}
+
+func (w *onixExportWriter) WriteHeader() error {
+	if _, err := fmt.Fprintf(w.out, "%s<ONIXMessage release=\"3.0\" xmlns=%q>", xml.Header, onixNamespace); err != nil {
+		return err
+	}
+	return w.encoder.Encode(onixHeader{
+		SenderName:   onixSenderName,
+		SentDateTime: w.now().UTC().Format("20060102T1504Z"),
+	})
+}
+
+func (w *onixExportWriter) WriteBook(book Book) error {
+	return w.encoder.Encode(onixProductFromBook(book))
+}
+
+func (w *onixExportWriter) Close() error {
+	if err := w.encoder.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.out, "</ONIXMessage>\n")
+	return err
+}
+
+func onixProductFromBook(book Book) onixProduct {
+	product := onixProduct{
+		RecordReference:  fmt.Sprintf("book-%d", book.ID),
+		NotificationType: "03",
+		ProductIdentifier: onixProductIdentifier{
+			ProductIDType: onixIDTypeISBN13,
+			IDValue:       book.ISBN,
+		},
+	}
+	if len(book.ISBN) == 10 {
+		product.ProductIdentifier.ProductIDType = onixIDTypeISBN10
+	}
+
+	product.DescriptiveDetail.ProductComposition = "00"
+	product.DescriptiveDetail.ProductForm = "00"
+	product.DescriptiveDetail.TitleDetail.TitleType = onixTitleTypeDistinct
+	product.DescriptiveDetail.TitleDetail.TitleElement.TitleElementLevel = onixTitleLevelProduct
+	product.DescriptiveDetail.TitleDetail.TitleElement.TitleText = book.Title
+	for i, author := range book.Authors() {
+		product.DescriptiveDetail.Contributor = append(product.DescriptiveDetail.Contributor, onixContributor{
+			SequenceNumber:  i + 1,
+			ContributorRole: onixRoleAuthor,
+			PersonName:      author,
+		})
+	}
+
+	if book.Publisher != "" {
+		product.PublishingDetail = &struct {
+			Publisher onixPublisher
+		}{Publisher: onixPublisher{PublishingRole: onixPublishingRoleMain, PublisherName: book.Publisher}}
+	}
+
+	if book.Currency != "" {
+		supply := onixSupplyDetail{ProductAvailability: "20"}
+		supply.Supplier.SupplierRole = "00"
+		supply.Supplier.SupplierName = onixSenderName
+		supply.Price = onixPrice{
+			PriceType:    "02",
+			PriceAmount:  strconv.FormatFloat(book.Price, 'f', 2, 64),
+			CurrencyCode: book.Currency,
+		}
+		product.ProductSupply = &struct {
+			SupplyDetail onixSupplyDetail
+		}{SupplyDetail: supply}
+	}
+
+	return product
+}
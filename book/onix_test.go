@@ -0,0 +1,150 @@
+package book
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const onixMessage = `<?xml version="1.0" encoding="UTF-8"?>
+<ONIXMessage release="3.0" xmlns="http://ns.editeur.org/onix/3.0/reference">
+  <Header><Sender><SenderName>Penguin</SenderName></Sender><SentDateTime>20261018</SentDateTime></Header>
+  <Product>
+    <RecordReference>com.penguin.9781101875322</RecordReference>
+    <NotificationType>03</NotificationType>
+    <ProductIdentifier><ProductIDType>01</ProductIDType><IDName>Internal</IDName><IDValue>P-1</IDValue></ProductIdentifier>
+    <ProductIdentifier><ProductIDType>15</ProductIDType><IDValue>978-1101875322</IDValue></ProductIdentifier>
+    <DescriptiveDetail>
+      <ProductComposition>00</ProductComposition>
+      <ProductForm>BC</ProductForm>
+      <TitleDetail>
+        <TitleType>01</TitleType>
+        <TitleElement>
+          <TitleElementLevel>01</TitleElementLevel>
+          <TitleText>Designing Your Life</TitleText>
+          <Subtitle>How to Build a Well-Lived, Joyful Life</Subtitle>
+        </TitleElement>
+      </TitleDetail>
+      <Contributor><SequenceNumber>1</SequenceNumber><ContributorRole>A01</ContributorRole><PersonName>Bill Burnett</PersonName></Contributor>
+      <Contributor><SequenceNumber>2</SequenceNumber><ContributorRole>A01</ContributorRole><NamesBeforeKey>Dave</NamesBeforeKey><KeyNames>Evans</KeyNames></Contributor>
+    </DescriptiveDetail>
+    <PublishingDetail><Publisher><PublishingRole>01</PublishingRole><PublisherName>Knopf</PublisherName></Publisher></PublishingDetail>
+    <ProductSupply><SupplyDetail><Price><PriceType>02</PriceType><PriceAmount>25.00</PriceAmount><CurrencyCode>USD</CurrencyCode></Price></SupplyDetail></ProductSupply>
+  </Product>
+</ONIXMessage>`
+
+func TestMapONIXProduct(t *testing.T) {
+	decoder := xml.NewDecoder(strings.NewReader(onixMessage))
+	product := onixNode{}
+	for {
+		token, _ := decoder.Token()
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "Product" {
+			decoder.DecodeElement(&product, &start)
+			break
+		}
+	}
+
+	fields, unmapped := mapONIXProduct(&product)
+
+	assert.Equal(t, map[string]string{
+		"isbn":      "9781101875322",
+		"title":     "Designing Your Life",
+		"author":    "Bill Burnett; Dave Evans",
+		"publisher": "Knopf",
+		"price":     "25.00",
+		"currency":  "USD",
+	}, fields)
+	assert.ElementsMatch(t, []string{
+		"Product/ProductIdentifier/IDName",
+		"Product/ProductIdentifier/IDValue",
+		"Product/DescriptiveDetail/ProductComposition",
+		"Product/DescriptiveDetail/ProductForm",
+		"Product/DescriptiveDetail/TitleDetail/TitleElement/Subtitle",
+	}, unmapped)
+}
+
+func TestImportONIX(t *testing.T) {
+	t.Run("report unmapped fields given onix dry run", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		request := newImportRequest(t, "feed.xml", onixMessage, map[string]string{"dry_run": "true"})
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByISBNQuery).WithArgs("9781101875322", 1).WillReturnError(gorm.ErrRecordNotFound)
+
+		handler := NewHandler(gormDB)
+		err := handler.Import(c)
+
+		job := ImportJob{}
+		json.Unmarshal(response.Body.Bytes(), &job)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, 1, job.Created)
+		assert.Equal(t, 1, job.Unmapped["Product/DescriptiveDetail/TitleDetail/TitleElement/Subtitle"])
+	})
+
+	t.Run("fail import given onix short tags", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+
+		request := newImportRequest(t, "feed.xml", `<ONIXmessage release="3.0"><product></product></ONIXmessage>`, nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		err := handler.Import(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestONIXExportWriter(t *testing.T) {
+	out := &bytes.Buffer{}
+	writer := newONIXExportWriter(out)
+	writer.now = func() time.Time { return time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC) }
+
+	book := Book{Title: "Designing Your Life", Author: "Bill Burnett and Dave Evans", ISBN: "9781101875322", Publisher: "Knopf", Price: 25, Currency: "USD"}
+	book.ID = 7
+
+	assert.NoError(t, writer.WriteHeader())
+	assert.NoError(t, writer.WriteBook(book))
+	assert.NoError(t, writer.WriteBook(Book{Title: "The Alchemist", Author: "Paulo Coelho", ISBN: "0062315005"}))
+	assert.NoError(t, writer.Close())
+
+	got := out.String()
+	assert.Contains(t, got, `<ONIXMessage release="3.0" xmlns="http://ns.editeur.org/onix/3.0/reference"><Header><Sender><SenderName>Book Store API</SenderName></Sender><SentDateTime>20261018T0930Z</SentDateTime></Header>`)
+	assert.Contains(t, got, `<RecordReference>book-7</RecordReference>`)
+	assert.Contains(t, got, `<Contributor><SequenceNumber>2</SequenceNumber><ContributorRole>A01</ContributorRole><PersonName>Dave Evans</PersonName></Contributor>`)
+	assert.Contains(t, got, `<Price><PriceType>02</PriceType><PriceAmount>25.00</PriceAmount><CurrencyCode>USD</CurrencyCode></Price>`)
+	assert.Contains(t, got, `<ProductIdentifier><ProductIDType>02</ProductIDType><IDValue>0062315005</IDValue></ProductIdentifier>`)
+	assert.Equal(t, 2, strings.Count(got, "<Product>"))
+	assert.Equal(t, 1, strings.Count(got, "<ProductSupply>"))
+
+	// The export must be readable by our own importer.
+	rows := make(chan importRow, 2)
+	assert.NoError(t, readONIX(&countingReader{reader: strings.NewReader(got)}, rows))
+	close(rows)
+	first := <-rows
+	assert.Equal(t, "Bill Burnett; Dave Evans", first.fields["author"])
+	assert.Equal(t, "25.00", first.fields["price"])
+}
@@ -0,0 +1,100 @@
+package book
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MovementPurchaseOrder = "purchase_order"
+	MovementPreorder      = "preorder"
+	MovementReturn        = "return"
+	MovementAdjustment    = "adjustment"
+)
+
+// StockMovement records a change to a book's stock and where it came from.
+// Quantity is positive for stock coming in and negative for stock going out.
+type StockMovement struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	BookID    uint      `json:"book_id" gorm:"not null;index"`
+	Quantity  int       `json:"quantity"`
+	Reason    string    `json:"reason" gorm:"not null"`
+	Reference string    `json:"reference"`
+	CreatedAt time.Time `json:"created_at"`
+	TenantID  string    `json:"-" gorm:"not null;default:'default';index"`
+}
+
+// MoveStock changes the stock of a book by quantity and records the
+// movement. The book row is locked until tx ends. It returns the book with
+// its new stock and whether the change brought it back in stock.
+func MoveStock(tx *gorm.DB, bookID uint, quantity int, reason string, reference string) (Book, bool, error) {
+	found := Book{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&found, bookID).Error; err != nil {
+		return Book{}, false, err
+	}
+	before := found.Stock
+	if err := tx.Model(&found).Update("stock", before+quantity).Error; err != nil {
+		return Book{}, false, err
+	}
+	found.Stock = before + quantity
+	movement := StockMovement{BookID: bookID, Quantity: quantity, Reason: reason, Reference: reference}
+	if err := tx.Create(&movement).Error; err != nil {
+		return Book{}, false, err
+	}
+	return found, isRestock(before, found.Stock), nil
+}
+
+// recordInitialStock records the stock that new books were created with as
+// adjustments, so the movements of a book always add up to its stock.
+func recordInitialStock(tx *gorm.DB, books ...Book) error {
+	movements := []StockMovement{}
+	for _, book := range books {
+		if book.Stock != 0 {
+			movements = append(movements, StockMovement{BookID: book.ID, Quantity: book.Stock, Reason: MovementAdjustment})
+		}
+	}
+	if len(movements) == 0 {
+		return nil
+	}
+	return tx.Create(&movements).Error
+}
+
+// saveBook saves an edited book whose stock was stock when it was read. A
+// change to the stock is applied as an adjustment through MoveStock, so it
+// is recorded and does not undo movements made since the book was read.
+func saveBook(tx *gorm.DB, book *Book, stock int) error {
+	if err := tx.Omit("stock").Save(book).Error; err != nil {
+		return err
+	}
+	if book.Stock == stock {
+		return nil
+	}
+	moved, _, err := MoveStock(tx, book.ID, book.Stock-stock, MovementAdjustment, "")
+	if err != nil {
+		return err
+	}
+	book.Stock = moved.Stock
+	return nil
+}
+
+// RestockFunc is called after a book's stock goes from zero to positive, so
+// that customers waiting for it can be told.
+type RestockFunc func(book Book)
+
+func WithRestockHandler(fn RestockFunc) Option {
+	return func(handler *handler) {
+		handler.onRestock = fn
+	}
+}
+
+func isRestock(before int, after int) bool {
+	return before <= 0 && after > 0
+}
+
+func (handler *handler) restocked(book Book) {
+	if handler.onRestock != nil {
+		handler.onRestock(book)
+	}
+}
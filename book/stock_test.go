@@ -0,0 +1,86 @@
+package book
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	updateStockQuery    = `UPDATE "books" SET "stock"=$1,"updated_at"=$2 WHERE "books"."deleted_at" IS NULL AND "id" = $3`
+	createMovementQuery = `INSERT INTO "stock_movements" ("book_id","quantity","reason","reference","created_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`
+)
+
+// expectMoveStock expects MoveStock to adjust the stock of book 1 from
+// before to after.
+func expectMoveStock(mock sqlmock.Sqlmock, before int, after int) {
+	mock.ExpectQuery(lockBookQuery).WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "stock"}).AddRow(1, "Atomic Habits", before))
+	mock.ExpectExec(updateStockQuery).WithArgs(after, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(createMovementQuery).WithArgs(1, after-before, MovementAdjustment, "", sqlmock.AnyArg(), "default").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+func TestRestock(t *testing.T) {
+	newUpdateContext := func(e *echo.Echo, body string) (echo.Context, *httptest.ResponseRecorder) {
+		request := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetPath("/books/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		return c, response
+	}
+
+	for _, tc := range []struct {
+		name      string
+		before    int
+		after     int
+		restocked bool
+	}{
+		{"call restock handler given stock goes from zero to positive", 0, 5, true},
+		{"skip restock handler given book was already in stock", 2, 5, false},
+		{"skip restock handler given book stays sold out", 0, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := echo.New()
+			defer e.Close()
+			c, response := newUpdateContext(e, fmt.Sprintf(`{"stock": %d}`, tc.after))
+
+			db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			defer db.Close()
+
+			gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+			mock.ExpectQuery(getBookByIdQuery).WithArgs("1", 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "isbn", "stock"}).AddRow(1, "Atomic Habits", "James Clear", "9781847941831", tc.before))
+			mock.ExpectBegin()
+			mock.ExpectExec(updateBookQuery).WillReturnResult(sqlmock.NewResult(0, 1))
+			if tc.after != tc.before {
+				expectMoveStock(mock, tc.before, tc.after)
+			}
+			mock.ExpectCommit()
+
+			restocked := []Book{}
+			handler := NewHandler(gormDB, WithRestockHandler(func(book Book) {
+				restocked = append(restocked, book)
+			}))
+			err := handler.Update(c)
+
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.Equal(t, tc.restocked, len(restocked) == 1)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
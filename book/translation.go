@@ -0,0 +1,53 @@
+package book
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+)
+
+// Translation is a book's title, subtitle and description in one locale.
+// Fields left empty fall back to the next locale.
+type Translation struct {
+	Title       string `json:"title,omitempty"`
+	Subtitle    string `json:"subtitle,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Localize returns the book with each of its title, subtitle and
+// description taken from the first of locales that has it. The book's own
+// fields are the last fallback, as they are in the default locale.
+func (book Book) Localize(locales []string) Book {
+	title, subtitle, description := "", "", ""
+	for _, locale := range locales {
+		translation := book.Translations[locale]
+		title = first(title, translation.Title)
+		subtitle = first(subtitle, translation.Subtitle)
+		description = first(description, translation.Description)
+	}
+	book.Title = first(title, book.Title)
+	book.Subtitle = first(subtitle, book.Subtitle)
+	book.Description = first(description, book.Description)
+	return book
+}
+
+func first(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// localize localizes books in place for the locales negotiated for the
+// request and returns them, naming the preferred one in Content-Language.
+func localize(c echo.Context, books ...Book) []Book {
+	locales := middleware.GetLocales(c)
+	for i := range books {
+		books[i] = books[i].Localize(locales)
+	}
+	if len(locales) > 0 {
+		c.Response().Header().Set("Content-Language", locales[0])
+	}
+	return books
+}
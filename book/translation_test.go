@@ -0,0 +1,39 @@
+package book
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalize(t *testing.T) {
+	book := Book{
+		Title:       "The Little Prince",
+		Subtitle:    "With the author's illustrations",
+		Description: "A pilot stranded in the desert meets a young prince.",
+		Translations: map[string]Translation{
+			"th":    {Title: "เจ้าชายน้อย", Description: "นักบินที่ติดอยู่กลางทะเลทรายได้พบเจ้าชายน้อย"},
+			"th-TH": {Subtitle: "ฉบับภาพประกอบของผู้เขียน"},
+		},
+	}
+
+	t.Run("take each field from the first locale that has it given fallback chain", func(t *testing.T) {
+		got := book.Localize([]string{"th-TH", "th", "en"})
+
+		assert.Equal(t, "เจ้าชายน้อย", got.Title)
+		assert.Equal(t, "ฉบับภาพประกอบของผู้เขียน", got.Subtitle)
+		assert.Equal(t, "นักบินที่ติดอยู่กลางทะเลทรายได้พบเจ้าชายน้อย", got.Description)
+	})
+
+	t.Run("fall back to book fields given locale without translation", func(t *testing.T) {
+		got := book.Localize([]string{"th-TH", "en"})
+
+		assert.Equal(t, "The Little Prince", got.Title)
+		assert.Equal(t, "ฉบับภาพประกอบของผู้เขียน", got.Subtitle)
+		assert.Equal(t, "A pilot stranded in the desert meets a young prince.", got.Description)
+	})
+
+	t.Run("keep book fields given no locales", func(t *testing.T) {
+		assert.Equal(t, book, book.Localize(nil))
+	})
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 type EnvGetter interface {
@@ -20,7 +21,19 @@ type ConfigProvider struct {
 }
 
 type Config struct {
-	Server Server
+	Server   Server
+	Metadata Metadata
+	Storage  Storage
+	Notifier Notifier
+	Payment  Payment
+	Invoice  Invoice
+
+	Recommendation Recommendation
+	Preorder       Preorder
+	Lending        Lending
+	Digital        Digital
+	Tenant         Tenant
+	Locale         Locale
 }
 
 type Server struct {
@@ -29,6 +42,123 @@ type Server struct {
 	DBConnectionString string
 }
 
+type Metadata struct {
+	BaseURL             string
+	TimeoutMilliseconds int
+	CacheTTLSeconds     int
+}
+
+// Storage selects where blobs such as cover images are kept. Driver is
+// "local" or "s3"; the S3 fields are only read for "s3".
+type Storage struct {
+	Driver            string
+	LocalDir          string
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+}
+
+// Notifier posts customer notifications to WebhookURL, or only logs them
+// when it is empty.
+type Notifier struct {
+	WebhookURL string
+}
+
+// Payment selects the payment gateway. Gateway is "fake" or "stripe"; the
+// fake keeps charges in memory and must not be used in production.
+type Payment struct {
+	Gateway         string
+	StripeBaseURL   string
+	StripeSecretKey string
+	WebhookSecret   string
+}
+
+// Invoice holds the seller details printed on invoices. TaxJurisdiction is
+// the code of the tax jurisdiction the seller charges tax in.
+type Invoice struct {
+	SellerName      string
+	SellerAddress   string
+	SellerTaxID     string
+	TaxJurisdiction string
+	NumberPrefix    string
+}
+
+// Recommendation sets how often related books are recomputed from order
+// history.
+type Recommendation struct {
+	RefreshIntervalMinutes int
+}
+
+// Preorder sets how often forthcoming books are checked for release.
+type Preorder struct {
+	ReleaseIntervalMinutes int
+}
+
+// Lending sets the lending library's rules. FinePerDay is charged for each
+// day a loan is overdue, in the smallest unit of FineCurrency.
+type Lending struct {
+	LoanDays       int
+	MaxRenewals    int
+	HoldPickupDays int
+	FinePerDay     int
+	FineCurrency   string
+}
+
+// Digital configures ebook and audiobook downloads. Download links are
+// signed with SigningSecret and expire after LinkTTLSeconds. BaseURL is put
+// in front of the links when set.
+type Digital struct {
+	SigningSecret  string
+	LinkTTLSeconds int
+	MaxDownloads   int
+	BaseURL        string
+}
+
+// Tenant maps storefronts to tenants. Hosts is a comma separated list of
+// host=tenant pairs. Requests for other hosts without a tenant claim go to
+// Default.
+type Tenant struct {
+	Hosts   string
+	Default string
+}
+
+// HostMap returns Hosts keyed by lower case host.
+func (t Tenant) HostMap() map[string]string {
+	hosts := map[string]string{}
+	for _, pair := range strings.Split(t.Hosts, ",") {
+		host, tenantID, found := strings.Cut(pair, "=")
+		if !found {
+			continue
+		}
+		host, tenantID = strings.ToLower(strings.TrimSpace(host)), strings.TrimSpace(tenantID)
+		if host != "" && tenantID != "" {
+			hosts[host] = tenantID
+		}
+	}
+	return hosts
+}
+
+// Locale sets the locales responses can be in. Supported is a comma
+// separated list of language tags. Default is the locale of the catalog's
+// untranslated fields and of everything not translated.
+type Locale struct {
+	Supported string
+	Default   string
+}
+
+// List returns the supported locales, which always include Default.
+func (l Locale) List() []string {
+	locales := []string{}
+	for _, locale := range strings.Split(l.Supported, ",") {
+		if locale = strings.TrimSpace(locale); locale != "" && locale != l.Default {
+			locales = append(locales, locale)
+		}
+	}
+	return append(locales, l.Default)
+}
+
 func (c *ConfigProvider) GetStringEnv(key string, defaultValue string) string {
 	value := c.Getter.Getenv(key)
 	if value == "" {
@@ -68,5 +198,62 @@ func (c *ConfigProvider) GetConfig() Config {
 			Port:               c.GetIntEnv("PORT", 1323),
 			DBConnectionString: c.GetStringEnv("DB_CONNECTION_STRING", ""),
 		},
+		Metadata: Metadata{
+			BaseURL:             c.GetStringEnv("METADATA_BASE_URL", "https://openlibrary.org"),
+			TimeoutMilliseconds: c.GetIntEnv("METADATA_TIMEOUT_MS", 2000),
+			CacheTTLSeconds:     c.GetIntEnv("METADATA_CACHE_TTL_SECONDS", 86400),
+		},
+		Storage: Storage{
+			Driver:            c.GetStringEnv("STORAGE_DRIVER", "local"),
+			LocalDir:          c.GetStringEnv("STORAGE_LOCAL_DIR", "data/blobs"),
+			S3Endpoint:        c.GetStringEnv("S3_ENDPOINT", ""),
+			S3Region:          c.GetStringEnv("S3_REGION", "us-east-1"),
+			S3Bucket:          c.GetStringEnv("S3_BUCKET", ""),
+			S3AccessKeyID:     c.GetStringEnv("S3_ACCESS_KEY_ID", ""),
+			S3SecretAccessKey: c.GetStringEnv("S3_SECRET_ACCESS_KEY", ""),
+		},
+		Notifier: Notifier{
+			WebhookURL: c.GetStringEnv("NOTIFIER_WEBHOOK_URL", ""),
+		},
+		Payment: Payment{
+			Gateway:         c.GetStringEnv("PAYMENT_GATEWAY", "fake"),
+			StripeBaseURL:   c.GetStringEnv("STRIPE_BASE_URL", "https://api.stripe.com"),
+			StripeSecretKey: c.GetStringEnv("STRIPE_SECRET_KEY", ""),
+			WebhookSecret:   c.GetStringEnv("PAYMENT_WEBHOOK_SECRET", ""),
+		},
+		Invoice: Invoice{
+			SellerName:      c.GetStringEnv("INVOICE_SELLER_NAME", "Book Store"),
+			SellerAddress:   c.GetStringEnv("INVOICE_SELLER_ADDRESS", ""),
+			SellerTaxID:     c.GetStringEnv("INVOICE_SELLER_TAX_ID", ""),
+			TaxJurisdiction: c.GetStringEnv("INVOICE_TAX_JURISDICTION", ""),
+			NumberPrefix:    c.GetStringEnv("INVOICE_NUMBER_PREFIX", "INV-"),
+		},
+		Recommendation: Recommendation{
+			RefreshIntervalMinutes: c.GetIntEnv("RECOMMENDATION_REFRESH_MINUTES", 60),
+		},
+		Preorder: Preorder{
+			ReleaseIntervalMinutes: c.GetIntEnv("PREORDER_RELEASE_MINUTES", 60),
+		},
+		Lending: Lending{
+			LoanDays:       c.GetIntEnv("LENDING_LOAN_DAYS", 14),
+			MaxRenewals:    c.GetIntEnv("LENDING_MAX_RENEWALS", 2),
+			HoldPickupDays: c.GetIntEnv("LENDING_HOLD_PICKUP_DAYS", 3),
+			FinePerDay:     c.GetIntEnv("LENDING_FINE_PER_DAY", 500),
+			FineCurrency:   c.GetStringEnv("LENDING_FINE_CURRENCY", "THB"),
+		},
+		Digital: Digital{
+			SigningSecret:  c.GetStringEnv("DIGITAL_SIGNING_SECRET", ""),
+			LinkTTLSeconds: c.GetIntEnv("DIGITAL_LINK_TTL_SECONDS", 900),
+			MaxDownloads:   c.GetIntEnv("DIGITAL_MAX_DOWNLOADS", 5),
+			BaseURL:        c.GetStringEnv("DIGITAL_DOWNLOAD_BASE_URL", ""),
+		},
+		Tenant: Tenant{
+			Hosts:   c.GetStringEnv("TENANT_HOSTS", ""),
+			Default: c.GetStringEnv("TENANT_DEFAULT", "default"),
+		},
+		Locale: Locale{
+			Supported: c.GetStringEnv("LOCALES", "en,th"),
+			Default:   c.GetStringEnv("LOCALE_DEFAULT", "en"),
+		},
 	}
 }
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -115,9 +116,41 @@ func TestGetBoolEnv(t *testing.T) {
 func TestGetConfig(t *testing.T) {
 	t.Run("get server given keys exist", func(t *testing.T) {
 		envGetter := StubEnvGetter{
-			"HOSTNAME":             "127.0.0.1",
-			"PORT":                 "5000",
-			"DB_CONNECTION_STRING": "db://localhost:5432",
+			"HOSTNAME":                       "127.0.0.1",
+			"PORT":                           "5000",
+			"DB_CONNECTION_STRING":           "db://localhost:5432",
+			"METADATA_BASE_URL":              "http://metadata.local",
+			"METADATA_TIMEOUT_MS":            "500",
+			"METADATA_CACHE_TTL_SECONDS":     "60",
+			"STORAGE_DRIVER":                 "s3",
+			"S3_ENDPOINT":                    "http://localhost:9000",
+			"S3_BUCKET":                      "covers",
+			"S3_ACCESS_KEY_ID":               "minio",
+			"S3_SECRET_ACCESS_KEY":           "minio123",
+			"NOTIFIER_WEBHOOK_URL":           "http://notifications.local/hook",
+			"PAYMENT_GATEWAY":                "stripe",
+			"STRIPE_SECRET_KEY":              "sk_test",
+			"PAYMENT_WEBHOOK_SECRET":         "whsec_test",
+			"INVOICE_SELLER_NAME":            "Phet Books Co., Ltd.",
+			"INVOICE_SELLER_ADDRESS":         "1 Sukhumvit Road, Bangkok 10110",
+			"INVOICE_SELLER_TAX_ID":          "0105551234567",
+			"INVOICE_TAX_JURISDICTION":       "TH",
+			"INVOICE_NUMBER_PREFIX":          "PB-",
+			"RECOMMENDATION_REFRESH_MINUTES": "15",
+			"PREORDER_RELEASE_MINUTES":       "30",
+			"LENDING_LOAN_DAYS":              "21",
+			"LENDING_MAX_RENEWALS":           "1",
+			"LENDING_HOLD_PICKUP_DAYS":       "5",
+			"LENDING_FINE_PER_DAY":           "1000",
+			"LENDING_FINE_CURRENCY":          "USD",
+			"DIGITAL_SIGNING_SECRET":         "download-secret",
+			"DIGITAL_LINK_TTL_SECONDS":       "300",
+			"DIGITAL_MAX_DOWNLOADS":          "3",
+			"DIGITAL_DOWNLOAD_BASE_URL":      "https://books.example.com",
+			"TENANT_HOSTS":                   "books.example.co.th=th,books.example.co.uk=uk",
+			"TENANT_DEFAULT":                 "th",
+			"LOCALES":                        "th,en,ja",
+			"LOCALE_DEFAULT":                 "th",
 		}
 		configProvider := ConfigProvider{Getter: envGetter}
 		config := configProvider.GetConfig()
@@ -129,6 +162,63 @@ func TestGetConfig(t *testing.T) {
 				Port:               5000,
 				DBConnectionString: "db://localhost:5432",
 			},
+			Metadata{
+				BaseURL:             "http://metadata.local",
+				TimeoutMilliseconds: 500,
+				CacheTTLSeconds:     60,
+			},
+			Storage{
+				Driver:            "s3",
+				LocalDir:          "data/blobs",
+				S3Endpoint:        "http://localhost:9000",
+				S3Region:          "us-east-1",
+				S3Bucket:          "covers",
+				S3AccessKeyID:     "minio",
+				S3SecretAccessKey: "minio123",
+			},
+			Notifier{
+				WebhookURL: "http://notifications.local/hook",
+			},
+			Payment{
+				Gateway:         "stripe",
+				StripeBaseURL:   "https://api.stripe.com",
+				StripeSecretKey: "sk_test",
+				WebhookSecret:   "whsec_test",
+			},
+			Invoice{
+				SellerName:      "Phet Books Co., Ltd.",
+				SellerAddress:   "1 Sukhumvit Road, Bangkok 10110",
+				SellerTaxID:     "0105551234567",
+				TaxJurisdiction: "TH",
+				NumberPrefix:    "PB-",
+			},
+			Recommendation{
+				RefreshIntervalMinutes: 15,
+			},
+			Preorder{
+				ReleaseIntervalMinutes: 30,
+			},
+			Lending{
+				LoanDays:       21,
+				MaxRenewals:    1,
+				HoldPickupDays: 5,
+				FinePerDay:     1000,
+				FineCurrency:   "USD",
+			},
+			Digital{
+				SigningSecret:  "download-secret",
+				LinkTTLSeconds: 300,
+				MaxDownloads:   3,
+				BaseURL:        "https://books.example.com",
+			},
+			Tenant{
+				Hosts:   "books.example.co.th=th,books.example.co.uk=uk",
+				Default: "th",
+			},
+			Locale{
+				Supported: "th,en,ja",
+				Default:   "th",
+			},
 		}
 
 		if got != want {
@@ -148,6 +238,49 @@ func TestGetConfig(t *testing.T) {
 				Port:               1323,
 				DBConnectionString: "",
 			},
+			Metadata{
+				BaseURL:             "https://openlibrary.org",
+				TimeoutMilliseconds: 2000,
+				CacheTTLSeconds:     86400,
+			},
+			Storage{
+				Driver:   "local",
+				LocalDir: "data/blobs",
+				S3Region: "us-east-1",
+			},
+			Notifier{},
+			Payment{
+				Gateway:       "fake",
+				StripeBaseURL: "https://api.stripe.com",
+			},
+			Invoice{
+				SellerName:   "Book Store",
+				NumberPrefix: "INV-",
+			},
+			Recommendation{
+				RefreshIntervalMinutes: 60,
+			},
+			Preorder{
+				ReleaseIntervalMinutes: 60,
+			},
+			Lending{
+				LoanDays:       14,
+				MaxRenewals:    2,
+				HoldPickupDays: 3,
+				FinePerDay:     500,
+				FineCurrency:   "THB",
+			},
+			Digital{
+				LinkTTLSeconds: 900,
+				MaxDownloads:   5,
+			},
+			Tenant{
+				Default: "default",
+			},
+			Locale{
+				Supported: "en,th",
+				Default:   "en",
+			},
 		}
 
 		if got != want {
@@ -155,3 +288,29 @@ func TestGetConfig(t *testing.T) {
 		}
 	})
 }
+
+func TestTenantHostMap(t *testing.T) {
+	t.Run("should map hosts to tenants given host=tenant pairs", func(t *testing.T) {
+		tenant := Tenant{Hosts: " Books.Example.co.th = th ,books.example.co.uk=uk,broken,=empty"}
+
+		got := tenant.HostMap()
+
+		want := map[string]string{"books.example.co.th": "th", "books.example.co.uk": "uk"}
+		if len(got) != len(want) || got["books.example.co.th"] != "th" || got["books.example.co.uk"] != "uk" {
+			t.Errorf("expected %v but got %v", want, got)
+		}
+	})
+}
+
+func TestLocaleList(t *testing.T) {
+	t.Run("should include default given it is not listed", func(t *testing.T) {
+		locale := Locale{Supported: "th, ja,", Default: "en"}
+
+		got := locale.List()
+
+		want := []string{"th", "ja", "en"}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("expected %v but got %v", want, got)
+		}
+	})
+}
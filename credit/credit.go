@@ -0,0 +1,193 @@
+package credit
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	EntryIssue    = "issue"
+	EntryGrant    = "grant"
+	EntryAdjust   = "adjustment"
+	EntryRedeem   = "redeem"
+	EntryReversal = "reversal"
+
+	// codeAlphabet leaves out letters and digits that are easy to mix up
+	// when a code is typed in.
+	codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	codeLength   = 16
+)
+
+// GiftCard is a card with a code that pays for orders up to the amount it
+// was issued for. Its balance is the sum of its ledger entries. Amounts are
+// in the smallest unit of Currency.
+type GiftCard struct {
+	ID        uint            `json:"id" gorm:"primaryKey"`
+	Code      string          `json:"code" gorm:"not null;uniqueIndex:idx_gift_cards_tenant_code"`
+	Amount    int64           `json:"amount"`
+	Currency  string          `json:"currency" gorm:"not null"`
+	ExpiresAt *time.Time      `json:"expires_at"`
+	Balance   int64           `json:"balance" gorm:"-"`
+	Entries   []GiftCardEntry `json:"entries,omitempty" gorm:"-"`
+	CreatedAt time.Time       `json:"created_at"`
+	TenantID  string          `json:"-" gorm:"not null;default:'default';uniqueIndex:idx_gift_cards_tenant_code"`
+}
+
+// GiftCardEntry is a change to a gift card's balance. Entries are only ever
+// added: a redemption is undone with a reversal entry, not by removing it.
+type GiftCardEntry struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	GiftCardID uint      `json:"gift_card_id" gorm:"not null;index"`
+	Type       string    `json:"type" gorm:"not null"`
+	Amount     int64     `json:"amount"`
+	OrderRef   string    `json:"order_ref,omitempty" gorm:"index"`
+	TenderID   *uint     `json:"tender_id,omitempty" gorm:"index"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Account is a customer's store credit in one currency at one storefront.
+// It holds no balance; its row is locked while the balance is read and
+// changed, so changes are made one at a time.
+type Account struct {
+	CustomerID string `gorm:"primaryKey"`
+	Currency   string `gorm:"primaryKey"`
+	TenantID   string `gorm:"primaryKey;default:'default'"`
+	CreatedAt  time.Time
+}
+
+func (Account) TableName() string {
+	return "credit_accounts"
+}
+
+// Entry is a change to a customer's store credit. Like gift card entries,
+// entries are only ever added.
+type Entry struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CustomerID string    `json:"customer_id" gorm:"not null;index:idx_credit_entries_account"`
+	Currency   string    `json:"currency" gorm:"not null;index:idx_credit_entries_account"`
+	Type       string    `json:"type" gorm:"not null"`
+	Amount     int64     `json:"amount"`
+	Reason     string    `json:"reason,omitempty"`
+	OrderRef   string    `json:"order_ref,omitempty" gorm:"index"`
+	TenderID   *uint     `json:"tender_id,omitempty" gorm:"index"`
+	CreatedAt  time.Time `json:"created_at"`
+	TenantID   string    `json:"-" gorm:"not null;default:'default';index:idx_credit_entries_account"`
+}
+
+func (Entry) TableName() string {
+	return "credit_entries"
+}
+
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+func (c *CustomValidator) Validate(i interface{}) error {
+	return c.validator.Struct(i)
+}
+
+type handler struct {
+	db  *gorm.DB
+	now func() time.Time
+}
+
+func NewHandler(db *gorm.DB) *handler {
+	return &handler{db: db, now: time.Now}
+}
+
+var errNotFound = errors.New("not found")
+
+// conflict is a rule that stops the request. It is reported with 409
+// Conflict.
+type conflict string
+
+func (err conflict) Error() string {
+	return string(err)
+}
+
+// invalid is a request that cannot be applied, such as an expired gift
+// card. It is reported with 422 Unprocessable Entity.
+type invalid string
+
+func (err invalid) Error() string {
+	return string(err)
+}
+
+// creditError maps an error from a ledger transaction to a response.
+func creditError(c echo.Context, err error, notFound string) error {
+	var rule conflict
+	var unusable invalid
+	switch {
+	case errors.Is(err, errNotFound), errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": notFound})
+	case errors.As(err, &rule):
+		return c.JSON(http.StatusConflict, map[string]string{"error": rule.Error()})
+	case errors.As(err, &unusable):
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": unusable.Error()})
+	}
+	middleware.GetLogger(c).Error("failed to update ledger", zap.Error(err))
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+}
+
+// newCode returns a random gift card code.
+func newCode() (string, error) {
+	random := make([]byte, codeLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	code := make([]byte, codeLength)
+	for i, b := range random {
+		code[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(code), nil
+}
+
+// normalizeCode lets customers type a code in lower case or with the spaces
+// and dashes it is printed with.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// maskCode hides all but the last four characters of a code.
+func maskCode(code string) string {
+	if len(code) <= 4 {
+		return code
+	}
+	return strings.Repeat("*", len(code)-4) + code[len(code)-4:]
+}
+
+func giftCardBalance(tx *gorm.DB, id uint) (int64, error) {
+	var balance int64
+	err := tx.Model(&GiftCardEntry{}).Select("COALESCE(SUM(amount), 0)").Where("gift_card_id = ?", id).Scan(&balance).Error
+	return balance, err
+}
+
+func creditBalance(tx *gorm.DB, customerID string, currency string) (int64, error) {
+	var balance int64
+	err := tx.Model(&Entry{}).Select("COALESCE(SUM(amount), 0)").
+		Where("customer_id = ? AND currency = ?", customerID, currency).
+		Scan(&balance).Error
+	return balance, err
+}
+
+// lockAccount locks the customer's account in the currency, opening it if
+// it is new.
+func lockAccount(tx *gorm.DB, customerID string, currency string, now time.Time) error {
+	account := Account{CustomerID: customerID, Currency: currency, CreatedAt: now}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+		return err
+	}
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("customer_id = ? AND currency = ?", customerID, currency).
+		First(&Account{}).Error
+}
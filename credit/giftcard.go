@@ -0,0 +1,83 @@
+package credit
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type GiftCardRequest struct {
+	Amount    int64      `json:"amount" validate:"gt=0"`
+	Currency  string     `json:"currency" validate:"required,len=3,uppercase"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// IssueGiftCard creates a gift card with a new code and credits it with the
+// issued amount.
+func (handler *handler) IssueGiftCard(c echo.Context) error {
+	request := GiftCardRequest{}
+	logger := middleware.GetLogger(c)
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(handler.now()) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "expires_at must be in the future"})
+	}
+
+	code, err := newCode()
+	if err != nil {
+		logger.Error("failed to generate gift card code", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	card := GiftCard{Code: code, Amount: request.Amount, Currency: request.Currency, ExpiresAt: request.ExpiresAt}
+	err = handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&card).Error; err != nil {
+			return err
+		}
+		entry := GiftCardEntry{GiftCardID: card.ID, Type: EntryIssue, Amount: card.Amount}
+		if err := tx.Create(&entry).Error; err != nil {
+			return err
+		}
+		card.Balance, card.Entries = entry.Amount, []GiftCardEntry{entry}
+		return nil
+	})
+	if err != nil {
+		logger.Error("failed to issue gift card", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to issue gift card"})
+	}
+
+	logger.Info("gift card issued", zap.Uint("id", card.ID), zap.Int64("amount", card.Amount), zap.String("currency", card.Currency))
+	return c.JSON(http.StatusCreated, card)
+}
+
+// GetGiftCard looks a gift card up by its code and shows its balance and
+// ledger.
+func (handler *handler) GetGiftCard(c echo.Context) error {
+	card := GiftCard{}
+	if err := handler.db.WithContext(c.Request().Context()).Where("code = ?", normalizeCode(c.Param("code"))).First(&card).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Gift card not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	if err := handler.db.WithContext(c.Request().Context()).Where("gift_card_id = ?", card.ID).Order("id").Find(&card.Entries).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	for _, entry := range card.Entries {
+		card.Balance += entry.Amount
+	}
+	return c.JSON(http.StatusOK, card)
+}
@@ -0,0 +1,199 @@
+package credit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	createGiftCardQuery    = `INSERT INTO "gift_cards" ("code","amount","currency","expires_at","created_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`
+	createCardEntryQuery   = `INSERT INTO "gift_card_entries" ("gift_card_id","type","amount","order_ref","tender_id","created_at") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`
+	getGiftCardQuery       = `SELECT * FROM "gift_cards" WHERE code = $1 ORDER BY "gift_cards"."id" LIMIT $2`
+	getTenantGiftCardQuery = `SELECT * FROM "gift_cards" WHERE code = $1 AND "gift_cards"."tenant_id" = $2 ORDER BY "gift_cards"."id" LIMIT $3`
+	getCardEntriesQuery    = `SELECT * FROM "gift_card_entries" WHERE gift_card_id = $1 ORDER BY id`
+	cardBalanceQuery       = `SELECT COALESCE(SUM(amount), 0) FROM "gift_card_entries" WHERE gift_card_id = $1`
+)
+
+var now = time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+
+func TestCode(t *testing.T) {
+	t.Run("generate unambiguous code given new card", func(t *testing.T) {
+		code, err := newCode()
+
+		assert.NoError(t, err)
+		assert.Len(t, code, codeLength)
+		assert.NotContains(t, code, "0")
+		assert.NotContains(t, code, "O")
+		assert.NotContains(t, code, "1")
+		assert.NotContains(t, code, "I")
+	})
+
+	t.Run("normalize code given lower case with dashes", func(t *testing.T) {
+		assert.Equal(t, "ABCD2345EFGH6789", normalizeCode("abcd-2345 efgh-6789"))
+	})
+
+	t.Run("mask all but last four given code", func(t *testing.T) {
+		assert.Equal(t, "************6789", maskCode("ABCD2345EFGH6789"))
+	})
+}
+
+func TestIssueGiftCard(t *testing.T) {
+	t.Run("issue card with balance given amount", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 50000, "currency": "THB"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(createGiftCardQuery).WithArgs(sqlmock.AnyArg(), 50000, "THB", nil, sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectQuery(createCardEntryQuery).WithArgs(3, EntryIssue, 50000, "", nil, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := handler.IssueGiftCard(c)
+
+		card := GiftCard{}
+		json.Unmarshal(response.Body.Bytes(), &card)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Len(t, card.Code, codeLength)
+		assert.Equal(t, int64(50000), card.Balance)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given expiry in the past", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 50000, "currency": "THB", "expires_at": "2024-06-30T00:00:00Z"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil)
+		handler.now = func() time.Time { return now }
+		err := handler.IssueGiftCard(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestGetGiftCard(t *testing.T) {
+	t.Run("derive balance from ledger given card code", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("code")
+		c.SetParamValues("abcd-2345-efgh-6789")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getGiftCardQuery).WithArgs("ABCD2345EFGH6789", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "code", "amount", "currency"}).AddRow(3, "ABCD2345EFGH6789", 50000, "THB"))
+		mock.ExpectQuery(getCardEntriesQuery).WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "gift_card_id", "type", "amount"}).
+				AddRow(1, 3, EntryIssue, 50000).
+				AddRow(2, 3, EntryRedeem, -30000).
+				AddRow(3, 3, EntryReversal, 10000))
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := handler.GetGiftCard(c)
+
+		card := GiftCard{}
+		json.Unmarshal(response.Body.Bytes(), &card)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, int64(30000), card.Balance)
+		assert.Len(t, card.Entries, 3)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return not found given unknown code", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("code")
+		c.SetParamValues("nope")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getGiftCardQuery).WithArgs("NOPE", 1).WillReturnError(gorm.ErrRecordNotFound)
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := handler.GetGiftCard(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return not found given card of another storefront under tenant plugin", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Customer-ID", "c-1")
+		request = request.WithContext(tenant.NewContext(request.Context(), "uk"))
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("code")
+		c.SetParamValues("abcd-2345-efgh-6789")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		assert.NoError(t, gormDB.Use(tenant.Plugin{}))
+
+		mock.ExpectQuery(getTenantGiftCardQuery).WithArgs("ABCD2345EFGH6789", "uk", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "code", "amount", "currency", "tenant_id"}))
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := handler.GetGiftCard(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,113 @@
+package credit
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// CreditRequest grants store credit to a customer, or takes it away when
+// the amount is negative.
+type CreditRequest struct {
+	Amount   int64  `json:"amount" validate:"ne=0"`
+	Currency string `json:"currency" validate:"required,len=3,uppercase"`
+	Reason   string `json:"reason" validate:"required,max=200"`
+}
+
+// Posted is a ledger entry with the balance it left.
+type Posted struct {
+	Entry
+	Balance int64 `json:"balance"`
+}
+
+type Balance struct {
+	Currency string `json:"currency"`
+	Balance  int64  `json:"balance"`
+}
+
+// Statement is a customer's store credit: the balance in each currency and
+// every entry, oldest first.
+type Statement struct {
+	CustomerID string    `json:"customer_id"`
+	Balances   []Balance `json:"balances"`
+	Entries    []Entry   `json:"entries"`
+}
+
+// GrantCredit adds an entry to the customer's store credit. An adjustment
+// cannot take the balance below zero.
+func (handler *handler) GrantCredit(c echo.Context) error {
+	request := CreditRequest{}
+	customerID := c.Param("id")
+	logger := middleware.GetLogger(c)
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	posted := Posted{Entry: Entry{CustomerID: customerID, Currency: request.Currency, Type: EntryGrant, Amount: request.Amount, Reason: request.Reason}}
+	if request.Amount < 0 {
+		posted.Type = EntryAdjust
+	}
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := lockAccount(tx, customerID, request.Currency, handler.now()); err != nil {
+			return err
+		}
+		balance, err := creditBalance(tx, customerID, request.Currency)
+		if err != nil {
+			return err
+		}
+		if balance+request.Amount < 0 {
+			return conflict("Store credit cannot go below zero")
+		}
+		if err := tx.Create(&posted.Entry).Error; err != nil {
+			return err
+		}
+		posted.Balance = balance + request.Amount
+		return nil
+	})
+	if err != nil {
+		return creditError(c, err, "Customer not found")
+	}
+
+	logger.Info("store credit posted", zap.String("customer_id", customerID), zap.Int64("amount", request.Amount), zap.String("currency", request.Currency))
+	return c.JSON(http.StatusCreated, posted)
+}
+
+func (handler *handler) statement(c echo.Context, customerID string) error {
+	statement := Statement{CustomerID: customerID, Balances: []Balance{}, Entries: []Entry{}}
+	if err := handler.db.WithContext(c.Request().Context()).Where("customer_id = ?", customerID).Order("id").Find(&statement.Entries).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	balances := map[string]int64{}
+	for _, entry := range statement.Entries {
+		balances[entry.Currency] += entry.Amount
+	}
+	for currency, balance := range balances {
+		statement.Balances = append(statement.Balances, Balance{Currency: currency, Balance: balance})
+	}
+	sort.Slice(statement.Balances, func(i, j int) bool {
+		return statement.Balances[i].Currency < statement.Balances[j].Currency
+	})
+	return c.JSON(http.StatusOK, statement)
+}
+
+// GetCustomerCredit shows a customer's store credit.
+func (handler *handler) GetCustomerCredit(c echo.Context) error {
+	return handler.statement(c, c.Param("id"))
+}
+
+// GetCredit shows the store credit of the customer making the request.
+func (handler *handler) GetCredit(c echo.Context) error {
+	return handler.statement(c, middleware.GetCustomerID(c))
+}
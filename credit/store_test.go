@@ -0,0 +1,139 @@
+package credit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	openAccountQuery   = `INSERT INTO "credit_accounts" ("customer_id","currency","tenant_id","created_at") VALUES ($1,$2,$3,$4) ON CONFLICT DO NOTHING`
+	lockAccountQuery   = `SELECT * FROM "credit_accounts" WHERE customer_id = $1 AND currency = $2 ORDER BY "credit_accounts"."customer_id" LIMIT $3 FOR UPDATE`
+	creditBalanceQuery = `SELECT COALESCE(SUM(amount), 0) FROM "credit_entries" WHERE customer_id = $1 AND currency = $2`
+	createCreditQuery  = `INSERT INTO "credit_entries" ("customer_id","currency","type","amount","reason","order_ref","tender_id","created_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "id"`
+	creditEntriesQuery = `SELECT * FROM "credit_entries" WHERE customer_id = $1 ORDER BY id`
+)
+
+func balance(value int64) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"coalesce"}).AddRow(value)
+}
+
+func expectAccount(mock sqlmock.Sqlmock, customerID string) {
+	mock.ExpectExec(openAccountQuery).WithArgs(customerID, "THB", "default", now).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(lockAccountQuery).WithArgs(customerID, "THB", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"customer_id", "currency"}).AddRow(customerID, "THB"))
+}
+
+func TestGrantCredit(t *testing.T) {
+	t.Run("post credit and return balance given grant", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 20000, "currency": "THB", "reason": "Damaged parcel"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("c-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		expectAccount(mock, "c-1")
+		mock.ExpectQuery(creditBalanceQuery).WithArgs("c-1", "THB").WillReturnRows(balance(5000))
+		mock.ExpectQuery(createCreditQuery).WithArgs("c-1", "THB", EntryGrant, 20000, "Damaged parcel", "", nil, sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := handler.GrantCredit(c)
+
+		posted := Posted{}
+		json.Unmarshal(response.Body.Bytes(), &posted)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, int64(25000), posted.Balance)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given adjustment below zero", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": -6000, "currency": "THB", "reason": "Granted twice"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("c-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		expectAccount(mock, "c-1")
+		mock.ExpectQuery(creditBalanceQuery).WithArgs("c-1", "THB").WillReturnRows(balance(5000))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := handler.GrantCredit(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetCredit(t *testing.T) {
+	t.Run("sum balance per currency given ledger", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(creditEntriesQuery).WithArgs("c-1").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "currency", "type", "amount"}).
+				AddRow(1, "c-1", "USD", EntryGrant, 1000).
+				AddRow(2, "c-1", "THB", EntryGrant, 20000).
+				AddRow(3, "c-1", "THB", EntryRedeem, -15000))
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireCustomer(handler.GetCredit)(c)
+
+		statement := Statement{}
+		json.Unmarshal(response.Body.Bytes(), &statement)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, []Balance{{Currency: "THB", Balance: 5000}, {Currency: "USD", Balance: 1000}}, statement.Balances)
+		assert.Len(t, statement.Entries, 3)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
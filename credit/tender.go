@@ -0,0 +1,276 @@
+package credit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TenderApplied  = "applied"
+	TenderReversed = "reversed"
+
+	SourceGiftCard    = "gift_card"
+	SourceStoreCredit = "store_credit"
+)
+
+// Tender is the part of an order paid with gift cards and store credit.
+// Remaining is what is left to pay with a payment. An order has one tender;
+// once it is reversed the order can be tendered again.
+type Tender struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	OrderRef   string    `json:"order_ref" gorm:"not null;uniqueIndex:idx_tenders_tenant_order_ref"`
+	CustomerID string    `json:"customer_id" gorm:"not null;index"`
+	Amount     int64     `json:"amount"`
+	Currency   string    `json:"currency" gorm:"not null"`
+	Applied    []Applied `json:"applied" gorm:"serializer:json"`
+	Covered    int64     `json:"covered"`
+	Remaining  int64     `json:"remaining"`
+	Status     string    `json:"status" gorm:"not null"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	TenantID   string    `json:"-" gorm:"not null;default:'default';uniqueIndex:idx_tenders_tenant_order_ref"`
+}
+
+// Applied is what one gift card or the store credit paid towards a tender.
+type Applied struct {
+	Source     string `json:"source"`
+	GiftCardID uint   `json:"gift_card_id,omitempty"`
+	Code       string `json:"code,omitempty"`
+	Amount     int64  `json:"amount"`
+}
+
+type TenderRequest struct {
+	Amount      int64    `json:"amount" validate:"gt=0"`
+	Currency    string   `json:"currency" validate:"required,len=3,uppercase"`
+	GiftCards   []string `json:"gift_cards" validate:"max=10,dive,required"`
+	StoreCredit bool     `json:"store_credit"`
+}
+
+// ApplyTender pays as much of an order as it can with the gift cards, in
+// the order given, and then with the customer's store credit. Each gift
+// card and the credit account are locked while their balance is read and
+// spent, so parallel redemptions cannot spend the same balance twice.
+func (handler *handler) ApplyTender(c echo.Context) error {
+	request := TenderRequest{}
+	orderRef := c.Param("id")
+	customerID := middleware.GetCustomerID(c)
+	logger := middleware.GetLogger(c)
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+	if len(request.GiftCards) == 0 && !request.StoreCredit {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "gift_cards or store_credit is required"})
+	}
+	codes := make([]string, len(request.GiftCards))
+	seen := map[string]bool{}
+	for i, code := range request.GiftCards {
+		codes[i] = normalizeCode(code)
+		if seen[codes[i]] {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "gift_cards must not repeat a card"})
+		}
+		seen[codes[i]] = true
+	}
+
+	tender := Tender{OrderRef: orderRef, CustomerID: customerID, Amount: request.Amount, Currency: request.Currency, Applied: []Applied{}, Status: TenderApplied}
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := unpaid(tx, orderRef); err != nil {
+			return err
+		}
+		if err := handler.openTender(tx, &tender); err != nil {
+			return err
+		}
+
+		cards := []GiftCard{}
+		if len(codes) > 0 {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code IN ?", codes).Order("id").Find(&cards).Error; err != nil {
+				return err
+			}
+		}
+		byCode := map[string]GiftCard{}
+		for _, card := range cards {
+			byCode[card.Code] = card
+		}
+
+		remaining := request.Amount
+		for _, code := range codes {
+			card, ok := byCode[code]
+			switch {
+			case !ok:
+				return invalid(fmt.Sprintf("Gift card %s not found", maskCode(code)))
+			case card.Currency != request.Currency:
+				return invalid(fmt.Sprintf("Gift card %s is in %s", maskCode(code), card.Currency))
+			case card.ExpiresAt != nil && !handler.now().Before(*card.ExpiresAt):
+				return invalid(fmt.Sprintf("Gift card %s has expired", maskCode(code)))
+			}
+			balance, err := giftCardBalance(tx, card.ID)
+			if err != nil {
+				return err
+			}
+			spent := min(balance, remaining)
+			if spent <= 0 {
+				continue
+			}
+			entry := GiftCardEntry{GiftCardID: card.ID, Type: EntryRedeem, Amount: -spent, OrderRef: orderRef, TenderID: &tender.ID}
+			if err := tx.Create(&entry).Error; err != nil {
+				return err
+			}
+			tender.Applied = append(tender.Applied, Applied{Source: SourceGiftCard, GiftCardID: card.ID, Code: maskCode(code), Amount: spent})
+			remaining -= spent
+		}
+
+		if request.StoreCredit && remaining > 0 {
+			if err := lockAccount(tx, customerID, request.Currency, handler.now()); err != nil {
+				return err
+			}
+			balance, err := creditBalance(tx, customerID, request.Currency)
+			if err != nil {
+				return err
+			}
+			if spent := min(balance, remaining); spent > 0 {
+				entry := Entry{CustomerID: customerID, Currency: request.Currency, Type: EntryRedeem, Amount: -spent, OrderRef: orderRef, TenderID: &tender.ID}
+				if err := tx.Create(&entry).Error; err != nil {
+					return err
+				}
+				tender.Applied = append(tender.Applied, Applied{Source: SourceStoreCredit, Amount: spent})
+				remaining -= spent
+			}
+		}
+
+		if remaining == request.Amount {
+			return invalid("There is no gift card or store credit balance to use")
+		}
+		tender.Covered, tender.Remaining = request.Amount-remaining, remaining
+		return tx.Save(&tender).Error
+	})
+	if err != nil {
+		return creditError(c, err, "Order not found")
+	}
+
+	logger.Info("tender applied", zap.String("order_ref", orderRef), zap.Int64("covered", tender.Covered), zap.Int64("remaining", tender.Remaining))
+	return c.JSON(http.StatusCreated, tender)
+}
+
+// openTender records the order's tender. An order that already has a tender
+// can only be tendered again once it has been reversed, and then reuses it.
+func (handler *handler) openTender(tx *gorm.DB, tender *Tender) error {
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(tender)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+
+	existing := Tender{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_ref = ?", tender.OrderRef).First(&existing).Error; err != nil {
+		return err
+	}
+	if existing.Status != TenderReversed || existing.CustomerID != tender.CustomerID {
+		return conflict("Order already has gift cards or store credit applied")
+	}
+	tender.ID, tender.CreatedAt = existing.ID, existing.CreatedAt
+	return nil
+}
+
+// unpaid returns a conflict unless the order's payment has not been made:
+// there is none, it is pending or it failed.
+func unpaid(tx *gorm.DB, orderRef string) error {
+	paid := payment.Payment{}
+	err := tx.Where("order_ref = ?", orderRef).First(&paid).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil
+	case err != nil:
+		return err
+	case paid.Status != payment.StatusPending && paid.Status != payment.StatusFailed:
+		return conflict("Order has already been paid")
+	}
+	return nil
+}
+
+// OrderTender returns the order's applied tender: the total it was applied
+// to, what is left to pay and its currency. It returns payment.ErrNoTender
+// when the order has no applied tender.
+func OrderTender(db *gorm.DB, orderRef string) (payment.Tendered, error) {
+	tender := Tender{}
+	err := db.Where("order_ref = ? AND status = ?", orderRef, TenderApplied).First(&tender).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return payment.Tendered{}, payment.ErrNoTender
+	}
+	if err != nil {
+		return payment.Tendered{}, err
+	}
+	return payment.Tendered{Amount: tender.Amount, Remaining: tender.Remaining, Currency: tender.Currency}, nil
+}
+
+func (handler *handler) findTender(db *gorm.DB, c echo.Context, tender *Tender) error {
+	err := db.Where("order_ref = ? AND customer_id = ?", c.Param("id"), middleware.GetCustomerID(c)).First(tender).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errNotFound
+	}
+	return err
+}
+
+// GetTender shows what was paid towards the customer's order with gift
+// cards and store credit.
+func (handler *handler) GetTender(c echo.Context) error {
+	tender := Tender{}
+	if err := handler.findTender(handler.db.WithContext(c.Request().Context()), c, &tender); err != nil {
+		return creditError(c, err, "Order has no tender")
+	}
+	return c.JSON(http.StatusOK, tender)
+}
+
+// ReverseTender gives back what the order's tender spent, for when the rest
+// of the order could not be paid or the order is cancelled. It is refused
+// once the order's payment has been authorized, as the order then goes
+// ahead. The redemptions stay in the ledgers, offset by reversal entries.
+func (handler *handler) ReverseTender(c echo.Context) error {
+	tender := Tender{}
+	logger := middleware.GetLogger(c)
+
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := handler.findTender(tx.Clauses(clause.Locking{Strength: "UPDATE"}), c, &tender); err != nil {
+			return err
+		}
+		if tender.Status == TenderReversed {
+			return conflict("Tender has already been reversed")
+		}
+		if err := unpaid(tx, tender.OrderRef); err != nil {
+			return err
+		}
+
+		for _, applied := range tender.Applied {
+			var err error
+			if applied.Source == SourceGiftCard {
+				err = tx.Create(&GiftCardEntry{GiftCardID: applied.GiftCardID, Type: EntryReversal, Amount: applied.Amount, OrderRef: tender.OrderRef, TenderID: &tender.ID}).Error
+			} else {
+				err = tx.Create(&Entry{CustomerID: tender.CustomerID, Currency: tender.Currency, Type: EntryReversal, Amount: applied.Amount, OrderRef: tender.OrderRef, TenderID: &tender.ID}).Error
+			}
+			if err != nil {
+				return err
+			}
+		}
+		tender.Status = TenderReversed
+		return tx.Model(&tender).Update("status", tender.Status).Error
+	})
+	if err != nil {
+		return creditError(c, err, "Order has no tender")
+	}
+
+	logger.Info("tender reversed", zap.String("order_ref", tender.OrderRef), zap.Int64("covered", tender.Covered))
+	return c.JSON(http.StatusOK, tender)
+}
@@ -0,0 +1,360 @@
+package credit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	openTenderQuery   = `INSERT INTO "tenders" ("order_ref","customer_id","amount","currency","applied","covered","remaining","status","created_at","updated_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) ON CONFLICT DO NOTHING RETURNING "id"`
+	lockOrderQuery    = `SELECT * FROM "tenders" WHERE order_ref = $1 ORDER BY "tenders"."id" LIMIT $2 FOR UPDATE`
+	lockGiftCardQuery = `SELECT * FROM "gift_cards" WHERE code IN ($1,$2) ORDER BY id FOR UPDATE`
+	saveTenderQuery   = `UPDATE "tenders" SET "order_ref"=$1,"customer_id"=$2,"amount"=$3,"currency"=$4,"applied"=$5,"covered"=$6,"remaining"=$7,"status"=$8,"created_at"=$9,"updated_at"=$10,"tenant_id"=$11 WHERE "id" = $12`
+	findTenderQuery   = `SELECT * FROM "tenders" WHERE order_ref = $1 AND customer_id = $2 ORDER BY "tenders"."id" LIMIT $3 FOR UPDATE`
+	reverseQuery      = `UPDATE "tenders" SET "status"=$1,"updated_at"=$2 WHERE "id" = $3`
+	orderPaymentQuery = `SELECT * FROM "payments" WHERE order_ref = $1 ORDER BY "payments"."id" LIMIT $2`
+	appliedQuery      = `SELECT * FROM "tenders" WHERE order_ref = $1 AND status = $2 ORDER BY "tenders"."id" LIMIT $3`
+)
+
+// expectPayment expects the order's payment to be looked up and found with
+// status, or not found when status is empty.
+func expectPayment(mock sqlmock.Sqlmock, status string) {
+	rows := sqlmock.NewRows([]string{"id", "order_ref", "customer_id", "status"})
+	if status != "" {
+		rows.AddRow(1, "ORD-1", "c-1", status)
+	}
+	mock.ExpectQuery(orderPaymentQuery).WithArgs("ORD-1", 1).WillReturnRows(rows)
+}
+
+func cardRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "code", "amount", "currency", "expires_at"}).
+		AddRow(3, "AAAABBBBCCCC1111", 50000, "THB", nil).
+		AddRow(4, "AAAABBBBCCCC2222", 10000, "THB", nil)
+}
+
+func TestApplyTender(t *testing.T) {
+	t.Run("spend gift cards in order then store credit given partial balances", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		body := `{"amount": 90000, "currency": "THB", "gift_cards": ["aaaa-bbbb-cccc-2222", "AAAABBBBCCCC1111"], "store_credit": true}`
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		expectPayment(mock, "")
+		mock.ExpectQuery(openTenderQuery).
+			WithArgs("ORD-1", "c-1", 90000, "THB", "[]", 0, 0, TenderApplied, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+		mock.ExpectQuery(lockGiftCardQuery).WithArgs("AAAABBBBCCCC2222", "AAAABBBBCCCC1111").WillReturnRows(cardRows())
+		mock.ExpectQuery(cardBalanceQuery).WithArgs(4).WillReturnRows(balance(10000))
+		mock.ExpectQuery(createCardEntryQuery).WithArgs(4, EntryRedeem, -10000, "ORD-1", 9, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+		mock.ExpectQuery(cardBalanceQuery).WithArgs(3).WillReturnRows(balance(45000))
+		mock.ExpectQuery(createCardEntryQuery).WithArgs(3, EntryRedeem, -45000, "ORD-1", 9, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+		expectAccount(mock, "c-1")
+		mock.ExpectQuery(creditBalanceQuery).WithArgs("c-1", "THB").WillReturnRows(balance(20000))
+		mock.ExpectQuery(createCreditQuery).WithArgs("c-1", "THB", EntryRedeem, -20000, "", "ORD-1", 9, sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+		mock.ExpectExec(saveTenderQuery).
+			WithArgs("ORD-1", "c-1", 90000, "THB", sqlmock.AnyArg(), 75000, 15000, TenderApplied, sqlmock.AnyArg(), sqlmock.AnyArg(), "default", 9).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireCustomer(handler.ApplyTender)(c)
+
+		tender := Tender{}
+		json.Unmarshal(response.Body.Bytes(), &tender)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, int64(75000), tender.Covered)
+		assert.Equal(t, int64(15000), tender.Remaining)
+		assert.Equal(t, []Applied{
+			{Source: SourceGiftCard, GiftCardID: 4, Code: "************2222", Amount: 10000},
+			{Source: SourceGiftCard, GiftCardID: 3, Code: "************1111", Amount: 45000},
+			{Source: SourceStoreCredit, Amount: 20000},
+		}, tender.Applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given order already tendered", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 90000, "currency": "THB", "store_credit": true}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		expectPayment(mock, "")
+		mock.ExpectQuery(openTenderQuery).
+			WithArgs("ORD-1", "c-1", 90000, "THB", "[]", 0, 0, TenderApplied, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(lockOrderQuery).WithArgs("ORD-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "customer_id", "status"}).AddRow(9, "ORD-1", "c-1", TenderApplied))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireCustomer(handler.ApplyTender)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return unprocessable entity given expired gift card", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 90000, "currency": "THB", "gift_cards": ["AAAABBBBCCCC1111", "AAAABBBBCCCC2222"]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		expectPayment(mock, "")
+		mock.ExpectQuery(openTenderQuery).
+			WithArgs("ORD-1", "c-1", 90000, "THB", "[]", 0, 0, TenderApplied, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+		mock.ExpectQuery(lockGiftCardQuery).WithArgs("AAAABBBBCCCC1111", "AAAABBBBCCCC2222").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "code", "amount", "currency", "expires_at"}).
+				AddRow(3, "AAAABBBBCCCC1111", 50000, "THB", now))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireCustomer(handler.ApplyTender)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+		assert.Contains(t, response.Body.String(), "expired")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given order already paid", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 90000, "currency": "THB", "store_credit": true}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		expectPayment(mock, payment.StatusAuthorized)
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireCustomer(handler.ApplyTender)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given no gift card or store credit", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 90000, "currency": "THB"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		handler := NewHandler(nil)
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireCustomer(handler.ApplyTender)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestReverseTender(t *testing.T) {
+	t.Run("credit back each source given applied tender", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		applied := `[{"source":"gift_card","gift_card_id":4,"amount":10000},{"source":"store_credit","amount":20000}]`
+		mock.ExpectBegin()
+		mock.ExpectQuery(findTenderQuery).WithArgs("ORD-1", "c-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "customer_id", "amount", "currency", "applied", "covered", "remaining", "status"}).
+				AddRow(9, "ORD-1", "c-1", 90000, "THB", applied, 30000, 60000, TenderApplied))
+		expectPayment(mock, payment.StatusFailed)
+		mock.ExpectQuery(createCardEntryQuery).WithArgs(4, EntryReversal, 10000, "ORD-1", 9, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(22))
+		mock.ExpectQuery(createCreditQuery).WithArgs("c-1", "THB", EntryReversal, 20000, "", "ORD-1", 9, sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+		mock.ExpectExec(reverseQuery).WithArgs(TenderReversed, sqlmock.AnyArg(), 9).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireCustomer(handler.ReverseTender)(c)
+
+		tender := Tender{}
+		json.Unmarshal(response.Body.Bytes(), &tender)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, TenderReversed, tender.Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given order payment authorized", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(findTenderQuery).WithArgs("ORD-1", "c-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "customer_id", "status"}).AddRow(9, "ORD-1", "c-1", TenderApplied))
+		expectPayment(mock, payment.StatusAuthorized)
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireCustomer(handler.ReverseTender)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.JSONEq(t, `{"error": "Order has already been paid"}`, response.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given tender already reversed", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(findTenderQuery).WithArgs("ORD-1", "c-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "customer_id", "status"}).AddRow(9, "ORD-1", "c-1", TenderReversed))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireCustomer(handler.ReverseTender)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOrderTender(t *testing.T) {
+	t.Run("return total and what is left to pay given applied tender", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(appliedQuery).WithArgs("ORD-1", TenderApplied, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "amount", "currency", "remaining", "status"}).AddRow(9, "ORD-1", 90000, "THB", 15000, TenderApplied))
+
+		tendered, err := OrderTender(gormDB, "ORD-1")
+
+		assert.NoError(t, err)
+		assert.Equal(t, payment.Tendered{Amount: 90000, Remaining: 15000, Currency: "THB"}, tendered)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return no tender given order without applied tender", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(appliedQuery).WithArgs("ORD-1", TenderApplied, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, err := OrderTender(gormDB, "ORD-1")
+
+		assert.ErrorIs(t, err, payment.ErrNoTender)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,334 @@
+package digital
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/blob"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	FormatEbook     = "ebook"
+	FormatAudiobook = "audiobook"
+
+	maxFileSize = 1 << 30
+)
+
+// File is the master copy of an ebook or audiobook in the blob store. It is
+// copied for each customer when they are granted the book.
+type File struct {
+	BookID      uint      `json:"book_id" gorm:"primaryKey;autoIncrement:false"`
+	Key         string    `json:"-" gorm:"not null"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	TenantID    string    `json:"-" gorm:"not null;default:'default';index"`
+}
+
+func (File) TableName() string {
+	return "digital_files"
+}
+
+// Entitlement gives a customer the right to download a book they bought.
+// Key is the customer's own copy of the file, taken when the order was
+// paid, so replacing the master file does not change what they bought. It
+// is empty until then.
+type Entitlement struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	CustomerID   string    `json:"customer_id" gorm:"not null;uniqueIndex:idx_entitlements_customer_book"`
+	BookID       uint      `json:"book_id" gorm:"not null;uniqueIndex:idx_entitlements_customer_book"`
+	OrderRef     string    `json:"order_ref" gorm:"not null;index"`
+	Key          string    `json:"-"`
+	FileName     string    `json:"file_name"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Downloads    int       `json:"downloads"`
+	MaxDownloads int       `json:"max_downloads"`
+	CreatedAt    time.Time `json:"created_at"`
+	TenantID     string    `json:"-" gorm:"not null;default:'default';index"`
+}
+
+type GrantRequest struct {
+	BookIDs []uint `json:"book_ids" validate:"min=1,unique,dive,required"`
+}
+
+// Options are the download settings.
+type Options struct {
+	SigningSecret string
+	LinkTTL       time.Duration
+	MaxDownloads  int
+	BaseURL       string
+}
+
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+func (c *CustomValidator) Validate(i interface{}) error {
+	return c.validator.Struct(i)
+}
+
+type handler struct {
+	db      *gorm.DB
+	blobs   blob.BlobStore
+	options Options
+	logger  *zap.Logger
+	now     func() time.Time
+}
+
+func NewHandler(db *gorm.DB, blobs blob.BlobStore, options Options, logger *zap.Logger) *handler {
+	return &handler{db: db, blobs: blobs, options: options, logger: logger, now: time.Now}
+}
+
+func isDigital(format string) bool {
+	return format == FormatEbook || format == FormatAudiobook
+}
+
+func fileKey(bookID uint) string {
+	return fmt.Sprintf("digital/books/%d", bookID)
+}
+
+func entitlementKey(id uint) string {
+	return fmt.Sprintf("digital/entitlements/%d", id)
+}
+
+// contentType takes the type the client sent, or guesses it from the file
+// name.
+func contentType(fileName string, sent string) string {
+	if sent != "" && sent != "application/octet-stream" {
+		return sent
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".epub":
+		return "application/epub+zip"
+	case ".m4b":
+		return "audio/mp4"
+	}
+	if guessed := mime.TypeByExtension(filepath.Ext(fileName)); guessed != "" {
+		return guessed
+	}
+	return "application/octet-stream"
+}
+
+// UploadFile stores the master file of an ebook or audiobook.
+func (handler *handler) UploadFile(c echo.Context) error {
+	found := book.Book{}
+	id := c.Param("id")
+	logger := middleware.GetLogger(c)
+
+	if handler.blobs == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "File storage is not configured"})
+	}
+
+	if err := handler.db.WithContext(c.Request().Context()).First(&found, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if !isDigital(found.Format) {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "Only ebooks and audiobooks have files"})
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A file field is required"})
+	}
+	if header.Size > maxFileSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("File must not exceed %d bytes", maxFileSize)})
+	}
+	file, err := header.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	defer file.Close()
+
+	stored := File{
+		BookID:      found.ID,
+		Key:         fileKey(found.ID),
+		FileName:    filepath.Base(header.Filename),
+		ContentType: contentType(header.Filename, header.Header.Get(echo.HeaderContentType)),
+		Size:        header.Size,
+		UploadedAt:  handler.now(),
+	}
+	if err := handler.blobs.Put(c.Request().Context(), stored.Key, file, stored.ContentType); err != nil {
+		logger.Error("failed to store file", zap.String("id", id), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store file"})
+	}
+	if err := handler.db.WithContext(c.Request().Context()).Clauses(clause.OnConflict{UpdateAll: true}).Create(&stored).Error; err != nil {
+		logger.Error("failed to save file", zap.String("id", id), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save file"})
+	}
+
+	logger.Info("digital file uploaded", zap.String("id", id), zap.Int64("size", stored.Size))
+	return c.JSON(http.StatusOK, stored)
+}
+
+// grantable are the statuses of payments whose books can be granted. Books
+// granted before the payment is captured are delivered once it is.
+var grantable = map[string]bool{
+	payment.StatusPending:    true,
+	payment.StatusAuthorized: true,
+	payment.StatusPaid:       true,
+}
+
+// Grant gives the customer who pays for an order the ebooks and audiobooks
+// in it. Books the customer already owns are returned as they are. Each
+// book's file is copied for the customer once the order is paid, so a grant
+// for an order that has not been captured yet waits for its capture.
+func (handler *handler) Grant(c echo.Context) error {
+	request := GrantRequest{}
+	orderRef := c.Param("id")
+	logger := middleware.GetLogger(c)
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+	if handler.blobs == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "File storage is not configured"})
+	}
+
+	paid := payment.Payment{}
+	if err := handler.db.WithContext(c.Request().Context()).Where("order_ref = ?", orderRef).First(&paid).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Order has no payment"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if !grantable[paid.Status] {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order is not being paid"})
+	}
+
+	files := []File{}
+	if err := handler.db.WithContext(c.Request().Context()).Where("book_id IN ?", request.BookIDs).Find(&files).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	byBook := map[uint]File{}
+	for _, file := range files {
+		byBook[file.BookID] = file
+	}
+	missing := []string{}
+	for _, bookID := range request.BookIDs {
+		if _, ok := byBook[bookID]; !ok {
+			missing = append(missing, strconv.FormatUint(uint64(bookID), 10))
+		}
+	}
+	if len(missing) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "Books have no digital file: " + strings.Join(missing, ", ")})
+	}
+
+	granted := []Entitlement{}
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		for _, bookID := range request.BookIDs {
+			entitlement := Entitlement{
+				CustomerID:   paid.CustomerID,
+				BookID:       bookID,
+				OrderRef:     orderRef,
+				MaxDownloads: handler.options.MaxDownloads,
+			}
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entitlement)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				if err := tx.Where("customer_id = ? AND book_id = ?", paid.CustomerID, bookID).First(&entitlement).Error; err != nil {
+					return err
+				}
+			}
+			granted = append(granted, entitlement)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("failed to grant books", zap.String("order_ref", orderRef), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to grant books"})
+	}
+
+	if paid.Status == payment.StatusPaid {
+		delivered, err := handler.deliver(c.Request().Context(), paid)
+		if err != nil {
+			logger.Error("failed to deliver books", zap.String("order_ref", orderRef), zap.Error(err))
+		}
+		for i := range granted {
+			if copied, ok := delivered[granted[i].ID]; ok {
+				granted[i] = copied
+			}
+		}
+	}
+
+	logger.Info("digital books granted", zap.String("order_ref", orderRef), zap.Int("books", len(granted)))
+	return c.JSON(http.StatusCreated, granted)
+}
+
+// OrderPaid delivers the books granted for an order once its payment is
+// captured.
+func (handler *handler) OrderPaid(ctx context.Context, paid payment.Payment) {
+	if handler.blobs == nil {
+		return
+	}
+	if _, err := handler.deliver(ctx, paid); err != nil {
+		handler.logger.Error("failed to deliver books", zap.String("order_ref", paid.OrderRef), zap.Error(err))
+	}
+}
+
+// deliver copies the current file of each book granted for a paid order
+// that has not been delivered yet, and returns the entitlements it
+// delivered by ID. The copy is made before the entitlement is updated and
+// deleted if the update fails, so no transaction is held open while files
+// are copied and no copy is left without an entitlement.
+func (handler *handler) deliver(ctx context.Context, paid payment.Payment) (map[uint]Entitlement, error) {
+	db := handler.db.WithContext(ctx)
+	pending := []Entitlement{}
+	if err := db.Where("order_ref = ? AND key = ''", paid.OrderRef).Find(&pending).Error; err != nil {
+		return nil, err
+	}
+
+	delivered := map[uint]Entitlement{}
+	for _, entitlement := range pending {
+		file := File{}
+		if err := db.Where("book_id = ?", entitlement.BookID).First(&file).Error; err != nil {
+			return delivered, err
+		}
+		key := entitlementKey(entitlement.ID)
+		if err := copyBlob(ctx, handler.blobs, file.Key, key); err != nil {
+			return delivered, err
+		}
+		err := db.Model(&entitlement).Updates(map[string]interface{}{
+			"key":          key,
+			"file_name":    file.FileName,
+			"content_type": file.ContentType,
+			"size":         file.Size,
+		}).Error
+		if err != nil {
+			if deleteErr := handler.blobs.Delete(ctx, key); deleteErr != nil {
+				handler.logger.Error("failed to delete undelivered copy", zap.String("key", key), zap.Error(deleteErr))
+			}
+			return delivered, err
+		}
+		entitlement.Key, entitlement.FileName, entitlement.ContentType, entitlement.Size = key, file.FileName, file.ContentType, file.Size
+		delivered[entitlement.ID] = entitlement
+	}
+	return delivered, nil
+}
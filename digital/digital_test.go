@@ -0,0 +1,365 @@
+package digital
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/payment"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	getBookQuery              = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2`
+	insertFileQuery           = `INSERT INTO "digital_files" ("book_id","key","file_name","content_type","size","uploaded_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7) ON CONFLICT ("book_id") DO UPDATE SET "key"="excluded"."key","file_name"="excluded"."file_name","content_type"="excluded"."content_type","size"="excluded"."size","uploaded_at"="excluded"."uploaded_at","tenant_id"="excluded"."tenant_id"`
+	getPaymentQuery           = `SELECT * FROM "payments" WHERE order_ref = $1 ORDER BY "payments"."id" LIMIT $2`
+	getFilesQuery             = `SELECT * FROM "digital_files" WHERE book_id IN ($1,$2)`
+	createEntitlementQuery    = `INSERT INTO "entitlements" ("customer_id","book_id","order_ref","key","file_name","content_type","size","downloads","max_downloads","created_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) ON CONFLICT DO NOTHING RETURNING "id"`
+	getEntitlementQuery       = `SELECT * FROM "entitlements" WHERE customer_id = $1 AND book_id = $2 ORDER BY "entitlements"."id" LIMIT $3`
+	getUndeliveredQuery       = `SELECT * FROM "entitlements" WHERE order_ref = $1 AND key = ''`
+	getFileQuery              = `SELECT * FROM "digital_files" WHERE book_id = $1 ORDER BY "digital_files"."book_id" LIMIT $2`
+	deliverQuery              = `UPDATE "entitlements" SET "content_type"=$1,"file_name"=$2,"key"=$3,"size"=$4 WHERE "id" = $5`
+	getTenantUndeliveredQuery = `SELECT * FROM "entitlements" WHERE (order_ref = $1 AND key = '') AND "entitlements"."tenant_id" = $2`
+	getTenantFileQuery        = `SELECT * FROM "digital_files" WHERE book_id = $1 AND "digital_files"."tenant_id" = $2 ORDER BY "digital_files"."book_id" LIMIT $3`
+	deliverTenantQuery        = `UPDATE "entitlements" SET "content_type"=$1,"file_name"=$2,"key"=$3,"size"=$4 WHERE "entitlements"."tenant_id" = $5 AND "id" = $6`
+)
+
+func expectPayment(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectQuery(getPaymentQuery).WithArgs("ORD-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "customer_id", "status", "tenant_id"}).AddRow(1, "ORD-1", "c-1", status, "default"))
+}
+
+func expectDelivery(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(getUndeliveredQuery).WithArgs("ORD-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "book_id", "order_ref", "key", "max_downloads"}).AddRow(7, "c-1", 1, "ORD-1", "", 3))
+	mock.ExpectQuery(getFileQuery).WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "key", "file_name", "content_type", "size"}).AddRow(1, "digital/books/1", "sapiens.epub", "application/epub+zip", 5))
+}
+
+func TestUploadFile(t *testing.T) {
+	t.Run("store master file given ebook", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "sapiens.epub")
+		io.WriteString(part, "epub!")
+		writer.Close()
+
+		request := httptest.NewRequest(http.MethodPut, "/", body)
+		request.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		store := newMemoryStore()
+
+		mock.ExpectQuery(getBookQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "format"}).AddRow(1, "Sapiens", FormatEbook))
+		mock.ExpectBegin()
+		mock.ExpectExec(insertFileQuery).
+			WithArgs(1, "digital/books/1", "sapiens.epub", "application/epub+zip", 5, now, "default").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, store, testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.UploadFile(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, []byte("epub!"), store.blobs["digital/books/1"])
+		assert.Equal(t, "application/epub+zip", store.types["digital/books/1"])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return unprocessable entity given print book", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "sapiens.pdf")
+		io.WriteString(part, "pdf")
+		writer.Close()
+
+		request := httptest.NewRequest(http.MethodPut, "/", body)
+		request.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "format"}).AddRow(1, "Sapiens", "print"))
+
+		handler := NewHandler(gormDB, newMemoryStore(), testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.UploadFile(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+	})
+}
+
+func TestGrant(t *testing.T) {
+	fileRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"book_id", "key", "file_name", "content_type", "size"}).
+			AddRow(1, "digital/books/1", "sapiens.epub", "application/epub+zip", 5).
+			AddRow(2, "digital/books/2", "dune.m4b", "audio/mp4", 9)
+	}
+
+	t.Run("copy file for customer given paid order and keep owned book", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"book_ids": [1, 2]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		store := newMemoryStore()
+		store.Put(context.Background(), "digital/books/1", strings.NewReader("epub!"), "application/epub+zip")
+
+		expectPayment(mock, payment.StatusPaid)
+		mock.ExpectQuery(getFilesQuery).WithArgs(1, 2).WillReturnRows(fileRows())
+		mock.ExpectBegin()
+		mock.ExpectQuery(createEntitlementQuery).
+			WithArgs("c-1", 1, "ORD-1", "", "", "", 0, 0, 3, sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectQuery(createEntitlementQuery).
+			WithArgs("c-1", 2, "ORD-1", "", "", "", 0, 0, 3, sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(getEntitlementQuery).WithArgs("c-1", 2, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "book_id", "order_ref", "key", "file_name", "downloads", "max_downloads"}).AddRow(5, "c-1", 2, "ORD-0", "digital/entitlements/5", "dune.m4b", 1, 3))
+		mock.ExpectCommit()
+		expectDelivery(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(deliverQuery).WithArgs("application/epub+zip", "sapiens.epub", "digital/entitlements/7", 5, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, store, testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Grant(c)
+
+		granted := []Entitlement{}
+		json.Unmarshal(response.Body.Bytes(), &granted)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Len(t, granted, 2)
+		assert.Equal(t, uint(7), granted[0].ID)
+		assert.Equal(t, "sapiens.epub", granted[0].FileName)
+		assert.Equal(t, "ORD-0", granted[1].OrderRef)
+		assert.Equal(t, []byte("epub!"), store.blobs["digital/entitlements/7"])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("grant without copying file given order not captured yet", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"book_ids": [1, 2]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		store := newMemoryStore()
+
+		expectPayment(mock, payment.StatusAuthorized)
+		mock.ExpectQuery(getFilesQuery).WithArgs(1, 2).WillReturnRows(fileRows())
+		mock.ExpectBegin()
+		mock.ExpectQuery(createEntitlementQuery).
+			WithArgs("c-1", 1, "ORD-1", "", "", "", 0, 0, 3, sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectQuery(createEntitlementQuery).
+			WithArgs("c-1", 2, "ORD-1", "", "", "", 0, 0, 3, sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, store, testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Grant(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Empty(t, store.blobs)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given voided order", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"book_ids": [1]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectPayment(mock, payment.StatusVoided)
+
+		handler := NewHandler(gormDB, newMemoryStore(), testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Grant(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return unprocessable entity given book without file", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"book_ids": [1, 3]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectPayment(mock, payment.StatusPaid)
+		mock.ExpectQuery(getFilesQuery).WithArgs(1, 3).WillReturnRows(fileRows())
+
+		handler := NewHandler(gormDB, newMemoryStore(), testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Grant(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+		assert.Contains(t, response.Body.String(), "3")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given repeated book", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"book_ids": [1, 1]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("ORD-1")
+
+		handler := NewHandler(nil, newMemoryStore(), testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Grant(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestOrderPaid(t *testing.T) {
+	paid := payment.Payment{OrderRef: "ORD-1", CustomerID: "c-1", Status: payment.StatusPaid, TenantID: "default"}
+
+	t.Run("copy files of granted books given captured payment", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		store := newMemoryStore()
+		store.Put(context.Background(), "digital/books/1", strings.NewReader("epub!"), "application/epub+zip")
+
+		expectDelivery(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(deliverQuery).WithArgs("application/epub+zip", "sapiens.epub", "digital/entitlements/7", 5, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, store, testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		handler.OrderPaid(context.Background(), paid)
+
+		assert.Equal(t, []byte("epub!"), store.blobs["digital/entitlements/7"])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("delete copy given entitlement not updated", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		store := newMemoryStore()
+		store.Put(context.Background(), "digital/books/1", strings.NewReader("epub!"), "application/epub+zip")
+
+		expectDelivery(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(deliverQuery).WithArgs("application/epub+zip", "sapiens.epub", "digital/entitlements/7", 5, 7).WillReturnError(errors.New("connection reset"))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, store, testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		handler.OrderPaid(context.Background(), paid)
+
+		assert.NotContains(t, store.blobs, "digital/entitlements/7")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("deliver only the storefront's entitlements given tenant plugin", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		assert.NoError(t, gormDB.Use(tenant.Plugin{}))
+		store := newMemoryStore()
+		store.Put(context.Background(), "digital/books/1", strings.NewReader("epub!"), "application/epub+zip")
+
+		mock.ExpectQuery(getTenantUndeliveredQuery).WithArgs("ORD-1", "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "book_id", "order_ref", "key", "max_downloads", "tenant_id"}).AddRow(7, "c-1", 1, "ORD-1", "", 3, "th"))
+		mock.ExpectQuery(getTenantFileQuery).WithArgs(1, "th", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"book_id", "key", "file_name", "content_type", "size", "tenant_id"}).AddRow(1, "digital/books/1", "sapiens.epub", "application/epub+zip", 5, "th"))
+		mock.ExpectBegin()
+		mock.ExpectExec(deliverTenantQuery).WithArgs("application/epub+zip", "sapiens.epub", "digital/entitlements/7", 5, "th", 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, store, testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		handler.OrderPaid(tenant.NewContext(context.Background(), "th"), payment.Payment{OrderRef: "ORD-1", CustomerID: "c-1", Status: payment.StatusPaid, TenantID: "th"})
+
+		assert.Equal(t, []byte("epub!"), store.blobs["digital/entitlements/7"])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
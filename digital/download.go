@@ -0,0 +1,153 @@
+package digital
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/blob"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/tenant"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LibraryItem is a book the customer owns, with a link to download it.
+// DownloadURL is empty once the download limit is reached.
+type LibraryItem struct {
+	Entitlement
+	Title       string     `json:"title"`
+	Author      string     `json:"author"`
+	Format      string     `json:"format"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+func copyBlob(ctx context.Context, blobs blob.BlobStore, from string, to string) error {
+	reader, info, err := blobs.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return blobs.Put(ctx, to, reader, info.ContentType)
+}
+
+// sign returns the HMAC-SHA256 of the entitlement ID and the time the link
+// expires, so neither can be changed without the secret.
+func sign(secret string, id uint, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// link returns a download URL for the entitlement that expires after the
+// link TTL.
+func (handler *handler) link(id uint) (string, time.Time) {
+	expires := handler.now().Add(handler.options.LinkTTL).Truncate(time.Second)
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", sign(handler.options.SigningSecret, id, expires.Unix()))
+	return fmt.Sprintf("%s/downloads/%d?%s", handler.options.BaseURL, id, query.Encode()), expires
+}
+
+// Library lists the books the customer owns, newest first, each with a
+// fresh download link. Books are listed once they are delivered.
+func (handler *handler) Library(c echo.Context) error {
+	items := []LibraryItem{}
+	err := handler.db.WithContext(c.Request().Context()).Model(&Entitlement{}).
+		Select("entitlements.*, books.title, books.author, books.format").
+		Joins("JOIN books ON books.id = entitlements.book_id AND books.tenant_id = ?", middleware.GetTenantID(c)).
+		Where("entitlements.customer_id = ? AND entitlements.key <> ''", middleware.GetCustomerID(c)).
+		Order("entitlements.id DESC").
+		Scan(&items).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	if handler.options.SigningSecret != "" {
+		for i := range items {
+			if items[i].Downloads >= items[i].MaxDownloads {
+				continue
+			}
+			link, expires := handler.link(items[i].ID)
+			items[i].DownloadURL, items[i].ExpiresAt = link, &expires
+		}
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+var errLimitReached = errors.New("download limit reached")
+
+// Download streams the customer's copy of a book. The link itself is the
+// credential: it must carry a valid signature that has not expired. Each
+// download counts towards the entitlement's limit, even if it is not
+// completed.
+func (handler *handler) Download(c echo.Context) error {
+	logger := middleware.GetLogger(c)
+
+	if handler.options.SigningSecret == "" || handler.blobs == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Downloads are not configured"})
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Download not found"})
+	}
+	expires, err := strconv.ParseInt(c.QueryParam("expires"), 10, 64)
+	expected := sign(handler.options.SigningSecret, uint(id), expires)
+	if err != nil || !hmac.Equal([]byte(c.QueryParam("signature")), []byte(expected)) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Download link is not valid"})
+	}
+	if handler.now().Unix() > expires {
+		return c.JSON(http.StatusGone, map[string]string{"error": "Download link has expired"})
+	}
+
+	// The signed link names the entitlement, and links are served from one
+	// base URL for every storefront, so the lookup is not scoped by tenant.
+	entitlement := Entitlement{}
+	err = handler.db.WithContext(tenant.Unscoped(c.Request().Context())).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&entitlement, id).Error; err != nil {
+			return err
+		}
+		if entitlement.Key == "" {
+			return gorm.ErrRecordNotFound
+		}
+		if entitlement.Downloads >= entitlement.MaxDownloads {
+			return errLimitReached
+		}
+		entitlement.Downloads++
+		return tx.Model(&entitlement).Update("downloads", entitlement.Downloads).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Download not found"})
+	}
+	if errors.Is(err, errLimitReached) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": fmt.Sprintf("The book has been downloaded %d times, the most allowed", entitlement.MaxDownloads)})
+	}
+	if err != nil {
+		logger.Error("failed to count download", zap.Uint64("id", id), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	reader, _, err := handler.blobs.Get(c.Request().Context(), entitlement.Key)
+	if err != nil {
+		logger.Error("failed to read file", zap.Uint64("id", id), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to read file"})
+	}
+	defer reader.Close()
+
+	logger.Info("digital book downloaded", zap.Uint64("id", id), zap.Int("downloads", entitlement.Downloads))
+	c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": entitlement.FileName}))
+	c.Response().Header().Set(echo.HeaderCacheControl, "private, no-store")
+	return c.Stream(http.StatusOK, entitlement.ContentType, reader)
+}
@@ -0,0 +1,234 @@
+package digital
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/blob"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	lockEntitlementQuery = `SELECT * FROM "entitlements" WHERE "entitlements"."id" = $1 ORDER BY "entitlements"."id" LIMIT $2 FOR UPDATE`
+	countDownloadQuery   = `UPDATE "entitlements" SET "downloads"=$1 WHERE "id" = $2`
+	libraryQuery         = `SELECT entitlements.*, books.title, books.author, books.format FROM "entitlements" JOIN books ON books.id = entitlements.book_id AND books.tenant_id = $1 WHERE entitlements.customer_id = $2 AND entitlements.key <> '' ORDER BY entitlements.id DESC`
+)
+
+var (
+	now         = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	testOptions = Options{SigningSecret: "secret", LinkTTL: 15 * time.Minute, MaxDownloads: 3, BaseURL: "https://books.example.com"}
+)
+
+type memoryStore struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+	types map[string]string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{blobs: map[string][]byte{}, types: map[string]string{}}
+}
+
+func (s *memoryStore) Put(ctx context.Context, key string, data io.Reader, contentType string) error {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[key], s.types[key] = content, contentType
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) (io.ReadCloser, blob.Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.blobs[key]
+	if !ok {
+		return nil, blob.Info{}, blob.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(content)), blob.Info{ContentType: s.types[key], Size: int64(len(content))}, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, key)
+	return nil
+}
+
+func entitlementRows(downloads int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "customer_id", "book_id", "order_ref", "key", "file_name", "content_type", "size", "downloads", "max_downloads"}).
+		AddRow(7, "c-1", 1, "ORD-1", "digital/entitlements/7", "sapiens.epub", "application/epub+zip", 5, downloads, 3)
+}
+
+func TestLink(t *testing.T) {
+	t.Run("sign entitlement and expiry given link", func(t *testing.T) {
+		handler := NewHandler(nil, nil, testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+
+		link, expires := handler.link(7)
+
+		parsed, err := url.Parse(link)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://books.example.com/downloads/7", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+		assert.Equal(t, now.Add(15*time.Minute), expires)
+		assert.Equal(t, "1717244100", parsed.Query().Get("expires"))
+		assert.Equal(t, sign("secret", 7, expires.Unix()), parsed.Query().Get("signature"))
+		assert.NotEqual(t, sign("secret", 8, expires.Unix()), parsed.Query().Get("signature"))
+	})
+}
+
+func TestDownload(t *testing.T) {
+	valid := func(id uint) string {
+		expires := now.Add(time.Minute).Unix()
+		return "/?" + url.Values{"expires": {"1717243260"}, "signature": {sign("secret", id, expires)}}.Encode()
+	}
+
+	t.Run("stream file and count download given valid link", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, valid(7), nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("7")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		store := newMemoryStore()
+		store.Put(context.Background(), "digital/entitlements/7", strings.NewReader("epub!"), "application/epub+zip")
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockEntitlementQuery).WithArgs(7, 1).WillReturnRows(entitlementRows(1))
+		mock.ExpectExec(countDownloadQuery).WithArgs(2, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, store, testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Download(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "epub!", response.Body.String())
+		assert.Equal(t, "application/epub+zip", response.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `attachment; filename=sapiens.epub`, response.Header().Get(echo.HeaderContentDisposition))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return forbidden given signature for another entitlement", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, valid(8), nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("7")
+
+		handler := NewHandler(nil, newMemoryStore(), testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Download(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("return gone given expired link", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		expires := now.Add(-time.Second).Unix()
+		request := httptest.NewRequest(http.MethodGet, "/?"+url.Values{"expires": {"1717243199"}, "signature": {sign("secret", 7, expires)}}.Encode(), nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("7")
+
+		handler := NewHandler(nil, newMemoryStore(), testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Download(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusGone, response.Code)
+	})
+
+	t.Run("return forbidden given download limit reached", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, valid(7), nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("7")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockEntitlementQuery).WithArgs(7, 1).WillReturnRows(entitlementRows(3))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, newMemoryStore(), testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Download(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+		assert.Contains(t, response.Body.String(), "3 times")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestLibrary(t *testing.T) {
+	t.Run("link books with downloads left given owned books", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.Request().Header.Set("X-Customer-ID", "c-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(libraryQuery).WithArgs("", "c-1").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "book_id", "downloads", "max_downloads", "title", "author", "format"}).
+				AddRow(8, "c-1", 2, 3, 3, "Dune", "Frank Herbert", FormatAudiobook).
+				AddRow(7, "c-1", 1, 0, 3, "Sapiens", "Yuval Noah Harari", FormatEbook))
+
+		handler := NewHandler(gormDB, newMemoryStore(), testOptions, zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireCustomer(handler.Library)(c)
+
+		items := []LibraryItem{}
+		json.Unmarshal(response.Body.Bytes(), &items)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Len(t, items, 2)
+		assert.Equal(t, "Dune", items[0].Title)
+		assert.Empty(t, items[0].DownloadURL)
+		assert.Contains(t, items[1].DownloadURL, "https://books.example.com/downloads/7?expires=")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,49 @@
+package i18n
+
+import (
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Default is the locale used when no requested locale has what is asked
+// for.
+const Default = "en"
+
+// Negotiate returns the supported locales to try for an Accept-Language
+// header, most preferred first. A regional tag falls back to its language,
+// so th-TH is followed by th, and the chain always ends with fallback.
+// Locales the client likes less than fallback are left out.
+func Negotiate(header string, supported []string, fallback string) []string {
+	locales := []string{}
+	seen := map[string]bool{}
+	add := func(tag string) bool {
+		for _, locale := range supported {
+			if strings.EqualFold(locale, tag) && !seen[locale] {
+				seen[locale] = true
+				locales = append(locales, locale)
+			}
+		}
+		return strings.EqualFold(tag, fallback)
+	}
+
+	tags, _, _ := language.ParseAcceptLanguage(header)
+	for _, tag := range tags {
+		if add(tag.String()) {
+			break
+		}
+		if base, confidence := tag.Base(); confidence != language.No && add(base.String()) {
+			break
+		}
+	}
+	if !seen[fallback] {
+		locales = append(locales, fallback)
+	}
+	return locales
+}
+
+// base returns the language of a locale, th for th-TH.
+func base(locale string) string {
+	language, _, _ := strings.Cut(locale, "-")
+	return strings.ToLower(language)
+}
@@ -0,0 +1,90 @@
+package i18n
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	supported := []string{"en", "th"}
+
+	t.Run("fall back from region to language to default given regional tag", func(t *testing.T) {
+		locales := Negotiate("th-TH,th;q=0.9,en;q=0.5", supported, "en")
+
+		assert.Equal(t, []string{"th", "en"}, locales)
+	})
+
+	t.Run("order by quality given several languages", func(t *testing.T) {
+		locales := Negotiate("en;q=0.4, fr, th;q=0.8", supported, "en")
+
+		assert.Equal(t, []string{"th", "en"}, locales)
+	})
+
+	t.Run("stop at default given default is preferred", func(t *testing.T) {
+		locales := Negotiate("en-US,th;q=0.5", supported, "en")
+
+		assert.Equal(t, []string{"en"}, locales)
+	})
+
+	t.Run("return default given no or invalid header", func(t *testing.T) {
+		assert.Equal(t, []string{"en"}, Negotiate("", supported, "en"))
+		assert.Equal(t, []string{"en"}, Negotiate(";;;", supported, "en"))
+	})
+}
+
+type shelf struct {
+	Name   string `json:"name" validate:"required"`
+	Locale string `json:"locale" validate:"omitempty,bcp47_language_tag"`
+	Books  int    `json:"books" validate:"gte=0"`
+	Code   string `json:"code" validate:"omitempty,alpha"`
+}
+
+func init() {
+	RegisterValidation("shelf_code", func(fl validator.FieldLevel) bool {
+		return fl.Field().String() != "XX"
+	})
+}
+
+func TestValidator(t *testing.T) {
+	t.Run("return one validator with custom tags given several calls", func(t *testing.T) {
+		validate, err := Validator()
+		again, againErr := Validator()
+
+		assert.NoError(t, err)
+		assert.NoError(t, againErr)
+		assert.Same(t, validate, again)
+		assert.Error(t, validate.Var("XX", "shelf_code"))
+		assert.NoError(t, validate.Var("AB", "shelf_code"))
+	})
+}
+
+func TestMessage(t *testing.T) {
+	validate, registerErr := Validator()
+	assert.NoError(t, registerErr)
+	err := validate.Struct(shelf{Locale: "not a tag", Books: -1})
+
+	t.Run("translate each field given Thai", func(t *testing.T) {
+		message := Message(err, []string{"th", "en"})
+
+		assert.Equal(t, "ต้องระบุ name; locale ต้องเป็นรหัสภาษา เช่น en หรือ th-TH; books ต้องมากกว่าหรือเท่ากับ 0", message)
+	})
+
+	t.Run("use English given no locales", func(t *testing.T) {
+		message := Message(err, nil)
+
+		assert.Equal(t, "name is a required field; locale must be a language tag such as en or th-TH; books must be 0 or greater", message)
+	})
+
+	t.Run("fall back to English given tag without Thai message", func(t *testing.T) {
+		message := Message(validate.Struct(shelf{Name: "Poetry", Code: "12"}), []string{"th-TH"})
+
+		assert.Equal(t, "code can only contain alphabetic characters", message)
+	})
+
+	t.Run("return error as it is given other error", func(t *testing.T) {
+		assert.Equal(t, "boom", Message(errors.New("boom"), []string{"th"}))
+	})
+}
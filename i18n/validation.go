@@ -0,0 +1,160 @@
+package i18n
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/th"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	entranslations "github.com/go-playground/validator/v10/translations/en"
+)
+
+var universal = ut.New(en.New(), en.New(), th.New())
+
+// messages are validation messages by locale and tag, on top of the
+// validator's own English ones. {0} is the field and {1} the tag's
+// parameter.
+var messages = map[string]map[string]string{
+	"en": {
+		"bcp47_language_tag": "{0} must be a language tag such as en or th-TH",
+	},
+	"th": {
+		"required":           "ต้องระบุ {0}",
+		"required_if":        "ต้องระบุ {0}",
+		"isbn":               "{0} ต้องเป็นเลข ISBN ที่ถูกต้อง",
+		"gt":                 "{0} ต้องมากกว่า {1}",
+		"gte":                "{0} ต้องมากกว่าหรือเท่ากับ {1}",
+		"lt":                 "{0} ต้องน้อยกว่า {1}",
+		"lte":                "{0} ต้องน้อยกว่าหรือเท่ากับ {1}",
+		"len":                "{0} ต้องมีความยาว {1} ตัวอักษร",
+		"min":                "{0} ต้องมีอย่างน้อย {1}",
+		"max":                "{0} ต้องมีไม่เกิน {1}",
+		"oneof":              "{0} ต้องเป็นหนึ่งใน [{1}]",
+		"uppercase":          "{0} ต้องเป็นตัวพิมพ์ใหญ่ทั้งหมด",
+		"email":              "{0} ต้องเป็นอีเมลที่ถูกต้อง",
+		"url":                "{0} ต้องเป็น URL ที่ถูกต้อง",
+		"bcp47_language_tag": "{0} ต้องเป็นรหัสภาษา เช่น en หรือ th-TH",
+	},
+}
+
+// RegisterTranslations registers validation messages in every locale on
+// validate, and names fields by their JSON name so that messages match the
+// request body. The messages are added to translators shared by the
+// process, so it can only be called once.
+func RegisterTranslations(validate *validator.Validate) error {
+	validate.RegisterTagNameFunc(jsonName)
+
+	english, _ := universal.GetTranslator(Default)
+	if err := entranslations.RegisterDefaultTranslations(validate, english); err != nil {
+		return err
+	}
+	for locale, tags := range messages {
+		translator, _ := universal.GetTranslator(locale)
+		for tag, text := range tags {
+			err := validate.RegisterTranslation(tag, translator, func(translator ut.Translator) error {
+				return translator.Add(tag, text, true)
+			}, translate)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	shared     *validator.Validate
+	sharedErr  error
+	sharedOnce sync.Once
+	custom     = map[string]validator.Func{}
+)
+
+// RegisterValidation adds a custom tag to the shared validator. It must be
+// called from an init function, before the validator is built.
+func RegisterValidation(tag string, fn validator.Func) {
+	custom[tag] = fn
+}
+
+// Validator returns the validator shared by every handler, with the custom
+// tags and the messages in every locale registered on it. It is built once,
+// as the messages can only be registered once per process.
+func Validator() (*validator.Validate, error) {
+	sharedOnce.Do(func() {
+		validate := validator.New()
+		for tag, fn := range custom {
+			if sharedErr = validate.RegisterValidation(tag, fn); sharedErr != nil {
+				return
+			}
+		}
+		if sharedErr = RegisterTranslations(validate); sharedErr != nil {
+			return
+		}
+		shared = validate
+	})
+	return shared, sharedErr
+}
+
+// MustValidator is like Validator but panics if the validator could not be
+// built. main builds it at startup, so handlers can use MustValidator.
+func MustValidator() *validator.Validate {
+	validate, err := Validator()
+	if err != nil {
+		panic("i18n: " + err.Error())
+	}
+	return validate
+}
+
+func translate(translator ut.Translator, fieldErr validator.FieldError) string {
+	message, err := translator.T(fieldErr.Tag(), fieldErr.Field(), fieldErr.Param())
+	if err != nil {
+		return fieldErr.Error()
+	}
+	return message
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	}
+	return name
+}
+
+// Message returns a validation error in the first of locales that has a
+// message for each of its fields, or else in English. Other errors are
+// returned as they are.
+func Message(err error, locales []string) string {
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return err.Error()
+	}
+	lines := make([]string, len(invalid))
+	for i, fieldErr := range invalid {
+		translator, _ := universal.GetTranslator(localeFor(fieldErr.Tag(), locales))
+		lines[i] = fieldErr.Translate(translator)
+	}
+	return strings.Join(lines, "; ")
+}
+
+// localeFor returns the first of locales, or its language, with a message
+// for tag.
+func localeFor(tag string, locales []string) string {
+	for _, locale := range locales {
+		for _, candidate := range []string{locale, base(locale)} {
+			if _, ok := messages[candidate][tag]; ok {
+				return candidate
+			}
+			if candidate == Default {
+				return Default
+			}
+		}
+	}
+	return Default
+}
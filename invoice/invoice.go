@@ -0,0 +1,284 @@
+package invoice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"github.com/phetployst/book-store-api/shipping"
+	"github.com/phetployst/book-store-api/tax"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sequenceName is the row of invoice_sequences that numbers invoices.
+const sequenceName = "invoice"
+
+// Seller is who issues the invoices. Jurisdiction is the tax jurisdiction
+// code the seller charges tax in; invoices show no tax when it is empty.
+type Seller struct {
+	Name         string
+	Address      string
+	TaxID        string
+	Jurisdiction string
+	NumberPrefix string
+}
+
+// Invoice is issued once per order, the first time it is asked for, and is
+// never changed afterwards. Amounts are in the smallest unit of Currency.
+type Invoice struct {
+	ID               uint      `json:"id" gorm:"primaryKey"`
+	Number           string    `json:"number" gorm:"not null;uniqueIndex:idx_invoices_tenant_number"`
+	Sequence         int64     `json:"-" gorm:"not null;uniqueIndex:idx_invoices_tenant_sequence"`
+	OrderRef         string    `json:"order_ref" gorm:"not null;uniqueIndex"`
+	CustomerID       string    `json:"customer_id" gorm:"not null;index"`
+	Currency         string    `json:"currency" gorm:"not null"`
+	Jurisdiction     string    `json:"jurisdiction"`
+	PricesIncludeTax bool      `json:"prices_include_tax"`
+	Net              int64     `json:"net"`
+	Tax              int64     `json:"tax"`
+	Gross            int64     `json:"gross"`
+	Paid             int64     `json:"paid"`
+	Lines            []Line    `json:"lines" gorm:"constraint:OnDelete:CASCADE"`
+	IssuedAt         time.Time `json:"issued_at"`
+	TenantID         string    `json:"-" gorm:"not null;default:'default';uniqueIndex:idx_invoices_tenant_number;uniqueIndex:idx_invoices_tenant_sequence"`
+}
+
+type Line struct {
+	ID        uint    `json:"-" gorm:"primaryKey"`
+	InvoiceID uint    `json:"-" gorm:"not null;index"`
+	BookID    uint    `json:"book_id"`
+	Title     string  `json:"title"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice int64   `json:"unit_price"`
+	TaxClass  string  `json:"tax_class"`
+	TaxRate   float64 `json:"tax_rate"`
+	Net       int64   `json:"net"`
+	Tax       int64   `json:"tax"`
+	Gross     int64   `json:"gross"`
+}
+
+func (Line) TableName() string {
+	return "invoice_lines"
+}
+
+// Sequence holds the last number given to an invoice of a tenant. It is
+// only advanced inside the transaction that inserts the invoice, so a failed
+// insert also gives its number back and invoice numbers have no gaps. Each
+// tenant has its own row and so its own numbers.
+type Sequence struct {
+	Name     string `gorm:"primaryKey"`
+	TenantID string `gorm:"primaryKey;default:'default'"`
+	Last     int64  `gorm:"not null"`
+}
+
+func (Sequence) TableName() string {
+	return "invoice_sequences"
+}
+
+var (
+	errNotPaid    = errors.New("order has not been paid")
+	errNotShipped = errors.New("order has no shipped books to invoice")
+)
+
+type handler struct {
+	db     *gorm.DB
+	seller Seller
+	now    func() time.Time
+}
+
+func NewHandler(db *gorm.DB, seller Seller) *handler {
+	return &handler{db: db, seller: seller, now: time.Now}
+}
+
+// next takes the next invoice number. The sequence row stays locked until
+// tx ends, so invoices are numbered one at a time.
+func next(tx *gorm.DB) (int64, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Sequence{Name: sequenceName}).Error; err != nil {
+		return 0, err
+	}
+	sequence := Sequence{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("name = ?", sequenceName).First(&sequence).Error; err != nil {
+		return 0, err
+	}
+	sequence.Last++
+	if err := tx.Model(&sequence).Where("name = ?", sequenceName).Update("last", sequence.Last).Error; err != nil {
+		return 0, err
+	}
+	return sequence.Last, nil
+}
+
+// shippedLines sums the books of every shipment of the order. Shipment
+// lines are not scoped by tenant, so the join filters the shipments by it.
+func shippedLines(db *gorm.DB, orderRef string, tenantID string) ([]shipping.ShipmentLine, error) {
+	lines := []shipping.ShipmentLine{}
+	err := db.Model(&shipping.ShipmentLine{}).
+		Select("shipment_lines.book_id, SUM(shipment_lines.quantity) AS quantity").
+		Joins("JOIN shipments ON shipments.id = shipment_lines.shipment_id AND shipments.tenant_id = ?", tenantID).
+		Where("shipments.order_ref = ?", orderRef).
+		Group("shipment_lines.book_id").
+		Order("shipment_lines.book_id").
+		Scan(&lines).Error
+	return lines, err
+}
+
+// draft builds the invoice of a paid order from the books shipped for it,
+// at their current prices, taxed in the seller's jurisdiction.
+func (handler *handler) draft(ctx context.Context, paid payment.Payment) (Invoice, error) {
+	if paid.Captured == 0 {
+		return Invoice{}, errNotPaid
+	}
+
+	shipped, err := shippedLines(handler.db.WithContext(ctx), paid.OrderRef, paid.TenantID)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if len(shipped) == 0 {
+		return Invoice{}, errNotShipped
+	}
+
+	ids := make([]uint, len(shipped))
+	for i, line := range shipped {
+		ids[i] = line.BookID
+	}
+	books := []book.Book{}
+	if err := handler.db.WithContext(ctx).Unscoped().Find(&books, ids).Error; err != nil {
+		return Invoice{}, err
+	}
+	byID := make(map[uint]book.Book, len(books))
+	for _, found := range books {
+		byID[found.ID] = found
+	}
+
+	jurisdiction := tax.Jurisdiction{}
+	if handler.seller.Jurisdiction != "" {
+		if jurisdiction, err = tax.Lookup(handler.db.WithContext(ctx), handler.seller.Jurisdiction); err != nil {
+			return Invoice{}, err
+		}
+	}
+
+	lines := make([]Line, len(shipped))
+	taxLines := make([]tax.Line, len(shipped))
+	for i, line := range shipped {
+		found := byID[line.BookID]
+		lines[i] = Line{
+			BookID:    line.BookID,
+			Title:     found.Title,
+			Quantity:  line.Quantity,
+			UnitPrice: int64(math.Round(found.Price * 100)),
+		}
+		taxLines[i] = tax.Line{BookID: line.BookID, Class: found.Format, Amount: lines[i].UnitPrice * int64(line.Quantity)}
+	}
+
+	result := tax.Calculate(jurisdiction, taxLines)
+	for i, taxed := range result.Lines {
+		lines[i].TaxClass, lines[i].TaxRate = taxed.Class, taxed.Rate
+		lines[i].Net, lines[i].Tax, lines[i].Gross = taxed.Net, taxed.Tax, taxed.Gross
+	}
+
+	return Invoice{
+		OrderRef:         paid.OrderRef,
+		CustomerID:       paid.CustomerID,
+		Currency:         paid.Currency,
+		Jurisdiction:     result.Jurisdiction,
+		PricesIncludeTax: result.PricesIncludeTax,
+		Net:              result.Net,
+		Tax:              result.Tax,
+		Gross:            result.Gross,
+		Paid:             paid.Captured - paid.Refunded,
+		Lines:            lines,
+	}, nil
+}
+
+// issue numbers and saves the invoice in one transaction.
+func (handler *handler) issue(ctx context.Context, invoice *Invoice) error {
+	return handler.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		sequence, err := next(tx)
+		if err != nil {
+			return err
+		}
+		invoice.Sequence = sequence
+		invoice.Number = fmt.Sprintf("%s%06d", handler.seller.NumberPrefix, sequence)
+		invoice.IssuedAt = handler.now()
+		return tx.Create(invoice).Error
+	})
+}
+
+func (handler *handler) find(ctx context.Context, orderRef string) (Invoice, error) {
+	found := Invoice{}
+	err := handler.db.WithContext(ctx).Preload("Lines").Where("order_ref = ?", orderRef).First(&found).Error
+	return found, err
+}
+
+// PDF returns the invoice of the customer's order, issuing it first if the
+// order does not have one yet.
+func (handler *handler) PDF(c echo.Context) error {
+	orderRef := c.Param("id")
+	customerID := middleware.GetCustomerID(c)
+	logger := middleware.GetLogger(c)
+
+	invoice, err := handler.find(c.Request().Context(), orderRef)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		invoice, err = handler.create(c, orderRef, customerID)
+		if err != nil {
+			return handler.issueError(c, err)
+		}
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if invoice.CustomerID != customerID {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	}
+
+	document, err := Render(invoice, handler.seller)
+	if err != nil {
+		logger.Error("failed to render invoice", zap.String("number", invoice.Number), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", invoice.Number+".pdf"))
+	return c.Blob(http.StatusOK, "application/pdf", document)
+}
+
+func (handler *handler) create(c echo.Context, orderRef string, customerID string) (Invoice, error) {
+	paid := payment.Payment{}
+	if err := handler.db.WithContext(c.Request().Context()).Where("order_ref = ? AND customer_id = ?", orderRef, customerID).First(&paid).Error; err != nil {
+		return Invoice{}, err
+	}
+
+	invoice, err := handler.draft(c.Request().Context(), paid)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	if err := handler.issue(c.Request().Context(), &invoice); err != nil {
+		// Another request may have issued the invoice first; its
+		// transaction won and ours gave its number back.
+		if existing, findErr := handler.find(c.Request().Context(), orderRef); findErr == nil {
+			return existing, nil
+		}
+		return Invoice{}, err
+	}
+
+	middleware.GetLogger(c).Info("invoice issued", zap.String("number", invoice.Number), zap.String("order_ref", orderRef))
+	return invoice, nil
+}
+
+func (handler *handler) issueError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	case errors.Is(err, errNotPaid), errors.Is(err, errNotShipped):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	middleware.GetLogger(c).Error("failed to issue invoice", zap.String("order_ref", c.Param("id")), zap.Error(err))
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
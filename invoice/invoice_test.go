@@ -0,0 +1,241 @@
+package invoice
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	getInvoiceQuery            = `SELECT * FROM "invoices" WHERE order_ref = $1 ORDER BY "invoices"."id" LIMIT $2`
+	getLinesQuery              = `SELECT * FROM "invoice_lines" WHERE "invoice_lines"."invoice_id" = $1`
+	getOrderPaymentQuery       = `SELECT * FROM "payments" WHERE order_ref = $1 AND customer_id = $2 ORDER BY "payments"."id" LIMIT $3`
+	shippedLinesQuery          = `SELECT shipment_lines.book_id, SUM(shipment_lines.quantity) AS quantity FROM "shipment_lines" JOIN shipments ON shipments.id = shipment_lines.shipment_id AND shipments.tenant_id = $1 WHERE shipments.order_ref = $2 GROUP BY "shipment_lines"."book_id" ORDER BY shipment_lines.book_id`
+	getBooksQuery              = `SELECT * FROM "books" WHERE "books"."id" IN ($1,$2)`
+	getJurisdictionQuery       = `SELECT * FROM "tax_jurisdictions" WHERE code = $1 ORDER BY "tax_jurisdictions"."id" LIMIT $2`
+	getTaxRatesQuery           = `SELECT * FROM "tax_rates" WHERE "tax_rates"."jurisdiction_id" = $1`
+	createSequenceQuery        = `INSERT INTO "invoice_sequences" ("name","tenant_id","last") VALUES ($1,$2,$3) ON CONFLICT DO NOTHING`
+	lockSequenceQuery          = `SELECT * FROM "invoice_sequences" WHERE name = $1 ORDER BY "invoice_sequences"."name" LIMIT $2 FOR UPDATE`
+	updateSequenceQuery        = `UPDATE "invoice_sequences" SET "last"=$1 WHERE name = $2 AND "name" = $3 AND "tenant_id" = $4`
+	createInvoiceQuery         = `INSERT INTO "invoices" ("number","sequence","order_ref","customer_id","currency","jurisdiction","prices_include_tax","net","tax","gross","paid","issued_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13) RETURNING "id"`
+	getTenantInvoiceQuery      = `SELECT * FROM "invoices" WHERE order_ref = $1 AND "invoices"."tenant_id" = $2 ORDER BY "invoices"."id" LIMIT $3`
+	getTenantOrderPaymentQuery = `SELECT * FROM "payments" WHERE (order_ref = $1 AND customer_id = $2) AND "payments"."tenant_id" = $3 ORDER BY "payments"."id" LIMIT $4`
+	getTenantBookQuery         = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."tenant_id" = $2`
+	getTenantJurisdictionQuery = `SELECT * FROM "tax_jurisdictions" WHERE code = $1 AND "tax_jurisdictions"."tenant_id" = $2 ORDER BY "tax_jurisdictions"."id" LIMIT $3`
+	getTenantTaxRatesQuery     = `SELECT * FROM "tax_rates" WHERE "tax_rates"."jurisdiction_id" = $1 AND "tax_rates"."tenant_id" = $2`
+	lockTenantSequenceQuery    = `SELECT * FROM "invoice_sequences" WHERE name = $1 AND "invoice_sequences"."tenant_id" = $2 ORDER BY "invoice_sequences"."name" LIMIT $3 FOR UPDATE`
+	updateTenantSequenceQuery  = `UPDATE "invoice_sequences" SET "last"=$1 WHERE name = $2 AND "invoice_sequences"."tenant_id" = $3 AND "name" = $4 AND "tenant_id" = $5`
+	createLineQuery            = `INSERT INTO "invoice_lines" ("invoice_id","book_id","title","quantity","unit_price","tax_class","tax_rate","net","tax","gross") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) ON CONFLICT ("id") DO UPDATE SET "invoice_id"="excluded"."invoice_id" RETURNING "id"`
+	createLinesQuery           = `INSERT INTO "invoice_lines" ("invoice_id","book_id","title","quantity","unit_price","tax_class","tax_rate","net","tax","gross") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10),($11,$12,$13,$14,$15,$16,$17,$18,$19,$20) ON CONFLICT ("id") DO UPDATE SET "invoice_id"="excluded"."invoice_id" RETURNING "id"`
+)
+
+var seller = Seller{Name: "Book Store", Address: "1 Sukhumvit Road, Bangkok 10110", TaxID: "0105551234567", Jurisdiction: "TH", NumberPrefix: "INV-"}
+
+func expectPayment(mock sqlmock.Sqlmock, status string, captured int64) {
+	mock.ExpectQuery(getInvoiceQuery).WithArgs("order-1", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(getOrderPaymentQuery).WithArgs("order-1", "customer-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "customer_id", "amount", "currency", "captured", "status", "tenant_id"}).
+			AddRow(1, "order-1", "customer-1", 53000, "THB", captured, status, "default"))
+}
+
+func TestInvoicePDF(t *testing.T) {
+	t.Run("issue next invoice number given paid and shipped order", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		now := time.Date(2024, 10, 1, 9, 0, 0, 0, time.UTC)
+
+		expectPayment(mock, payment.StatusPaid, 53000)
+		mock.ExpectQuery(shippedLinesQuery).WithArgs("default", "order-1").
+			WillReturnRows(sqlmock.NewRows([]string{"book_id", "quantity"}).AddRow(1, 2).AddRow(2, 1))
+		mock.ExpectQuery(getBooksQuery).WithArgs(1, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "price", "format"}).
+				AddRow(1, "Dune", 200.0, "print").
+				AddRow(2, "Dune (audiobook)", 100.0, "audiobook"))
+		mock.ExpectQuery(getJurisdictionQuery).WithArgs("TH", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "code", "prices_include_tax"}).AddRow(1, "TH", true))
+		mock.ExpectQuery(getTaxRatesQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "jurisdiction_id", "class", "rate"}).AddRow(1, 1, "audiobook", 7.0))
+		mock.ExpectBegin()
+		mock.ExpectExec(createSequenceQuery).WithArgs(sequenceName, "default", 0).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(lockSequenceQuery).WithArgs(sequenceName, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "tenant_id", "last"}).AddRow(sequenceName, "default", 41))
+		mock.ExpectExec(updateSequenceQuery).WithArgs(42, sequenceName, sequenceName, "default").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(createInvoiceQuery).
+			WithArgs("INV-000042", 42, "order-1", "customer-1", "THB", "TH", true, 49346, 654, 50000, 53000, now, "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(createLinesQuery).
+			WithArgs(1, 1, "Dune", 2, 20000, "print", 0.0, 40000, 0, 40000,
+				1, 2, "Dune (audiobook)", 1, 10000, "audiobook", 7.0, 9346, 654, 10000).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, seller)
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireCustomer(handler.PDF)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "application/pdf", response.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `inline; filename="INV-000042.pdf"`, response.Header().Get(echo.HeaderContentDisposition))
+		assert.True(t, bytes.HasPrefix(response.Body.Bytes(), []byte("%PDF-")))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("render issued invoice again given it exists", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getInvoiceQuery).WithArgs("order-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "number", "order_ref", "customer_id", "currency"}).AddRow(1, "INV-000007", "order-1", "customer-1", "THB"))
+		mock.ExpectQuery(getLinesQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "invoice_id", "book_id", "title", "quantity"}).AddRow(1, 1, 1, "Dune", 2))
+
+		handler := NewHandler(gormDB, seller)
+		err := middleware.RequireCustomer(handler.PDF)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, `inline; filename="INV-000007.pdf"`, response.Header().Get(echo.HeaderContentDisposition))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return not found given invoice of another customer", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getInvoiceQuery).WithArgs("order-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "number", "order_ref", "customer_id"}).AddRow(1, "INV-000007", "order-1", "customer-2"))
+		mock.ExpectQuery(getLinesQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		handler := NewHandler(gormDB, seller)
+		err := middleware.RequireCustomer(handler.PDF)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given order not paid", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectPayment(mock, payment.StatusAuthorized, 0)
+
+		handler := NewHandler(gormDB, seller)
+		err := middleware.RequireCustomer(handler.PDF)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("number invoice from the storefront's sequence given tenant plugin", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		request.Header.Set("X-Tenant-ID", "th")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		assert.NoError(t, gormDB.Use(tenant.Plugin{}))
+
+		now := time.Date(2024, 10, 1, 9, 0, 0, 0, time.UTC)
+
+		mock.ExpectQuery(getTenantInvoiceQuery).WithArgs("order-1", "th", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(getTenantOrderPaymentQuery).WithArgs("order-1", "customer-1", "th", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "customer_id", "amount", "currency", "captured", "status", "tenant_id"}).
+				AddRow(1, "order-1", "customer-1", 40000, "THB", 40000, payment.StatusPaid, "th"))
+		mock.ExpectQuery(shippedLinesQuery).WithArgs("th", "order-1").
+			WillReturnRows(sqlmock.NewRows([]string{"book_id", "quantity"}).AddRow(1, 2))
+		mock.ExpectQuery(getTenantBookQuery).WithArgs(1, "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "price", "format", "tenant_id"}).AddRow(1, "Dune", 200.0, "print", "th"))
+		mock.ExpectQuery(getTenantJurisdictionQuery).WithArgs("TH", "th", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "code", "prices_include_tax", "tenant_id"}).AddRow(1, "TH", true, "th"))
+		mock.ExpectQuery(getTenantTaxRatesQuery).WithArgs(1, "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "jurisdiction_id", "class", "rate", "tenant_id"}))
+		mock.ExpectBegin()
+		mock.ExpectExec(createSequenceQuery).WithArgs(sequenceName, "th", 0).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(lockTenantSequenceQuery).WithArgs(sequenceName, "th", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "tenant_id", "last"}).AddRow(sequenceName, "th", 6))
+		mock.ExpectExec(updateTenantSequenceQuery).WithArgs(7, sequenceName, "th", sequenceName, "th").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(createInvoiceQuery).
+			WithArgs("INV-000007", 7, "order-1", "customer-1", "THB", "TH", true, 40000, 0, 40000, 40000, now, "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(createLineQuery).
+			WithArgs(1, 1, "Dune", 2, 20000, "print", 0.0, 40000, 0, 40000).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, seller)
+		handler.now = func() time.Time { return now }
+		err := middleware.ResolveTenant(nil, "")(middleware.RequireCustomer(handler.PDF))(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, `inline; filename="INV-000007.pdf"`, response.Header().Get(echo.HeaderContentDisposition))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
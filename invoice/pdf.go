@@ -0,0 +1,189 @@
+package invoice
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The PDF is written by hand with the standard Helvetica fonts, which every
+// reader has built in, so nothing has to be embedded or rendered elsewhere.
+// Text is encoded as WinAnsi; characters outside Latin-1 are printed as "?".
+
+const (
+	pageWidth  = 595.0 // A4 in points
+	pageHeight = 842.0
+	margin     = 50.0
+
+	fontRegular = "F1"
+	fontBold    = "F2"
+)
+
+// helveticaWidths and helveticaBoldWidths are the advance widths of the
+// printable ASCII characters, from space to tilde, in thousandths of the
+// font size.
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [...]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// document collects the content of each page and writes them out as a PDF.
+type document struct {
+	title   string
+	created time.Time
+	pages   []*bytes.Buffer
+}
+
+func newDocument(title string, created time.Time) *document {
+	return &document{title: title, created: created}
+}
+
+func (doc *document) addPage() {
+	doc.pages = append(doc.pages, &bytes.Buffer{})
+}
+
+func (doc *document) page() *bytes.Buffer {
+	return doc.pages[len(doc.pages)-1]
+}
+
+// text draws s with its baseline starting at x, y, measured in points from
+// the bottom left of the page.
+func (doc *document) text(font string, size float64, x, y float64, s string) {
+	fmt.Fprintf(doc.page(), "BT /%s %s Tf %s %s Td (%s) Tj ET\n", font, number(size), number(x), number(y), escape(s))
+}
+
+// textRight draws s so that it ends at x.
+func (doc *document) textRight(font string, size float64, x, y float64, s string) {
+	doc.text(font, size, x-textWidth(font, size, s), y, s)
+}
+
+func (doc *document) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(doc.page(), "0.5 w %s %s m %s %s l S\n", number(x1), number(y1), number(x2), number(y2))
+}
+
+// bytes writes the document. Page contents are compressed; everything else
+// is plain text so the structure is easy to inspect.
+func (doc *document) bytes() ([]byte, error) {
+	out := &bytes.Buffer{}
+	offsets := []int{}
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 to 4 are fixed; each page then takes two, its page object
+	// followed by its content stream.
+	kids := make([]string, len(doc.pages))
+	for i := range doc.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(doc.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, content := range doc.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			number(pageWidth), number(pageHeight), fontRegular, fontBold, 6+2*i))
+
+		compressed := &bytes.Buffer{}
+		writer := zlib.NewWriter(compressed)
+		if _, err := writer.Write(content.Bytes()); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.Bytes()))
+	}
+
+	object(fmt.Sprintf("<< /Title (%s) /CreationDate (D:%s) >>", escape(doc.title), doc.created.UTC().Format("20060102150405Z")))
+	info := len(offsets)
+
+	xref := out.Len()
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, info, xref)
+	return out.Bytes(), nil
+}
+
+// textWidth is the width of s in points when drawn in font at size.
+func textWidth(font string, size float64, s string) float64 {
+	widths := helveticaWidths[:]
+	if font == fontBold {
+		widths = helveticaBoldWidths[:]
+	}
+	total := 0
+	for _, r := range s {
+		if r >= ' ' && r <= '~' {
+			total += widths[r-' ']
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// wrap splits s into lines no wider than width, breaking between words.
+// A word wider than width gets a line of its own.
+func wrap(font string, size float64, width float64, s string) []string {
+	lines := []string{}
+	for _, paragraph := range strings.Split(s, "\n") {
+		current := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if current != "" {
+				candidate = current + " " + word
+			}
+			if current != "" && textWidth(font, size, candidate) > width {
+				lines = append(lines, current)
+				candidate = word
+			}
+			current = candidate
+		}
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// escape encodes s as the body of a PDF literal string in WinAnsi.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+func number(value float64) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", value), "0"), ".")
+}
@@ -0,0 +1,130 @@
+package invoice
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var issuedAt = time.Date(2024, 10, 1, 9, 0, 0, 0, time.UTC)
+
+// checkXref asserts that every object listed in the cross-reference table
+// starts where the table says it does.
+func checkXref(t *testing.T, document []byte) int {
+	t.Helper()
+	start := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(document)
+	if !assert.NotNil(t, start) {
+		return 0
+	}
+	xref, _ := strconv.Atoi(string(start[1]))
+	assert.True(t, bytes.HasPrefix(document[xref:], []byte("xref\n")))
+
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(document[xref:], -1)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		assert.True(t, bytes.HasPrefix(document[offset:], []byte(fmt.Sprintf("%d 0 obj\n", i+1))), "object %d", i+1)
+	}
+	return len(entries)
+}
+
+// contents inflates the content stream of every page.
+func contents(t *testing.T, document []byte) []string {
+	t.Helper()
+	pages := []string{}
+	streams := regexp.MustCompile(`(?s)/Length (\d+) /Filter /FlateDecode >>\nstream\n`).FindAllSubmatchIndex(document, -1)
+	for _, stream := range streams {
+		length, _ := strconv.Atoi(string(document[stream[2]:stream[3]]))
+		reader, err := zlib.NewReader(bytes.NewReader(document[stream[1] : stream[1]+length]))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		content, _ := io.ReadAll(reader)
+		pages = append(pages, string(content))
+	}
+	return pages
+}
+
+func TestDocument(t *testing.T) {
+	t.Run("write valid cross reference table given two pages", func(t *testing.T) {
+		doc := newDocument("Invoice (draft)", issuedAt)
+		doc.addPage()
+		doc.text(fontBold, 12, 50, 700, "Page one")
+		doc.addPage()
+		doc.text(fontRegular, 12, 50, 700, "Page two")
+
+		document, err := doc.bytes()
+
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(document, []byte("%PDF-1.4\n")))
+		assert.Equal(t, 9, checkXref(t, document))
+		assert.Contains(t, string(document), "/Count 2")
+		assert.Contains(t, string(document), `/Title (Invoice \(draft\))`)
+		assert.Equal(t, []string{
+			"BT /F2 12 Tf 50 700 Td (Page one) Tj ET\n",
+			"BT /F1 12 Tf 50 700 Td (Page two) Tj ET\n",
+		}, contents(t, document))
+	})
+}
+
+func TestEscape(t *testing.T) {
+	t.Run("escape delimiters and encode Latin-1 given mixed text", func(t *testing.T) {
+		assert.Equal(t, `Caf\351 \(2nd ed.\) \\ ?`, escape(`Café (2nd ed.) \ ก`))
+	})
+}
+
+func TestWrap(t *testing.T) {
+	t.Run("break between words given text wider than width", func(t *testing.T) {
+		got := wrap(fontRegular, 10, 80, "The Hitchhiker's Guide to the Galaxy")
+
+		assert.Equal(t, []string{"The Hitchhiker's", "Guide to the", "Galaxy"}, got)
+		for _, line := range got {
+			assert.LessOrEqual(t, textWidth(fontRegular, 10, line), 80.0)
+		}
+	})
+}
+
+func TestMoney(t *testing.T) {
+	cases := map[int64]string{
+		0:         "0.00 THB",
+		5:         "0.05 THB",
+		123456789: "1,234,567.89 THB",
+		-100050:   "-1,000.50 THB",
+	}
+	for amount, want := range cases {
+		t.Run("format "+want+" given "+strconv.FormatInt(amount, 10), func(t *testing.T) {
+			assert.Equal(t, want, money(amount, "thb"))
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	t.Run("continue lines on a new page given many lines", func(t *testing.T) {
+		invoice := Invoice{Number: "INV-000001", OrderRef: "order-1", CustomerID: "customer-1", Currency: "THB", IssuedAt: issuedAt}
+		for i := 1; i <= 60; i++ {
+			invoice.Lines = append(invoice.Lines, Line{BookID: uint(i), Title: fmt.Sprintf("Book %d", i), Quantity: 1, UnitPrice: 1000, TaxClass: "print", Net: 1000, Gross: 1000})
+			invoice.Net += 1000
+			invoice.Gross += 1000
+		}
+		invoice.Paid = invoice.Gross
+
+		document, err := Render(invoice, Seller{Name: "Book Store", Address: "1 Sukhumvit Road, Bangkok"})
+
+		assert.NoError(t, err)
+		checkXref(t, document)
+		pages := contents(t, document)
+		assert.Len(t, pages, 2)
+		assert.Contains(t, pages[0], "(Book 1) Tj")
+		assert.Contains(t, pages[1], "(Book 60) Tj")
+		assert.Contains(t, pages[1], "(600.00 THB) Tj")
+		assert.NotContains(t, strings.Join(pages, ""), "Balance due")
+		assert.NotContains(t, strings.Join(pages, ""), "(Tax 0%")
+	})
+}
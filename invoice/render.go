@@ -0,0 +1,178 @@
+package invoice
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Columns of the line table, as the x of their left edge for the title and
+// of their right edge for the numbers.
+const (
+	columnItem      = margin
+	columnQuantity  = 330.0
+	columnUnitPrice = 410.0
+	columnTaxRate   = 465.0
+	columnAmount    = pageWidth - margin
+
+	itemWidth  = 250.0
+	bodySize   = 10.0
+	lineHeight = 14.0
+)
+
+type taxGroup struct {
+	Class string
+	Rate  float64
+	Net   int64
+	Tax   int64
+}
+
+// Render lays out the invoice as an A4 PDF, continuing the line table on
+// new pages when it does not fit.
+func Render(invoice Invoice, seller Seller) ([]byte, error) {
+	doc := newDocument("Invoice "+invoice.Number, invoice.IssuedAt)
+	doc.addPage()
+	y := pageHeight - margin
+
+	doc.text(fontBold, 18, margin, y-18, "TAX INVOICE / RECEIPT")
+	doc.textRight(fontRegular, bodySize, columnAmount, y-6, "Invoice no. "+invoice.Number)
+	doc.textRight(fontRegular, bodySize, columnAmount, y-6-lineHeight, "Date "+invoice.IssuedAt.Format("2006-01-02"))
+	doc.textRight(fontRegular, bodySize, columnAmount, y-6-2*lineHeight, "Order "+invoice.OrderRef)
+	y -= 70
+
+	top := y
+	doc.text(fontBold, bodySize, margin, y, "From")
+	y -= lineHeight
+	doc.text(fontBold, bodySize, margin, y, seller.Name)
+	for _, line := range wrap(fontRegular, bodySize, 230, seller.Address) {
+		if line == "" {
+			continue
+		}
+		y -= lineHeight
+		doc.text(fontRegular, bodySize, margin, y, line)
+	}
+	if seller.TaxID != "" {
+		y -= lineHeight
+		doc.text(fontRegular, bodySize, margin, y, "Tax ID "+seller.TaxID)
+	}
+
+	doc.text(fontBold, bodySize, 320, top, "Bill to")
+	doc.text(fontRegular, bodySize, 320, top-lineHeight, "Customer "+invoice.CustomerID)
+	y -= 2 * lineHeight
+
+	y = tableHeader(doc, y, invoice.PricesIncludeTax)
+	for _, line := range invoice.Lines {
+		titles := wrap(fontRegular, bodySize, itemWidth, line.Title)
+		if y-float64(len(titles))*lineHeight < margin {
+			doc.addPage()
+			y = tableHeader(doc, pageHeight-margin, invoice.PricesIncludeTax)
+		}
+		amount := line.Net
+		if invoice.PricesIncludeTax {
+			amount = line.Gross
+		}
+		doc.textRight(fontRegular, bodySize, columnQuantity, y, strconv.Itoa(line.Quantity))
+		doc.textRight(fontRegular, bodySize, columnUnitPrice, y, money(line.UnitPrice, ""))
+		doc.textRight(fontRegular, bodySize, columnTaxRate, y, percent(line.TaxRate))
+		doc.textRight(fontRegular, bodySize, columnAmount, y, money(amount, ""))
+		for _, title := range titles {
+			doc.text(fontRegular, bodySize, columnItem, y, title)
+			y -= lineHeight
+		}
+	}
+
+	groups := []taxGroup{}
+	if invoice.Jurisdiction != "" {
+		groups = taxGroups(invoice.Lines)
+	}
+	rows := 5 + len(groups)
+	if y-float64(rows)*lineHeight < margin {
+		doc.addPage()
+		y = pageHeight - margin
+	}
+	doc.line(margin, y+lineHeight-4, columnAmount, y+lineHeight-4)
+	y -= 4
+
+	total := func(font string, label string, amount int64) {
+		doc.textRight(font, bodySize, columnTaxRate, y, label)
+		doc.textRight(font, bodySize, columnAmount, y, money(amount, invoice.Currency))
+		y -= lineHeight
+	}
+	total(fontRegular, "Subtotal excluding tax", invoice.Net)
+	for _, group := range groups {
+		total(fontRegular, fmt.Sprintf("Tax %s on %s %s", percent(group.Rate), group.Class, money(group.Net, "")), group.Tax)
+	}
+	total(fontBold, "Total", invoice.Gross)
+	total(fontRegular, "Paid", invoice.Paid)
+	if due := invoice.Gross - invoice.Paid; due > 0 {
+		total(fontBold, "Balance due", due)
+	}
+
+	if invoice.PricesIncludeTax {
+		y -= lineHeight
+		doc.text(fontRegular, bodySize, margin, y, "Prices include tax.")
+	}
+
+	return doc.bytes()
+}
+
+func tableHeader(doc *document, y float64, pricesIncludeTax bool) float64 {
+	amount := "Amount"
+	if pricesIncludeTax {
+		amount = "Amount incl. tax"
+	}
+	doc.text(fontBold, bodySize, columnItem, y, "Item")
+	doc.textRight(fontBold, bodySize, columnQuantity, y, "Qty")
+	doc.textRight(fontBold, bodySize, columnUnitPrice, y, "Unit price")
+	doc.textRight(fontBold, bodySize, columnTaxRate, y, "Tax")
+	doc.textRight(fontBold, bodySize, columnAmount, y, amount)
+	doc.line(margin, y-4, columnAmount, y-4)
+	return y - lineHeight - 4
+}
+
+// taxGroups sums the lines by tax class and rate for the tax breakdown.
+func taxGroups(lines []Line) []taxGroup {
+	groups := []taxGroup{}
+	index := map[string]int{}
+	for _, line := range lines {
+		key := fmt.Sprintf("%s/%v", line.TaxClass, line.TaxRate)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, taxGroup{Class: line.TaxClass, Rate: line.TaxRate})
+		}
+		groups[i].Net += line.Net
+		groups[i].Tax += line.Tax
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Class != groups[j].Class {
+			return groups[i].Class < groups[j].Class
+		}
+		return groups[i].Rate < groups[j].Rate
+	})
+	return groups
+}
+
+// money formats an amount in the smallest unit of its currency with two
+// decimals and thousands separators, such as "1,234.50 THB".
+func money(amount int64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	whole := strconv.FormatInt(amount/100, 10)
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+	formatted := fmt.Sprintf("%s%s.%02d", sign, whole, amount%100)
+	if currency != "" {
+		formatted += " " + strings.ToUpper(currency)
+	}
+	return formatted
+}
+
+func percent(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', -1, 64) + "%"
+}
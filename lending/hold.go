@@ -0,0 +1,171 @@
+package lending
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"gorm.io/gorm"
+)
+
+// Hold queues a member for a book that has no copy on the shelf. When a
+// copy comes back, the hold that has waited longest is ready: the copy is
+// kept for the member until ExpiresAt. Position is the place in the queue
+// of a waiting hold.
+type Hold struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	BookID    uint       `json:"book_id" gorm:"not null;index"`
+	MemberID  string     `json:"member_id" gorm:"not null;index" validate:"required"`
+	Status    string     `json:"status" gorm:"not null;index"`
+	CopyID    *uint      `json:"copy_id"`
+	ReadyAt   *time.Time `json:"ready_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	TenantID  string     `json:"-" gorm:"not null;default:'default';index"`
+	Position  int        `json:"position,omitempty" gorm:"-"`
+}
+
+// position counts the waiting holds for the book up to and including this
+// one.
+func position(db *gorm.DB, hold *Hold) error {
+	if hold.Status != HoldWaiting {
+		return nil
+	}
+	var ahead int64
+	if err := db.Model(&Hold{}).Where("book_id = ? AND status = ? AND id <= ?", hold.BookID, HoldWaiting, hold.ID).Count(&ahead).Error; err != nil {
+		return err
+	}
+	hold.Position = int(ahead)
+	return nil
+}
+
+// PlaceHold queues a member for a book. Holds are only taken when every
+// copy is out, since otherwise the member can borrow one straight away.
+func (handler *handler) PlaceHold(c echo.Context) error {
+	hold := Hold{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+
+	if err := c.Bind(&hold); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	bookID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+	}
+	if err := c.Validate(hold); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	var held book.Book
+	var ready []Hold
+	err = handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if held, err = lockBook(tx, uint(bookID)); err != nil {
+			return err
+		}
+		if ready, err = handler.expireHolds(tx, held.ID); err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&Copy{}).Where("book_id = ? AND status = ?", held.ID, CopyAvailable).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return conflict("A copy is available to borrow")
+		}
+		if err := tx.Model(&Hold{}).Where("book_id = ? AND member_id = ? AND status IN ?", held.ID, hold.MemberID, []string{HoldWaiting, HoldReady}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return conflict("The member already has a hold on this book")
+		}
+		if err := tx.Model(&Loan{}).Where("book_id = ? AND member_id = ? AND returned_at IS NULL", held.ID, hold.MemberID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return conflict("The member already has this book on loan")
+		}
+
+		hold = Hold{BookID: held.ID, MemberID: hold.MemberID, Status: HoldWaiting}
+		if err := tx.Create(&hold).Error; err != nil {
+			return err
+		}
+		return position(tx, &hold)
+	})
+	if err != nil {
+		return lendingError(c, err, "Book not found")
+	}
+	handler.notifyReady(held, ready)
+	return c.JSON(http.StatusCreated, hold)
+}
+
+// CancelHold cancels a waiting or ready hold. The copy kept for a ready
+// hold goes to the next member in the queue.
+func (handler *handler) CancelHold(c echo.Context) error {
+	hold := Hold{}
+	var held book.Book
+	var ready *Hold
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		found := Hold{}
+		if err := tx.First(&found, c.Param("id")).Error; err != nil {
+			return err
+		}
+		var err error
+		if held, err = lockBook(tx, found.BookID); err != nil {
+			return err
+		}
+		if err := tx.First(&hold, found.ID).Error; err != nil {
+			return err
+		}
+		if hold.Status != HoldWaiting && hold.Status != HoldReady {
+			return conflict("The hold is no longer active")
+		}
+
+		wasReady := hold.Status == HoldReady
+		hold.Status = HoldCancelled
+		if err := tx.Save(&hold).Error; err != nil {
+			return err
+		}
+		if !wasReady {
+			return nil
+		}
+		item := Copy{}
+		if err := tx.First(&item, *hold.CopyID).Error; err != nil {
+			return err
+		}
+		ready, err = handler.assign(tx, &item)
+		return err
+	})
+	if err != nil {
+		return lendingError(c, err, "Hold not found")
+	}
+	if ready != nil {
+		handler.notifyReady(held, []Hold{*ready})
+	}
+	return c.JSON(http.StatusOK, hold)
+}
+
+// GetMemberHolds lists a member's holds, newest first, to staff or the
+// member.
+func (handler *handler) GetMemberHolds(c echo.Context) error {
+	holds := []Hold{}
+	if !ownRecords(c) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Members can only see their own holds"})
+	}
+	if err := handler.db.WithContext(c.Request().Context()).Where("member_id = ?", c.Param("id")).Order("id DESC").Find(&holds).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	for i := range holds {
+		if err := position(handler.db.WithContext(c.Request().Context()), &holds[i]); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+	return c.JSON(http.StatusOK, holds)
+}
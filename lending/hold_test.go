@@ -0,0 +1,198 @@
+package lending
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/notification"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	availableCopiesQuery = `SELECT count(*) FROM "library_copies" WHERE book_id = $1 AND status = $2`
+	memberHoldsQuery     = `SELECT count(*) FROM "holds" WHERE book_id = $1 AND member_id = $2 AND status IN ($3,$4)`
+	memberOpenLoansQuery = `SELECT count(*) FROM "loans" WHERE book_id = $1 AND member_id = $2 AND returned_at IS NULL`
+	createHoldQuery      = `INSERT INTO "holds" ("book_id","member_id","status","copy_id","ready_at","expires_at","created_at","updated_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "id"`
+	positionQuery        = `SELECT count(*) FROM "holds" WHERE book_id = $1 AND status = $2 AND id <= $3`
+	getHoldQuery         = `SELECT * FROM "holds" WHERE "holds"."id" = $1 ORDER BY "holds"."id" LIMIT $2`
+)
+
+func count(value int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"count"}).AddRow(value)
+}
+
+func TestPlaceHold(t *testing.T) {
+	expectBook := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(lockBookQuery).WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "Sapiens"))
+		mock.ExpectQuery(expiredHoldsQuery).WithArgs(1, HoldReady, now).WillReturnRows(rows(holdColumns))
+	}
+
+	t.Run("queue member behind earlier holds given every copy out", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"member_id": "m-2", "status": "ready"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		expectBook(mock)
+		mock.ExpectQuery(availableCopiesQuery).WithArgs(1, CopyAvailable).WillReturnRows(count(0))
+		mock.ExpectQuery(memberHoldsQuery).WithArgs(1, "m-2", HoldWaiting, HoldReady).WillReturnRows(count(0))
+		mock.ExpectQuery(memberOpenLoansQuery).WithArgs(1, "m-2").WillReturnRows(count(0))
+		mock.ExpectQuery(createHoldQuery).WithArgs(1, "m-2", HoldWaiting, nil, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+		mock.ExpectQuery(positionQuery).WithArgs(1, HoldWaiting, 5).WillReturnRows(count(2))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.PlaceHold(c)
+
+		hold := Hold{}
+		json.Unmarshal(response.Body.Bytes(), &hold)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, HoldWaiting, hold.Status)
+		assert.Equal(t, 2, hold.Position)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given copy available", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"member_id": "m-2"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		expectBook(mock)
+		mock.ExpectQuery(availableCopiesQuery).WithArgs(1, CopyAvailable).WillReturnRows(count(1))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.PlaceHold(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given no member", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		handler := NewHandler(nil, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.PlaceHold(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestCancelHold(t *testing.T) {
+	t.Run("put copy back on shelf given ready hold and no one waiting", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("4")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		ready := now.Add(-time.Hour)
+		expires := ready.Add(policy.PickupPeriod)
+		mock.ExpectBegin()
+		mock.ExpectQuery(getHoldQuery).WithArgs("4", 1).
+			WillReturnRows(rows(holdColumns).AddRow(4, 1, "m-1", HoldReady, 7, ready, expires, ready))
+		mock.ExpectQuery(lockBookQuery).WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(getHoldQuery).WithArgs(4, 1).
+			WillReturnRows(rows(holdColumns).AddRow(4, 1, "m-1", HoldReady, 7, ready, expires, ready))
+		mock.ExpectExec(saveHoldQuery).
+			WithArgs(1, "m-1", HoldCancelled, 7, ready, expires, ready, sqlmock.AnyArg(), "", 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(getCopyQuery).WithArgs(7, 1).WillReturnRows(rows(copyColumns).AddRow(7, 1, "LIB-1", CopyOnHold))
+		mock.ExpectQuery(nextHoldQuery).WithArgs(1, HoldWaiting, 1).WillReturnRows(rows(holdColumns))
+		mock.ExpectExec(updateCopyQuery).WithArgs(CopyAvailable, sqlmock.AnyArg(), 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.CancelHold(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given hold already fulfilled", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("4")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(getHoldQuery).WithArgs("4", 1).
+			WillReturnRows(rows(holdColumns).AddRow(4, 1, "m-1", HoldFulfilled, 7, now, now, now))
+		mock.ExpectQuery(lockBookQuery).WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(getHoldQuery).WithArgs(4, 1).
+			WillReturnRows(rows(holdColumns).AddRow(4, 1, "m-1", HoldFulfilled, 7, now, now, now))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.CancelHold(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,267 @@
+package lending
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/notification"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	CopyAvailable = "available"
+	CopyOnLoan    = "on_loan"
+	CopyOnHold    = "on_hold"
+
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldFulfilled = "fulfilled"
+	HoldCancelled = "cancelled"
+	HoldExpired   = "expired"
+)
+
+// Copy is a physical copy of a book in the lending library, identified by
+// the barcode on its label. A copy on hold is kept for the member whose
+// hold is ready.
+type Copy struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	BookID    uint      `json:"book_id" gorm:"not null;index"`
+	Barcode   string    `json:"barcode" gorm:"not null;uniqueIndex:idx_library_copies_tenant_barcode" validate:"required"`
+	Status    string    `json:"status" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	TenantID  string    `json:"-" gorm:"not null;default:'default';uniqueIndex:idx_library_copies_tenant_barcode"`
+}
+
+func (Copy) TableName() string {
+	return "library_copies"
+}
+
+// Policy holds the lending rules.
+type Policy struct {
+	LoanPeriod   time.Duration
+	MaxRenewals  int
+	PickupPeriod time.Duration
+	FinePerDay   int64
+	Currency     string
+}
+
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+func (c *CustomValidator) Validate(i interface{}) error {
+	return c.validator.Struct(i)
+}
+
+type handler struct {
+	db       *gorm.DB
+	policy   Policy
+	notifier notification.Notifier
+	logger   *zap.Logger
+	now      func() time.Time
+}
+
+func NewHandler(db *gorm.DB, policy Policy, notifier notification.Notifier, logger *zap.Logger) *handler {
+	return &handler{db: db, policy: policy, notifier: notifier, logger: logger, now: time.Now}
+}
+
+var errNotFound = errors.New("not found")
+
+// conflict is a lending rule that stops the request. It is reported with
+// 409 Conflict.
+type conflict string
+
+func (err conflict) Error() string {
+	return string(err)
+}
+
+// lendingError maps an error from a lending transaction to a response.
+func lendingError(c echo.Context, err error, notFound string) error {
+	var rule conflict
+	switch {
+	case errors.Is(err, errNotFound), errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": notFound})
+	case errors.As(err, &rule):
+		return c.JSON(http.StatusConflict, map[string]string{"error": rule.Error()})
+	}
+	middleware.GetLogger(c).Error("failed to update lending", zap.Error(err))
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+}
+
+// lockBook locks the book row. Every change to a book's copies, loans and
+// holds locks the book first, so they are made one at a time.
+func lockBook(tx *gorm.DB, bookID uint) (book.Book, error) {
+	found := book.Book{}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&found, bookID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return book.Book{}, errNotFound
+	}
+	return found, err
+}
+
+// assign gives a copy that has come back to the member who has waited
+// longest for the book, or puts it back on the shelf. It returns the hold
+// that is now ready, if any.
+func (handler *handler) assign(tx *gorm.DB, item *Copy) (*Hold, error) {
+	hold := Hold{}
+	err := tx.Where("book_id = ? AND status = ?", item.BookID, HoldWaiting).Order("id").First(&hold).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		item.Status = CopyAvailable
+		return nil, tx.Model(item).Update("status", item.Status).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := handler.now()
+	expires := now.Add(handler.policy.PickupPeriod)
+	hold.Status, hold.CopyID, hold.ReadyAt, hold.ExpiresAt = HoldReady, &item.ID, &now, &expires
+	if err := tx.Save(&hold).Error; err != nil {
+		return nil, err
+	}
+	item.Status = CopyOnHold
+	if err := tx.Model(item).Update("status", item.Status).Error; err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// expireHolds expires the book's ready holds that were not picked up in
+// time and passes their copies on. Holds are expired when the book is next
+// lent, returned or held, so no background job is needed.
+func (handler *handler) expireHolds(tx *gorm.DB, bookID uint) ([]Hold, error) {
+	expired := []Hold{}
+	err := tx.Where("book_id = ? AND status = ? AND expires_at < ?", bookID, HoldReady, handler.now()).
+		Order("id").Find(&expired).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ready := []Hold{}
+	for _, hold := range expired {
+		if err := tx.Model(&hold).Update("status", HoldExpired).Error; err != nil {
+			return nil, err
+		}
+		item := Copy{}
+		if err := tx.First(&item, *hold.CopyID).Error; err != nil {
+			return nil, err
+		}
+		next, err := handler.assign(tx, &item)
+		if err != nil {
+			return nil, err
+		}
+		if next != nil {
+			ready = append(ready, *next)
+		}
+	}
+	return ready, nil
+}
+
+// notifyReady tells members that the copy they held is waiting for them.
+// It runs in the background after the transaction has committed.
+func (handler *handler) notifyReady(held book.Book, holds []Hold) {
+	if len(holds) == 0 {
+		return
+	}
+	go func() {
+		for _, hold := range holds {
+			err := handler.notifier.Notify(context.Background(), notification.Notification{
+				Type:       notification.TypeHoldReady,
+				CustomerID: hold.MemberID,
+				Subject:    fmt.Sprintf("%s is ready to pick up", held.Title),
+				Data: map[string]any{
+					"hold_id":    hold.ID,
+					"book_id":    held.ID,
+					"title":      held.Title,
+					"expires_at": hold.ExpiresAt,
+				},
+				CreatedAt: handler.now(),
+			})
+			if err != nil {
+				handler.logger.Error("failed to notify hold ready", zap.Uint("hold_id", hold.ID), zap.Error(err))
+			}
+		}
+	}()
+}
+
+// ownRecords reports whether the caller may see the loans and holds of the
+// member in the path. Staff may see anyone's; a member only their own.
+func ownRecords(c echo.Context) bool {
+	if middleware.GetStaffID(c) != "" {
+		return true
+	}
+	customerID := middleware.GetCustomerID(c)
+	return customerID != "" && customerID == c.Param("id")
+}
+
+// CreateCopy adds a copy of a book to the library. If members are waiting
+// for the book, the copy goes to the first of them.
+func (handler *handler) CreateCopy(c echo.Context) error {
+	item := Copy{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+
+	if err := c.Bind(&item); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	bookID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+	}
+	item.BookID = uint(bookID)
+	item.Barcode = strings.TrimSpace(item.Barcode)
+	if err := c.Validate(item); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	var held book.Book
+	var ready *Hold
+	err = handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if held, err = lockBook(tx, item.BookID); err != nil {
+			return err
+		}
+		var taken int64
+		if err := tx.Model(&Copy{}).Where("barcode = ?", item.Barcode).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return conflict("A copy with this barcode already exists")
+		}
+
+		item.ID, item.Status = 0, CopyAvailable
+		if err := tx.Create(&item).Error; err != nil {
+			return err
+		}
+		ready, err = handler.assign(tx, &item)
+		return err
+	})
+	if err != nil {
+		return lendingError(c, err, "Book not found")
+	}
+	if ready != nil {
+		handler.notifyReady(held, []Hold{*ready})
+	}
+	return c.JSON(http.StatusCreated, item)
+}
+
+func (handler *handler) GetCopies(c echo.Context) error {
+	copies := []Copy{}
+	if err := handler.db.WithContext(c.Request().Context()).Where("book_id = ?", c.Param("id")).Order("id").Find(&copies).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, copies)
+}
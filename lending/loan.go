@@ -0,0 +1,290 @@
+package lending
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"gorm.io/gorm"
+)
+
+const (
+	LoanActive   = "active"
+	LoanOverdue  = "overdue"
+	LoanReturned = "returned"
+)
+
+// Loan is a copy lent to a member until DueAt. Fine is what the member owes
+// for returning it late, in the smallest unit of Currency. While the loan
+// is open it is the fine so far.
+type Loan struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	CopyID     uint       `json:"copy_id" gorm:"not null;index"`
+	BookID     uint       `json:"book_id" gorm:"not null;index"`
+	MemberID   string     `json:"member_id" gorm:"not null;index"`
+	BorrowedAt time.Time  `json:"borrowed_at"`
+	DueAt      time.Time  `json:"due_at"`
+	Renewals   int        `json:"renewals"`
+	ReturnedAt *time.Time `json:"returned_at"`
+	Fine       int64      `json:"fine"`
+	Currency   string     `json:"currency"`
+	TenantID   string     `json:"-" gorm:"not null;default:'default';index"`
+	Overdue    bool       `json:"overdue" gorm:"-"`
+}
+
+type CheckoutRequest struct {
+	Barcode  string `json:"barcode" validate:"required"`
+	MemberID string `json:"member_id" validate:"required"`
+}
+
+type CheckinRequest struct {
+	Barcode string `json:"barcode" validate:"required"`
+}
+
+// fine is what a loan due at due costs when it is returned at returned.
+// Every day or part of a day late is charged.
+func (policy Policy) fine(due time.Time, returned time.Time) int64 {
+	if !returned.After(due) {
+		return 0
+	}
+	days := int64(math.Ceil(returned.Sub(due).Hours() / 24))
+	return days * policy.FinePerDay
+}
+
+// view fills in whether an open loan is overdue and its fine so far.
+func (handler *handler) view(loan Loan) Loan {
+	if loan.ReturnedAt == nil {
+		now := handler.now()
+		loan.Overdue = now.After(loan.DueAt)
+		loan.Fine = handler.policy.fine(loan.DueAt, now)
+	}
+	return loan
+}
+
+// findCopy finds a copy by barcode, locks its book and expires the book's
+// holds that were not picked up. The copy is read again after that so that
+// its status is current.
+func (handler *handler) findCopy(tx *gorm.DB, barcode string) (Copy, book.Book, []Hold, error) {
+	found := Copy{}
+	if err := tx.Where("barcode = ?", strings.TrimSpace(barcode)).First(&found).Error; err != nil {
+		return Copy{}, book.Book{}, nil, err
+	}
+	held, err := lockBook(tx, found.BookID)
+	if err != nil {
+		return Copy{}, book.Book{}, nil, err
+	}
+	ready, err := handler.expireHolds(tx, found.BookID)
+	if err != nil {
+		return Copy{}, book.Book{}, nil, err
+	}
+	item := Copy{}
+	if err := tx.First(&item, found.ID).Error; err != nil {
+		return Copy{}, book.Book{}, nil, err
+	}
+	return item, held, ready, nil
+}
+
+// Checkout lends a copy to a member. A copy on hold can only be borrowed by
+// the member who held it, which fulfils the hold.
+func (handler *handler) Checkout(c echo.Context) error {
+	request := CheckoutRequest{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	loan := Loan{}
+	var held book.Book
+	var ready []Hold
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		item, found, expired, err := handler.findCopy(tx, request.Barcode)
+		if err != nil {
+			return err
+		}
+		held, ready = found, expired
+
+		switch item.Status {
+		case CopyOnLoan:
+			return conflict("This copy is already on loan")
+		case CopyOnHold:
+			hold := Hold{}
+			if err := tx.Where("copy_id = ? AND status = ?", item.ID, HoldReady).First(&hold).Error; err != nil {
+				return err
+			}
+			if hold.MemberID != request.MemberID {
+				return conflict("This copy is held for another member")
+			}
+			if err := tx.Model(&hold).Update("status", HoldFulfilled).Error; err != nil {
+				return err
+			}
+		}
+
+		now := handler.now()
+		loan = Loan{
+			CopyID:     item.ID,
+			BookID:     item.BookID,
+			MemberID:   request.MemberID,
+			BorrowedAt: now,
+			DueAt:      now.Add(handler.policy.LoanPeriod),
+			Currency:   handler.policy.Currency,
+		}
+		if err := tx.Create(&loan).Error; err != nil {
+			return err
+		}
+		return tx.Model(&item).Update("status", CopyOnLoan).Error
+	})
+	if err != nil {
+		return lendingError(c, err, "Copy not found")
+	}
+	handler.notifyReady(held, ready)
+	return c.JSON(http.StatusCreated, handler.view(loan))
+}
+
+// Renew extends a loan by another loan period from today. A loan cannot be
+// renewed once it is overdue, more than the policy allows, or while other
+// members are waiting for the book.
+func (handler *handler) Renew(c echo.Context) error {
+	loan := Loan{}
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		found := Loan{}
+		if err := tx.First(&found, c.Param("id")).Error; err != nil {
+			return err
+		}
+		if _, err := lockBook(tx, found.BookID); err != nil {
+			return err
+		}
+		if err := tx.First(&loan, found.ID).Error; err != nil {
+			return err
+		}
+
+		now := handler.now()
+		if loan.ReturnedAt != nil {
+			return conflict("The loan has been returned")
+		}
+		if now.After(loan.DueAt) {
+			return conflict("An overdue loan cannot be renewed")
+		}
+		if loan.Renewals >= handler.policy.MaxRenewals {
+			return conflict(fmt.Sprintf("A loan can be renewed at most %d times", handler.policy.MaxRenewals))
+		}
+		var waiting int64
+		if err := tx.Model(&Hold{}).Where("book_id = ? AND status = ?", loan.BookID, HoldWaiting).Count(&waiting).Error; err != nil {
+			return err
+		}
+		if waiting > 0 {
+			return conflict("Other members are waiting for this book")
+		}
+
+		loan.DueAt, loan.Renewals = now.Add(handler.policy.LoanPeriod), loan.Renewals+1
+		return tx.Save(&loan).Error
+	})
+	if err != nil {
+		return lendingError(c, err, "Loan not found")
+	}
+	return c.JSON(http.StatusOK, handler.view(loan))
+}
+
+// Checkin returns a copy, charging a fine if it is late. The copy goes to
+// the first member waiting for the book, or back on the shelf.
+func (handler *handler) Checkin(c echo.Context) error {
+	request := CheckinRequest{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	loan := Loan{}
+	var held book.Book
+	var ready []Hold
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		item, found, expired, err := handler.findCopy(tx, request.Barcode)
+		if err != nil {
+			return err
+		}
+		held, ready = found, expired
+		result := tx.Where("copy_id = ? AND returned_at IS NULL", item.ID).Limit(1).Find(&loan)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return conflict("This copy is not on loan")
+		}
+
+		now := handler.now()
+		loan.ReturnedAt, loan.Fine = &now, handler.policy.fine(loan.DueAt, now)
+		if err := tx.Save(&loan).Error; err != nil {
+			return err
+		}
+
+		next, err := handler.assign(tx, &item)
+		if err != nil {
+			return err
+		}
+		if next != nil {
+			ready = append(ready, *next)
+		}
+		return nil
+	})
+	if err != nil {
+		return lendingError(c, err, "Copy not found")
+	}
+	handler.notifyReady(held, ready)
+	return c.JSON(http.StatusOK, handler.view(loan))
+}
+
+// GetMemberLoans lists a member's loans, newest first, optionally only the
+// active, overdue or returned ones. Only staff and the member can list them.
+func (handler *handler) GetMemberLoans(c echo.Context) error {
+	if !ownRecords(c) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Members can only see their own loans"})
+	}
+
+	var status func(*gorm.DB) *gorm.DB
+	switch c.QueryParam("status") {
+	case "":
+		status = func(db *gorm.DB) *gorm.DB { return db }
+	case LoanActive:
+		status = func(db *gorm.DB) *gorm.DB { return db.Where("returned_at IS NULL") }
+	case LoanOverdue:
+		status = func(db *gorm.DB) *gorm.DB { return db.Where("returned_at IS NULL AND due_at < ?", handler.now()) }
+	case LoanReturned:
+		status = func(db *gorm.DB) *gorm.DB { return db.Where("returned_at IS NOT NULL") }
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "status must be active, overdue or returned"})
+	}
+	query := handler.db.WithContext(c.Request().Context()).Where("member_id = ?", c.Param("id")).Scopes(status)
+	return handler.loans(c, query.Order("borrowed_at DESC, id DESC"))
+}
+
+// GetOverdue lists every open loan past its due date, the longest overdue
+// first.
+func (handler *handler) GetOverdue(c echo.Context) error {
+	return handler.loans(c, handler.db.WithContext(c.Request().Context()).Where("returned_at IS NULL AND due_at < ?", handler.now()).Order("due_at, id"))
+}
+
+func (handler *handler) loans(c echo.Context, query *gorm.DB) error {
+	loans := []Loan{}
+	if err := query.Find(&loans).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	for i := range loans {
+		loans[i] = handler.view(loans[i])
+	}
+	return c.JSON(http.StatusOK, loans)
+}
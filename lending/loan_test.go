@@ -0,0 +1,550 @@
+package lending
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/notification"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	findCopyQuery           = `SELECT * FROM "library_copies" WHERE barcode = $1 ORDER BY "library_copies"."id" LIMIT $2`
+	lockBookQuery           = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2 FOR UPDATE`
+	expiredHoldsQuery       = `SELECT * FROM "holds" WHERE book_id = $1 AND status = $2 AND expires_at < $3 ORDER BY id`
+	getCopyQuery            = `SELECT * FROM "library_copies" WHERE "library_copies"."id" = $1 ORDER BY "library_copies"."id" LIMIT $2`
+	readyHoldQuery          = `SELECT * FROM "holds" WHERE copy_id = $1 AND status = $2 ORDER BY "holds"."id" LIMIT $3`
+	createLoanQuery         = `INSERT INTO "loans" ("copy_id","book_id","member_id","borrowed_at","due_at","renewals","returned_at","fine","currency","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING "id"`
+	updateCopyQuery         = `UPDATE "library_copies" SET "status"=$1,"updated_at"=$2 WHERE "id" = $3`
+	updateHoldQuery         = `UPDATE "holds" SET "status"=$1,"updated_at"=$2 WHERE "id" = $3`
+	getLoanQuery            = `SELECT * FROM "loans" WHERE "loans"."id" = $1 ORDER BY "loans"."id" LIMIT $2`
+	waitingHoldsQuery       = `SELECT count(*) FROM "holds" WHERE book_id = $1 AND status = $2`
+	saveLoanQuery           = `UPDATE "loans" SET "copy_id"=$1,"book_id"=$2,"member_id"=$3,"borrowed_at"=$4,"due_at"=$5,"renewals"=$6,"returned_at"=$7,"fine"=$8,"currency"=$9,"tenant_id"=$10 WHERE "id" = $11`
+	openLoanQuery           = `SELECT * FROM "loans" WHERE copy_id = $1 AND returned_at IS NULL LIMIT $2`
+	nextHoldQuery           = `SELECT * FROM "holds" WHERE book_id = $1 AND status = $2 ORDER BY id,"holds"."id" LIMIT $3`
+	saveHoldQuery           = `UPDATE "holds" SET "book_id"=$1,"member_id"=$2,"status"=$3,"copy_id"=$4,"ready_at"=$5,"expires_at"=$6,"created_at"=$7,"updated_at"=$8,"tenant_id"=$9 WHERE "id" = $10`
+	memberLoansQuery        = `SELECT * FROM "loans" WHERE member_id = $1 AND returned_at IS NULL ORDER BY borrowed_at DESC, id DESC`
+	overdueLoansQuery       = `SELECT * FROM "loans" WHERE returned_at IS NULL AND due_at < $1 ORDER BY due_at, id`
+	findTenantCopyQuery     = `SELECT * FROM "library_copies" WHERE barcode = $1 AND "library_copies"."tenant_id" = $2 ORDER BY "library_copies"."id" LIMIT $3`
+	lockTenantBookQuery     = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."tenant_id" = $2 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $3 FOR UPDATE`
+	expiredTenantHoldsQuery = `SELECT * FROM "holds" WHERE (book_id = $1 AND status = $2 AND expires_at < $3) AND "holds"."tenant_id" = $4 ORDER BY id`
+	getTenantCopyQuery      = `SELECT * FROM "library_copies" WHERE "library_copies"."id" = $1 AND "library_copies"."tenant_id" = $2 ORDER BY "library_copies"."id" LIMIT $3`
+	updateTenantCopyQuery   = `UPDATE "library_copies" SET "status"=$1,"updated_at"=$2 WHERE "library_copies"."tenant_id" = $3 AND "id" = $4`
+	loanColumns             = "id,copy_id,book_id,member_id,borrowed_at,due_at,renewals,returned_at,fine,currency"
+	copyColumns             = "id,book_id,barcode,status"
+	holdColumns             = "id,book_id,member_id,status,copy_id,ready_at,expires_at,created_at"
+)
+
+var now = time.Date(2024, 5, 10, 10, 0, 0, 0, time.UTC)
+
+var policy = Policy{
+	LoanPeriod:   14 * 24 * time.Hour,
+	MaxRenewals:  2,
+	PickupPeriod: 3 * 24 * time.Hour,
+	FinePerDay:   500,
+	Currency:     "THB",
+}
+
+func rows(columns string) *sqlmock.Rows {
+	return sqlmock.NewRows(strings.Split(columns, ","))
+}
+
+// expectCopy expects findCopy to find the copy with barcode LIB-1 and no
+// expired holds.
+func expectCopy(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectQuery(findCopyQuery).WithArgs("LIB-1", 1).
+		WillReturnRows(rows(copyColumns).AddRow(7, 1, "LIB-1", status))
+	mock.ExpectQuery(lockBookQuery).WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "Sapiens"))
+	mock.ExpectQuery(expiredHoldsQuery).WithArgs(1, HoldReady, now).WillReturnRows(rows(holdColumns))
+	mock.ExpectQuery(getCopyQuery).WithArgs(7, 1).
+		WillReturnRows(rows(copyColumns).AddRow(7, 1, "LIB-1", status))
+}
+
+func TestFine(t *testing.T) {
+	due := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("charge nothing given returned on time", func(t *testing.T) {
+		assert.Equal(t, int64(0), policy.fine(due, due))
+	})
+
+	t.Run("charge every started day given returned late", func(t *testing.T) {
+		assert.Equal(t, int64(500), policy.fine(due, due.Add(time.Minute)))
+		assert.Equal(t, int64(1500), policy.fine(due, due.Add(48*time.Hour+time.Hour)))
+	})
+}
+
+func TestCheckout(t *testing.T) {
+	t.Run("lend copy for loan period given copy available", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"barcode": "LIB-1", "member_id": "m-1"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		expectCopy(mock, CopyAvailable)
+		mock.ExpectQuery(createLoanQuery).WithArgs(7, 1, "m-1", now, now.Add(policy.LoanPeriod), 0, nil, 0, "THB", "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectExec(updateCopyQuery).WithArgs(CopyOnLoan, sqlmock.AnyArg(), 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Checkout(c)
+
+		loan := Loan{}
+		json.Unmarshal(response.Body.Bytes(), &loan)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, uint(3), loan.ID)
+		assert.Equal(t, now.Add(14*24*time.Hour), loan.DueAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("fulfil hold given copy held for the member", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"barcode": "LIB-1", "member_id": "m-1"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		expectCopy(mock, CopyOnHold)
+		mock.ExpectQuery(readyHoldQuery).WithArgs(7, HoldReady, 1).
+			WillReturnRows(rows(holdColumns).AddRow(4, 1, "m-1", HoldReady, 7, now, now.Add(policy.PickupPeriod), now))
+		mock.ExpectExec(updateHoldQuery).WithArgs(HoldFulfilled, sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(createLoanQuery).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectExec(updateCopyQuery).WithArgs(CopyOnLoan, sqlmock.AnyArg(), 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Checkout(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given copy held for another member", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"barcode": "LIB-1", "member_id": "m-2"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		expectCopy(mock, CopyOnHold)
+		mock.ExpectQuery(readyHoldQuery).WithArgs(7, HoldReady, 1).
+			WillReturnRows(rows(holdColumns).AddRow(4, 1, "m-1", HoldReady, 7, now, now.Add(policy.PickupPeriod), now))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Checkout(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return not found given unknown barcode", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"barcode": "LIB-9", "member_id": "m-1"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(findCopyQuery).WithArgs("LIB-9", 1).WillReturnError(gorm.ErrRecordNotFound)
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Checkout(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("lend copy of the request's storefront given tenant plugin", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"barcode": "LIB-1", "member_id": "m-1"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request = request.WithContext(tenant.NewContext(request.Context(), "th"))
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		assert.NoError(t, gormDB.Use(tenant.Plugin{}))
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(findTenantCopyQuery).WithArgs("LIB-1", "th", 1).
+			WillReturnRows(rows(copyColumns+",tenant_id").AddRow(7, 1, "LIB-1", CopyAvailable, "th"))
+		mock.ExpectQuery(lockTenantBookQuery).WithArgs(1, "th", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "tenant_id"}).AddRow(1, "Sapiens", "th"))
+		mock.ExpectQuery(expiredTenantHoldsQuery).WithArgs(1, HoldReady, now, "th").WillReturnRows(rows(holdColumns))
+		mock.ExpectQuery(getTenantCopyQuery).WithArgs(7, "th", 1).
+			WillReturnRows(rows(copyColumns+",tenant_id").AddRow(7, 1, "LIB-1", CopyAvailable, "th"))
+		mock.ExpectQuery(createLoanQuery).WithArgs(7, 1, "m-1", now, now.Add(policy.LoanPeriod), 0, nil, 0, "THB", "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectExec(updateTenantCopyQuery).WithArgs(CopyOnLoan, sqlmock.AnyArg(), "th", 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Checkout(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRenew(t *testing.T) {
+	loan := func(mock sqlmock.Sqlmock, due time.Time, renewals int) {
+		mock.ExpectQuery(getLoanQuery).WithArgs("3", 1).
+			WillReturnRows(rows(loanColumns).AddRow(3, 7, 1, "m-1", now.Add(-7*24*time.Hour), due, renewals, nil, 0, "THB"))
+		mock.ExpectQuery(lockBookQuery).WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(getLoanQuery).WithArgs(3, 1).
+			WillReturnRows(rows(loanColumns).AddRow(3, 7, 1, "m-1", now.Add(-7*24*time.Hour), due, renewals, nil, 0, "THB"))
+	}
+
+	t.Run("extend due date from today given no one waiting", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("3")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		loan(mock, now.Add(24*time.Hour), 1)
+		mock.ExpectQuery(waitingHoldsQuery).WithArgs(1, HoldWaiting).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(saveLoanQuery).
+			WithArgs(7, 1, "m-1", now.Add(-7*24*time.Hour), now.Add(policy.LoanPeriod), 2, nil, 0, "THB", "", 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Renew(c)
+
+		got := Loan{}
+		json.Unmarshal(response.Body.Bytes(), &got)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, 2, got.Renewals)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given renewal limit reached", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("3")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		loan(mock, now.Add(24*time.Hour), 2)
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Renew(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.Contains(t, response.Body.String(), "at most 2 times")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given members waiting for the book", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("3")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		loan(mock, now.Add(24*time.Hour), 0)
+		mock.ExpectQuery(waitingHoldsQuery).WithArgs(1, HoldWaiting).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Renew(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given loan overdue", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("3")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		loan(mock, now.Add(-time.Hour), 0)
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Renew(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCheckin(t *testing.T) {
+	t.Run("charge fine and keep copy for next hold given late return", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"barcode": "LIB-1"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		due := now.Add(-50 * time.Hour)
+		borrowed := due.Add(-policy.LoanPeriod)
+		mock.ExpectBegin()
+		expectCopy(mock, CopyOnLoan)
+		mock.ExpectQuery(openLoanQuery).WithArgs(7, 1).
+			WillReturnRows(rows(loanColumns).AddRow(3, 7, 1, "m-1", borrowed, due, 0, nil, 0, "THB"))
+		mock.ExpectExec(saveLoanQuery).
+			WithArgs(7, 1, "m-1", borrowed, due, 0, now, 1500, "THB", "", 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(nextHoldQuery).WithArgs(1, HoldWaiting, 1).
+			WillReturnRows(rows(holdColumns).AddRow(4, 1, "m-2", HoldWaiting, nil, nil, nil, now.Add(-time.Hour)))
+		mock.ExpectExec(saveHoldQuery).
+			WithArgs(1, "m-2", HoldReady, 7, now, now.Add(policy.PickupPeriod), now.Add(-time.Hour), sqlmock.AnyArg(), "", 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(updateCopyQuery).WithArgs(CopyOnHold, sqlmock.AnyArg(), 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Checkin(c)
+
+		loan := Loan{}
+		json.Unmarshal(response.Body.Bytes(), &loan)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, int64(1500), loan.Fine)
+		assert.False(t, loan.Overdue)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given copy not on loan", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"barcode": "LIB-1"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		expectCopy(mock, CopyAvailable)
+		mock.ExpectQuery(openLoanQuery).WithArgs(7, 1).WillReturnRows(rows(loanColumns))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.Checkin(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetOverdue(t *testing.T) {
+	t.Run("return fine so far given loans past due", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(overdueLoansQuery).WithArgs(now).
+			WillReturnRows(rows(loanColumns).AddRow(3, 7, 1, "m-1", now.Add(-20*24*time.Hour), now.Add(-6*24*time.Hour), 0, nil, 0, "THB"))
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := handler.GetOverdue(c)
+
+		loans := []Loan{}
+		json.Unmarshal(response.Body.Bytes(), &loans)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Len(t, loans, 1)
+		assert.True(t, loans[0].Overdue)
+		assert.Equal(t, int64(3000), loans[0].Fine)
+	})
+}
+
+func TestGetMemberLoans(t *testing.T) {
+	t.Run("return active loans given status active", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?status=active", nil)
+		request.Header.Set("X-Customer-ID", "m-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("m-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(memberLoansQuery).WithArgs("m-1").
+			WillReturnRows(rows(loanColumns).AddRow(3, 7, 1, "m-1", now.Add(-24*time.Hour), now.Add(13*24*time.Hour), 0, nil, 0, "THB"))
+
+		handler := NewHandler(gormDB, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireStaffOrCustomer(handler.GetMemberLoans)(c)
+
+		loans := []Loan{}
+		json.Unmarshal(response.Body.Bytes(), &loans)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Len(t, loans, 1)
+		assert.False(t, loans[0].Overdue)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given unknown status", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?status=lost", nil)
+		request.Header.Set("X-Staff-ID", "staff-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("m-1")
+
+		handler := NewHandler(nil, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireStaffOrCustomer(handler.GetMemberLoans)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("return forbidden given loans of another member", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Customer-ID", "m-2")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("m-1")
+
+		handler := NewHandler(nil, policy, notification.NewLogNotifier(zap.NewNop()), zap.NewNop())
+		handler.now = func() time.Time { return now }
+		err := middleware.RequireStaffOrCustomer(handler.GetMemberLoans)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+}
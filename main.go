@@ -11,8 +11,25 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/phetployst/book-store-api/book"
 	"github.com/phetployst/book-store-api/config"
+	"github.com/phetployst/book-store-api/credit"
+	"github.com/phetployst/book-store-api/digital"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/invoice"
+	"github.com/phetployst/book-store-api/lending"
 	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"github.com/phetployst/book-store-api/preorder"
+	"github.com/phetployst/book-store-api/promotion"
+	"github.com/phetployst/book-store-api/purchasing"
+	"github.com/phetployst/book-store-api/recommendation"
+	"github.com/phetployst/book-store-api/review"
+	"github.com/phetployst/book-store-api/rma"
 	"github.com/phetployst/book-store-api/router"
+	"github.com/phetployst/book-store-api/shipping"
+	"github.com/phetployst/book-store-api/tax"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/phetployst/book-store-api/timeline"
+	"github.com/phetployst/book-store-api/wishlist"
 	echoSwagger "github.com/swaggo/echo-swagger"
 
 	_ "github.com/phetployst/book-store-api/docs"
@@ -26,6 +43,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	zap.ReplaceGlobals(logger)
 
 	e := echo.New()
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
@@ -37,20 +55,45 @@ func main() {
 	config := configProvider.GetConfig()
 
 	db, err := gorm.Open(postgres.Open(config.Server.DBConnectionString), &gorm.Config{
-		Logger: middleware.CreateGormLogger(),
+		Logger:         middleware.CreateGormLogger(),
+		TranslateError: true,
 	})
 	if err != nil {
 		logger.Fatal("failed to open database connection", zap.Error(err))
 		panic("failed to connect to database")
 	}
 
-	db.AutoMigrate(&book.Book{})
-	router.RegisterRoutes(e, db)
+	db.AutoMigrate(&book.Book{}, &review.Review{}, &wishlist.Item{}, &wishlist.StockSubscription{}, &promotion.Promotion{}, &promotion.Redemption{}, &tax.Jurisdiction{}, &tax.Rate{}, &payment.Payment{}, &payment.WebhookEvent{},
+		&shipping.Zone{}, &shipping.Method{}, &shipping.Rate{}, &shipping.Shipment{}, &shipping.ShipmentLine{},
+		&timeline.Entry{}, &rma.Return{}, &rma.Line{},
+		&invoice.Invoice{}, &invoice.Line{}, &invoice.Sequence{},
+		&book.StockMovement{}, &purchasing.Supplier{}, &purchasing.SupplierBook{}, &purchasing.PurchaseOrder{}, &purchasing.OrderLine{},
+		&recommendation.Related{}, &lending.Copy{}, &lending.Loan{}, &lending.Hold{},
+		&digital.File{}, &digital.Entitlement{},
+		&credit.GiftCard{}, &credit.GiftCardEntry{}, &credit.Account{}, &credit.Entry{}, &credit.Tender{},
+		&preorder.Preorder{})
+	if err := db.Use(tenant.Plugin{}); err != nil {
+		logger.Fatal("failed to register tenant plugin", zap.Error(err))
+	}
+	e.Use(middleware.ResolveTenant(config.Tenant.HostMap(), config.Tenant.Default))
+	e.Use(middleware.Localize(config.Locale.List(), config.Locale.Default))
+	if _, err := i18n.Validator(); err != nil {
+		logger.Fatal("failed to register validation messages", zap.Error(err))
+	}
+	gateway := router.NewPaymentGateway(config)
+	router.RegisterRoutes(e, db, config, gateway)
 	address := fmt.Sprintf("%s:%d", config.Server.Hostname, config.Server.Port)
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
+	// The jobs work for every storefront.
+	jobCtx := tenant.Unscoped(ctx)
+	refreshInterval := time.Duration(config.Recommendation.RefreshIntervalMinutes) * time.Minute
+	go recommendation.NewJob(db, logger, refreshInterval).Run(jobCtx)
+	releaseInterval := time.Duration(config.Preorder.ReleaseIntervalMinutes) * time.Minute
+	go preorder.NewJob(db, logger, releaseInterval, payment.NewHandler(db, gateway, router.PaymentOptions(db, config, router.NewBlobStore(config))...).CaptureOrder).Run(jobCtx)
+
 	go func() {
 		if err := e.Start(address); err != nil && err != http.ErrServerClosed {
 			logger.Fatal("failed to start server", zap.Error(err))
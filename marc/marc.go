@@ -0,0 +1,170 @@
+package marc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+)
+
+const (
+	Namespace = "http://www.loc.gov/MARC21/slim"
+
+	subfieldDelimiter = 0x1F
+	fieldTerminator   = 0x1E
+	recordTerminator  = 0x1D
+
+	leaderLength    = 24
+	maxRecordLength = 99999
+	maxFieldLength  = 9999
+)
+
+var ErrRecordTooLong = errors.New("marc record exceeds 99999 bytes")
+
+type Subfield struct {
+	Code  byte
+	Value string
+}
+
+// Field is a control field when Tag is below 010, otherwise a data field with
+// two indicators and subfields.
+type Field struct {
+	Tag        string
+	Value      string
+	Indicator1 byte
+	Indicator2 byte
+	Subfields  []Subfield
+}
+
+func (f Field) IsControl() bool {
+	return f.Tag < "010"
+}
+
+// Record is a MARC21 record. Only the leader positions that describe the
+// record are kept; record length and base address are computed when encoding.
+type Record struct {
+	Status             byte
+	Type               byte
+	BibliographicLevel byte
+	EncodingLevel      byte
+	CatalogingForm     byte
+	Fields             []Field
+}
+
+func NewBibliographicRecord() Record {
+	return Record{
+		Status:             'n',
+		Type:               'a',
+		BibliographicLevel: 'm',
+		EncodingLevel:      ' ',
+		CatalogingForm:     'i',
+	}
+}
+
+func (r *Record) AddControlField(tag string, value string) {
+	r.Fields = append(r.Fields, Field{Tag: tag, Value: value})
+}
+
+func (r *Record) AddDataField(tag string, indicator1 byte, indicator2 byte, subfields ...Subfield) {
+	r.Fields = append(r.Fields, Field{Tag: tag, Indicator1: indicator1, Indicator2: indicator2, Subfields: subfields})
+}
+
+func (f Field) encode() []byte {
+	data := &bytes.Buffer{}
+	if f.IsControl() {
+		data.WriteString(f.Value)
+	} else {
+		data.WriteByte(f.Indicator1)
+		data.WriteByte(f.Indicator2)
+		for _, subfield := range f.Subfields {
+			data.WriteByte(subfieldDelimiter)
+			data.WriteByte(subfield.Code)
+			data.WriteString(subfield.Value)
+		}
+	}
+	data.WriteByte(fieldTerminator)
+	return data.Bytes()
+}
+
+func (r Record) leader(recordLength int, baseAddress int) string {
+	// Position 09 "a" declares UTF-8 (UCS) character coding.
+	return fmt.Sprintf("%05d%c%c%c a22%05d%c%c 4500",
+		recordLength, r.Status, r.Type, r.BibliographicLevel, baseAddress, r.EncodingLevel, r.CatalogingForm)
+}
+
+// MarshalBinary encodes the record in ISO 2709 MARC21 transmission format.
+// Lengths and offsets in the leader and directory are counted in bytes.
+func (r Record) MarshalBinary() ([]byte, error) {
+	directory := &bytes.Buffer{}
+	data := &bytes.Buffer{}
+
+	for _, field := range r.Fields {
+		encoded := field.encode()
+		if len(encoded) > maxFieldLength {
+			return nil, fmt.Errorf("marc field %s exceeds %d bytes", field.Tag, maxFieldLength)
+		}
+		fmt.Fprintf(directory, "%3s%04d%05d", field.Tag, len(encoded), data.Len())
+		data.Write(encoded)
+	}
+	directory.WriteByte(fieldTerminator)
+
+	baseAddress := leaderLength + directory.Len()
+	recordLength := baseAddress + data.Len() + 1
+	if recordLength > maxRecordLength {
+		return nil, ErrRecordTooLong
+	}
+
+	record := bytes.NewBufferString(r.leader(recordLength, baseAddress))
+	record.Write(directory.Bytes())
+	record.Write(data.Bytes())
+	record.WriteByte(recordTerminator)
+	return record.Bytes(), nil
+}
+
+type xmlSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlDataField struct {
+	Tag        string        `xml:"tag,attr"`
+	Indicator1 string        `xml:"ind1,attr"`
+	Indicator2 string        `xml:"ind2,attr"`
+	Subfields  []xmlSubfield `xml:"subfield"`
+}
+
+type xmlRecord struct {
+	XMLName       xml.Name          `xml:"record"`
+	Leader        string            `xml:"leader"`
+	ControlFields []xmlControlField `xml:"controlfield"`
+	DataFields    []xmlDataField    `xml:"datafield"`
+}
+
+// MarshalXML encodes the record as a MARCXML record element. Control fields
+// always precede data fields, as they do in a MARC record.
+func (r Record) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	binary, err := r.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	record := xmlRecord{Leader: string(binary[:leaderLength])}
+	for _, field := range r.Fields {
+		if field.IsControl() {
+			record.ControlFields = append(record.ControlFields, xmlControlField{Tag: field.Tag, Value: field.Value})
+			continue
+		}
+		dataField := xmlDataField{Tag: field.Tag, Indicator1: string(field.Indicator1), Indicator2: string(field.Indicator2)}
+		for _, subfield := range field.Subfields {
+			dataField.Subfields = append(dataField.Subfields, xmlSubfield{Code: string(subfield.Code), Value: subfield.Value})
+		}
+		record.DataFields = append(record.DataFields, dataField)
+	}
+
+	return encoder.Encode(record)
+}
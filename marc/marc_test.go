@@ -0,0 +1,63 @@
+package marc
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	t.Run("encode leader and directory given control and data fields", func(t *testing.T) {
+		record := NewBibliographicRecord()
+		record.AddControlField("001", "42")
+		record.AddDataField("245", '1', '0', Subfield{Code: 'a', Value: "Title"})
+
+		got, err := record.MarshalBinary()
+
+		want := "00063nam a2200049 i 4500" +
+			"001000300000" + "245001000003" + "\x1e" +
+			"42\x1e" +
+			"10\x1faTitle\x1e" +
+			"\x1d"
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	})
+
+	t.Run("count lengths in bytes given multi-byte characters", func(t *testing.T) {
+		record := NewBibliographicRecord()
+		record.AddDataField("245", '0', '0', Subfield{Code: 'a', Value: "ต้นไม้"})
+
+		got, err := record.MarshalBinary()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "00061", string(got[:5]))
+		assert.Equal(t, 61, len(got))
+		assert.Equal(t, "245002300000", string(got[24:36]))
+	})
+
+	t.Run("return error given record longer than the leader allows", func(t *testing.T) {
+		record := NewBibliographicRecord()
+		for i := 0; i < 20; i++ {
+			record.AddDataField("500", ' ', ' ', Subfield{Code: 'a', Value: string(make([]byte, 9000))})
+		}
+
+		_, err := record.MarshalBinary()
+
+		assert.ErrorIs(t, err, ErrRecordTooLong)
+	})
+}
+
+func TestMarshalXML(t *testing.T) {
+	record := NewBibliographicRecord()
+	record.AddControlField("001", "42")
+	record.AddDataField("020", ' ', ' ', Subfield{Code: 'a', Value: "9781101875322"})
+
+	got, err := xml.Marshal(record)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `<record><leader>00071nam a2200049 i 4500</leader>`+
+		`<controlfield tag="001">42</controlfield>`+
+		`<datafield tag="020" ind1=" " ind2=" "><subfield code="a">9781101875322</subfield></datafield>`+
+		`</record>`, string(got))
+}
@@ -0,0 +1,67 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker stops calling a failing provider. After threshold
+// consecutive failures it rejects lookups with ErrUnavailable until cooldown
+// has passed, then lets a single lookup through to probe the provider.
+type CircuitBreaker struct {
+	provider  MetadataProvider
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func NewCircuitBreaker(provider MetadataProvider, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{provider: provider, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+func (b *CircuitBreaker) Lookup(ctx context.Context, isbn string) (Record, error) {
+	if !b.allow() {
+		return Record{}, ErrUnavailable
+	}
+
+	record, err := b.provider.Lookup(ctx, isbn)
+	b.record(err)
+	return record, err
+}
+
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil || errors.Is(err, ErrNotFound) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
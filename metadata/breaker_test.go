@@ -0,0 +1,45 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Run("open after consecutive failures and probe after cooldown", func(t *testing.T) {
+		now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+		stub := &stubProvider{err: errors.New("timeout")}
+		breaker := NewCircuitBreaker(stub, 2, time.Minute)
+		breaker.now = func() time.Time { return now }
+
+		breaker.Lookup(context.Background(), "9781847941831")
+		breaker.Lookup(context.Background(), "9781847941831")
+		_, err := breaker.Lookup(context.Background(), "9781847941831")
+
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.Equal(t, 2, stub.calls)
+
+		now = now.Add(time.Minute)
+		stub.err = nil
+		_, err = breaker.Lookup(context.Background(), "9781847941831")
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3, stub.calls)
+		assert.Equal(t, 0, breaker.failures)
+	})
+
+	t.Run("stay closed given isbn is not found", func(t *testing.T) {
+		stub := &stubProvider{err: ErrNotFound}
+		breaker := NewCircuitBreaker(stub, 1, time.Minute)
+
+		breaker.Lookup(context.Background(), "9780062315007")
+		_, err := breaker.Lookup(context.Background(), "9780062315007")
+
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Equal(t, 2, stub.calls)
+	})
+}
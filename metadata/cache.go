@@ -0,0 +1,69 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	record  Record
+	err     error
+	expires time.Time
+}
+
+// CachingProvider remembers lookups, including ISBNs the provider does not
+// know, for a fixed time. Other errors are not cached.
+type CachingProvider struct {
+	provider MetadataProvider
+	ttl      time.Duration
+	size     int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func NewCachingProvider(provider MetadataProvider, ttl time.Duration, size int) *CachingProvider {
+	return &CachingProvider{
+		provider: provider,
+		ttl:      ttl,
+		size:     size,
+		now:      time.Now,
+		entries:  make(map[string]cacheEntry),
+	}
+}
+
+func (p *CachingProvider) Lookup(ctx context.Context, isbn string) (Record, error) {
+	p.mu.Lock()
+	entry, ok := p.entries[isbn]
+	p.mu.Unlock()
+	if ok && p.now().Before(entry.expires) {
+		return entry.record, entry.err
+	}
+
+	record, err := p.provider.Lookup(ctx, isbn)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Record{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.entries) >= p.size {
+		p.evictExpired()
+	}
+	if len(p.entries) < p.size {
+		p.entries[isbn] = cacheEntry{record: record, err: err, expires: p.now().Add(p.ttl)}
+	}
+	return record, err
+}
+
+func (p *CachingProvider) evictExpired() {
+	now := p.now()
+	for isbn, entry := range p.entries {
+		if !now.Before(entry.expires) {
+			delete(p.entries, isbn)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubProvider struct {
+	calls  int
+	record Record
+	err    error
+}
+
+func (p *stubProvider) Lookup(ctx context.Context, isbn string) (Record, error) {
+	p.calls++
+	return p.record, p.err
+}
+
+func TestCachingProvider(t *testing.T) {
+	t.Run("serve cached record until it expires", func(t *testing.T) {
+		now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+		stub := &stubProvider{record: Record{Title: "Atomic Habits"}}
+		cache := NewCachingProvider(stub, time.Minute, 10)
+		cache.now = func() time.Time { return now }
+
+		cache.Lookup(context.Background(), "9781847941831")
+		record, err := cache.Lookup(context.Background(), "9781847941831")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Atomic Habits", record.Title)
+		assert.Equal(t, 1, stub.calls)
+
+		now = now.Add(time.Minute)
+		cache.Lookup(context.Background(), "9781847941831")
+		assert.Equal(t, 2, stub.calls)
+	})
+
+	t.Run("cache not found given unknown isbn", func(t *testing.T) {
+		stub := &stubProvider{err: ErrNotFound}
+		cache := NewCachingProvider(stub, time.Minute, 10)
+
+		cache.Lookup(context.Background(), "9780062315007")
+		_, err := cache.Lookup(context.Background(), "9780062315007")
+
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Equal(t, 1, stub.calls)
+	})
+
+	t.Run("skip cache given provider error", func(t *testing.T) {
+		stub := &stubProvider{err: errors.New("timeout")}
+		cache := NewCachingProvider(stub, time.Minute, 10)
+
+		cache.Lookup(context.Background(), "9781847941831")
+		cache.Lookup(context.Background(), "9781847941831")
+
+		assert.Equal(t, 2, stub.calls)
+	})
+}
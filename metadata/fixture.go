@@ -0,0 +1,39 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// FixtureProvider serves records from an Open Library style JSON document
+// keyed by "ISBN:<isbn>", so tests can run without network access.
+type FixtureProvider struct {
+	books map[string]openLibraryBook
+}
+
+func LoadFixtureProvider(path string) (*FixtureProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	books := map[string]openLibraryBook{}
+	if err := json.Unmarshal(data, &books); err != nil {
+		return nil, err
+	}
+	return &FixtureProvider{books: books}, nil
+}
+
+func (p *FixtureProvider) Lookup(ctx context.Context, isbn string) (Record, error) {
+	if err := ctx.Err(); err != nil {
+		return Record{}, err
+	}
+
+	book, ok := p.books["ISBN:"+strings.TrimSpace(isbn)]
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return book.record(isbn), nil
+}
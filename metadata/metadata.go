@@ -0,0 +1,24 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrNotFound    = errors.New("metadata not found")
+	ErrUnavailable = errors.New("metadata provider unavailable")
+)
+
+type Record struct {
+	ISBN      string   `json:"isbn"`
+	Title     string   `json:"title"`
+	Authors   []string `json:"authors"`
+	Publisher string   `json:"publisher"`
+}
+
+// MetadataProvider looks up bibliographic metadata for an ISBN. It returns
+// ErrNotFound when the provider has no record for the ISBN.
+type MetadataProvider interface {
+	Lookup(ctx context.Context, isbn string) (Record, error)
+}
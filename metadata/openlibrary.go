@@ -0,0 +1,77 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type openLibraryName struct {
+	Name string `json:"name"`
+}
+
+type openLibraryBook struct {
+	Title      string            `json:"title"`
+	Authors    []openLibraryName `json:"authors"`
+	Publishers []openLibraryName `json:"publishers"`
+}
+
+// OpenLibraryClient looks up books with the Open Library Books API.
+type OpenLibraryClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewOpenLibraryClient(baseURL string, timeout time.Duration) *OpenLibraryClient {
+	return &OpenLibraryClient{baseURL: baseURL, client: &http.Client{Timeout: timeout}}
+}
+
+func (c *OpenLibraryClient) Lookup(ctx context.Context, isbn string) (Record, error) {
+	query := url.Values{}
+	query.Set("bibkeys", "ISBN:"+isbn)
+	query.Set("format", "json")
+	query.Set("jscmd", "data")
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/books?"+query.Encode(), nil)
+	if err != nil {
+		return Record{}, err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return Record{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, response.Body)
+		return Record{}, fmt.Errorf("open library returned status %d", response.StatusCode)
+	}
+
+	books := map[string]openLibraryBook{}
+	if err := json.NewDecoder(response.Body).Decode(&books); err != nil {
+		return Record{}, fmt.Errorf("failed to decode open library response: %w", err)
+	}
+
+	book, ok := books["ISBN:"+isbn]
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return book.record(isbn), nil
+}
+
+func (book openLibraryBook) record(isbn string) Record {
+	record := Record{ISBN: isbn, Title: book.Title, Authors: []string{}}
+	for _, author := range book.Authors {
+		record.Authors = append(record.Authors, author.Name)
+	}
+	if len(book.Publishers) > 0 {
+		record.Publisher = book.Publishers[0].Name
+	}
+	return record
+}
@@ -0,0 +1,76 @@
+package metadata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fixturePath = "testdata/openlibrary.json"
+
+func TestOpenLibraryClient(t *testing.T) {
+	fixture, _ := os.ReadFile(fixturePath)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/books" || r.URL.Query().Get("jscmd") != "data" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("bibkeys") == "ISBN:0000000000" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(fixture)
+	}))
+	defer server.Close()
+
+	client := NewOpenLibraryClient(server.URL, time.Second)
+
+	t.Run("return record given known isbn", func(t *testing.T) {
+		record, err := client.Lookup(context.Background(), "9781101875322")
+
+		assert.NoError(t, err)
+		assert.Equal(t, Record{
+			ISBN:      "9781101875322",
+			Title:     "Designing Your Life",
+			Authors:   []string{"Bill Burnett", "Dave Evans"},
+			Publisher: "Knopf",
+		}, record)
+	})
+
+	t.Run("return not found given unknown isbn", func(t *testing.T) {
+		_, err := client.Lookup(context.Background(), "9780062315007")
+
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("return error given server error", func(t *testing.T) {
+		_, err := client.Lookup(context.Background(), "0000000000")
+
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestFixtureProvider(t *testing.T) {
+	provider, err := LoadFixtureProvider(fixturePath)
+	assert.NoError(t, err)
+
+	t.Run("return record given known isbn", func(t *testing.T) {
+		record, err := provider.Lookup(context.Background(), "9781847941831")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Atomic Habits", record.Title)
+		assert.Equal(t, []string{"James Clear"}, record.Authors)
+	})
+
+	t.Run("return not found given unknown isbn", func(t *testing.T) {
+		_, err := provider.Lookup(context.Background(), "9780062315007")
+
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	customerContextKey = "customer-id"
+	customerIDHeader   = "X-Customer-ID"
+)
+
+// RequireCustomer identifies the customer making the request from the
+// X-Customer-ID header, which is set by the storefront gateway after it has
+// authenticated the customer.
+func RequireCustomer(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		customerID := strings.TrimSpace(c.Request().Header.Get(customerIDHeader))
+		if customerID == "" {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": customerIDHeader + " header is required"})
+		}
+		c.Set(customerContextKey, customerID)
+		return next(c)
+	}
+}
+
+func GetCustomerID(c echo.Context) string {
+	customerID, _ := c.Get(customerContextKey).(string)
+	return customerID
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireCustomer(t *testing.T) {
+	t.Run("should set customer id to context given X-Customer-ID exists", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := RequireCustomer(func(c echo.Context) error {
+			return c.String(http.StatusOK, GetCustomerID(c))
+		})(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "customer-1", response.Body.String())
+	})
+
+	t.Run("should return unauthorized given X-Customer-ID is missing", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := RequireCustomer(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/i18n"
+)
+
+const localeContextKey = "locales"
+
+// Localize negotiates the locales of the response from the Accept-Language
+// header. Handlers try them in order, falling back to the next one for
+// whatever the first is missing, down to fallback.
+func Localize(supported []string, fallback string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(localeContextKey, i18n.Negotiate(c.Request().Header.Get("Accept-Language"), supported, fallback))
+			return next(c)
+		}
+	}
+}
+
+// GetLocales returns the negotiated locales, most preferred first, or nil
+// when the request was not localized.
+func GetLocales(c echo.Context) []string {
+	locales, _ := c.Get(localeContextKey).([]string)
+	return locales
+}
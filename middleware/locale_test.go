@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalize(t *testing.T) {
+	t.Run("should set locales to context given Accept-Language", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept-Language", "th-TH,th;q=0.9,en;q=0.8")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := Localize([]string{"th", "en"}, "en")(func(c echo.Context) error {
+			return c.String(http.StatusOK, strings.Join(GetLocales(c), ","))
+		})(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "th,en", response.Body.String())
+	})
+
+	t.Run("should use fallback given no Accept-Language", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := Localize([]string{"th", "en"}, "en")(func(c echo.Context) error {
+			return c.String(http.StatusOK, strings.Join(GetLocales(c), ","))
+		})(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "en", response.Body.String())
+	})
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireStaff(t *testing.T) {
+	t.Run("should set staff id to context given X-Staff-ID exists", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Staff-ID", "staff-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := RequireStaff(func(c echo.Context) error {
+			return c.String(http.StatusOK, GetStaffID(c))
+		})(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "staff-1", response.Body.String())
+	})
+
+	t.Run("should return forbidden given X-Staff-ID is missing", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := RequireStaff(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+}
+
+func TestRequireStaffOrCustomer(t *testing.T) {
+	t.Run("should set staff id to context given X-Staff-ID exists", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Staff-ID", "staff-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := RequireStaffOrCustomer(func(c echo.Context) error {
+			return c.String(http.StatusOK, GetStaffID(c)+GetCustomerID(c))
+		})(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "staff-1", response.Body.String())
+	})
+
+	t.Run("should set customer id to context given only X-Customer-ID exists", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := RequireStaffOrCustomer(func(c echo.Context) error {
+			return c.String(http.StatusOK, GetStaffID(c)+GetCustomerID(c))
+		})(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "customer-1", response.Body.String())
+	})
+
+	t.Run("should return unauthorized given neither header exists", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := RequireStaffOrCustomer(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/tenant"
+)
+
+const (
+	tenantContextKey = "tenant-id"
+	tenantIDHeader   = "X-Tenant-ID"
+)
+
+// ResolveTenant identifies the storefront a request is for from its Host,
+// looked up in hosts, or else from the X-Tenant-ID header, which the
+// storefront gateway sets from the tenant claim of the customer's token.
+// Requests neither claims go to fallback, or are rejected when it is empty.
+// The tenant is put in the request's context for the tenant plugin to scope
+// queries with.
+func ResolveTenant(hosts map[string]string, fallback string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			request := c.Request()
+			host := request.Host
+			if name, _, err := net.SplitHostPort(host); err == nil {
+				host = name
+			}
+			fromHost := hosts[strings.ToLower(host)]
+			fromClaim := strings.TrimSpace(request.Header.Get(tenantIDHeader))
+
+			tenantID := fromHost
+			switch {
+			case fromHost != "" && fromClaim != "" && fromClaim != fromHost:
+				return c.JSON(http.StatusForbidden, map[string]string{"error": tenantIDHeader + " does not match the storefront"})
+			case tenantID == "":
+				tenantID = fromClaim
+			}
+			if tenantID == "" {
+				tenantID = fallback
+			}
+			if tenantID == "" {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown storefront"})
+			}
+
+			c.Set(tenantContextKey, tenantID)
+			c.SetRequest(request.WithContext(tenant.NewContext(request.Context(), tenantID)))
+			return next(c)
+		}
+	}
+}
+
+func GetTenantID(c echo.Context) string {
+	tenantID, _ := c.Get(tenantContextKey).(string)
+	return tenantID
+}
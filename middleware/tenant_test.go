@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveTenant(t *testing.T) {
+	hosts := map[string]string{"books.example.co.th": "th", "books.example.co.uk": "uk"}
+	resolved := func(c echo.Context) error {
+		fromContext, _ := tenant.FromContext(c.Request().Context())
+		return c.String(http.StatusOK, GetTenantID(c)+" "+fromContext)
+	}
+
+	t.Run("should resolve tenant from host given known host", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Host = "Books.Example.co.th:443"
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := ResolveTenant(hosts, "")(resolved)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "th th", response.Body.String())
+	})
+
+	t.Run("should resolve tenant from claim given unknown host", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Tenant-ID", "uk")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := ResolveTenant(hosts, tenant.Default)(resolved)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "uk uk", response.Body.String())
+	})
+
+	t.Run("should return forbidden given claim of another storefront", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Host = "books.example.co.th"
+		request.Header.Set("X-Tenant-ID", "uk")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := ResolveTenant(hosts, tenant.Default)(resolved)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("should use fallback given neither host nor claim", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := ResolveTenant(hosts, tenant.Default)(resolved)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "default default", response.Body.String())
+	})
+
+	t.Run("should return bad request given no tenant and no fallback", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		err := ResolveTenant(hosts, "")(resolved)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
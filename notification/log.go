@@ -0,0 +1,26 @@
+package notification
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// LogNotifier writes notifications to the log. It is used when no delivery
+// service is configured.
+type LogNotifier struct {
+	logger *zap.Logger
+}
+
+func NewLogNotifier(logger *zap.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, notification Notification) error {
+	n.logger.Info("notification",
+		zap.String("type", notification.Type),
+		zap.String("customer_id", notification.CustomerID),
+		zap.String("subject", notification.Subject),
+		zap.Any("data", notification.Data))
+	return nil
+}
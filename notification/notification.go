@@ -0,0 +1,27 @@
+package notification
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	TypeBackInStock = "book.back_in_stock"
+	TypeHoldReady   = "library.hold_ready"
+)
+
+// Notification is a message for a single customer. Data carries the details
+// of the notification type, e.g. the book that is back in stock.
+type Notification struct {
+	Type       string         `json:"type"`
+	CustomerID string         `json:"customer_id"`
+	Subject    string         `json:"subject"`
+	Data       map[string]any `json:"data"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// Notifier delivers notifications to customers, for example by e-mail or
+// through a messaging service.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
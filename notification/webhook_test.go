@@ -0,0 +1,42 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	t.Run("post notification as json", func(t *testing.T) {
+		received := Notification{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&received)
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+
+		notifier := NewWebhookNotifier(server.URL, time.Second)
+		err := notifier.Notify(context.Background(), Notification{Type: TypeBackInStock, CustomerID: "customer-1"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, TypeBackInStock, received.Type)
+		assert.Equal(t, "customer-1", received.CustomerID)
+	})
+
+	t.Run("return error given webhook fails", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		notifier := NewWebhookNotifier(server.URL, time.Second)
+		err := notifier.Notify(context.Background(), Notification{Type: TypeBackInStock})
+
+		assert.Error(t, err)
+	})
+}
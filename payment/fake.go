@@ -0,0 +1,156 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DeclinedPaymentMethod is declined by FakeGateway; any other payment
+// method is authorized.
+const DeclinedPaymentMethod = "pm_card_declined"
+
+// FakeSignatureHeader carries the signature of webhooks for FakeGateway.
+const FakeSignatureHeader = "Webhook-Signature"
+
+type fakeCharge struct {
+	charge   Charge
+	refunded int64
+}
+
+type fakeResult struct {
+	charge Charge
+	refund Refund
+	err    error
+}
+
+// FakeGateway is an in-process PaymentGateway for tests and local
+// development. Charge IDs are numbered in order, so runs are repeatable.
+type FakeGateway struct {
+	mu        sync.Mutex
+	secret    string
+	charges   map[string]*fakeCharge
+	responses map[string]fakeResult
+	next      int
+	now       func() time.Time
+}
+
+func NewFakeGateway(webhookSecret string) *FakeGateway {
+	return &FakeGateway{
+		secret:    webhookSecret,
+		charges:   map[string]*fakeCharge{},
+		responses: map[string]fakeResult{},
+		now:       time.Now,
+	}
+}
+
+// once replays the response stored under key, or runs call and stores it.
+func (g *FakeGateway) once(key string, call func() fakeResult) fakeResult {
+	if result, ok := g.responses[key]; ok && key != "" {
+		return result
+	}
+	result := call()
+	if key != "" {
+		g.responses[key] = result
+	}
+	return result
+}
+
+func (g *FakeGateway) Authorize(ctx context.Context, request AuthorizeRequest) (Charge, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	result := g.once(request.IdempotencyKey, func() fakeResult {
+		if request.PaymentMethod == DeclinedPaymentMethod {
+			return fakeResult{err: fmt.Errorf("%w: card declined", ErrDeclined)}
+		}
+		g.next++
+		charge := Charge{
+			ID:       fmt.Sprintf("fake_ch_%d", g.next),
+			Status:   ChargeAuthorized,
+			Amount:   request.Amount,
+			Currency: request.Currency,
+		}
+		g.charges[charge.ID] = &fakeCharge{charge: charge}
+		return fakeResult{charge: charge}
+	})
+	return result.charge, result.err
+}
+
+func (g *FakeGateway) Capture(ctx context.Context, chargeID string, amount int64, idempotencyKey string) (Charge, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	result := g.once(idempotencyKey, func() fakeResult {
+		stored, ok := g.charges[chargeID]
+		if !ok {
+			return fakeResult{err: ErrChargeNotFound}
+		}
+		if stored.charge.Status != ChargeAuthorized {
+			return fakeResult{err: ErrInvalidState}
+		}
+		if amount == 0 {
+			amount = stored.charge.Amount
+		}
+		if amount > stored.charge.Amount {
+			return fakeResult{err: ErrInvalidState}
+		}
+		stored.charge.Status = ChargeCaptured
+		stored.charge.Captured = amount
+		return fakeResult{charge: stored.charge}
+	})
+	return result.charge, result.err
+}
+
+func (g *FakeGateway) Refund(ctx context.Context, chargeID string, amount int64, idempotencyKey string) (Refund, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	result := g.once(idempotencyKey, func() fakeResult {
+		stored, ok := g.charges[chargeID]
+		if !ok {
+			return fakeResult{err: ErrChargeNotFound}
+		}
+		if stored.charge.Status != ChargeCaptured || amount > stored.charge.Captured-stored.refunded {
+			return fakeResult{err: ErrInvalidState}
+		}
+		stored.refunded += amount
+		g.next++
+		return fakeResult{refund: Refund{ID: fmt.Sprintf("fake_re_%d", g.next), Amount: amount}}
+	})
+	return result.refund, result.err
+}
+
+func (g *FakeGateway) Void(ctx context.Context, chargeID string, idempotencyKey string) (Charge, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	result := g.once(idempotencyKey, func() fakeResult {
+		stored, ok := g.charges[chargeID]
+		if !ok {
+			return fakeResult{err: ErrChargeNotFound}
+		}
+		if stored.charge.Status != ChargeAuthorized {
+			return fakeResult{err: ErrInvalidState}
+		}
+		stored.charge.Status = ChargeVoided
+		return fakeResult{charge: stored.charge}
+	})
+	return result.charge, result.err
+}
+
+// ParseWebhook accepts an Event encoded as JSON and signed with Sign in the
+// Webhook-Signature header.
+func (g *FakeGateway) ParseWebhook(payload []byte, header http.Header) (Event, error) {
+	if err := verifySignature(g.secret, payload, header.Get(FakeSignatureHeader), g.now()); err != nil {
+		return Event{}, err
+	}
+	event := Event{}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return Event{}, err
+	}
+	return event, nil
+}
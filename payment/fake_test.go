@@ -0,0 +1,50 @@
+package payment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeGateway(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("return the same charge given repeated idempotency key", func(t *testing.T) {
+		gateway := NewFakeGateway("")
+		request := AuthorizeRequest{Amount: 1000, Currency: "USD", PaymentMethod: "pm_card_visa", IdempotencyKey: "payment-1-authorize-1"}
+
+		first, err := gateway.Authorize(ctx, request)
+		assert.NoError(t, err)
+		second, err := gateway.Authorize(ctx, request)
+		assert.NoError(t, err)
+
+		assert.Equal(t, "fake_ch_1", first.ID)
+		assert.Equal(t, first, second)
+	})
+
+	t.Run("return declined given declined payment method", func(t *testing.T) {
+		gateway := NewFakeGateway("")
+
+		_, err := gateway.Authorize(ctx, AuthorizeRequest{Amount: 1000, Currency: "USD", PaymentMethod: DeclinedPaymentMethod})
+
+		assert.ErrorIs(t, err, ErrDeclined)
+	})
+
+	t.Run("capture, refund and reject a second void given authorized charge", func(t *testing.T) {
+		gateway := NewFakeGateway("")
+		charge, _ := gateway.Authorize(ctx, AuthorizeRequest{Amount: 1000, Currency: "USD", PaymentMethod: "pm_card_visa"})
+
+		captured, err := gateway.Capture(ctx, charge.ID, 0, "capture")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1000), captured.Captured)
+
+		_, err = gateway.Refund(ctx, charge.ID, 400, "refund-0")
+		assert.NoError(t, err)
+		_, err = gateway.Refund(ctx, charge.ID, 700, "refund-400")
+		assert.ErrorIs(t, err, ErrInvalidState)
+
+		_, err = gateway.Void(ctx, charge.ID, "void")
+		assert.ErrorIs(t, err, ErrInvalidState)
+	})
+}
@@ -0,0 +1,78 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Charge states as reported by a gateway.
+const (
+	ChargePending    = "pending"
+	ChargeAuthorized = "authorized"
+	ChargeCaptured   = "captured"
+	ChargeVoided     = "voided"
+	ChargeFailed     = "failed"
+)
+
+// Webhook event types, independent of the gateway that sent them.
+const (
+	EventCaptured = "payment.captured"
+	EventRefunded = "payment.refunded"
+	EventVoided   = "payment.voided"
+	EventFailed   = "payment.failed"
+)
+
+var (
+	ErrDeclined         = errors.New("payment declined")
+	ErrInvalidState     = errors.New("payment is not in a state that allows this")
+	ErrChargeNotFound   = errors.New("charge not found")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// AuthorizeRequest reserves Amount, in the smallest unit of Currency, on a
+// payment method without taking it yet. Retrying with the same
+// IdempotencyKey never authorizes twice.
+type AuthorizeRequest struct {
+	Amount         int64
+	Currency       string
+	PaymentMethod  string
+	Reference      string
+	IdempotencyKey string
+}
+
+type Charge struct {
+	ID       string
+	Status   string
+	Amount   int64
+	Captured int64
+	Currency string
+}
+
+type Refund struct {
+	ID     string
+	Amount int64
+}
+
+// Event is a webhook notification about a charge. Amount is the total
+// captured for payment.captured and the total refunded for payment.refunded.
+type Event struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	ChargeID string `json:"charge_id"`
+	Amount   int64  `json:"amount"`
+}
+
+// PaymentGateway is a payment provider. Every call that moves money takes
+// an idempotency key so it is safe to retry after a timeout.
+type PaymentGateway interface {
+	Authorize(ctx context.Context, request AuthorizeRequest) (Charge, error)
+	// Capture takes amount of an authorized charge, or all of it when
+	// amount is 0.
+	Capture(ctx context.Context, chargeID string, amount int64, idempotencyKey string) (Charge, error)
+	Refund(ctx context.Context, chargeID string, amount int64, idempotencyKey string) (Refund, error)
+	Void(ctx context.Context, chargeID string, idempotencyKey string) (Charge, error)
+	// ParseWebhook verifies the signature of a webhook request and returns
+	// its event.
+	ParseWebhook(payload []byte, header http.Header) (Event, error)
+}
@@ -0,0 +1,637 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/tenant"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	StatusPending    = "pending"
+	StatusAuthorized = "authorized"
+	StatusPaid       = "paid"
+	StatusRefunded   = "refunded"
+	StatusVoided     = "voided"
+	StatusFailed     = "failed"
+)
+
+var (
+	ErrPaymentNotFound = errors.New("payment not found")
+	ErrNotPaid         = errors.New("payment is not paid")
+	ErrNotAuthorized   = errors.New("payment is not authorized")
+	ErrRefundTooLarge  = errors.New("refund exceeds the amount left to refund")
+	ErrNoTender        = errors.New("order has no tender")
+	ErrInProgress      = errors.New("another change to the payment is in progress")
+
+	errCaptureTooLarge = errors.New("capture exceeds the authorized amount")
+)
+
+// Payment is the payment of an order, identified by the order's reference.
+// Amounts are in the smallest unit of the currency, such as cents. Status
+// only becomes paid once the gateway confirms the capture. Pending is the
+// capture, refund or void the gateway is being asked for, with the key and
+// amount it was asked with; it is cleared once the answer is recorded.
+type Payment struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	OrderRef      string    `json:"order_ref" gorm:"not null;uniqueIndex:idx_payments_tenant_order_ref"`
+	CustomerID    string    `json:"customer_id" gorm:"not null;index"`
+	ChargeID      string    `json:"-" gorm:"index"`
+	Amount        int64     `json:"amount"`
+	Currency      string    `json:"currency"`
+	Captured      int64     `json:"captured"`
+	Refunded      int64     `json:"refunded"`
+	Status        string    `json:"status" gorm:"not null;index"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	Attempts      int       `json:"-"`
+	Pending       string    `json:"-"`
+	PendingKey    string    `json:"-"`
+	PendingAmount int64     `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	TenantID      string    `json:"-" gorm:"not null;default:'default';uniqueIndex:idx_payments_tenant_order_ref"`
+}
+
+type PaymentRequest struct {
+	OrderRef      string `json:"order_ref" validate:"required,max=100"`
+	Amount        int64  `json:"amount" validate:"gt=0"`
+	Currency      string `json:"currency" validate:"required,len=3,uppercase"`
+	PaymentMethod string `json:"payment_method" validate:"required"`
+}
+
+type AmountRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+func (c *CustomValidator) Validate(i interface{}) error {
+	return c.validator.Struct(i)
+}
+
+// Tendered is what gift cards and store credit paid towards an order:
+// Amount is the order total they were applied to and Remaining is what is
+// left to pay.
+type Tendered struct {
+	Amount    int64
+	Remaining int64
+	Currency  string
+}
+
+// TenderFunc returns the gift cards and store credit applied to an order. It
+// returns ErrNoTender when none were applied to the order.
+type TenderFunc func(db *gorm.DB, orderRef string) (Tendered, error)
+
+// PaidFunc is called once a payment has been captured, after the capture is
+// saved. ctx is scoped to the payment's tenant.
+type PaidFunc func(ctx context.Context, paid Payment)
+
+type handler struct {
+	db      *gorm.DB
+	gateway PaymentGateway
+	tenders TenderFunc
+	onPaid  PaidFunc
+}
+
+type Option func(*handler)
+
+// WithTenders makes payments charge only what is left of an order after
+// its gift cards and store credit.
+func WithTenders(fn TenderFunc) Option {
+	return func(handler *handler) {
+		handler.tenders = fn
+	}
+}
+
+// WithPaidHandler calls fn for each payment that is captured, whether by a
+// request, a job or a webhook.
+func WithPaidHandler(fn PaidFunc) Option {
+	return func(handler *handler) {
+		handler.onPaid = fn
+	}
+}
+
+func NewHandler(db *gorm.DB, gateway PaymentGateway, options ...Option) *handler {
+	handler := &handler{db: db, gateway: gateway}
+	for _, option := range options {
+		option(handler)
+	}
+	return handler
+}
+
+// gatewayFailure marks an error returned by the gateway, as opposed to one
+// from the database.
+type gatewayFailure struct {
+	err error
+}
+
+func (failure gatewayFailure) Error() string {
+	return failure.err.Error()
+}
+
+func (failure gatewayFailure) Unwrap() error {
+	return failure.err
+}
+
+func gatewayError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrDeclined):
+		return c.JSON(http.StatusPaymentRequired, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrInvalidState):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	middleware.GetLogger(c).Error("payment gateway failed", zap.Error(err))
+	return c.JSON(http.StatusBadGateway, map[string]string{"error": "Payment gateway is unavailable"})
+}
+
+func lookupError(c echo.Context, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Payment not found"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+// Create authorizes the payment of an order. An order whose earlier attempt
+// failed may be paid again; each attempt has its own idempotency key, while
+// retrying after a gateway error reuses the key of the attempt.
+func (handler *handler) Create(c echo.Context) error {
+	request := PaymentRequest{}
+	customerID := middleware.GetCustomerID(c)
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&request); err != nil {
+		logger.Error("failed to bind payment", zap.Error(err))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	// The amount is the order total. Gift cards and store credit must have
+	// been applied to the same total, and only what they left is charged.
+	amount := request.Amount
+	if handler.tenders != nil {
+		tendered, err := handler.tenders(handler.db.WithContext(c.Request().Context()), request.OrderRef)
+		switch {
+		case errors.Is(err, ErrNoTender):
+		case err != nil:
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		case request.Amount != tendered.Amount || request.Currency != tendered.Currency:
+			return c.JSON(http.StatusUnprocessableEntity, map[string]string{
+				"error": fmt.Sprintf("amount must be the %d %s gift cards and store credit were applied to", tendered.Amount, tendered.Currency),
+			})
+		case tendered.Remaining == 0:
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Order is paid in full with gift cards and store credit"})
+		default:
+			amount = tendered.Remaining
+		}
+	}
+
+	payment := Payment{}
+	err := handler.db.WithContext(c.Request().Context()).Where("order_ref = ?", request.OrderRef).First(&payment).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		payment = Payment{OrderRef: request.OrderRef, CustomerID: customerID, Attempts: 1}
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	case payment.CustomerID != customerID || (payment.Status != StatusPending && payment.Status != StatusFailed):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order already has a payment"})
+	case payment.Status == StatusFailed || payment.Amount != amount || payment.Currency != request.Currency:
+		payment.Attempts++
+	}
+
+	payment.Amount, payment.Currency = amount, request.Currency
+	payment.Status, payment.FailureReason = StatusPending, ""
+	if err := handler.db.WithContext(c.Request().Context()).Save(&payment).Error; err != nil {
+		logger.Error("failed to save payment", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	charge, err := handler.gateway.Authorize(c.Request().Context(), AuthorizeRequest{
+		Amount:         payment.Amount,
+		Currency:       payment.Currency,
+		PaymentMethod:  request.PaymentMethod,
+		Reference:      payment.OrderRef,
+		IdempotencyKey: fmt.Sprintf("payment-%d-authorize-%d", payment.ID, payment.Attempts),
+	})
+	if errors.Is(err, ErrDeclined) {
+		payment.Status, payment.FailureReason = StatusFailed, err.Error()
+		if err := handler.db.WithContext(c.Request().Context()).Save(&payment).Error; err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+	if err != nil {
+		return gatewayError(c, err)
+	}
+
+	payment.ChargeID, payment.Status = charge.ID, StatusAuthorized
+	if err := handler.db.WithContext(c.Request().Context()).Save(&payment).Error; err != nil {
+		logger.Error("failed to save payment", zap.String("charge_id", charge.ID), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	logger.Info("payment authorized", zap.Uint("id", payment.ID), zap.String("order_ref", payment.OrderRef))
+	return c.JSON(http.StatusCreated, payment)
+}
+
+// GetById returns a payment of the customer making the request.
+func (handler *handler) GetById(c echo.Context) error {
+	payment := Payment{}
+	err := handler.db.WithContext(c.Request().Context()).Where("customer_id = ?", middleware.GetCustomerID(c)).First(&payment, c.Param("id")).Error
+	if err != nil {
+		return lookupError(c, err)
+	}
+	return c.JSON(http.StatusOK, payment)
+}
+
+// FindOrder returns the payment of an order made by customerID. Reads of an
+// order's records use it so that customers only see their own orders. It
+// returns ErrPaymentNotFound for orders of other customers.
+func FindOrder(db *gorm.DB, orderRef string, customerID string) (Payment, error) {
+	payment := Payment{}
+	err := db.Where("order_ref = ? AND customer_id = ?", orderRef, customerID).First(&payment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return payment, ErrPaymentNotFound
+	}
+	return payment, err
+}
+
+// lockPayment reads a payment and locks it until tx ends. Captures, refunds,
+// voids and webhooks of a payment all hold the lock, so they happen one
+// after the other and none of their changes are lost.
+func lockPayment(tx *gorm.DB, payment *Payment, conds ...interface{}) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(payment, conds...).Error
+}
+
+// Gateway calls that change a payment.
+const (
+	operationCapture = "capture"
+	operationRefund  = "refund"
+	operationVoid    = "void"
+)
+
+// pendingCalls maps webhook events to the call they answer.
+var pendingCalls = map[string]string{
+	EventCaptured: operationCapture,
+	EventRefunded: operationRefund,
+	EventVoided:   operationVoid,
+}
+
+// operation is a gateway call on a payment with the amount and idempotency
+// key it is made with.
+type operation struct {
+	name   string
+	amount int64
+	key    string
+}
+
+// begin locks the payment found by conds and records on it the operation
+// that plan returns, then commits so that no lock is held while the
+// gateway is called. An operation with no name leaves the payment as it
+// is. Only the same operation with the same key may start again while one
+// is in progress, which resumes it after a failure.
+func (handler *handler) begin(ctx context.Context, payment *Payment, plan func(*Payment) (operation, error), conds ...interface{}) (operation, error) {
+	op := operation{}
+	err := handler.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockPayment(tx, payment, conds...); err != nil {
+			return err
+		}
+		var err error
+		if op, err = plan(payment); err != nil || op.name == "" {
+			return err
+		}
+		if payment.Pending != "" && (payment.Pending != op.name || payment.PendingKey != op.key || payment.PendingAmount != op.amount) {
+			return ErrInProgress
+		}
+		payment.Pending, payment.PendingKey, payment.PendingAmount = op.name, op.key, op.amount
+		return tx.Save(payment).Error
+	})
+	return op, err
+}
+
+// finish locks the payment again once the gateway has answered op, clears
+// op unless another operation replaced it, and lets record apply the
+// answer.
+func (handler *handler) finish(ctx context.Context, payment *Payment, op operation, record func(*Payment)) error {
+	id := payment.ID
+	return handler.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		*payment = Payment{}
+		if err := lockPayment(tx, payment, id); err != nil {
+			return err
+		}
+		if payment.PendingKey == op.key {
+			payment.clearPending()
+		}
+		if record != nil {
+			record(payment)
+		}
+		return tx.Save(payment).Error
+	})
+}
+
+// fail ends op after the gateway returned err. An operation the gateway
+// refused is cleared. One whose outcome is unknown, such as after a
+// timeout, stays in progress so that it can only be retried with its key.
+func (handler *handler) fail(ctx context.Context, payment *Payment, op operation, err error) error {
+	if errors.Is(err, ErrDeclined) || errors.Is(err, ErrInvalidState) || errors.Is(err, ErrChargeNotFound) {
+		if err := handler.finish(ctx, payment, op, nil); err != nil {
+			return err
+		}
+	}
+	return gatewayFailure{err}
+}
+
+func (payment *Payment) clearPending() {
+	payment.Pending, payment.PendingKey, payment.PendingAmount = "", "", 0
+}
+
+// capture makes the capture begun as op and records it once the gateway
+// confirms it. It reports whether the gateway confirmed the capture.
+func (handler *handler) capture(ctx context.Context, payment *Payment, op operation) (bool, error) {
+	charge, err := handler.gateway.Capture(ctx, payment.ChargeID, op.amount, op.key)
+	if err != nil {
+		return false, handler.fail(ctx, payment, op, err)
+	}
+	confirmed := charge.Status == ChargeCaptured
+	captured := false
+	err = handler.finish(ctx, payment, op, func(payment *Payment) {
+		if confirmed {
+			captured = payment.apply(Event{Type: EventCaptured, Amount: charge.Captured})
+		}
+	})
+	if err == nil && captured {
+		handler.paid(ctx, *payment)
+	}
+	return confirmed, err
+}
+
+// paid tells the paid handler, if any, that the payment was captured.
+func (handler *handler) paid(ctx context.Context, payment Payment) {
+	if handler.onPaid != nil {
+		handler.onPaid(tenant.NewContext(ctx, payment.TenantID), payment)
+	}
+}
+
+// Capture takes an authorized payment, in full unless an amount is given.
+// When the gateway has not confirmed the capture yet the payment stays
+// authorized and 202 is returned; a webhook completes it later.
+func (handler *handler) Capture(c echo.Context) error {
+	request := AmountRequest{}
+	payment := Payment{}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&request); err != nil || request.Amount < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "amount must be a positive number"})
+	}
+
+	op, err := handler.begin(c.Request().Context(), &payment, func(payment *Payment) (operation, error) {
+		if payment.Status != StatusAuthorized {
+			return operation{}, ErrNotAuthorized
+		}
+		if request.Amount > payment.Amount {
+			return operation{}, errCaptureTooLarge
+		}
+		return operation{name: operationCapture, amount: request.Amount, key: fmt.Sprintf("payment-%d-capture", payment.ID)}, nil
+	}, c.Param("id"))
+	confirmed := false
+	if err == nil {
+		confirmed, err = handler.capture(c.Request().Context(), &payment, op)
+	}
+	switch {
+	case errors.Is(err, ErrNotAuthorized):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Only authorized payments can be captured"})
+	case errors.Is(err, errCaptureTooLarge):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "amount must not exceed the authorized amount"})
+	case err != nil:
+		return paymentError(c, err)
+	case !confirmed:
+		return c.JSON(http.StatusAccepted, payment)
+	}
+
+	logger.Info("payment captured", zap.Uint("id", payment.ID), zap.Int64("amount", payment.Captured))
+	return c.JSON(http.StatusOK, payment)
+}
+
+// CaptureOrder captures the authorized payment of an order in full. It lets
+// jobs, such as releasing preorders, take payment without a request. A
+// payment that is already paid is returned as it is, and a capture the
+// gateway has not confirmed yet is left for the webhook to complete.
+func (handler *handler) CaptureOrder(ctx context.Context, orderRef string) (Payment, error) {
+	payment := Payment{}
+	op, err := handler.begin(ctx, &payment, func(payment *Payment) (operation, error) {
+		switch payment.Status {
+		case StatusPaid:
+			return operation{}, nil
+		case StatusAuthorized:
+			return operation{name: operationCapture, key: fmt.Sprintf("payment-%d-capture", payment.ID)}, nil
+		}
+		return operation{}, ErrNotAuthorized
+	}, "order_ref = ?", orderRef)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return payment, ErrPaymentNotFound
+	}
+	if err != nil || op.name == "" {
+		return payment, err
+	}
+	_, err = handler.capture(ctx, &payment, op)
+	return payment, err
+}
+
+// Refund gives back part of a paid payment, or all that is left of it when
+// no amount is given.
+func (handler *handler) Refund(c echo.Context) error {
+	request := AmountRequest{}
+	payment := Payment{}
+
+	if err := c.Bind(&request); err != nil || request.Amount < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "amount must be a positive number"})
+	}
+
+	if err := handler.refund(c, &payment, request.Amount, c.Param("id")); err != nil {
+		return refundError(c, err)
+	}
+	return c.JSON(http.StatusOK, payment)
+}
+
+// RefundOrder refunds amount of the paid payment of an order, or all that
+// is left of it when amount is 0. It lets other parts of the store, such as
+// returns, give money back.
+func (handler *handler) RefundOrder(c echo.Context, orderRef string, amount int64) (Payment, error) {
+	payment := Payment{}
+	err := handler.refund(c, &payment, amount, "order_ref = ?", orderRef)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return payment, ErrPaymentNotFound
+	}
+	return payment, err
+}
+
+// refund refunds the payment found by conds. As refunds of a payment are
+// made one at a time, the amount already refunded tells refunds apart in
+// their idempotency key: a refund retried after a failure reuses its key,
+// while the next refund always has a new one.
+func (handler *handler) refund(c echo.Context, payment *Payment, amount int64, conds ...interface{}) error {
+	logger := middleware.GetLogger(c)
+	ctx := c.Request().Context()
+
+	var refunded int64
+	op, err := handler.begin(ctx, payment, func(payment *Payment) (operation, error) {
+		if payment.Status != StatusPaid {
+			return operation{}, ErrNotPaid
+		}
+		remaining := payment.Captured - payment.Refunded
+		if amount == 0 {
+			amount = remaining
+		}
+		if amount > remaining {
+			return operation{}, ErrRefundTooLarge
+		}
+		refunded = payment.Refunded
+		return operation{name: operationRefund, amount: amount, key: fmt.Sprintf("payment-%d-refund-%d", payment.ID, payment.Refunded)}, nil
+	}, conds...)
+	if err != nil {
+		return err
+	}
+
+	refund, err := handler.gateway.Refund(ctx, payment.ChargeID, op.amount, op.key)
+	if err != nil {
+		return handler.fail(ctx, payment, op, err)
+	}
+	err = handler.finish(ctx, payment, op, func(payment *Payment) {
+		payment.apply(Event{Type: EventRefunded, Amount: refunded + refund.Amount})
+	})
+	if err != nil {
+		logger.Error("failed to save payment", zap.Uint("id", payment.ID), zap.Error(err))
+		return err
+	}
+
+	logger.Info("payment refunded", zap.Uint("id", payment.ID), zap.Int64("amount", refund.Amount))
+	return nil
+}
+
+// refundError maps the errors of refund and RefundOrder to a response.
+func refundError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrPaymentNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Payment not found"})
+	case errors.Is(err, ErrNotPaid):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Only paid payments can be refunded"})
+	case errors.Is(err, ErrRefundTooLarge):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "amount must not exceed the amount left to refund"})
+	}
+	return paymentError(c, err)
+}
+
+// paymentError maps a failed lookup, gateway call or save to a response.
+func paymentError(c echo.Context, err error) error {
+	var failure gatewayFailure
+	if errors.As(err, &failure) {
+		return gatewayError(c, failure.err)
+	}
+	if errors.Is(err, ErrInProgress) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Another change to the payment is in progress"})
+	}
+	return lookupError(c, err)
+}
+
+// void releases the authorized payment found by conds.
+func (handler *handler) void(ctx context.Context, payment *Payment, conds ...interface{}) error {
+	op, err := handler.begin(ctx, payment, func(payment *Payment) (operation, error) {
+		if payment.Status != StatusAuthorized {
+			return operation{}, ErrNotAuthorized
+		}
+		return operation{name: operationVoid, key: fmt.Sprintf("payment-%d-void", payment.ID)}, nil
+	}, conds...)
+	if err != nil {
+		return err
+	}
+	if _, err := handler.gateway.Void(ctx, payment.ChargeID, op.key); err != nil {
+		return handler.fail(ctx, payment, op, err)
+	}
+	return handler.finish(ctx, payment, op, func(payment *Payment) {
+		payment.apply(Event{Type: EventVoided})
+	})
+}
+
+// VoidOrder releases the authorized payment of an order, for when an order
+// is cancelled before it is captured.
+func (handler *handler) VoidOrder(ctx context.Context, orderRef string) (Payment, error) {
+	payment := Payment{}
+	err := handler.void(ctx, &payment, "order_ref = ?", orderRef)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return payment, ErrPaymentNotFound
+	}
+	return payment, err
+}
+
+// Void releases an authorized payment that will not be captured.
+func (handler *handler) Void(c echo.Context) error {
+	payment := Payment{}
+	logger := middleware.GetLogger(c)
+
+	err := handler.void(c.Request().Context(), &payment, c.Param("id"))
+	if errors.Is(err, ErrNotAuthorized) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Only authorized payments can be voided"})
+	}
+	if err != nil {
+		logger.Error("failed to void payment", zap.String("id", c.Param("id")), zap.Error(err))
+		return paymentError(c, err)
+	}
+
+	logger.Info("payment voided", zap.Uint("id", payment.ID))
+	return c.JSON(http.StatusOK, payment)
+}
+
+// apply moves the payment forward for an event and reports whether it
+// changed. Payments never move back, so replayed or out of order events
+// are harmless.
+func (payment *Payment) apply(event Event) bool {
+	open := payment.Status == StatusPending || payment.Status == StatusAuthorized
+
+	switch event.Type {
+	case EventCaptured:
+		if !open {
+			return false
+		}
+		payment.Status, payment.Captured = StatusPaid, event.Amount
+	case EventRefunded:
+		if payment.Status != StatusPaid || event.Amount <= payment.Refunded {
+			return false
+		}
+		payment.Refunded = min(event.Amount, payment.Captured)
+		if payment.Refunded == payment.Captured {
+			payment.Status = StatusRefunded
+		}
+	case EventVoided:
+		if !open {
+			return false
+		}
+		payment.Status = StatusVoided
+	case EventFailed:
+		if !open {
+			return false
+		}
+		payment.Status, payment.FailureReason = StatusFailed, "Payment failed"
+	default:
+		return false
+	}
+	// The event may answer a call whose answer was lost, such as after a
+	// timeout, which would otherwise stay pending.
+	if payment.Pending == pendingCalls[event.Type] || event.Type == EventFailed {
+		payment.clearPending()
+	}
+	return true
+}
@@ -0,0 +1,626 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	getPaymentByOrderQuery       = `SELECT * FROM "payments" WHERE order_ref = $1 ORDER BY "payments"."id" LIMIT $2`
+	getPaymentByIdQuery          = `SELECT * FROM "payments" WHERE "payments"."id" = $1 ORDER BY "payments"."id" LIMIT $2`
+	createPaymentQuery           = `INSERT INTO "payments" ("order_ref","customer_id","charge_id","amount","currency","captured","refunded","status","failure_reason","attempts","pending","pending_key","pending_amount","created_at","updated_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16) RETURNING "id"`
+	updatePaymentQuery           = `UPDATE "payments" SET "order_ref"=$1,"customer_id"=$2,"charge_id"=$3,"amount"=$4,"currency"=$5,"captured"=$6,"refunded"=$7,"status"=$8,"failure_reason"=$9,"attempts"=$10,"pending"=$11,"pending_key"=$12,"pending_amount"=$13,"created_at"=$14,"updated_at"=$15,"tenant_id"=$16 WHERE "id" = $17`
+	createWebhookQuery           = `INSERT INTO "payment_webhook_events" ("id","type","created_at") VALUES ($1,$2,$3) ON CONFLICT DO NOTHING`
+	lockPaymentQuery             = `SELECT * FROM "payments" WHERE charge_id = $1 ORDER BY "payments"."id" LIMIT $2 FOR UPDATE`
+	lockPaymentByIdQuery         = `SELECT * FROM "payments" WHERE "payments"."id" = $1 ORDER BY "payments"."id" LIMIT $2 FOR UPDATE`
+	lockPaymentByOrder           = `SELECT * FROM "payments" WHERE order_ref = $1 ORDER BY "payments"."id" LIMIT $2 FOR UPDATE`
+	getTenantPaymentByOrderQuery = `SELECT * FROM "payments" WHERE order_ref = $1 AND "payments"."tenant_id" = $2 ORDER BY "payments"."id" LIMIT $3`
+	updateTenantPaymentQuery     = `UPDATE "payments" SET "order_ref"=$1,"customer_id"=$2,"charge_id"=$3,"amount"=$4,"currency"=$5,"captured"=$6,"refunded"=$7,"status"=$8,"failure_reason"=$9,"attempts"=$10,"pending"=$11,"pending_key"=$12,"pending_amount"=$13,"created_at"=$14,"updated_at"=$15,"tenant_id"=$16 WHERE "payments"."tenant_id" = $17 AND "id" = $18`
+	getCustomerPaymentQuery      = `SELECT * FROM "payments" WHERE customer_id = $1 AND "payments"."id" = $2 ORDER BY "payments"."id" LIMIT $3`
+)
+
+var (
+	paymentColumns = []string{"id", "order_ref", "customer_id", "charge_id", "amount", "currency", "captured", "refunded", "status", "attempts"}
+	pendingColumns = append(paymentColumns, "pending", "pending_key", "pending_amount")
+)
+
+func TestCreatePayment(t *testing.T) {
+	t.Run("authorize payment given new order", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"order_ref": "order-1", "amount": 2500, "currency": "USD", "payment_method": "pm_card_visa"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getPaymentByOrderQuery).WithArgs("order-1", 1).
+			WillReturnRows(sqlmock.NewRows(paymentColumns))
+		mock.ExpectBegin()
+		mock.ExpectQuery(createPaymentQuery).
+			WithArgs("order-1", "customer-1", "", 2500, "USD", 0, 0, StatusPending, "", 1, "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(updatePaymentQuery).
+			WithArgs("order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, "", 1, "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "default", 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, NewFakeGateway(""))
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("authorize payment in the request's storefront given tenant plugin", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"order_ref": "order-1", "amount": 2500, "currency": "USD", "payment_method": "pm_card_visa"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		request = request.WithContext(tenant.NewContext(request.Context(), "th"))
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		assert.NoError(t, gormDB.Use(tenant.Plugin{}))
+
+		mock.ExpectQuery(getTenantPaymentByOrderQuery).
+			WithArgs("order-1", "th", 1).
+			WillReturnRows(sqlmock.NewRows(paymentColumns))
+		mock.ExpectBegin()
+		mock.ExpectQuery(createPaymentQuery).
+			WithArgs("order-1", "customer-1", "", 2500, "USD", 0, 0, StatusPending, "", 1, "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(updateTenantPaymentQuery).
+			WithArgs("order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, "", 1, "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "th", "th", 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, NewFakeGateway(""))
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("mark payment failed given declined card", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"order_ref": "order-1", "amount": 2500, "currency": "USD", "payment_method": "pm_card_declined"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getPaymentByOrderQuery).WithArgs("order-1", 1).
+			WillReturnRows(sqlmock.NewRows(paymentColumns))
+		mock.ExpectBegin()
+		mock.ExpectQuery(createPaymentQuery).
+			WithArgs("order-1", "customer-1", "", 2500, "USD", 0, 0, StatusPending, "", 1, "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(updatePaymentQuery).
+			WithArgs("order-1", "customer-1", "", 2500, "USD", 0, 0, StatusFailed, "payment declined: card declined", 1, "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "default", 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, NewFakeGateway(""))
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusPaymentRequired, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given order already authorized", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"order_ref": "order-1", "amount": 2500, "currency": "USD", "payment_method": "pm_card_visa"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getPaymentByOrderQuery).WithArgs("order-1", 1).
+			WillReturnRows(sqlmock.NewRows(paymentColumns).AddRow(1, "order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, 1))
+
+		handler := NewHandler(gormDB, NewFakeGateway(""))
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("charge what is left after tender given order total", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"order_ref": "order-1", "amount": 2500, "currency": "USD", "payment_method": "pm_card_visa"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getPaymentByOrderQuery).WithArgs("order-1", 1).
+			WillReturnRows(sqlmock.NewRows(paymentColumns))
+		mock.ExpectBegin()
+		mock.ExpectQuery(createPaymentQuery).
+			WithArgs("order-1", "customer-1", "", 1000, "USD", 0, 0, StatusPending, "", 1, "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(updatePaymentQuery).
+			WithArgs("order-1", "customer-1", "fake_ch_1", 1000, "USD", 0, 0, StatusAuthorized, "", 1, "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "default", 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		orders := []string{}
+		handler := NewHandler(gormDB, NewFakeGateway(""), WithTenders(func(db *gorm.DB, orderRef string) (Tendered, error) {
+			orders = append(orders, orderRef)
+			return Tendered{Amount: 2500, Remaining: 1000, Currency: "USD"}, nil
+		}))
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, []string{"order-1"}, orders)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return unprocessable entity given amount other than tendered total", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"order_ref": "order-1", "amount": 2500, "currency": "USD", "payment_method": "pm_card_visa"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		handler := NewHandler(gormDB, NewFakeGateway(""), WithTenders(func(db *gorm.DB, orderRef string) (Tendered, error) {
+			return Tendered{Amount: 1500, Remaining: 0, Currency: "USD"}, nil
+		}))
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+		assert.JSONEq(t, `{"error": "amount must be the 1500 USD gift cards and store credit were applied to"}`, response.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given order paid in full by tender", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"order_ref": "order-1", "amount": 2500, "currency": "USD", "payment_method": "pm_card_visa"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		handler := NewHandler(gormDB, NewFakeGateway(""), WithTenders(func(db *gorm.DB, orderRef string) (Tendered, error) {
+			return Tendered{Amount: 2500, Remaining: 0, Currency: "USD"}, nil
+		}))
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCapturePayment(t *testing.T) {
+	t.Run("mark payment paid given confirmed capture", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		gateway := NewFakeGateway("")
+		gateway.Authorize(context.Background(), AuthorizeRequest{Amount: 2500, Currency: "USD", PaymentMethod: "pm_card_visa"})
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPaymentByIdQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows(paymentColumns).AddRow(1, "order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, 1))
+		mock.ExpectExec(updatePaymentQuery).
+			WithArgs("order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, "", 1, "capture", "payment-1-capture", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "", 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPaymentByIdQuery).WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows(pendingColumns).AddRow(1, "order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, 1, "capture", "payment-1-capture", 0))
+		mock.ExpectExec(updatePaymentQuery).
+			WithArgs("order-1", "customer-1", "fake_ch_1", 2500, "USD", 2500, 0, StatusPaid, "", 1, "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "", 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, gateway)
+		err := handler.Capture(c)
+
+		payment := Payment{}
+		json.Unmarshal(response.Body.Bytes(), &payment)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, StatusPaid, payment.Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given void in progress", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPaymentByIdQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows(pendingColumns).AddRow(1, "order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, 1, "void", "payment-1-void", 0))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, NewFakeGateway(""))
+		err := handler.Capture(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("keep capture pending given gateway timeout", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPaymentByIdQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows(paymentColumns).AddRow(1, "order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, 1))
+		mock.ExpectExec(updatePaymentQuery).
+			WithArgs("order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, "", 1, "capture", "payment-1-capture", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "", 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, timeoutGateway{NewFakeGateway("")})
+		err := handler.Capture(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadGateway, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given voided payment", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPaymentByIdQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows(paymentColumns).AddRow(1, "order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusVoided, 1))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, NewFakeGateway(""))
+		err := handler.Capture(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetPayment(t *testing.T) {
+	t.Run("return not found given payment of another customer", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(``))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getCustomerPaymentQuery).WithArgs("customer-1", "1", 1).WillReturnRows(sqlmock.NewRows(paymentColumns))
+
+		handler := NewHandler(gormDB, NewFakeGateway(""))
+		err := middleware.RequireCustomer(handler.GetById)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRefundPayment(t *testing.T) {
+	t.Run("refund locked payment with a key for the amount already refunded", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 1000}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		gateway := &recordingGateway{PaymentGateway: NewFakeGateway("")}
+		charge, _ := gateway.Authorize(context.Background(), AuthorizeRequest{Amount: 2500, Currency: "USD", PaymentMethod: "pm_card_visa"})
+		gateway.Capture(context.Background(), charge.ID, 0, "capture")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPaymentByIdQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows(paymentColumns).AddRow(1, "order-1", "customer-1", charge.ID, 2500, "USD", 2500, 500, StatusPaid, 1))
+		mock.ExpectExec(updatePaymentQuery).
+			WithArgs("order-1", "customer-1", charge.ID, 2500, "USD", 2500, 500, StatusPaid, "", 1, "refund", "payment-1-refund-500", 1000, sqlmock.AnyArg(), sqlmock.AnyArg(), "", 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPaymentByIdQuery).WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows(pendingColumns).AddRow(1, "order-1", "customer-1", charge.ID, 2500, "USD", 2500, 500, StatusPaid, 1, "refund", "payment-1-refund-500", 1000))
+		mock.ExpectExec(updatePaymentQuery).
+			WithArgs("order-1", "customer-1", charge.ID, 2500, "USD", 2500, 1500, StatusPaid, "", 1, "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "", 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, gateway)
+		err := handler.Refund(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, []string{"payment-1-refund-500"}, gateway.refundKeys)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+type recordingGateway struct {
+	PaymentGateway
+	refundKeys []string
+}
+
+func (gateway *recordingGateway) Refund(ctx context.Context, chargeID string, amount int64, idempotencyKey string) (Refund, error) {
+	gateway.refundKeys = append(gateway.refundKeys, idempotencyKey)
+	return gateway.PaymentGateway.Refund(ctx, chargeID, amount, idempotencyKey)
+}
+
+type timeoutGateway struct {
+	PaymentGateway
+}
+
+func (timeoutGateway) Capture(ctx context.Context, chargeID string, amount int64, idempotencyKey string) (Charge, error) {
+	return Charge{}, context.DeadlineExceeded
+}
+
+func TestCaptureOrder(t *testing.T) {
+	t.Run("capture in full given authorized order", func(t *testing.T) {
+		gateway := NewFakeGateway("")
+		gateway.Authorize(context.Background(), AuthorizeRequest{Amount: 2500, Currency: "USD", PaymentMethod: "pm_card_visa"})
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPaymentByOrder).WithArgs("order-1", 1).
+			WillReturnRows(sqlmock.NewRows(paymentColumns).AddRow(1, "order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, 1))
+		mock.ExpectExec(updatePaymentQuery).
+			WithArgs("order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, "", 1, "capture", "payment-1-capture", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "", 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPaymentByIdQuery).WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows(pendingColumns).AddRow(1, "order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, 1, "capture", "payment-1-capture", 0))
+		mock.ExpectExec(updatePaymentQuery).
+			WithArgs("order-1", "customer-1", "fake_ch_1", 2500, "USD", 2500, 0, StatusPaid, "", 1, "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "", 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		paid := []string{}
+		handler := NewHandler(gormDB, gateway, WithPaidHandler(func(ctx context.Context, payment Payment) {
+			paid = append(paid, payment.OrderRef)
+		}))
+		payment, err := handler.CaptureOrder(context.Background(), "order-1")
+
+		assert.NoError(t, err)
+		assert.Equal(t, StatusPaid, payment.Status)
+		assert.Equal(t, int64(2500), payment.Captured)
+		assert.Equal(t, []string{"order-1"}, paid)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return not authorized given failed payment", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPaymentByOrder).WithArgs("order-1", 1).
+			WillReturnRows(sqlmock.NewRows(paymentColumns).AddRow(1, "order-1", "customer-1", "", 2500, "USD", 0, 0, StatusFailed, 1))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, NewFakeGateway(""))
+		_, err := handler.CaptureOrder(context.Background(), "order-1")
+
+		assert.ErrorIs(t, err, ErrNotAuthorized)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestWebhook(t *testing.T) {
+	newWebhookContext := func(e *echo.Echo, payload string, signature string) (echo.Context, *httptest.ResponseRecorder) {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(payload))
+		request.Header.Set(FakeSignatureHeader, signature)
+		response := httptest.NewRecorder()
+		return e.NewContext(request, response), response
+	}
+	payload := `{"id": "evt_1", "type": "payment.captured", "charge_id": "fake_ch_1", "amount": 2500}`
+
+	t.Run("mark payment paid given captured event", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newWebhookContext(e, payload, Sign("secret", []byte(payload), time.Now()))
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectExec(createWebhookQuery).WithArgs("evt_1", EventCaptured, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(lockPaymentQuery).WithArgs("fake_ch_1", 1).
+			WillReturnRows(sqlmock.NewRows(paymentColumns).AddRow(1, "order-1", "customer-1", "fake_ch_1", 2500, "USD", 0, 0, StatusAuthorized, 1))
+		mock.ExpectExec(updatePaymentQuery).
+			WithArgs("order-1", "customer-1", "fake_ch_1", 2500, "USD", 2500, 0, StatusPaid, "", 1, "", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "", 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		paid := []string{}
+		handler := NewHandler(gormDB, NewFakeGateway("secret"), WithPaidHandler(func(ctx context.Context, payment Payment) {
+			paid = append(paid, payment.OrderRef)
+		}))
+		err := handler.Webhook(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, []string{"order-1"}, paid)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("skip event given event already processed", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newWebhookContext(e, payload, Sign("secret", []byte(payload), time.Now()))
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectExec(createWebhookQuery).WithArgs("evt_1", EventCaptured, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, NewFakeGateway("secret"))
+		err := handler.Webhook(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), "Event already processed")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given invalid signature", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newWebhookContext(e, payload, Sign("wrong", []byte(payload), time.Now()))
+
+		handler := NewHandler(nil, NewFakeGateway("secret"))
+		err := handler.Webhook(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
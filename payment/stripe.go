@@ -0,0 +1,207 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeSignatureHeader carries the signature of Stripe webhooks.
+const StripeSignatureHeader = "Stripe-Signature"
+
+type StripeConfig struct {
+	BaseURL       string
+	SecretKey     string
+	WebhookSecret string
+}
+
+// StripeGateway talks to the Stripe Payment Intents API, or any service
+// with the same API. Charges are payment intents confirmed with manual
+// capture, so authorizing and capturing are separate steps.
+type StripeGateway struct {
+	config StripeConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewStripeGateway(config StripeConfig, client *http.Client) *StripeGateway {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &StripeGateway{config: config, client: client, now: time.Now}
+}
+
+type stripePaymentIntent struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Amount         int64  `json:"amount"`
+	AmountReceived int64  `json:"amount_received"`
+	Currency       string `json:"currency"`
+}
+
+func (intent stripePaymentIntent) charge() Charge {
+	status := ChargePending
+	switch intent.Status {
+	case "requires_capture":
+		status = ChargeAuthorized
+	case "succeeded":
+		status = ChargeCaptured
+	case "canceled":
+		status = ChargeVoided
+	case "requires_payment_method":
+		status = ChargeFailed
+	}
+	return Charge{
+		ID:       intent.ID,
+		Status:   status,
+		Amount:   intent.Amount,
+		Captured: intent.AmountReceived,
+		Currency: strings.ToUpper(intent.Currency),
+	}
+}
+
+type stripeError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (g *StripeGateway) Authorize(ctx context.Context, request AuthorizeRequest) (Charge, error) {
+	form := url.Values{
+		"amount":              {strconv.FormatInt(request.Amount, 10)},
+		"currency":            {strings.ToLower(request.Currency)},
+		"payment_method":      {request.PaymentMethod},
+		"capture_method":      {"manual"},
+		"confirm":             {"true"},
+		"metadata[reference]": {request.Reference},
+	}
+	intent := stripePaymentIntent{}
+	if err := g.post(ctx, "/v1/payment_intents", form, request.IdempotencyKey, &intent); err != nil {
+		return Charge{}, err
+	}
+	return intent.charge(), nil
+}
+
+func (g *StripeGateway) Capture(ctx context.Context, chargeID string, amount int64, idempotencyKey string) (Charge, error) {
+	form := url.Values{}
+	if amount > 0 {
+		form.Set("amount_to_capture", strconv.FormatInt(amount, 10))
+	}
+	intent := stripePaymentIntent{}
+	if err := g.post(ctx, "/v1/payment_intents/"+url.PathEscape(chargeID)+"/capture", form, idempotencyKey, &intent); err != nil {
+		return Charge{}, err
+	}
+	return intent.charge(), nil
+}
+
+func (g *StripeGateway) Refund(ctx context.Context, chargeID string, amount int64, idempotencyKey string) (Refund, error) {
+	form := url.Values{
+		"payment_intent": {chargeID},
+		"amount":         {strconv.FormatInt(amount, 10)},
+	}
+	refund := Refund{}
+	if err := g.post(ctx, "/v1/refunds", form, idempotencyKey, &refund); err != nil {
+		return Refund{}, err
+	}
+	return refund, nil
+}
+
+func (g *StripeGateway) Void(ctx context.Context, chargeID string, idempotencyKey string) (Charge, error) {
+	intent := stripePaymentIntent{}
+	if err := g.post(ctx, "/v1/payment_intents/"+url.PathEscape(chargeID)+"/cancel", url.Values{}, idempotencyKey, &intent); err != nil {
+		return Charge{}, err
+	}
+	return intent.charge(), nil
+}
+
+func (g *StripeGateway) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, g.config.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+g.config.SecretKey)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		request.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	response, err := g.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 300 {
+		return stripeFailure(response.StatusCode, body)
+	}
+	return json.Unmarshal(body, out)
+}
+
+func stripeFailure(status int, body []byte) error {
+	failure := stripeError{}
+	json.Unmarshal(body, &failure)
+
+	switch {
+	case failure.Error.Type == "card_error":
+		return fmt.Errorf("%w: %s", ErrDeclined, failure.Error.Message)
+	case failure.Error.Code == "payment_intent_unexpected_state":
+		return fmt.Errorf("%w: %s", ErrInvalidState, failure.Error.Message)
+	case failure.Error.Code == "resource_missing":
+		return ErrChargeNotFound
+	}
+	return fmt.Errorf("stripe returned status %d: %s", status, failure.Error.Message)
+}
+
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID             string `json:"id"`
+			PaymentIntent  string `json:"payment_intent"`
+			AmountReceived int64  `json:"amount_received"`
+			AmountRefunded int64  `json:"amount_refunded"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// ParseWebhook verifies the Stripe-Signature header and maps the payment
+// intent and charge events this store handles. Other event types are
+// returned with an empty Type.
+func (g *StripeGateway) ParseWebhook(payload []byte, header http.Header) (Event, error) {
+	if err := verifySignature(g.config.WebhookSecret, payload, header.Get(StripeSignatureHeader), g.now()); err != nil {
+		return Event{}, err
+	}
+
+	received := stripeEvent{}
+	if err := json.Unmarshal(payload, &received); err != nil {
+		return Event{}, err
+	}
+
+	object := received.Data.Object
+	event := Event{ID: received.ID, ChargeID: object.ID}
+	switch received.Type {
+	case "payment_intent.succeeded":
+		event.Type, event.Amount = EventCaptured, object.AmountReceived
+	case "payment_intent.canceled":
+		event.Type = EventVoided
+	case "payment_intent.payment_failed":
+		event.Type = EventFailed
+	case "charge.refunded":
+		event.Type, event.ChargeID, event.Amount = EventRefunded, object.PaymentIntent, object.AmountRefunded
+	}
+	return event, nil
+}
@@ -0,0 +1,138 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripeGateway(t *testing.T) {
+	requests := []*http.Request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests = append(requests, r)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/payment_intents":
+			if r.PostForm.Get("payment_method") == "pm_card_visa_chargeDeclined" {
+				w.WriteHeader(http.StatusPaymentRequired)
+				w.Write([]byte(`{"error": {"type": "card_error", "code": "card_declined", "message": "Your card was declined."}}`))
+				return
+			}
+			w.Write([]byte(`{"id": "pi_1", "status": "requires_capture", "amount": 2500, "amount_received": 0, "currency": "usd"}`))
+		case "/v1/payment_intents/pi_1/capture":
+			w.Write([]byte(`{"id": "pi_1", "status": "succeeded", "amount": 2500, "amount_received": 2000, "currency": "usd"}`))
+		case "/v1/payment_intents/pi_2/cancel":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"type": "invalid_request_error", "code": "payment_intent_unexpected_state", "message": "already captured"}}`))
+		case "/v1/refunds":
+			w.Write([]byte(`{"id": "re_1", "amount": 500}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"type": "invalid_request_error", "code": "resource_missing"}}`))
+		}
+	}))
+	defer server.Close()
+
+	gateway := NewStripeGateway(StripeConfig{BaseURL: server.URL, SecretKey: "sk_test"}, nil)
+	ctx := context.Background()
+
+	t.Run("authorize with manual capture given valid card", func(t *testing.T) {
+		charge, err := gateway.Authorize(ctx, AuthorizeRequest{Amount: 2500, Currency: "USD", PaymentMethod: "pm_card_visa", Reference: "order-1", IdempotencyKey: "payment-1-authorize-1"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, Charge{ID: "pi_1", Status: ChargeAuthorized, Amount: 2500, Currency: "USD"}, charge)
+
+		request := requests[len(requests)-1]
+		assert.Equal(t, "Bearer sk_test", request.Header.Get("Authorization"))
+		assert.Equal(t, "payment-1-authorize-1", request.Header.Get("Idempotency-Key"))
+		assert.Equal(t, "manual", request.PostForm.Get("capture_method"))
+		assert.Equal(t, "usd", request.PostForm.Get("currency"))
+		assert.Equal(t, "order-1", request.PostForm.Get("metadata[reference]"))
+	})
+
+	t.Run("return declined given card error", func(t *testing.T) {
+		_, err := gateway.Authorize(ctx, AuthorizeRequest{Amount: 2500, Currency: "USD", PaymentMethod: "pm_card_visa_chargeDeclined"})
+
+		assert.ErrorIs(t, err, ErrDeclined)
+	})
+
+	t.Run("capture part of the amount given amount", func(t *testing.T) {
+		charge, err := gateway.Capture(ctx, "pi_1", 2000, "payment-1-capture")
+
+		assert.NoError(t, err)
+		assert.Equal(t, ChargeCaptured, charge.Status)
+		assert.Equal(t, int64(2000), charge.Captured)
+		assert.Equal(t, "2000", requests[len(requests)-1].PostForm.Get("amount_to_capture"))
+	})
+
+	t.Run("refund payment intent given amount", func(t *testing.T) {
+		refund, err := gateway.Refund(ctx, "pi_1", 500, "payment-1-refund-0")
+
+		assert.NoError(t, err)
+		assert.Equal(t, Refund{ID: "re_1", Amount: 500}, refund)
+		assert.Equal(t, "pi_1", requests[len(requests)-1].PostForm.Get("payment_intent"))
+	})
+
+	t.Run("return invalid state given unexpected state error", func(t *testing.T) {
+		_, err := gateway.Void(ctx, "pi_2", "payment-2-void")
+
+		assert.ErrorIs(t, err, ErrInvalidState)
+	})
+
+	t.Run("return not found given missing charge", func(t *testing.T) {
+		_, err := gateway.Void(ctx, "pi_9", "payment-9-void")
+
+		assert.ErrorIs(t, err, ErrChargeNotFound)
+	})
+}
+
+func TestStripeParseWebhook(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	gateway := NewStripeGateway(StripeConfig{WebhookSecret: "whsec_test"}, nil)
+	gateway.now = func() time.Time { return now }
+
+	t.Run("map refund event to its payment intent given valid signature", func(t *testing.T) {
+		payload := []byte(`{"id": "evt_1", "type": "charge.refunded", "data": {"object": {"id": "ch_1", "payment_intent": "pi_1", "amount_refunded": 500}}}`)
+		header := http.Header{StripeSignatureHeader: {Sign("whsec_test", payload, now)}}
+
+		event, err := gateway.ParseWebhook(payload, header)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Event{ID: "evt_1", Type: EventRefunded, ChargeID: "pi_1", Amount: 500}, event)
+	})
+
+	t.Run("return invalid signature given tampered payload", func(t *testing.T) {
+		payload := []byte(`{"id": "evt_1", "type": "payment_intent.succeeded", "data": {"object": {"id": "pi_1", "amount_received": 2500}}}`)
+		header := http.Header{StripeSignatureHeader: {Sign("whsec_test", payload, now)}}
+
+		_, err := gateway.ParseWebhook([]byte(`{"id": "evt_1", "type": "payment_intent.succeeded", "data": {"object": {"id": "pi_1", "amount_received": 1}}}`), header)
+
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	t.Run("return invalid signature given old timestamp", func(t *testing.T) {
+		payload := []byte(`{"id": "evt_1"}`)
+		header := http.Header{StripeSignatureHeader: {Sign("whsec_test", payload, now.Add(-10*time.Minute))}}
+
+		_, err := gateway.ParseWebhook(payload, header)
+
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	t.Run("accept any matching signature given rotated secrets", func(t *testing.T) {
+		payload := []byte(`{"id": "evt_2", "type": "payment_intent.canceled", "data": {"object": {"id": "pi_1"}}}`)
+		old := Sign("whsec_old", payload, now)
+		current := Sign("whsec_test", payload, now)
+		header := http.Header{StripeSignatureHeader: {old + ",v1=" + current[len("t=1700000000,v1="):]}}
+
+		event, err := gateway.ParseWebhook(payload, header)
+
+		assert.NoError(t, err)
+		assert.Equal(t, EventVoided, event.Type)
+	})
+}
@@ -0,0 +1,99 @@
+package payment
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/tenant"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxWebhookSize = 1 << 20
+
+// WebhookEvent records a processed webhook so that deliveries the gateway
+// repeats are only applied once.
+type WebhookEvent struct {
+	ID        string `gorm:"primaryKey"`
+	Type      string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+func (WebhookEvent) TableName() string {
+	return "payment_webhook_events"
+}
+
+// Webhook applies a signed gateway event to the payment of its charge. The
+// event is recorded in the same transaction as the payment change, so an
+// event is applied exactly once even when delivered concurrently.
+func (handler *handler) Webhook(c echo.Context) error {
+	logger := middleware.GetLogger(c)
+
+	payload, err := io.ReadAll(io.LimitReader(c.Request().Body, maxWebhookSize))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	event, err := handler.gateway.ParseWebhook(payload, c.Request().Header)
+	if err != nil {
+		logger.Warn("rejected payment webhook", zap.Error(err))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if event.ID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Event has no id"})
+	}
+
+	// Webhooks come from the gateway for every storefront, and the charge
+	// identifies the payment.
+	duplicate := false
+	var paid *Payment
+	err = handler.db.WithContext(tenant.Unscoped(c.Request().Context())).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&WebhookEvent{ID: event.ID, Type: event.Type})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			duplicate = true
+			return nil
+		}
+		if event.Type == "" || event.ChargeID == "" {
+			return nil
+		}
+
+		payment := Payment{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("charge_id = ?", event.ChargeID).First(&payment).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Warn("payment webhook for unknown charge", zap.String("event_id", event.ID), zap.String("charge_id", event.ChargeID))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		wasPaid := payment.Status == StatusPaid
+		if !payment.apply(event) {
+			return nil
+		}
+		if !wasPaid && payment.Status == StatusPaid {
+			paid = &payment
+		}
+		return tx.Save(&payment).Error
+	})
+	if err != nil {
+		logger.Error("failed to process payment webhook", zap.String("event_id", event.ID), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to process event"})
+	}
+
+	if duplicate {
+		return c.JSON(http.StatusOK, map[string]string{"message": "Event already processed"})
+	}
+	if paid != nil {
+		handler.paid(c.Request().Context(), *paid)
+	}
+	logger.Info("payment webhook processed", zap.String("event_id", event.ID), zap.String("type", event.Type))
+	return c.JSON(http.StatusOK, map[string]string{"message": "Event processed"})
+}
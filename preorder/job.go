@@ -0,0 +1,174 @@
+package preorder
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/payment"
+	"github.com/phetployst/book-store-api/tenant"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CaptureFunc captures the payment of an order, such as
+// payment's CaptureOrder.
+type CaptureFunc func(ctx context.Context, orderRef string) (payment.Payment, error)
+
+// Job releases forthcoming books on their publication date and fulfils
+// their preorders.
+type Job struct {
+	db       *gorm.DB
+	logger   *zap.Logger
+	interval time.Duration
+	capture  CaptureFunc
+	now      func() time.Time
+}
+
+func NewJob(db *gorm.DB, logger *zap.Logger, interval time.Duration, capture CaptureFunc) *Job {
+	return &Job{db: db, logger: logger, interval: interval, capture: capture, now: time.Now}
+}
+
+// Run releases books straight away and then every interval until ctx is
+// done.
+func (job *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+	for {
+		if result, err := job.Release(ctx); err != nil {
+			job.logger.Error("failed to release preorders", zap.Error(err))
+		} else if result != (Result{}) {
+			job.logger.Info("released preorders",
+				zap.Int("books", result.Books),
+				zap.Int("allocated", result.Allocated),
+				zap.Int("fulfilled", result.Fulfilled),
+				zap.Int("failed", result.Failed))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Result counts what a release did.
+type Result struct {
+	Books     int
+	Allocated int
+	Fulfilled int
+	Failed    int
+}
+
+var errOutOfStock = errors.New("not enough stock")
+
+// Release makes the forthcoming books whose publication date has come
+// available, then allocates stock to their preorders, oldest first, and
+// captures the payment of each allocated order. Preorders that do not get
+// stock wait for the next release after the book is restocked; they are
+// never passed by later preorders of the same book. A capture that fails
+// because of the gateway is retried on the next release, while a declined
+// one, or an order without an authorized payment, gives the stock back and
+// fails the preorder.
+func (job *Job) Release(ctx context.Context) (Result, error) {
+	result := Result{}
+	db := job.db.WithContext(ctx)
+	now := job.now()
+
+	released := db.Model(&book.Book{}).
+		Where("status = ? AND publication_date <= ?", book.StatusForthcoming, now).
+		Update("status", book.StatusAvailable)
+	if released.Error != nil {
+		return result, released.Error
+	}
+	result.Books = int(released.RowsAffected)
+
+	pending := []Preorder{}
+	err := db.Joins("JOIN books ON books.id = preorders.book_id").
+		Where("preorders.status = ? AND books.status IN ? AND books.publication_date <= ?", StatusPending, []string{"", book.StatusAvailable}, now).
+		Order("preorders.id").
+		Find(&pending).Error
+	if err != nil {
+		return result, err
+	}
+	short := map[uint]bool{}
+	for _, preorder := range pending {
+		if short[preorder.BookID] {
+			continue
+		}
+		err := job.allocate(db, preorder.ID)
+		switch {
+		case errors.Is(err, errOutOfStock):
+			short[preorder.BookID] = true
+		case errors.Is(err, errNotPending):
+			// Cancelled since it was listed.
+		case err != nil:
+			return result, err
+		default:
+			result.Allocated++
+		}
+	}
+
+	allocated := []Preorder{}
+	if err := db.Where("status = ?", StatusAllocated).Order("id").Find(&allocated).Error; err != nil {
+		return result, err
+	}
+	for _, preorder := range allocated {
+		// Order references are only unique within a tenant.
+		captured, err := job.capture(tenant.NewContext(ctx, preorder.TenantID), preorder.OrderRef)
+		switch {
+		case err == nil && captured.Status == payment.StatusPaid:
+			err = db.Model(&preorder).Updates(map[string]interface{}{"status": StatusFulfilled, "fulfilled_at": now}).Error
+			if err != nil {
+				return result, err
+			}
+			result.Fulfilled++
+		case err == nil:
+			// The gateway has not confirmed the capture yet.
+		case errors.Is(err, payment.ErrPaymentNotFound), errors.Is(err, payment.ErrNotAuthorized), errors.Is(err, payment.ErrDeclined), errors.Is(err, payment.ErrInvalidState):
+			if err := job.fail(db, preorder, err.Error()); err != nil {
+				return result, err
+			}
+			result.Failed++
+		default:
+			job.logger.Error("failed to capture preorder", zap.Uint("id", preorder.ID), zap.String("order_ref", preorder.OrderRef), zap.Error(err))
+		}
+	}
+	return result, nil
+}
+
+// allocate takes the stock of a pending preorder. It returns errOutOfStock
+// when there is not enough, leaving the stock as it was.
+func (job *Job) allocate(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		preorder := Preorder{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&preorder, id).Error; err != nil {
+			return err
+		}
+		if preorder.Status != StatusPending {
+			return errNotPending
+		}
+		moved, _, err := book.MoveStock(tx, preorder.BookID, -preorder.Quantity, book.MovementPreorder, preorder.OrderRef)
+		if err != nil {
+			return err
+		}
+		if moved.Stock < 0 {
+			return errOutOfStock
+		}
+		return tx.Model(&preorder).Update("status", StatusAllocated).Error
+	})
+}
+
+// fail gives back the stock of an allocated preorder whose payment could not
+// be captured.
+func (job *Job) fail(db *gorm.DB, preorder Preorder, reason string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if _, _, err := book.MoveStock(tx, preorder.BookID, preorder.Quantity, book.MovementPreorder, preorder.OrderRef); err != nil {
+			return err
+		}
+		return tx.Model(&preorder).Updates(map[string]interface{}{"status": StatusFailed, "failure_reason": reason}).Error
+	})
+}
@@ -0,0 +1,197 @@
+package preorder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/payment"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	releaseBooksQuery   = `UPDATE "books" SET "status"=$1,"updated_at"=$2 WHERE (status = $3 AND publication_date <= $4) AND "books"."deleted_at" IS NULL`
+	pendingQuery        = `SELECT "preorders"."id","preorders"."book_id","preorders"."order_ref","preorders"."customer_id","preorders"."quantity","preorders"."status","preorders"."failure_reason","preorders"."fulfilled_at","preorders"."created_at","preorders"."updated_at","preorders"."tenant_id" FROM "preorders" JOIN books ON books.id = preorders.book_id WHERE preorders.status = $1 AND books.status IN ($2,$3) AND books.publication_date <= $4 ORDER BY preorders.id`
+	lockByIDQuery       = `SELECT * FROM "preorders" WHERE "preorders"."id" = $1 ORDER BY "preorders"."id" LIMIT $2 FOR UPDATE`
+	lockBookQuery       = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2 FOR UPDATE`
+	updateStockQuery    = `UPDATE "books" SET "stock"=$1,"updated_at"=$2 WHERE "books"."deleted_at" IS NULL AND "id" = $3`
+	createMovementQuery = `INSERT INTO "stock_movements" ("book_id","quantity","reason","reference","created_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`
+	allocateQuery       = `UPDATE "preorders" SET "status"=$1,"updated_at"=$2 WHERE "id" = $3`
+	allocatedQuery      = `SELECT * FROM "preorders" WHERE status = $1 ORDER BY id`
+	fulfilPreorderQuery = `UPDATE "preorders" SET "fulfilled_at"=$1,"status"=$2,"updated_at"=$3 WHERE "id" = $4`
+	failPreorderQuery   = `UPDATE "preorders" SET "failure_reason"=$1,"status"=$2,"updated_at"=$3 WHERE "id" = $4`
+)
+
+var now = time.Date(2024, 9, 10, 6, 0, 0, 0, time.UTC)
+
+func preorderRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "book_id", "order_ref", "customer_id", "quantity", "status"})
+}
+
+func expectMoveStock(mock sqlmock.Sqlmock, bookID uint, stock int, quantity int, reference string) {
+	mock.ExpectQuery(lockBookQuery).WithArgs(bookID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "stock"}).AddRow(bookID, "Nexus", stock))
+	mock.ExpectExec(updateStockQuery).WithArgs(stock+quantity, sqlmock.AnyArg(), bookID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(createMovementQuery).WithArgs(bookID, quantity, book.MovementPreorder, reference, sqlmock.AnyArg(), "default").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+func TestRelease(t *testing.T) {
+	t.Run("allocate stock in order and capture payment given release day", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectExec(releaseBooksQuery).WithArgs(book.StatusAvailable, sqlmock.AnyArg(), book.StatusForthcoming, now).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		mock.ExpectQuery(pendingQuery).WithArgs(StatusPending, "", book.StatusAvailable, now).
+			WillReturnRows(preorderRows().
+				AddRow(4, 1, "ORD-1", "c-1", 1, StatusPending).
+				AddRow(5, 2, "ORD-2", "c-2", 3, StatusPending).
+				AddRow(6, 2, "ORD-3", "c-3", 1, StatusPending))
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockByIDQuery).WithArgs(4, 1).WillReturnRows(preorderRows().AddRow(4, 1, "ORD-1", "c-1", 1, StatusPending))
+		expectMoveStock(mock, 1, 5, -1, "ORD-1")
+		mock.ExpectExec(allocateQuery).WithArgs(StatusAllocated, sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockByIDQuery).WithArgs(5, 1).WillReturnRows(preorderRows().AddRow(5, 2, "ORD-2", "c-2", 3, StatusPending))
+		expectMoveStock(mock, 2, 2, -3, "ORD-2")
+		mock.ExpectRollback()
+
+		mock.ExpectQuery(allocatedQuery).WithArgs(StatusAllocated).
+			WillReturnRows(preorderRows().
+				AddRow(4, 1, "ORD-1", "c-1", 1, StatusAllocated).
+				AddRow(7, 3, "ORD-7", "c-7", 2, StatusAllocated))
+		mock.ExpectBegin()
+		mock.ExpectExec(fulfilPreorderQuery).WithArgs(now, StatusFulfilled, sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		expectMoveStock(mock, 3, 0, 2, "ORD-7")
+		mock.ExpectExec(failPreorderQuery).WithArgs("payment declined", StatusFailed, sqlmock.AnyArg(), 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		captured := []string{}
+		capture := func(ctx context.Context, orderRef string) (payment.Payment, error) {
+			captured = append(captured, orderRef)
+			if orderRef == "ORD-7" {
+				return payment.Payment{}, payment.ErrDeclined
+			}
+			return payment.Payment{OrderRef: orderRef, Status: payment.StatusPaid}, nil
+		}
+		job := NewJob(gormDB, zap.NewNop(), time.Hour, capture)
+		job.now = func() time.Time { return now }
+
+		result, err := job.Release(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, Result{Books: 2, Allocated: 1, Fulfilled: 1, Failed: 1}, result)
+		assert.Equal(t, []string{"ORD-1", "ORD-7"}, captured)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("fail preorder given order without payment", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectExec(releaseBooksQuery).WithArgs(book.StatusAvailable, sqlmock.AnyArg(), book.StatusForthcoming, now).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		mock.ExpectQuery(pendingQuery).WithArgs(StatusPending, "", book.StatusAvailable, now).WillReturnRows(preorderRows())
+		mock.ExpectQuery(allocatedQuery).WithArgs(StatusAllocated).
+			WillReturnRows(preorderRows().AddRow(4, 1, "ORD-1", "c-1", 1, StatusAllocated))
+		mock.ExpectBegin()
+		expectMoveStock(mock, 1, 0, 1, "ORD-1")
+		mock.ExpectExec(failPreorderQuery).WithArgs(payment.ErrPaymentNotFound.Error(), StatusFailed, sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		capture := func(ctx context.Context, orderRef string) (payment.Payment, error) {
+			return payment.Payment{}, payment.ErrPaymentNotFound
+		}
+		job := NewJob(gormDB, zap.NewNop(), time.Hour, capture)
+		job.now = func() time.Time { return now }
+
+		result, err := job.Release(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, Result{Failed: 1}, result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("keep preorder allocated given gateway unavailable", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectExec(releaseBooksQuery).WithArgs(book.StatusAvailable, sqlmock.AnyArg(), book.StatusForthcoming, now).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		mock.ExpectQuery(pendingQuery).WithArgs(StatusPending, "", book.StatusAvailable, now).WillReturnRows(preorderRows())
+		mock.ExpectQuery(allocatedQuery).WithArgs(StatusAllocated).
+			WillReturnRows(preorderRows().AddRow(4, 1, "ORD-1", "c-1", 1, StatusAllocated))
+
+		capture := func(ctx context.Context, orderRef string) (payment.Payment, error) {
+			return payment.Payment{}, context.DeadlineExceeded
+		}
+		job := NewJob(gormDB, zap.NewNop(), time.Hour, capture)
+		job.now = func() time.Time { return now }
+
+		result, err := job.Release(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, Result{}, result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("capture in the preorder's storefront given tenant plugin", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		assert.NoError(t, gormDB.Use(tenant.Plugin{}))
+
+		mock.ExpectBegin()
+		mock.ExpectExec(releaseBooksQuery).WithArgs(book.StatusAvailable, sqlmock.AnyArg(), book.StatusForthcoming, now).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		mock.ExpectQuery(pendingQuery).WithArgs(StatusPending, "", book.StatusAvailable, now).WillReturnRows(preorderRows())
+		mock.ExpectQuery(allocatedQuery).WithArgs(StatusAllocated).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "order_ref", "customer_id", "quantity", "status", "tenant_id"}).
+				AddRow(4, 1, "ORD-1", "c-1", 1, StatusAllocated, "th"))
+		mock.ExpectBegin()
+		mock.ExpectExec(fulfilPreorderQuery).WithArgs(now, StatusFulfilled, sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		tenants := []string{}
+		capture := func(ctx context.Context, orderRef string) (payment.Payment, error) {
+			tenantID, _ := tenant.FromContext(ctx)
+			tenants = append(tenants, tenantID)
+			return payment.Payment{OrderRef: orderRef, Status: payment.StatusPaid, TenantID: tenantID}, nil
+		}
+		job := NewJob(gormDB, zap.NewNop(), time.Hour, capture)
+		job.now = func() time.Time { return now }
+
+		result, err := job.Release(tenant.Unscoped(context.Background()))
+
+		assert.NoError(t, err)
+		assert.Equal(t, Result{Fulfilled: 1}, result)
+		assert.Equal(t, []string{"th"}, tenants)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,208 @@
+package preorder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusAllocated = "allocated"
+	StatusFulfilled = "fulfilled"
+	StatusCancelled = "cancelled"
+	StatusFailed    = "failed"
+)
+
+// Preorder is an order for a book that has not been published yet. On
+// release day the release job allocates stock to it and captures the
+// payment of its order. Allocated preorders have their stock but are still
+// waiting for the payment.
+type Preorder struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	BookID        uint       `json:"book_id" gorm:"not null;uniqueIndex:idx_preorders_tenant_order_book"`
+	OrderRef      string     `json:"order_ref" gorm:"not null;uniqueIndex:idx_preorders_tenant_order_book"`
+	CustomerID    string     `json:"customer_id" gorm:"not null;index"`
+	Quantity      int        `json:"quantity"`
+	Status        string     `json:"status" gorm:"not null;index"`
+	FailureReason string     `json:"failure_reason,omitempty"`
+	FulfilledAt   *time.Time `json:"fulfilled_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	TenantID      string     `json:"-" gorm:"not null;default:'default';uniqueIndex:idx_preorders_tenant_order_book"`
+}
+
+type PreorderRequest struct {
+	OrderRef string `json:"order_ref" validate:"required,max=100"`
+	Quantity int    `json:"quantity" validate:"gte=0,lte=100"`
+}
+
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+func (c *CustomValidator) Validate(i interface{}) error {
+	return c.validator.Struct(i)
+}
+
+// VoidFunc releases the authorized payment of an order, such as payment's
+// VoidOrder.
+type VoidFunc func(ctx context.Context, orderRef string) (payment.Payment, error)
+
+type handler struct {
+	db   *gorm.DB
+	void VoidFunc
+}
+
+func NewHandler(db *gorm.DB, void VoidFunc) *handler {
+	return &handler{db: db, void: void}
+}
+
+// Create preorders a forthcoming book for one of the customer's orders. The
+// order's payment must already be authorized with POST /payments; it is
+// captured on release day.
+func (handler *handler) Create(c echo.Context) error {
+	request := PreorderRequest{}
+	found := book.Book{}
+	logger := middleware.GetLogger(c)
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+	if request.Quantity == 0 {
+		request.Quantity = 1
+	}
+
+	if err := handler.db.WithContext(c.Request().Context()).First(&found, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if found.Status != book.StatusForthcoming {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Only forthcoming books can be preordered"})
+	}
+
+	paid := payment.Payment{}
+	err := handler.db.WithContext(c.Request().Context()).
+		Where("order_ref = ? AND customer_id = ?", request.OrderRef, middleware.GetCustomerID(c)).
+		First(&paid).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if paid.Status != payment.StatusAuthorized {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order's payment must be authorized"})
+	}
+
+	preorder := Preorder{
+		BookID:     found.ID,
+		OrderRef:   request.OrderRef,
+		CustomerID: middleware.GetCustomerID(c),
+		Quantity:   request.Quantity,
+		Status:     StatusPending,
+	}
+	result := handler.db.WithContext(c.Request().Context()).Clauses(clause.OnConflict{DoNothing: true}).Create(&preorder)
+	if result.Error != nil {
+		logger.Error("failed to create preorder", zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create preorder"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order already preorders this book"})
+	}
+
+	logger.Info("book preordered", zap.Uint("id", preorder.ID), zap.Uint("book_id", found.ID), zap.String("order_ref", preorder.OrderRef))
+	return c.JSON(http.StatusCreated, preorder)
+}
+
+// GetAll lists the customer's preorders, newest first.
+func (handler *handler) GetAll(c echo.Context) error {
+	preorders := []Preorder{}
+	err := handler.db.WithContext(c.Request().Context()).Where("customer_id = ?", middleware.GetCustomerID(c)).Order("id DESC").Find(&preorders).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, preorders)
+}
+
+var errNotPending = errors.New("preorder is not pending")
+
+// voidFailure marks an error from releasing the order's payment.
+type voidFailure struct {
+	err error
+}
+
+func (failure voidFailure) Error() string {
+	return failure.err.Error()
+}
+
+// Cancel cancels one of the customer's preorders before stock is allocated
+// to it. Cancelling the last open preorder of an order also releases the
+// order's payment; if that fails, the preorder is not cancelled.
+func (handler *handler) Cancel(c echo.Context) error {
+	preorder := Preorder{}
+	logger := middleware.GetLogger(c)
+
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND customer_id = ?", c.Param("id"), middleware.GetCustomerID(c)).
+			First(&preorder).Error
+		if err != nil {
+			return err
+		}
+		if preorder.Status != StatusPending {
+			return errNotPending
+		}
+		preorder.Status = StatusCancelled
+		if err := tx.Model(&preorder).Update("status", preorder.Status).Error; err != nil {
+			return err
+		}
+
+		var open int64
+		err = tx.Model(&Preorder{}).
+			Where("order_ref = ? AND status IN ?", preorder.OrderRef, []string{StatusPending, StatusAllocated}).
+			Count(&open).Error
+		if err != nil || open > 0 {
+			return err
+		}
+		_, err = handler.void(c.Request().Context(), preorder.OrderRef)
+		if err != nil && !errors.Is(err, payment.ErrNotAuthorized) && !errors.Is(err, payment.ErrPaymentNotFound) {
+			return voidFailure{err}
+		}
+		return nil
+	})
+	var failure voidFailure
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Preorder not found"})
+	case errors.Is(err, errNotPending):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Only pending preorders can be cancelled"})
+	case errors.As(err, &failure):
+		logger.Error("failed to void preorder payment", zap.String("order_ref", preorder.OrderRef), zap.Error(err))
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Failed to release the order's payment"})
+	case err != nil:
+		logger.Error("failed to cancel preorder", zap.String("id", c.Param("id")), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	logger.Info("preorder cancelled", zap.Uint("id", preorder.ID))
+	return c.JSON(http.StatusOK, preorder)
+}
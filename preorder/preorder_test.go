@@ -0,0 +1,283 @@
+package preorder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	getBookQuery        = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2`
+	createPreorderQuery = `INSERT INTO "preorders" ("book_id","order_ref","customer_id","quantity","status","failure_reason","fulfilled_at","created_at","updated_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) ON CONFLICT DO NOTHING RETURNING "id"`
+	lockPreorderQuery   = `SELECT * FROM "preorders" WHERE id = $1 AND customer_id = $2 ORDER BY "preorders"."id" LIMIT $3 FOR UPDATE`
+	cancelPreorderQuery = `UPDATE "preorders" SET "status"=$1,"updated_at"=$2 WHERE "id" = $3`
+	orderPaymentQuery   = `SELECT * FROM "payments" WHERE order_ref = $1 AND customer_id = $2 ORDER BY "payments"."id" LIMIT $3`
+	openPreordersQuery  = `SELECT count(*) FROM "preorders" WHERE order_ref = $1 AND status IN ($2,$3)`
+)
+
+func expectPayment(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectQuery(orderPaymentQuery).WithArgs("ORD-1", "c-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "customer_id", "status"}).AddRow(1, "ORD-1", "c-1", status))
+}
+
+func TestCreate(t *testing.T) {
+	t.Run("accept preorder given forthcoming book", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"order_ref": "ORD-1"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "status"}).AddRow(1, "Nexus", book.StatusForthcoming))
+		expectPayment(mock, payment.StatusAuthorized)
+		mock.ExpectBegin()
+		mock.ExpectQuery(createPreorderQuery).
+			WithArgs(1, "ORD-1", "c-1", 1, StatusPending, "", nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, nil)
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		preorder := Preorder{}
+		json.Unmarshal(response.Body.Bytes(), &preorder)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, uint(4), preorder.ID)
+		assert.Equal(t, StatusPending, preorder.Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given book already available", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"order_ref": "ORD-1", "quantity": 2}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "status"}).AddRow(1, "Sapiens", ""))
+
+		handler := NewHandler(gormDB, nil)
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given payment not authorized", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"order_ref": "ORD-1"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "status"}).AddRow(1, "Nexus", book.StatusForthcoming))
+		expectPayment(mock, payment.StatusFailed)
+
+		handler := NewHandler(gormDB, nil)
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given no order", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"quantity": 1}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		handler := NewHandler(nil, nil)
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestCancel(t *testing.T) {
+	t.Run("cancel preorder given pending preorder", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("4")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPreorderQuery).WithArgs("4", "c-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "order_ref", "customer_id", "status"}).AddRow(4, 1, "ORD-1", "c-1", StatusPending))
+		mock.ExpectExec(cancelPreorderQuery).WithArgs(StatusCancelled, sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(openPreordersQuery).WithArgs("ORD-1", StatusPending, StatusAllocated).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectCommit()
+
+		voided := []string{}
+		handler := NewHandler(gormDB, func(ctx context.Context, orderRef string) (payment.Payment, error) {
+			voided = append(voided, orderRef)
+			return payment.Payment{OrderRef: orderRef, Status: payment.StatusVoided}, nil
+		})
+		err := middleware.RequireCustomer(handler.Cancel)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, []string{"ORD-1"}, voided)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("keep payment given order has other open preorders", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("4")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPreorderQuery).WithArgs("4", "c-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "order_ref", "customer_id", "status"}).AddRow(4, 1, "ORD-1", "c-1", StatusPending))
+		mock.ExpectExec(cancelPreorderQuery).WithArgs(StatusCancelled, sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(openPreordersQuery).WithArgs("ORD-1", StatusPending, StatusAllocated).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, func(ctx context.Context, orderRef string) (payment.Payment, error) {
+			t.Fatal("payment must not be voided")
+			return payment.Payment{}, nil
+		})
+		err := middleware.RequireCustomer(handler.Cancel)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("keep preorder pending given void fails", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("4")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPreorderQuery).WithArgs("4", "c-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "order_ref", "customer_id", "status"}).AddRow(4, 1, "ORD-1", "c-1", StatusPending))
+		mock.ExpectExec(cancelPreorderQuery).WithArgs(StatusCancelled, sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(openPreordersQuery).WithArgs("ORD-1", StatusPending, StatusAllocated).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, func(ctx context.Context, orderRef string) (payment.Payment, error) {
+			return payment.Payment{}, context.DeadlineExceeded
+		})
+		err := middleware.RequireCustomer(handler.Cancel)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadGateway, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given allocated preorder", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "c-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("4")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPreorderQuery).WithArgs("4", "c-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "order_ref", "customer_id", "status"}).AddRow(4, 1, "ORD-1", "c-1", StatusAllocated))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, nil)
+		err := middleware.RequireCustomer(handler.Cancel)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
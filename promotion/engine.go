@@ -0,0 +1,427 @@
+package promotion
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Line is one cart line as seen by the engine. Amounts are in cents so that
+// discounts add up exactly.
+type Line struct {
+	BookID    uint
+	Quantity  int
+	UnitPrice int64
+	Category  string
+	Authors   []string
+	TaxClass  string
+}
+
+type Cart struct {
+	CustomerID string
+	Lines      []Line
+	Codes      []string
+}
+
+// Usage is how often a promotion has been redeemed, overall and by the
+// customer the cart belongs to.
+type Usage struct {
+	Total    int
+	Customer int
+}
+
+type Discount struct {
+	PromotionID uint    `json:"promotion_id"`
+	Name        string  `json:"name"`
+	Code        string  `json:"code,omitempty"`
+	Amount      float64 `json:"amount"`
+}
+
+type LineQuote struct {
+	BookID    uint       `json:"book_id"`
+	Quantity  int        `json:"quantity"`
+	UnitPrice float64    `json:"unit_price"`
+	Subtotal  float64    `json:"subtotal"`
+	Discount  float64    `json:"discount"`
+	Total     float64    `json:"total"`
+	Discounts []Discount `json:"discounts"`
+	TaxClass  string     `json:"tax_class,omitempty"`
+	TaxRate   float64    `json:"tax_rate"`
+	Tax       float64    `json:"tax"`
+}
+
+type Rejection struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+type Quote struct {
+	Subtotal float64     `json:"subtotal"`
+	Discount float64     `json:"discount"`
+	Total    float64     `json:"total"`
+	Lines    []LineQuote `json:"lines"`
+	Applied  []Discount  `json:"applied"`
+	Rejected []Rejection `json:"rejected"`
+
+	// Tax is only calculated when the request names a jurisdiction.
+	Jurisdiction     string  `json:"jurisdiction,omitempty"`
+	PricesIncludeTax bool    `json:"prices_include_tax"`
+	Tax              float64 `json:"tax"`
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func fromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (p Promotion) activeAt(now time.Time) string {
+	if p.StartsAt != nil && now.Before(*p.StartsAt) {
+		return "Promotion has not started yet"
+	}
+	if p.EndsAt != nil && !now.Before(*p.EndsAt) {
+		return "Promotion has ended"
+	}
+	return ""
+}
+
+func (p Promotion) withinLimits(usage Usage) string {
+	if p.UsageLimit > 0 && usage.Total >= p.UsageLimit {
+		return "Promotion usage limit reached"
+	}
+	if p.UsageLimitPerCustomer > 0 && usage.Customer >= p.UsageLimitPerCustomer {
+		return "You have already used this promotion"
+	}
+	return ""
+}
+
+func (p Promotion) eligible(line Line) bool {
+	if p.Category != "" && !strings.EqualFold(p.Category, line.Category) {
+		return false
+	}
+	if p.Author != "" {
+		for _, author := range line.Authors {
+			if strings.EqualFold(p.Author, author) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// allocation holds what one set of promotions takes off each line, in the
+// order the promotions were applied.
+type allocation struct {
+	promotions []Promotion
+	amounts    [][]int64
+	total      int64
+}
+
+// Evaluate decides which promotions apply to the cart and how much each takes
+// off every line. The outcome only depends on its arguments:
+//
+//   - Promotions are considered in Priority order, then by ID.
+//   - Automatic promotions (no code) apply whenever they are eligible; coded
+//     ones only when their code is in the cart.
+//   - Non-exclusive promotions stack. Each is applied to what is left of the
+//     line after the promotions before it, so a line never goes below zero.
+//   - An exclusive promotion cannot be combined. If the cart is eligible for
+//     one, the engine picks whichever gives the larger discount: one of the
+//     exclusive promotions alone or all of the stackable ones together. Ties go
+//     to the option whose first promotion comes first.
+func Evaluate(cart Cart, promotions []Promotion, usage map[uint]Usage, now time.Time) Quote {
+	sorted := append([]Promotion{}, promotions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	codes := map[string]bool{}
+	for _, code := range cart.Codes {
+		if code = normalizeCode(code); code != "" {
+			codes[code] = false
+		}
+	}
+
+	quote := Quote{Applied: []Discount{}, Rejected: []Rejection{}}
+	var subtotal int64
+	for _, line := range cart.Lines {
+		subtotal += line.UnitPrice * int64(line.Quantity)
+	}
+
+	reject := func(promotion Promotion, reason string) {
+		if promotion.Code != "" {
+			quote.Rejected = append(quote.Rejected, Rejection{Code: promotion.Code, Reason: reason})
+		}
+	}
+
+	candidates := []Promotion{}
+	for _, promotion := range sorted {
+		if code := normalizeCode(promotion.Code); code != "" {
+			if _, ok := codes[code]; !ok {
+				continue
+			}
+			codes[code] = true
+		}
+
+		if reason := promotion.activeAt(now); reason != "" {
+			reject(promotion, reason)
+			continue
+		}
+		if reason := promotion.withinLimits(usage[promotion.ID]); reason != "" {
+			reject(promotion, reason)
+			continue
+		}
+		if subtotal < toCents(promotion.MinOrderValue) {
+			reject(promotion, "Minimum order value not met")
+			continue
+		}
+		if !hasEligibleLine(promotion, cart.Lines) {
+			reject(promotion, "No eligible items in the cart")
+			continue
+		}
+		candidates = append(candidates, promotion)
+	}
+
+	for _, code := range sortedKeys(codes) {
+		if !codes[code] {
+			quote.Rejected = append(quote.Rejected, Rejection{Code: code, Reason: "Unknown code"})
+		}
+	}
+
+	best := chooseAllocation(cart.Lines, candidates)
+	chosen := map[uint]bool{}
+	for _, promotion := range best.promotions {
+		chosen[promotion.ID] = true
+	}
+	for _, promotion := range candidates {
+		if !chosen[promotion.ID] {
+			reject(promotion, "Cannot be combined with the other promotions in the cart")
+		}
+	}
+
+	lineDiscounts := make([]int64, len(cart.Lines))
+	quote.Lines = make([]LineQuote, len(cart.Lines))
+	for i, line := range cart.Lines {
+		quote.Lines[i] = LineQuote{
+			BookID:    line.BookID,
+			Quantity:  line.Quantity,
+			UnitPrice: fromCents(line.UnitPrice),
+			Subtotal:  fromCents(line.UnitPrice * int64(line.Quantity)),
+			Discounts: []Discount{},
+		}
+	}
+
+	for p, promotion := range best.promotions {
+		var applied int64
+		for i, amount := range best.amounts[p] {
+			if amount == 0 {
+				continue
+			}
+			applied += amount
+			lineDiscounts[i] += amount
+			quote.Lines[i].Discounts = append(quote.Lines[i].Discounts, Discount{
+				PromotionID: promotion.ID, Name: promotion.Name, Code: promotion.Code, Amount: fromCents(amount),
+			})
+		}
+		if applied > 0 {
+			quote.Applied = append(quote.Applied, Discount{
+				PromotionID: promotion.ID, Name: promotion.Name, Code: promotion.Code, Amount: fromCents(applied),
+			})
+		}
+	}
+
+	for i, line := range cart.Lines {
+		lineTotal := line.UnitPrice * int64(line.Quantity)
+		quote.Lines[i].Discount = fromCents(lineDiscounts[i])
+		quote.Lines[i].Total = fromCents(lineTotal - lineDiscounts[i])
+	}
+	quote.Subtotal = fromCents(subtotal)
+	quote.Discount = fromCents(best.total)
+	quote.Total = fromCents(subtotal - best.total)
+	return quote
+}
+
+func hasEligibleLine(promotion Promotion, lines []Line) bool {
+	for _, line := range lines {
+		if line.Quantity > 0 && promotion.eligible(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(values map[string]bool) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func chooseAllocation(lines []Line, candidates []Promotion) allocation {
+	stackable := []Promotion{}
+	options := [][]Promotion{}
+	for _, promotion := range candidates {
+		if promotion.Exclusive {
+			options = append(options, []Promotion{promotion})
+		} else {
+			stackable = append(stackable, promotion)
+		}
+	}
+	if len(stackable) > 0 {
+		options = append(options, stackable)
+	}
+
+	// Candidates are already in priority order, so ordering the options by
+	// the position of their first promotion makes ties deterministic.
+	position := map[uint]int{}
+	for i, promotion := range candidates {
+		position[promotion.ID] = i
+	}
+	sort.SliceStable(options, func(i, j int) bool {
+		return position[options[i][0].ID] < position[options[j][0].ID]
+	})
+
+	best := allocation{}
+	for _, option := range options {
+		if candidate := allocate(lines, option); candidate.total > best.total {
+			best = candidate
+		}
+	}
+	return best
+}
+
+func allocate(lines []Line, promotions []Promotion) allocation {
+	remaining := make([]int64, len(lines))
+	for i, line := range lines {
+		remaining[i] = line.UnitPrice * int64(line.Quantity)
+	}
+
+	result := allocation{promotions: promotions, amounts: make([][]int64, len(promotions))}
+	for p, promotion := range promotions {
+		var amounts []int64
+		switch promotion.Type {
+		case TypePercentage:
+			amounts = percentageDiscount(promotion, lines, remaining)
+		case TypeFixed:
+			amounts = fixedDiscount(promotion, lines, remaining)
+		case TypeBuyXGetY:
+			amounts = buyXGetYDiscount(promotion, lines, remaining)
+		default:
+			amounts = make([]int64, len(lines))
+		}
+		for i, amount := range amounts {
+			remaining[i] -= amount
+			result.total += amount
+		}
+		result.amounts[p] = amounts
+	}
+	return result
+}
+
+func percentageDiscount(promotion Promotion, lines []Line, remaining []int64) []int64 {
+	amounts := make([]int64, len(lines))
+	for i, line := range lines {
+		if promotion.eligible(line) {
+			amounts[i] = int64(math.Round(float64(remaining[i]) * promotion.Value / 100))
+		}
+	}
+	return amounts
+}
+
+// fixedDiscount spreads the amount over eligible lines in proportion to what
+// is left of them. Cents lost to rounding go to the first lines with room.
+func fixedDiscount(promotion Promotion, lines []Line, remaining []int64) []int64 {
+	amounts := make([]int64, len(lines))
+
+	var eligible int64
+	for i, line := range lines {
+		if promotion.eligible(line) {
+			eligible += remaining[i]
+		}
+	}
+	if eligible == 0 {
+		return amounts
+	}
+
+	amount := min(toCents(promotion.Value), eligible)
+	var allocated int64
+	for i, line := range lines {
+		if promotion.eligible(line) {
+			amounts[i] = amount * remaining[i] / eligible
+			allocated += amounts[i]
+		}
+	}
+	for i, line := range lines {
+		if allocated == amount {
+			break
+		}
+		if promotion.eligible(line) && amounts[i] < remaining[i] {
+			extra := min(amount-allocated, remaining[i]-amounts[i])
+			amounts[i] += extra
+			allocated += extra
+		}
+	}
+	return amounts
+}
+
+// buyXGetYDiscount makes the cheapest GetQuantity units of every group of
+// BuyQuantity+GetQuantity eligible units free, most expensive units first.
+// Units are counted per line rather than one by one, so the quantity of a
+// line does not matter to how long it takes.
+func buyXGetYDiscount(promotion Promotion, lines []Line, remaining []int64) []int64 {
+	amounts := make([]int64, len(lines))
+	group := int64(promotion.BuyQuantity + promotion.GetQuantity)
+	if promotion.BuyQuantity < 1 || promotion.GetQuantity < 1 {
+		return amounts
+	}
+
+	// A tier is what is left of a line and how many units share it. The
+	// unit price is not divided out, as what is left of a line after other
+	// promotions need not split into whole cents per unit.
+	type tier struct {
+		line      int
+		remaining int64
+		quantity  int64
+	}
+	tiers := []tier{}
+	var total int64
+	for i, line := range lines {
+		if !promotion.eligible(line) || line.Quantity < 1 {
+			continue
+		}
+		tiers = append(tiers, tier{line: i, remaining: remaining[i], quantity: int64(line.Quantity)})
+		total += int64(line.Quantity)
+	}
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].remaining*tiers[j].quantity > tiers[j].remaining*tiers[i].quantity
+	})
+
+	// Units are numbered from the most expensive. Unit n is free when it is
+	// among the last GetQuantity of its group and its group is complete.
+	buy := int64(promotion.BuyQuantity)
+	complete := total / group * group
+	freeBefore := func(n int64) int64 {
+		n = min(n, complete)
+		return n/group*(group-buy) + max(0, n%group-buy)
+	}
+	var position int64
+	for _, tier := range tiers {
+		free := freeBefore(position+tier.quantity) - freeBefore(position)
+		amounts[tier.line] += int64(math.Round(float64(tier.remaining*free) / float64(tier.quantity)))
+		position += tier.quantity
+	}
+	return amounts
+}
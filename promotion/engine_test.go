@@ -0,0 +1,165 @@
+package promotion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+func TestEvaluate(t *testing.T) {
+	atomicHabits := Line{BookID: 1, Quantity: 2, UnitPrice: 1500, Category: "self-help", Authors: []string{"James Clear"}}
+	alchemist := Line{BookID: 2, Quantity: 1, UnitPrice: 1000, Category: "fiction", Authors: []string{"Paulo Coelho"}}
+
+	t.Run("apply percentage discount to every line given automatic promotion", func(t *testing.T) {
+		cart := Cart{Lines: []Line{atomicHabits, alchemist}}
+		promotions := []Promotion{{ID: 1, Name: "Autumn sale", Type: TypePercentage, Value: 10}}
+
+		quote := Evaluate(cart, promotions, nil, now)
+
+		assert.Equal(t, 40.0, quote.Subtotal)
+		assert.Equal(t, 4.0, quote.Discount)
+		assert.Equal(t, 36.0, quote.Total)
+		assert.Equal(t, 3.0, quote.Lines[0].Discount)
+		assert.Equal(t, 1.0, quote.Lines[1].Discount)
+		assert.Equal(t, []Discount{{PromotionID: 1, Name: "Autumn sale", Amount: 4}}, quote.Applied)
+	})
+
+	t.Run("limit discount to scoped lines given category and author scope", func(t *testing.T) {
+		cart := Cart{Lines: []Line{atomicHabits, alchemist}}
+		promotions := []Promotion{
+			{ID: 1, Name: "Fiction week", Type: TypePercentage, Value: 50, Category: "Fiction"},
+			{ID: 2, Name: "Clear fans", Type: TypeFixed, Value: 2, Author: "james clear"},
+		}
+
+		quote := Evaluate(cart, promotions, nil, now)
+
+		assert.Equal(t, 2.0, quote.Lines[0].Discount)
+		assert.Equal(t, 5.0, quote.Lines[1].Discount)
+		assert.Equal(t, 7.0, quote.Discount)
+	})
+
+	t.Run("split fixed discount by line value without losing cents", func(t *testing.T) {
+		cart := Cart{Lines: []Line{
+			{BookID: 1, Quantity: 1, UnitPrice: 1000},
+			{BookID: 2, Quantity: 1, UnitPrice: 1000},
+			{BookID: 3, Quantity: 1, UnitPrice: 1000},
+		}}
+		promotions := []Promotion{{ID: 1, Name: "Ten off", Code: "TEN", Type: TypeFixed, Value: 10}}
+		cart.Codes = []string{"ten"}
+
+		quote := Evaluate(cart, promotions, nil, now)
+
+		assert.Equal(t, 10.0, quote.Discount)
+		assert.Equal(t, 3.34, quote.Lines[0].Discount)
+		assert.Equal(t, 3.33, quote.Lines[1].Discount)
+		assert.Equal(t, 3.33, quote.Lines[2].Discount)
+	})
+
+	t.Run("make cheapest units free given buy two get one", func(t *testing.T) {
+		cart := Cart{Lines: []Line{atomicHabits, alchemist, {BookID: 3, Quantity: 3, UnitPrice: 800}}}
+		promotions := []Promotion{{ID: 1, Name: "3 for 2", Type: TypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1}}
+
+		quote := Evaluate(cart, promotions, nil, now)
+
+		// Units by price: 15, 15, 10 | 8, 8, 8 -> the 10 and one 8 are free.
+		assert.Equal(t, 0.0, quote.Lines[0].Discount)
+		assert.Equal(t, 10.0, quote.Lines[1].Discount)
+		assert.Equal(t, 8.0, quote.Lines[2].Discount)
+	})
+
+	t.Run("count free units per line given large quantities", func(t *testing.T) {
+		cart := Cart{Lines: []Line{{BookID: 1, Quantity: 1000000, UnitPrice: 1500}, {BookID: 2, Quantity: 2, UnitPrice: 800}}}
+		promotions := []Promotion{{ID: 1, Name: "3 for 2", Type: TypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1}}
+
+		quote := Evaluate(cart, promotions, nil, now)
+
+		// 1,000,002 units make 333,334 groups. The 15s fill 333,333 of them
+		// with one free each; the last group is 15, 8, 8, so an 8 is free.
+		assert.Equal(t, 4999995.0, quote.Lines[0].Discount)
+		assert.Equal(t, 8.0, quote.Lines[1].Discount)
+	})
+
+	t.Run("keep cents of free units given discounted line", func(t *testing.T) {
+		cart := Cart{Lines: []Line{{BookID: 1, Quantity: 3, UnitPrice: 1000}}}
+		promotions := []Promotion{
+			{ID: 1, Name: "One off", Type: TypeFixed, Value: 1, Priority: 1},
+			{ID: 2, Name: "3 for 2", Type: TypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Priority: 2},
+		}
+
+		quote := Evaluate(cart, promotions, nil, now)
+
+		// 29.00 is left for three units, so the free one is worth 9.67.
+		assert.Equal(t, 10.67, quote.Discount)
+		assert.Equal(t, 9.67, quote.Applied[1].Amount)
+	})
+
+	t.Run("stack promotions in priority order on the remaining amount", func(t *testing.T) {
+		cart := Cart{Lines: []Line{alchemist}, Codes: []string{"FIVE"}}
+		promotions := []Promotion{
+			{ID: 2, Name: "Five off", Code: "FIVE", Type: TypeFixed, Value: 5, Priority: 2},
+			{ID: 1, Name: "Half price", Type: TypePercentage, Value: 50, Priority: 1},
+		}
+
+		quote := Evaluate(cart, promotions, nil, now)
+
+		assert.Equal(t, 10.0, quote.Discount)
+		assert.Equal(t, []Discount{
+			{PromotionID: 1, Name: "Half price", Amount: 5},
+			{PromotionID: 2, Name: "Five off", Code: "FIVE", Amount: 5},
+		}, quote.Lines[0].Discounts)
+	})
+
+	t.Run("choose the larger of exclusive and stacked promotions", func(t *testing.T) {
+		cart := Cart{Lines: []Line{atomicHabits}, Codes: []string{"VIP"}}
+		promotions := []Promotion{
+			{ID: 1, Name: "Ten percent", Type: TypePercentage, Value: 10},
+			{ID: 2, Name: "Two off", Type: TypeFixed, Value: 2},
+			{ID: 3, Name: "VIP", Code: "VIP", Type: TypePercentage, Value: 25, Exclusive: true},
+		}
+
+		quote := Evaluate(cart, promotions, nil, now)
+
+		assert.Equal(t, 7.5, quote.Discount)
+		assert.Len(t, quote.Applied, 1)
+		assert.Equal(t, uint(3), quote.Applied[0].PromotionID)
+	})
+
+	t.Run("reject exclusive code given stacked promotions give more", func(t *testing.T) {
+		cart := Cart{Lines: []Line{atomicHabits}, Codes: []string{"VIP"}}
+		promotions := []Promotion{
+			{ID: 1, Name: "Half price", Type: TypePercentage, Value: 50},
+			{ID: 3, Name: "VIP", Code: "VIP", Type: TypePercentage, Value: 25, Exclusive: true},
+		}
+
+		quote := Evaluate(cart, promotions, nil, now)
+
+		assert.Equal(t, 15.0, quote.Discount)
+		assert.Equal(t, []Rejection{{Code: "VIP", Reason: "Cannot be combined with the other promotions in the cart"}}, quote.Rejected)
+	})
+
+	t.Run("reject codes given unmet conditions", func(t *testing.T) {
+		ended := now.Add(-time.Hour)
+		cart := Cart{Lines: []Line{alchemist}, Codes: []string{"OLD", "BIG", "ONCE", "FULL", "NOPE"}}
+		promotions := []Promotion{
+			{ID: 1, Name: "Old", Code: "OLD", Type: TypeFixed, Value: 1, EndsAt: &ended},
+			{ID: 2, Name: "Big", Code: "BIG", Type: TypeFixed, Value: 1, MinOrderValue: 50},
+			{ID: 3, Name: "Once", Code: "ONCE", Type: TypeFixed, Value: 1, UsageLimitPerCustomer: 1},
+			{ID: 4, Name: "Full", Code: "FULL", Type: TypeFixed, Value: 1, UsageLimit: 100},
+		}
+		usage := map[uint]Usage{3: {Total: 1, Customer: 1}, 4: {Total: 100}}
+
+		quote := Evaluate(cart, promotions, usage, now)
+
+		assert.Equal(t, 0.0, quote.Discount)
+		assert.Equal(t, []Rejection{
+			{Code: "OLD", Reason: "Promotion has ended"},
+			{Code: "BIG", Reason: "Minimum order value not met"},
+			{Code: "ONCE", Reason: "You have already used this promotion"},
+			{Code: "FULL", Reason: "Promotion usage limit reached"},
+			{Code: "NOPE", Reason: "Unknown code"},
+		}, quote.Rejected)
+	})
+}
@@ -0,0 +1,197 @@
+package promotion
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	TypePercentage = "percentage"
+	TypeFixed      = "fixed"
+	TypeBuyXGetY   = "buy_x_get_y"
+)
+
+// Promotion is a discount rule. Promotions without a code apply
+// automatically; the others only when a customer enters the code.
+type Promotion struct {
+	ID                    uint       `json:"id" gorm:"primaryKey"`
+	Name                  string     `json:"name" gorm:"not null" validate:"required"`
+	Code                  string     `json:"code" gorm:"uniqueIndex:idx_promotions_code,where:code <> ''"`
+	Type                  string     `json:"type" gorm:"not null" validate:"oneof=percentage fixed buy_x_get_y"`
+	Value                 float64    `json:"value" validate:"gte=0"`
+	BuyQuantity           int        `json:"buy_quantity" validate:"gte=0"`
+	GetQuantity           int        `json:"get_quantity" validate:"gte=0"`
+	Category              string     `json:"category"`
+	Author                string     `json:"author"`
+	MinOrderValue         float64    `json:"min_order_value" validate:"gte=0"`
+	UsageLimit            int        `json:"usage_limit" validate:"gte=0"`
+	UsageLimitPerCustomer int        `json:"usage_limit_per_customer" validate:"gte=0"`
+	StartsAt              *time.Time `json:"starts_at"`
+	EndsAt                *time.Time `json:"ends_at"`
+	Exclusive             bool       `json:"exclusive"`
+	Priority              int        `json:"priority"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+	TenantID              string     `json:"-" gorm:"not null;default:'default';uniqueIndex:idx_promotions_code"`
+}
+
+// Redemption records that a customer used a promotion on an order. Usage
+// limits are counted from redemptions. A promotion is redeemed at most once
+// per order.
+type Redemption struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	PromotionID uint      `json:"promotion_id" gorm:"not null;index;uniqueIndex:idx_promotion_redemptions_order"`
+	CustomerID  string    `json:"customer_id" gorm:"not null;index"`
+	OrderRef    string    `json:"order_ref" gorm:"uniqueIndex:idx_promotion_redemptions_order"`
+	CreatedAt   time.Time `json:"created_at"`
+	TenantID    string    `json:"-" gorm:"not null;default:'default';index"`
+}
+
+func (Redemption) TableName() string {
+	return "promotion_redemptions"
+}
+
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+func (c *CustomValidator) Validate(i interface{}) error {
+	if err := c.validator.Struct(i); err != nil {
+		return err
+	}
+	if promotion, ok := i.(Promotion); ok {
+		return promotion.validateRules()
+	}
+	return nil
+}
+
+// validateRules checks the rules that depend on the promotion type.
+func (p Promotion) validateRules() error {
+	switch p.Type {
+	case TypePercentage:
+		if p.Value <= 0 || p.Value > 100 {
+			return errors.New("percentage value must be greater than 0 and at most 100")
+		}
+	case TypeFixed:
+		if p.Value <= 0 {
+			return errors.New("fixed value must be greater than 0")
+		}
+	case TypeBuyXGetY:
+		if p.BuyQuantity < 1 || p.GetQuantity < 1 {
+			return errors.New("buy_quantity and get_quantity must be at least 1")
+		}
+	}
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+type handler struct {
+	db  *gorm.DB
+	now func() time.Time
+}
+
+func NewHandler(db *gorm.DB) *handler {
+	return &handler{db: db, now: time.Now}
+}
+
+func (handler *handler) Create(c echo.Context) error {
+	promotion := Promotion{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&promotion); err != nil {
+		logger.Error("failed to bind promotion", zap.Error(err))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	promotion.ID = 0
+	promotion.Code = normalizeCode(promotion.Code)
+	if err := c.Validate(promotion); err != nil {
+		logger.Error("failed to validate promotion", zap.Error(err))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	if result := handler.db.WithContext(c.Request().Context()).Create(&promotion); result.Error != nil {
+		logger.Error("failed to insert promotion", zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+
+	logger.Info("promotion created", zap.Uint("id", promotion.ID))
+	return c.JSON(http.StatusCreated, promotion)
+}
+
+func (handler *handler) GetAll(c echo.Context) error {
+	promotions := []Promotion{}
+	if result := handler.db.WithContext(c.Request().Context()).Order("priority").Order("id").Find(&promotions); result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+	return c.JSON(http.StatusOK, promotions)
+}
+
+func (handler *handler) GetById(c echo.Context) error {
+	promotion := Promotion{}
+
+	if err := handler.db.WithContext(c.Request().Context()).First(&promotion, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Promotion not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, promotion)
+}
+
+func (handler *handler) Update(c echo.Context) error {
+	promotion := Promotion{}
+	id := c.Param("id")
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := handler.db.WithContext(c.Request().Context()).First(&promotion, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Promotion not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	promotionID := promotion.ID
+	if err := c.Bind(&promotion); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	promotion.ID = promotionID
+	promotion.Code = normalizeCode(promotion.Code)
+	if err := c.Validate(promotion); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	if result := handler.db.WithContext(c.Request().Context()).Save(&promotion); result.Error != nil {
+		logger.Error("failed to update promotion", zap.String("id", id), zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update promotion"})
+	}
+
+	logger.Info("promotion updated", zap.String("id", id))
+	return c.JSON(http.StatusOK, promotion)
+}
+
+func (handler *handler) Delete(c echo.Context) error {
+	result := handler.db.WithContext(c.Request().Context()).Delete(&Promotion{}, c.Param("id"))
+	if result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Promotion not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Promotion successfully deleted"})
+}
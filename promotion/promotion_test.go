@@ -0,0 +1,253 @@
+package promotion
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	createPromotionQuery = `INSERT INTO "promotions" ("name","code","type","value","buy_quantity","get_quantity","category","author","min_order_value","usage_limit","usage_limit_per_customer","starts_at","ends_at","exclusive","priority","created_at","updated_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18) RETURNING "id"`
+	getBooksByIdsQuery   = `SELECT * FROM "books" WHERE "books"."id" IN ($1,$2) AND "books"."deleted_at" IS NULL`
+	getPromotionsQuery   = `SELECT * FROM "promotions" WHERE code = '' OR code IN ($1)`
+	getJurisdictionQuery = `SELECT * FROM "tax_jurisdictions" WHERE code = $1 ORDER BY "tax_jurisdictions"."id" LIMIT $2`
+	getTaxRatesQuery     = `SELECT * FROM "tax_rates" WHERE "tax_rates"."jurisdiction_id" = $1`
+	getUsageQuery        = `SELECT promotion_id, COUNT(*) AS total, SUM(CASE WHEN customer_id = $1 THEN 1 ELSE 0 END) AS customer FROM "promotion_redemptions" WHERE promotion_id IN ($2,$3) GROUP BY "promotion_id"`
+	lockPromotionsQuery  = `SELECT * FROM "promotions" WHERE code = '' FOR UPDATE`
+	getBookByIdsQuery    = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL`
+	getOrderUsageQuery   = `SELECT promotion_id, COUNT(*) AS total, SUM(CASE WHEN customer_id = $1 THEN 1 ELSE 0 END) AS customer FROM "promotion_redemptions" WHERE promotion_id IN ($2) AND order_ref <> $3 GROUP BY "promotion_id"`
+	redeemQuery          = `INSERT INTO "promotion_redemptions" ("promotion_id","customer_id","order_ref","created_at","tenant_id") VALUES ($1,$2,$3,$4,$5) ON CONFLICT ("promotion_id","order_ref") DO NOTHING RETURNING "id"`
+)
+
+func newPromotionContext(e *echo.Echo, body string) (echo.Context, *httptest.ResponseRecorder) {
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set("X-Customer-ID", "customer-1")
+	response := httptest.NewRecorder()
+	return e.NewContext(request, response), response
+}
+
+func TestCreatePromotion(t *testing.T) {
+	t.Run("create promotion given valid coupon", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newPromotionContext(e, `{"name": "Welcome", "code": " welcome10 ", "type": "percentage", "value": 10, "usage_limit_per_customer": 1}`)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(createPromotionQuery).
+			WithArgs("Welcome", "WELCOME10", TypePercentage, 10.0, 0, 0, "", "", 0.0, 0, 1, nil, nil, false, 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		err := handler.Create(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given percentage above 100", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newPromotionContext(e, `{"name": "Too good", "type": "percentage", "value": 150}`)
+
+		handler := NewHandler(nil)
+		err := handler.Create(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("return bad request in Thai given promotion without name", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newPromotionContext(e, `{"type": "percentage", "value": 10}`)
+		c.Request().Header.Set("Accept-Language", "th-TH")
+
+		handler := NewHandler(nil)
+		err := middleware.Localize([]string{"en", "th"}, "en")(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.JSONEq(t, `{"error": "ต้องระบุ name"}`, response.Body.String())
+	})
+
+	t.Run("return bad request given buy x get y without quantities", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newPromotionContext(e, `{"name": "3 for 2", "type": "buy_x_get_y"}`)
+
+		handler := NewHandler(nil)
+		err := handler.Create(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestQuote(t *testing.T) {
+	t.Run("return discount breakdown given cart with coupon", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newPromotionContext(e, `{"items": [{"book_id": 1, "quantity": 2}, {"book_id": 2, "quantity": 1}], "codes": ["fiction5"]}`)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBooksByIdsQuery).WithArgs(1, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "price", "category"}).
+				AddRow(1, "Atomic Habits", "James Clear", 15.0, "self-help").
+				AddRow(2, "The Alchemist", "Paulo Coelho", 10.0, "fiction"))
+		mock.ExpectQuery(getPromotionsQuery).WithArgs("FICTION5").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "code", "type", "value", "category"}).
+				AddRow(1, "Autumn sale", "", TypePercentage, 10.0, "").
+				AddRow(2, "Fiction five", "FICTION5", TypeFixed, 5.0, "fiction"))
+		mock.ExpectQuery(getUsageQuery).WithArgs("customer-1", 1, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"promotion_id", "total", "customer"}))
+
+		handler := NewHandler(gormDB)
+		err := middleware.RequireCustomer(handler.Quote)(c)
+
+		quote := Quote{}
+		json.Unmarshal(response.Body.Bytes(), &quote)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, 40.0, quote.Subtotal)
+		assert.Equal(t, 9.0, quote.Discount)
+		assert.Equal(t, 4.0, quote.Lines[1].Total)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("add tax to discounted lines given jurisdiction", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newPromotionContext(e, `{"items": [{"book_id": 1, "quantity": 2}, {"book_id": 2, "quantity": 1}], "jurisdiction": "us-ny"}`)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBooksByIdsQuery).WithArgs(1, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "price", "format"}).
+				AddRow(1, "Atomic Habits", "James Clear", 15.0, "").
+				AddRow(2, "The Alchemist", "Paulo Coelho", 10.0, "ebook"))
+		mock.ExpectQuery(`SELECT * FROM "promotions" WHERE code = ''`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "code", "type", "value"}).
+				AddRow(1, "Autumn sale", "", TypePercentage, 10.0))
+		mock.ExpectQuery(`SELECT promotion_id, COUNT(*) AS total, SUM(CASE WHEN customer_id = $1 THEN 1 ELSE 0 END) AS customer FROM "promotion_redemptions" WHERE promotion_id IN ($2) GROUP BY "promotion_id"`).
+			WithArgs("customer-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"promotion_id", "total", "customer"}))
+		mock.ExpectQuery(getJurisdictionQuery).WithArgs("US-NY", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "code"}).AddRow(1, "US-NY"))
+		mock.ExpectQuery(getTaxRatesQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "jurisdiction_id", "class", "rate"}).
+				AddRow(1, 1, "print", 8.875).
+				AddRow(2, 1, "ebook", 5.0))
+
+		handler := NewHandler(gormDB)
+		err := middleware.RequireCustomer(handler.Quote)(c)
+
+		quote := Quote{}
+		json.Unmarshal(response.Body.Bytes(), &quote)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, 2.4, quote.Lines[0].Tax)
+		assert.Equal(t, 0.45, quote.Lines[1].Tax)
+		assert.Equal(t, 2.85, quote.Tax)
+		assert.Equal(t, 38.85, quote.Total)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given unknown book", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newPromotionContext(e, `{"items": [{"book_id": 1, "quantity": 1}, {"book_id": 38, "quantity": 1}]}`)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBooksByIdsQuery).WithArgs(1, 38).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "price"}).AddRow(1, 15.0))
+
+		handler := NewHandler(gormDB)
+		err := middleware.RequireCustomer(handler.Quote)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+	t.Run("return bad request given quantity too large", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newPromotionContext(e, `{"items": [{"book_id": 1, "quantity": 2000000000}]}`)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		handler := NewHandler(gormDB)
+		err := middleware.RequireCustomer(handler.Quote)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRedeem(t *testing.T) {
+	t.Run("leave usage alone given order already redeemed", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newPromotionContext(e, `{"items": [{"book_id": 1, "quantity": 1}], "order_ref": "order-1"}`)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(getBookByIdsQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "price"}).AddRow(1, 15.0))
+		mock.ExpectQuery(lockPromotionsQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "type", "value", "usage_limit"}).
+				AddRow(1, "First hundred", TypePercentage, 10.0, 1))
+		mock.ExpectQuery(getOrderUsageQuery).WithArgs("customer-1", 1, "order-1").
+			WillReturnRows(sqlmock.NewRows([]string{"promotion_id", "total", "customer"}))
+		mock.ExpectQuery(redeemQuery).WithArgs(1, "customer-1", "order-1", sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		err := middleware.RequireCustomer(handler.Redeem)(c)
+
+		quote := Quote{}
+		json.Unmarshal(response.Body.Bytes(), &quote)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, 1.5, quote.Discount)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,250 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/tax"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxItemQuantity is the most copies of one book a cart can hold.
+const maxItemQuantity = 10000
+
+type CartItem struct {
+	BookID   uint `json:"book_id"`
+	Quantity int  `json:"quantity"`
+}
+
+type QuoteRequest struct {
+	Items        []CartItem `json:"items"`
+	Codes        []string   `json:"codes"`
+	OrderRef     string     `json:"order_ref"`
+	Jurisdiction string     `json:"jurisdiction"`
+}
+
+type errInvalidCart struct {
+	message string
+}
+
+func (err errInvalidCart) Error() string {
+	return err.message
+}
+
+// cart loads the books in the request and turns them into engine lines.
+func cart(db *gorm.DB, customerID string, request QuoteRequest) (Cart, error) {
+	if len(request.Items) == 0 {
+		return Cart{}, errInvalidCart{"items must not be empty"}
+	}
+
+	ids := make([]uint, 0, len(request.Items))
+	for _, item := range request.Items {
+		if item.Quantity < 1 || item.Quantity > maxItemQuantity {
+			return Cart{}, errInvalidCart{fmt.Sprintf("quantity of book %d must be between 1 and %d", item.BookID, maxItemQuantity)}
+		}
+		ids = append(ids, item.BookID)
+	}
+
+	books := []book.Book{}
+	if err := db.Find(&books, ids).Error; err != nil {
+		return Cart{}, err
+	}
+	byID := make(map[uint]book.Book, len(books))
+	for _, found := range books {
+		byID[found.ID] = found
+	}
+
+	result := Cart{CustomerID: customerID, Codes: request.Codes}
+	for _, item := range request.Items {
+		found, ok := byID[item.BookID]
+		if !ok {
+			return Cart{}, errInvalidCart{fmt.Sprintf("book %d not found", item.BookID)}
+		}
+		result.Lines = append(result.Lines, Line{
+			BookID:    found.ID,
+			Quantity:  item.Quantity,
+			UnitPrice: toCents(found.Price),
+			Category:  found.Category,
+			Authors:   found.Authors(),
+			TaxClass:  tax.ClassOf(found.Format),
+		})
+	}
+	return result, nil
+}
+
+// evaluate loads the promotions that may apply to the cart along with their
+// usage and runs the engine. Passing lock holds the promotion rows until the
+// transaction ends, so concurrent redemptions cannot exceed usage limits.
+func (handler *handler) evaluate(db *gorm.DB, customerID string, request QuoteRequest, lock bool) (Quote, error) {
+	cart, err := cart(db, customerID, request)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	codes := []string{}
+	for _, code := range request.Codes {
+		if code = normalizeCode(code); code != "" {
+			codes = append(codes, code)
+		}
+	}
+
+	query := db.Where("code = ''")
+	if len(codes) > 0 {
+		query = db.Where("code = '' OR code IN ?", codes)
+	}
+	if lock {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	promotions := []Promotion{}
+	if err := query.Find(&promotions).Error; err != nil {
+		return Quote{}, err
+	}
+
+	usage, err := promotionUsage(db, customerID, request.OrderRef, promotions)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	quote := Evaluate(cart, promotions, usage, handler.now())
+	if request.Jurisdiction == "" {
+		return quote, nil
+	}
+
+	jurisdiction, err := tax.Lookup(db, request.Jurisdiction)
+	if errors.Is(err, tax.ErrUnknownJurisdiction) {
+		return Quote{}, errInvalidCart{fmt.Sprintf("tax jurisdiction %s not found", request.Jurisdiction)}
+	}
+	if err != nil {
+		return Quote{}, err
+	}
+	applyTax(&quote, cart, jurisdiction)
+	return quote, nil
+}
+
+// applyTax taxes each line on its discounted total. When prices exclude tax
+// the tax is added to the line and quote totals.
+func applyTax(quote *Quote, cart Cart, jurisdiction tax.Jurisdiction) {
+	lines := make([]tax.Line, len(quote.Lines))
+	for i, line := range quote.Lines {
+		lines[i] = tax.Line{BookID: line.BookID, Class: cart.Lines[i].TaxClass, Amount: toCents(line.Total)}
+	}
+	result := tax.Calculate(jurisdiction, lines)
+
+	for i, taxed := range result.Lines {
+		quote.Lines[i].TaxClass = taxed.Class
+		quote.Lines[i].TaxRate = taxed.Rate
+		quote.Lines[i].Tax = fromCents(taxed.Tax)
+		quote.Lines[i].Total = fromCents(taxed.Gross)
+	}
+	quote.Jurisdiction = result.Jurisdiction
+	quote.PricesIncludeTax = result.PricesIncludeTax
+	quote.Tax = fromCents(result.Tax)
+	quote.Total = fromCents(result.Gross)
+}
+
+// promotionUsage counts the redemptions of the promotions. Those of orderRef
+// are left out, so redeeming an order again is priced as the first time.
+func promotionUsage(db *gorm.DB, customerID string, orderRef string, promotions []Promotion) (map[uint]Usage, error) {
+	usage := map[uint]Usage{}
+	if len(promotions) == 0 {
+		return usage, nil
+	}
+
+	ids := make([]uint, len(promotions))
+	for i, promotion := range promotions {
+		ids[i] = promotion.ID
+	}
+
+	rows := []struct {
+		PromotionID uint
+		Total       int
+		Customer    int
+	}{}
+	query := db.Model(&Redemption{}).
+		Select("promotion_id, COUNT(*) AS total, SUM(CASE WHEN customer_id = ? THEN 1 ELSE 0 END) AS customer", customerID).
+		Where("promotion_id IN ?", ids)
+	if orderRef != "" {
+		query = query.Where("order_ref <> ?", orderRef)
+	}
+	err := query.Group("promotion_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		usage[row.PromotionID] = Usage{Total: row.Total, Customer: row.Customer}
+	}
+	return usage, nil
+}
+
+func (handler *handler) quoteError(c echo.Context, err error) error {
+	var invalid errInvalidCart
+	if errors.As(err, &invalid) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": invalid.Error()})
+	}
+	middleware.GetLogger(c).Error("failed to evaluate promotions", zap.Error(err))
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+// Quote prices a cart with the promotions that apply to it without using
+// them up.
+func (handler *handler) Quote(c echo.Context) error {
+	request := QuoteRequest{}
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	quote, err := handler.evaluate(handler.db.WithContext(c.Request().Context()), middleware.GetCustomerID(c), request, false)
+	if err != nil {
+		return handler.quoteError(c, err)
+	}
+	return c.JSON(http.StatusOK, quote)
+}
+
+// Redeem prices an order like Quote and records a redemption for every
+// promotion applied to it, which counts towards the usage limits. Redeeming
+// an order again records nothing new for the promotions it already used.
+func (handler *handler) Redeem(c echo.Context) error {
+	request := QuoteRequest{}
+	logger := middleware.GetLogger(c)
+	customerID := middleware.GetCustomerID(c)
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if request.OrderRef == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "order_ref is required"})
+	}
+
+	quote := Quote{}
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if quote, err = handler.evaluate(tx, customerID, request, true); err != nil {
+			return err
+		}
+		for _, applied := range quote.Applied {
+			redemption := Redemption{PromotionID: applied.PromotionID, CustomerID: customerID, OrderRef: request.OrderRef}
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "promotion_id"}, {Name: "order_ref"}},
+				DoNothing: true,
+			}).Create(&redemption).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return handler.quoteError(c, err)
+	}
+
+	logger.Info("promotions redeemed", zap.String("order_ref", request.OrderRef), zap.Int("applied", len(quote.Applied)))
+	return c.JSON(http.StatusCreated, quote)
+}
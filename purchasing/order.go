@@ -0,0 +1,357 @@
+package purchasing
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	StatusDraft             = "draft"
+	StatusSent              = "sent"
+	StatusPartiallyReceived = "partially_received"
+	StatusReceived          = "received"
+)
+
+// PurchaseOrder is an order for books placed with a supplier. It is edited
+// as a draft, sent, and then received in one or more deliveries.
+type PurchaseOrder struct {
+	ID         uint        `json:"id" gorm:"primaryKey"`
+	SupplierID uint        `json:"supplier_id" gorm:"not null;index" validate:"required"`
+	Status     string      `json:"status" gorm:"not null;index"`
+	Note       string      `json:"note"`
+	Lines      []OrderLine `json:"lines" gorm:"constraint:OnDelete:CASCADE" validate:"min=1,dive"`
+	SentAt     *time.Time  `json:"sent_at"`
+	ReceivedAt *time.Time  `json:"received_at"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+	TenantID   string      `json:"-" gorm:"not null;default:'default';index"`
+}
+
+// OrderLine is a book ordered from the supplier. CostPrice defaults to the
+// supplier's cost price for the book.
+type OrderLine struct {
+	ID              uint    `json:"-" gorm:"primaryKey"`
+	PurchaseOrderID uint    `json:"-" gorm:"not null;index"`
+	BookID          uint    `json:"book_id" gorm:"not null" validate:"required"`
+	Quantity        int     `json:"quantity" validate:"min=1"`
+	Received        int     `json:"received"`
+	CostPrice       float64 `json:"cost_price" validate:"gte=0"`
+	TenantID        string  `json:"-" gorm:"not null;default:'default';index"`
+}
+
+func (OrderLine) TableName() string {
+	return "purchase_order_lines"
+}
+
+type ReceiptLine struct {
+	BookID   uint `json:"book_id" validate:"required"`
+	Quantity int  `json:"quantity" validate:"min=1"`
+}
+
+type ReceiptRequest struct {
+	Lines []ReceiptLine `json:"lines" validate:"min=1,dive"`
+}
+
+func (order PurchaseOrder) validateLines() error {
+	seen := map[uint]bool{}
+	for _, line := range order.Lines {
+		if seen[line.BookID] {
+			return fmt.Errorf("book %d is listed more than once", line.BookID)
+		}
+		seen[line.BookID] = true
+	}
+	return nil
+}
+
+// reference identifies the purchase order on stock movements.
+func (order PurchaseOrder) reference() string {
+	return fmt.Sprintf("PO-%d", order.ID)
+}
+
+type errInvalidOrder struct {
+	message string
+}
+
+func (err errInvalidOrder) Error() string {
+	return err.message
+}
+
+// prepare checks that the supplier supplies every book of the order and
+// fills in the supplier's cost price where the line has none.
+func prepare(db *gorm.DB, order *PurchaseOrder) error {
+	supplier := Supplier{}
+	if err := db.Preload("Books").First(&supplier, order.SupplierID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidOrder{fmt.Sprintf("supplier %d not found", order.SupplierID)}
+		}
+		return err
+	}
+	supplied := make(map[uint]SupplierBook, len(supplier.Books))
+	for _, entry := range supplier.Books {
+		supplied[entry.BookID] = entry
+	}
+
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		entry, ok := supplied[line.BookID]
+		if !ok {
+			return errInvalidOrder{fmt.Sprintf("book %d is not supplied by %s", line.BookID, supplier.Name)}
+		}
+		line.ID, line.PurchaseOrderID, line.Received = 0, order.ID, 0
+		if line.CostPrice == 0 {
+			line.CostPrice = entry.CostPrice
+		}
+	}
+	return nil
+}
+
+var errNotSent = errors.New("only sent purchase orders can be received")
+
+func orderError(c echo.Context, err error) error {
+	var invalid errInvalidOrder
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Purchase order not found"})
+	case errors.As(err, &invalid):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+func findOrder(db *gorm.DB, id string) (PurchaseOrder, error) {
+	order := PurchaseOrder{}
+	err := db.Preload("Lines").First(&order, id).Error
+	return order, err
+}
+
+func (handler *handler) CreateOrder(c echo.Context) error {
+	order := PurchaseOrder{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&order); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	order.ID, order.Status, order.SentAt, order.ReceivedAt = 0, StatusDraft, nil, nil
+	if err := c.Validate(order); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+	if err := prepare(handler.db.WithContext(c.Request().Context()), &order); err != nil {
+		return orderError(c, err)
+	}
+
+	if result := handler.db.WithContext(c.Request().Context()).Create(&order); result.Error != nil {
+		logger.Error("failed to insert purchase order", zap.Uint("supplier_id", order.SupplierID), zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+
+	logger.Info("purchase order created", zap.Uint("id", order.ID), zap.Uint("supplier_id", order.SupplierID))
+	return c.JSON(http.StatusCreated, order)
+}
+
+// GetOrders lists purchase orders, optionally only those with a status.
+func (handler *handler) GetOrders(c echo.Context) error {
+	orders := []PurchaseOrder{}
+	query := handler.db.WithContext(c.Request().Context()).Preload("Lines").Order("id")
+	if status := c.QueryParam("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if result := query.Find(&orders); result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+	return c.JSON(http.StatusOK, orders)
+}
+
+func (handler *handler) GetOrder(c echo.Context) error {
+	order, err := findOrder(handler.db.WithContext(c.Request().Context()), c.Param("id"))
+	if err != nil {
+		return orderError(c, err)
+	}
+	return c.JSON(http.StatusOK, order)
+}
+
+// UpdateOrder replaces the supplier, note and lines of a draft.
+func (handler *handler) UpdateOrder(c echo.Context) error {
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	order, err := findOrder(handler.db.WithContext(c.Request().Context()), c.Param("id"))
+	if err != nil {
+		return orderError(c, err)
+	}
+	if order.Status != StatusDraft {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Only draft purchase orders can be changed"})
+	}
+
+	orderID, createdAt := order.ID, order.CreatedAt
+	order.Lines = nil
+	if err := c.Bind(&order); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	order.ID, order.Status, order.CreatedAt = orderID, StatusDraft, createdAt
+	order.SentAt, order.ReceivedAt = nil, nil
+	if err := c.Validate(order); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+	if err := prepare(handler.db.WithContext(c.Request().Context()), &order); err != nil {
+		return orderError(c, err)
+	}
+
+	err = handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("purchase_order_id = ?", order.ID).Delete(&OrderLine{}).Error; err != nil {
+			return err
+		}
+		return tx.Save(&order).Error
+	})
+	if err != nil {
+		logger.Error("failed to update purchase order", zap.Uint("id", order.ID), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update purchase order"})
+	}
+	return c.JSON(http.StatusOK, order)
+}
+
+func (handler *handler) DeleteOrder(c echo.Context) error {
+	result := handler.db.WithContext(c.Request().Context()).Where("status = ?", StatusDraft).Delete(&PurchaseOrder{}, c.Param("id"))
+	if result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Draft purchase order not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Purchase order successfully deleted"})
+}
+
+// Send marks a draft as sent to the supplier. It cannot be changed after.
+func (handler *handler) Send(c echo.Context) error {
+	logger := middleware.GetLogger(c)
+
+	order, err := findOrder(handler.db.WithContext(c.Request().Context()), c.Param("id"))
+	if err != nil {
+		return orderError(c, err)
+	}
+	if order.Status != StatusDraft {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Purchase order has already been sent"})
+	}
+
+	now := handler.now()
+	order.Status, order.SentAt = StatusSent, &now
+	if result := handler.db.WithContext(c.Request().Context()).Omit("Lines").Save(&order); result.Error != nil {
+		logger.Error("failed to send purchase order", zap.Uint("id", order.ID), zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send purchase order"})
+	}
+
+	logger.Info("purchase order sent", zap.Uint("id", order.ID), zap.Uint("supplier_id", order.SupplierID))
+	return c.JSON(http.StatusOK, order)
+}
+
+// Receive books a delivery against a sent purchase order. Every book
+// received is added to stock with a stock movement. The order is received
+// once every line has been delivered in full.
+func (handler *handler) Receive(c echo.Context) error {
+	request := ReceiptRequest{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	order := PurchaseOrder{}
+	restocked := []book.Book{}
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, c.Param("id")).Error; err != nil {
+			return err
+		}
+		if order.Status != StatusSent && order.Status != StatusPartiallyReceived {
+			return errNotSent
+		}
+		if err := tx.Where("purchase_order_id = ?", order.ID).Order("id").Find(&order.Lines).Error; err != nil {
+			return err
+		}
+
+		lines := make(map[uint]*OrderLine, len(order.Lines))
+		for i := range order.Lines {
+			lines[order.Lines[i].BookID] = &order.Lines[i]
+		}
+		for _, received := range request.Lines {
+			line, ok := lines[received.BookID]
+			if !ok {
+				return errInvalidOrder{fmt.Sprintf("book %d is not on the purchase order", received.BookID)}
+			}
+			if outstanding := line.Quantity - line.Received; received.Quantity > outstanding {
+				return errInvalidOrder{fmt.Sprintf("only %d of book %d are still to be received", outstanding, received.BookID)}
+			}
+			line.Received += received.Quantity
+			if err := tx.Save(line).Error; err != nil {
+				return err
+			}
+			found, restock, err := book.MoveStock(tx, received.BookID, received.Quantity, book.MovementPurchaseOrder, order.reference())
+			if err != nil {
+				return err
+			}
+			if restock {
+				restocked = append(restocked, found)
+			}
+		}
+
+		order.Status = StatusReceived
+		for _, line := range order.Lines {
+			if line.Received < line.Quantity {
+				order.Status = StatusPartiallyReceived
+			}
+		}
+		if order.Status == StatusReceived {
+			now := handler.now()
+			order.ReceivedAt = &now
+		}
+		return tx.Omit("Lines").Save(&order).Error
+	})
+	if err != nil {
+		var invalid errInvalidOrder
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound) && order.ID == 0:
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Purchase order not found"})
+		case errors.Is(err, errNotSent):
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		case errors.As(err, &invalid):
+			return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		}
+		logger.Error("failed to receive purchase order", zap.String("id", c.Param("id")), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	if handler.onRestock != nil {
+		for _, found := range restocked {
+			handler.onRestock(found)
+		}
+	}
+
+	logger.Info("purchase order received", zap.Uint("id", order.ID), zap.String("status", order.Status))
+	return c.JSON(http.StatusOK, order)
+}
+
+// GetStockMovements lists the stock movements of a book, newest first.
+func (handler *handler) GetStockMovements(c echo.Context) error {
+	movements := []book.StockMovement{}
+	if result := handler.db.WithContext(c.Request().Context()).Where("book_id = ?", c.Param("id")).Order("id DESC").Find(&movements); result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+	return c.JSON(http.StatusOK, movements)
+}
@@ -0,0 +1,290 @@
+package purchasing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	getSupplierQuery            = `SELECT * FROM "suppliers" WHERE "suppliers"."id" = $1 ORDER BY "suppliers"."id" LIMIT $2`
+	getSupplierBooksQuery       = `SELECT * FROM "supplier_books" WHERE "supplier_books"."supplier_id" = $1`
+	getTenantSupplierQuery      = `SELECT * FROM "suppliers" WHERE "suppliers"."id" = $1 AND "suppliers"."tenant_id" = $2 ORDER BY "suppliers"."id" LIMIT $3`
+	getTenantSupplierBooksQuery = `SELECT * FROM "supplier_books" WHERE "supplier_books"."supplier_id" = $1 AND "supplier_books"."tenant_id" = $2`
+	createOrderQuery            = `INSERT INTO "purchase_orders" ("supplier_id","status","note","sent_at","received_at","created_at","updated_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`
+	createOrderLinesQuery       = `INSERT INTO "purchase_order_lines" ("purchase_order_id","book_id","quantity","received","cost_price","tenant_id") VALUES ($1,$2,$3,$4,$5,$6) ON CONFLICT ("id") DO UPDATE SET "purchase_order_id"="excluded"."purchase_order_id" RETURNING "id"`
+	lockOrderQuery              = `SELECT * FROM "purchase_orders" WHERE "purchase_orders"."id" = $1 ORDER BY "purchase_orders"."id" LIMIT $2 FOR UPDATE`
+	getOrderLinesQuery          = `SELECT * FROM "purchase_order_lines" WHERE purchase_order_id = $1 ORDER BY id`
+	updateOrderLineQuery        = `UPDATE "purchase_order_lines" SET "purchase_order_id"=$1,"book_id"=$2,"quantity"=$3,"received"=$4,"cost_price"=$5,"tenant_id"=$6 WHERE "id" = $7`
+	lockBookQuery               = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2 FOR UPDATE`
+	updateStockQuery            = `UPDATE "books" SET "stock"=$1,"updated_at"=$2 WHERE "books"."deleted_at" IS NULL AND "id" = $3`
+	createStockMovementQuery    = `INSERT INTO "stock_movements" ("book_id","quantity","reason","reference","created_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`
+	updateOrderQuery            = `UPDATE "purchase_orders" SET "supplier_id"=$1,"status"=$2,"note"=$3,"sent_at"=$4,"received_at"=$5,"created_at"=$6,"updated_at"=$7,"tenant_id"=$8 WHERE "id" = $9`
+)
+
+func expectSupplier(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(getSupplierQuery).WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(3, "Nanmee Books"))
+	mock.ExpectQuery(getSupplierBooksQuery).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "supplier_id", "book_id", "sku", "cost_price", "lead_time_days"}).AddRow(1, 3, 1, "NB-1", 150.0, 7))
+}
+
+func TestCreateOrder(t *testing.T) {
+	t.Run("create draft with supplier cost price given no cost price", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"supplier_id": 3, "status": "received", "lines": [{"book_id": 1, "quantity": 20}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectSupplier(mock)
+		mock.ExpectBegin()
+		mock.ExpectQuery(createOrderQuery).WithArgs(3, StatusDraft, "", nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(createOrderLinesQuery).WithArgs(1, 1, 20, 0, 150.0, "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, nil)
+		err := handler.CreateOrder(c)
+
+		order := PurchaseOrder{}
+		json.Unmarshal(response.Body.Bytes(), &order)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, StatusDraft, order.Status)
+		assert.Equal(t, 150.0, order.Lines[0].CostPrice)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given book the supplier does not supply", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"supplier_id": 3, "lines": [{"book_id": 2, "quantity": 20}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectSupplier(mock)
+
+		handler := NewHandler(gormDB, nil)
+		err := handler.CreateOrder(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.Contains(t, response.Body.String(), "book 2 is not supplied by Nanmee Books")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("create draft in the request's storefront given tenant plugin", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"supplier_id": 3, "lines": [{"book_id": 1, "quantity": 20}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request = request.WithContext(tenant.NewContext(request.Context(), "th"))
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		assert.NoError(t, gormDB.Use(tenant.Plugin{}))
+
+		mock.ExpectQuery(getTenantSupplierQuery).WithArgs(3, "th", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "tenant_id"}).AddRow(3, "Nanmee Books", "th"))
+		mock.ExpectQuery(getTenantSupplierBooksQuery).WithArgs(3, "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "supplier_id", "book_id", "sku", "cost_price", "lead_time_days", "tenant_id"}).AddRow(1, 3, 1, "NB-1", 150.0, 7, "th"))
+		mock.ExpectBegin()
+		mock.ExpectQuery(createOrderQuery).WithArgs(3, StatusDraft, "", nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(createOrderLinesQuery).WithArgs(1, 1, 20, 0, 150.0, "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, nil)
+		err := handler.CreateOrder(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReceiveOrder(t *testing.T) {
+	t.Run("receive part of order into stock given sent order", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"lines": [{"book_id": 1, "quantity": 5}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockOrderQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "supplier_id", "status"}).AddRow(1, 3, StatusSent))
+		mock.ExpectQuery(getOrderLinesQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "purchase_order_id", "book_id", "quantity", "received", "cost_price"}).AddRow(1, 1, 1, 20, 0, 150.0))
+		mock.ExpectExec(updateOrderLineQuery).WithArgs(1, 1, 20, 5, 150.0, "", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(lockBookQuery).WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "stock"}).AddRow(1, "Dune", 0))
+		mock.ExpectExec(updateStockQuery).WithArgs(5, sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(createStockMovementQuery).WithArgs(1, 5, book.MovementPurchaseOrder, "PO-1", sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(updateOrderQuery).
+			WithArgs(3, StatusPartiallyReceived, "", nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		restocked := []book.Book{}
+		handler := NewHandler(gormDB, func(found book.Book) { restocked = append(restocked, found) })
+		err := handler.Receive(c)
+
+		order := PurchaseOrder{}
+		json.Unmarshal(response.Body.Bytes(), &order)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, StatusPartiallyReceived, order.Status)
+		assert.Equal(t, 5, order.Lines[0].Received)
+		assert.Len(t, restocked, 1)
+		assert.Equal(t, 5, restocked[0].Stock)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("mark order received given last books delivered", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"lines": [{"book_id": 1, "quantity": 15}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		now := time.Date(2024, 10, 1, 9, 0, 0, 0, time.UTC)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockOrderQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "supplier_id", "status"}).AddRow(1, 3, StatusPartiallyReceived))
+		mock.ExpectQuery(getOrderLinesQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "purchase_order_id", "book_id", "quantity", "received", "cost_price"}).AddRow(1, 1, 1, 20, 5, 150.0))
+		mock.ExpectExec(updateOrderLineQuery).WithArgs(1, 1, 20, 20, 150.0, "", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(lockBookQuery).WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "stock"}).AddRow(1, "Dune", 3))
+		mock.ExpectExec(updateStockQuery).WithArgs(18, sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(createStockMovementQuery).WithArgs(1, 15, book.MovementPurchaseOrder, "PO-1", sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		mock.ExpectExec(updateOrderQuery).
+			WithArgs(3, StatusReceived, "", nil, now, sqlmock.AnyArg(), sqlmock.AnyArg(), "", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		restocked := []book.Book{}
+		handler := NewHandler(gormDB, func(found book.Book) { restocked = append(restocked, found) })
+		handler.now = func() time.Time { return now }
+		err := handler.Receive(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Empty(t, restocked)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return unprocessable entity given more than outstanding", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"lines": [{"book_id": 1, "quantity": 16}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockOrderQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "supplier_id", "status"}).AddRow(1, 3, StatusPartiallyReceived))
+		mock.ExpectQuery(getOrderLinesQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "purchase_order_id", "book_id", "quantity", "received"}).AddRow(1, 1, 1, 20, 5))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, nil)
+		err := handler.Receive(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given draft order", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"lines": [{"book_id": 1, "quantity": 1}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockOrderQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "supplier_id", "status"}).AddRow(1, 3, StatusDraft))
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, nil)
+		err := handler.Receive(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,189 @@
+package purchasing
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/shipping"
+)
+
+const (
+	defaultSalesDays = 30
+	defaultCoverDays = 30
+	maxReportDays    = 365
+)
+
+// Suggestion is a book that should be reordered, with how many to order
+// and from which supplier.
+type Suggestion struct {
+	BookID            uint    `json:"book_id"`
+	Title             string  `json:"title"`
+	Stock             int     `json:"stock"`
+	LowStockThreshold int     `json:"low_stock_threshold"`
+	OnOrder           int     `json:"on_order"`
+	DailySales        float64 `json:"daily_sales"`
+	SupplierID        uint    `json:"supplier_id,omitempty"`
+	SKU               string  `json:"sku,omitempty"`
+	CostPrice         float64 `json:"cost_price,omitempty"`
+	LeadTimeDays      int     `json:"lead_time_days"`
+	ReorderPoint      int     `json:"reorder_point"`
+	SuggestedQuantity int     `json:"suggested_quantity"`
+}
+
+// Candidate is what is known about a book when deciding whether to
+// reorder it. Sold is the number sold over the sales window.
+type Candidate struct {
+	Book     book.Book
+	Sold     int
+	OnOrder  int
+	Supplier *SupplierBook
+}
+
+// Suggest decides which candidates to reorder. A book is reordered when its
+// stock and what is already on order fall to its reorder point: the low
+// stock threshold plus the sales expected before a new order arrives. It
+// is then topped up to cover coverDays of sales above the reorder point.
+func Suggest(candidates []Candidate, salesDays int, coverDays int) []Suggestion {
+	suggestions := []Suggestion{}
+	for _, candidate := range candidates {
+		daily := float64(candidate.Sold) / float64(salesDays)
+		suggestion := Suggestion{
+			BookID:            candidate.Book.ID,
+			Title:             candidate.Book.Title,
+			Stock:             candidate.Book.Stock,
+			LowStockThreshold: candidate.Book.LowStockThreshold,
+			OnOrder:           candidate.OnOrder,
+			DailySales:        math.Round(daily*100) / 100,
+		}
+		if supplier := candidate.Supplier; supplier != nil {
+			suggestion.SupplierID, suggestion.SKU = supplier.SupplierID, supplier.SKU
+			suggestion.CostPrice, suggestion.LeadTimeDays = supplier.CostPrice, supplier.LeadTimeDays
+		}
+
+		suggestion.ReorderPoint = suggestion.LowStockThreshold + int(math.Ceil(daily*float64(suggestion.LeadTimeDays)))
+		available := suggestion.Stock + suggestion.OnOrder
+		if available > suggestion.ReorderPoint || (suggestion.LowStockThreshold == 0 && candidate.Sold == 0) {
+			continue
+		}
+		suggestion.SuggestedQuantity = max(suggestion.ReorderPoint+int(math.Ceil(daily*float64(coverDays)))-available, 1)
+		suggestions = append(suggestions, suggestion)
+	}
+	sort.Slice(suggestions, func(i, j int) bool { return suggestions[i].BookID < suggestions[j].BookID })
+	return suggestions
+}
+
+// cheapest picks the supplier with the lowest cost price for each book,
+// then the shortest lead time.
+func cheapest(supplied []SupplierBook) map[uint]*SupplierBook {
+	best := map[uint]*SupplierBook{}
+	for i := range supplied {
+		entry := &supplied[i]
+		current, ok := best[entry.BookID]
+		if !ok || entry.CostPrice < current.CostPrice ||
+			(entry.CostPrice == current.CostPrice && entry.LeadTimeDays < current.LeadTimeDays) {
+			best[entry.BookID] = entry
+		}
+	}
+	return best
+}
+
+type bookQuantity struct {
+	BookID   uint
+	Quantity int
+}
+
+func dayParam(c echo.Context, name string, defaultValue int) (int, bool) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return defaultValue, true
+	}
+	days, err := strconv.Atoi(value)
+	return days, err == nil && days >= 1 && days <= maxReportDays
+}
+
+// ReorderSuggestions lists the books to reorder. Sales velocity is the
+// number of books shipped over the last days query parameter, 30 by
+// default, and cover_days sets how many days of sales an order should last.
+func (handler *handler) ReorderSuggestions(c echo.Context) error {
+	salesDays, ok := dayParam(c, "days", defaultSalesDays)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "days must be between 1 and 365"})
+	}
+	coverDays, ok := dayParam(c, "cover_days", defaultCoverDays)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "cover_days must be between 1 and 365"})
+	}
+
+	sold := []bookQuantity{}
+	since := handler.now().Add(-time.Duration(salesDays) * 24 * time.Hour)
+	err := handler.db.WithContext(c.Request().Context()).Model(&shipping.ShipmentLine{}).
+		Select("shipment_lines.book_id, SUM(shipment_lines.quantity) AS quantity").
+		Joins("JOIN shipments ON shipments.id = shipment_lines.shipment_id AND shipments.tenant_id = ?", middleware.GetTenantID(c)).
+		Where("shipments.shipped_at >= ?", since).
+		Group("shipment_lines.book_id").
+		Scan(&sold).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	onOrder := []bookQuantity{}
+	err = handler.db.WithContext(c.Request().Context()).Model(&OrderLine{}).
+		Select("purchase_order_lines.book_id, SUM(purchase_order_lines.quantity - purchase_order_lines.received) AS quantity").
+		Joins("JOIN purchase_orders ON purchase_orders.id = purchase_order_lines.purchase_order_id").
+		Where("purchase_orders.status IN ?", []string{StatusSent, StatusPartiallyReceived}).
+		Group("purchase_order_lines.book_id").
+		Scan(&onOrder).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	soldByBook := map[uint]int{}
+	ids := map[uint]bool{}
+	for _, line := range sold {
+		soldByBook[line.BookID] = line.Quantity
+		ids[line.BookID] = true
+	}
+	onOrderByBook := map[uint]int{}
+	for _, line := range onOrder {
+		onOrderByBook[line.BookID] = line.Quantity
+	}
+
+	books := []book.Book{}
+	query := handler.db.WithContext(c.Request().Context()).Where("low_stock_threshold > 0")
+	if len(ids) > 0 {
+		query = query.Or("id IN ?", keys(ids))
+	}
+	if err := query.Order("id").Find(&books).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if len(books) == 0 {
+		return c.JSON(http.StatusOK, []Suggestion{})
+	}
+
+	bookIDs := make([]uint, len(books))
+	for i, found := range books {
+		bookIDs[i] = found.ID
+	}
+	supplied := []SupplierBook{}
+	if err := handler.db.WithContext(c.Request().Context()).Where("book_id IN ?", bookIDs).Find(&supplied).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	suppliers := cheapest(supplied)
+
+	candidates := make([]Candidate, len(books))
+	for i, found := range books {
+		candidates[i] = Candidate{
+			Book:     found,
+			Sold:     soldByBook[found.ID],
+			OnOrder:  onOrderByBook[found.ID],
+			Supplier: suppliers[found.ID],
+		}
+	}
+	return c.JSON(http.StatusOK, Suggest(candidates, salesDays, coverDays))
+}
@@ -0,0 +1,76 @@
+package purchasing
+
+import (
+	"testing"
+
+	"github.com/phetployst/book-store-api/book"
+	"github.com/stretchr/testify/assert"
+)
+
+func stocked(id uint, stock int, threshold int) book.Book {
+	found := book.Book{Title: "Book", Stock: stock, LowStockThreshold: threshold}
+	found.ID = id
+	return found
+}
+
+func TestSuggest(t *testing.T) {
+	t.Run("cover lead time and cover days given book selling every day", func(t *testing.T) {
+		supplier := &SupplierBook{SupplierID: 3, BookID: 1, SKU: "SUP-1", CostPrice: 120, LeadTimeDays: 7}
+
+		got := Suggest([]Candidate{{Book: stocked(1, 18, 5), Sold: 60, Supplier: supplier}}, 30, 30)
+
+		assert.Equal(t, []Suggestion{{
+			BookID:            1,
+			Title:             "Book",
+			Stock:             18,
+			LowStockThreshold: 5,
+			DailySales:        2,
+			SupplierID:        3,
+			SKU:               "SUP-1",
+			CostPrice:         120,
+			LeadTimeDays:      7,
+			ReorderPoint:      19,
+			SuggestedQuantity: 61,
+		}}, got)
+	})
+
+	t.Run("skip book given stock above reorder point", func(t *testing.T) {
+		got := Suggest([]Candidate{{Book: stocked(1, 30, 5), Sold: 60}}, 30, 30)
+
+		assert.Empty(t, got)
+	})
+
+	t.Run("count stock on order given open purchase order", func(t *testing.T) {
+		got := Suggest([]Candidate{{Book: stocked(1, 2, 5), OnOrder: 10}}, 30, 30)
+
+		assert.Empty(t, got)
+	})
+
+	t.Run("order at least one given threshold reached without sales", func(t *testing.T) {
+		got := Suggest([]Candidate{{Book: stocked(1, 5, 5)}}, 30, 30)
+
+		assert.Len(t, got, 1)
+		assert.Equal(t, 1, got[0].SuggestedQuantity)
+		assert.Zero(t, got[0].SupplierID)
+	})
+
+	t.Run("skip book given no threshold and no sales", func(t *testing.T) {
+		got := Suggest([]Candidate{{Book: stocked(1, 0, 0)}}, 30, 30)
+
+		assert.Empty(t, got)
+	})
+}
+
+func TestCheapest(t *testing.T) {
+	t.Run("prefer lower cost then shorter lead time given several suppliers", func(t *testing.T) {
+		got := cheapest([]SupplierBook{
+			{SupplierID: 1, BookID: 1, CostPrice: 100, LeadTimeDays: 10},
+			{SupplierID: 2, BookID: 1, CostPrice: 90, LeadTimeDays: 14},
+			{SupplierID: 3, BookID: 1, CostPrice: 90, LeadTimeDays: 5},
+			{SupplierID: 1, BookID: 2, CostPrice: 50},
+		})
+
+		assert.Equal(t, uint(3), got[1].SupplierID)
+		assert.Equal(t, uint(1), got[2].SupplierID)
+	})
+}
@@ -0,0 +1,243 @@
+package purchasing
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Supplier is a publisher or distributor books are bought from.
+type Supplier struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	Name      string         `json:"name" gorm:"not null" validate:"required"`
+	Email     string         `json:"email" validate:"omitempty,email"`
+	Phone     string         `json:"phone"`
+	Books     []SupplierBook `json:"books" gorm:"constraint:OnDelete:CASCADE" validate:"dive"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	TenantID  string         `json:"-" gorm:"not null;default:'default';index"`
+}
+
+// SupplierBook is a book a supplier sells, under the supplier's own SKU, at
+// CostPrice, arriving LeadTimeDays after it is ordered.
+type SupplierBook struct {
+	ID           uint    `json:"-" gorm:"primaryKey"`
+	SupplierID   uint    `json:"-" gorm:"not null;uniqueIndex:idx_supplier_book"`
+	BookID       uint    `json:"book_id" gorm:"not null;uniqueIndex:idx_supplier_book;index" validate:"required"`
+	SKU          string  `json:"sku"`
+	CostPrice    float64 `json:"cost_price" validate:"gte=0"`
+	LeadTimeDays int     `json:"lead_time_days" validate:"gte=0"`
+	TenantID     string  `json:"-" gorm:"not null;default:'default';index"`
+}
+
+func (SupplierBook) TableName() string {
+	return "supplier_books"
+}
+
+func (supplier Supplier) validateBooks() error {
+	seen := map[uint]bool{}
+	for _, supplied := range supplier.Books {
+		if seen[supplied.BookID] {
+			return fmt.Errorf("book %d is listed more than once", supplied.BookID)
+		}
+		seen[supplied.BookID] = true
+	}
+	return nil
+}
+
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+func (c *CustomValidator) Validate(i interface{}) error {
+	if err := c.validator.Struct(i); err != nil {
+		return err
+	}
+	switch value := i.(type) {
+	case Supplier:
+		return value.validateBooks()
+	case PurchaseOrder:
+		return value.validateLines()
+	}
+	return nil
+}
+
+type handler struct {
+	db        *gorm.DB
+	onRestock book.RestockFunc
+	now       func() time.Time
+}
+
+func NewHandler(db *gorm.DB, onRestock book.RestockFunc) *handler {
+	return &handler{db: db, onRestock: onRestock, now: time.Now}
+}
+
+// booksExist reports whether every book in ids exists.
+func booksExist(db *gorm.DB, ids map[uint]bool) (bool, error) {
+	if len(ids) == 0 {
+		return true, nil
+	}
+	var found int64
+	if err := db.Model(&book.Book{}).Where("id IN ?", keys(ids)).Count(&found).Error; err != nil {
+		return false, err
+	}
+	return int(found) == len(ids), nil
+}
+
+func (handler *handler) CreateSupplier(c echo.Context) error {
+	supplier := Supplier{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&supplier); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	supplier.ID = 0
+	if err := c.Validate(supplier); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	ids := map[uint]bool{}
+	for i := range supplier.Books {
+		supplier.Books[i].ID = 0
+		ids[supplier.Books[i].BookID] = true
+	}
+	exist, err := booksExist(handler.db.WithContext(c.Request().Context()), ids)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if !exist {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Supplier lists a book that does not exist"})
+	}
+
+	if result := handler.db.WithContext(c.Request().Context()).Create(&supplier); result.Error != nil {
+		logger.Error("failed to insert supplier", zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+
+	logger.Info("supplier created", zap.Uint("id", supplier.ID))
+	return c.JSON(http.StatusCreated, supplier)
+}
+
+func (handler *handler) GetSuppliers(c echo.Context) error {
+	suppliers := []Supplier{}
+	if result := handler.db.WithContext(c.Request().Context()).Preload("Books").Order("id").Find(&suppliers); result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+	return c.JSON(http.StatusOK, suppliers)
+}
+
+func (handler *handler) GetSupplier(c echo.Context) error {
+	supplier := Supplier{}
+	if err := handler.db.WithContext(c.Request().Context()).Preload("Books").First(&supplier, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Supplier not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, supplier)
+}
+
+// UpdateSupplier replaces a supplier's details and the books it supplies.
+func (handler *handler) UpdateSupplier(c echo.Context) error {
+	supplier := Supplier{}
+	id := c.Param("id")
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := handler.db.WithContext(c.Request().Context()).First(&supplier, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Supplier not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	supplierID := supplier.ID
+	if err := c.Bind(&supplier); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	supplier.ID = supplierID
+	if err := c.Validate(supplier); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	ids := map[uint]bool{}
+	for i := range supplier.Books {
+		supplier.Books[i].ID = 0
+		supplier.Books[i].SupplierID = supplier.ID
+		ids[supplier.Books[i].BookID] = true
+	}
+	exist, err := booksExist(handler.db.WithContext(c.Request().Context()), ids)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if !exist {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Supplier lists a book that does not exist"})
+	}
+
+	err = handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("supplier_id = ?", supplier.ID).Delete(&SupplierBook{}).Error; err != nil {
+			return err
+		}
+		return tx.Save(&supplier).Error
+	})
+	if err != nil {
+		logger.Error("failed to update supplier", zap.String("id", id), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update supplier"})
+	}
+	return c.JSON(http.StatusOK, supplier)
+}
+
+// DeleteSupplier removes a supplier and its draft purchase orders. Suppliers
+// that have been sent purchase orders are kept for their history.
+func (handler *handler) DeleteSupplier(c echo.Context) error {
+	id := c.Param("id")
+
+	var orders int64
+	if err := handler.db.WithContext(c.Request().Context()).Model(&PurchaseOrder{}).Where("supplier_id = ? AND status <> ?", id, StatusDraft).Count(&orders).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if orders > 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Supplier has purchase orders"})
+	}
+
+	var deleted int64
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("supplier_id = ?", id).Delete(&PurchaseOrder{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&Supplier{}, id)
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if deleted == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Supplier not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Supplier successfully deleted"})
+}
+
+func keys(values map[uint]bool) []uint {
+	result := make([]uint, 0, len(values))
+	for value := range values {
+		result = append(result, value)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
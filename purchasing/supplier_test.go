@@ -0,0 +1,88 @@
+package purchasing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	countBooksQuery     = `SELECT count(*) FROM "books" WHERE id IN ($1,$2) AND "books"."deleted_at" IS NULL`
+	countSentOrderQuery = `SELECT count(*) FROM "purchase_orders" WHERE supplier_id = $1 AND status <> $2`
+)
+
+func TestCreateSupplier(t *testing.T) {
+	t.Run("return bad request given book listed twice", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name": "Nanmee Books", "books": [{"book_id": 1}, {"book_id": 1}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		handler := NewHandler(nil, nil)
+		err := handler.CreateSupplier(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("return bad request given book does not exist", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name": "Nanmee Books", "books": [{"book_id": 2, "sku": "NB-2"}, {"book_id": 1, "sku": "NB-1"}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(countBooksQuery).WithArgs(1, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		handler := NewHandler(gormDB, nil)
+		err := handler.CreateSupplier(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeleteSupplier(t *testing.T) {
+	t.Run("return conflict given supplier has been sent purchase orders", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("3")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(countSentOrderQuery).WithArgs("3", StatusDraft).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+		handler := NewHandler(gormDB, nil)
+		err := handler.DeleteSupplier(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
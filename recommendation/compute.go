@@ -0,0 +1,142 @@
+package recommendation
+
+import (
+	"sort"
+
+	"github.com/phetployst/book-store-api/book"
+)
+
+const (
+	ReasonBoughtTogether = "bought_together"
+	ReasonSameAuthor     = "same_author"
+	ReasonSameCategory   = "same_category"
+
+	// maxOrderBooks leaves out orders with more distinct books than this,
+	// such as bulk orders for schools, which pair every book with every
+	// other and say little about what customers like.
+	maxOrderBooks = 50
+)
+
+// Related is a book recommended alongside BookID, at position Rank from 1.
+// Orders is the number of orders that had both books, and is only set
+// when Reason is bought_together.
+type Related struct {
+	BookID        uint   `gorm:"primaryKey;autoIncrement:false"`
+	RelatedBookID uint   `gorm:"primaryKey;autoIncrement:false"`
+	Rank          int    `gorm:"not null"`
+	Reason        string `gorm:"not null"`
+	Orders        int
+	TenantID      string `gorm:"not null;default:'default';index"`
+}
+
+func (Related) TableName() string {
+	return "related_books"
+}
+
+// Compute ranks up to limit related books for every book. orders holds the
+// books of each order. Books bought together come first, the most orders
+// first. The rest of the list is filled with books by the same author, then
+// books in the same category, the best rated first.
+func Compute(orders [][]uint, books []book.Book, limit int) []Related {
+	together := map[uint]map[uint]int{}
+	for _, order := range orders {
+		distinct := unique(order)
+		if len(distinct) < 2 || len(distinct) > maxOrderBooks {
+			continue
+		}
+		for _, a := range distinct {
+			for _, b := range distinct {
+				if a == b {
+					continue
+				}
+				if together[a] == nil {
+					together[a] = map[uint]int{}
+				}
+				together[a][b]++
+			}
+		}
+	}
+
+	byID := map[uint]book.Book{}
+	byAuthor := map[string][]uint{}
+	byCategory := map[string][]uint{}
+	for _, found := range books {
+		byID[found.ID] = found
+		for _, author := range found.Authors() {
+			byAuthor[author] = append(byAuthor[author], found.ID)
+		}
+		if found.Category != "" {
+			byCategory[found.Category] = append(byCategory[found.Category], found.ID)
+		}
+	}
+	better := func(ids []uint) func(i, j int) bool {
+		return func(i, j int) bool {
+			a, b := byID[ids[i]], byID[ids[j]]
+			if a.RatingAverage != b.RatingAverage {
+				return a.RatingAverage > b.RatingAverage
+			}
+			return a.ID < b.ID
+		}
+	}
+
+	related := []Related{}
+	for _, found := range books {
+		chosen := map[uint]bool{found.ID: true}
+		add := func(id uint, reason string, orders int) {
+			chosen[id] = true
+			related = append(related, Related{BookID: found.ID, RelatedBookID: id, Rank: len(chosen) - 1, Reason: reason, Orders: orders})
+		}
+
+		bought := []uint{}
+		for id := range together[found.ID] {
+			if _, ok := byID[id]; ok {
+				bought = append(bought, id)
+			}
+		}
+		counts := together[found.ID]
+		sort.Slice(bought, func(i, j int) bool {
+			if counts[bought[i]] != counts[bought[j]] {
+				return counts[bought[i]] > counts[bought[j]]
+			}
+			return bought[i] < bought[j]
+		})
+		for _, id := range bought {
+			if len(chosen) > limit {
+				break
+			}
+			add(id, ReasonBoughtTogether, counts[id])
+		}
+
+		fill := func(candidates []uint, reason string) {
+			candidates = append([]uint{}, candidates...)
+			sort.Slice(candidates, better(candidates))
+			for _, id := range candidates {
+				if len(chosen) > limit {
+					return
+				}
+				if !chosen[id] {
+					add(id, reason, 0)
+				}
+			}
+		}
+		sameAuthor := []uint{}
+		for _, author := range found.Authors() {
+			sameAuthor = append(sameAuthor, byAuthor[author]...)
+		}
+		fill(sameAuthor, ReasonSameAuthor)
+		fill(byCategory[found.Category], ReasonSameCategory)
+	}
+	return related
+}
+
+func unique(ids []uint) []uint {
+	seen := map[uint]bool{}
+	distinct := []uint{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			distinct = append(distinct, id)
+		}
+	}
+	return distinct
+}
@@ -0,0 +1,76 @@
+package recommendation
+
+import (
+	"testing"
+
+	"github.com/phetployst/book-store-api/book"
+	"github.com/stretchr/testify/assert"
+)
+
+func catalog(books ...book.Book) []book.Book {
+	for i := range books {
+		books[i].ID = uint(i + 1)
+	}
+	return books
+}
+
+func relatedTo(related []Related, bookID uint) []Related {
+	found := []Related{}
+	for _, entry := range related {
+		if entry.BookID == bookID {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+func TestCompute(t *testing.T) {
+	t.Run("rank books bought together by orders given order history", func(t *testing.T) {
+		books := catalog(
+			book.Book{Title: "A", Author: "Ann"},
+			book.Book{Title: "B", Author: "Bob"},
+			book.Book{Title: "C", Author: "Cat"},
+		)
+
+		got := Compute([][]uint{{1, 2}, {1, 3}, {3, 1, 1}}, books, 5)
+
+		assert.Equal(t, []Related{
+			{BookID: 1, RelatedBookID: 3, Rank: 1, Reason: ReasonBoughtTogether, Orders: 2},
+			{BookID: 1, RelatedBookID: 2, Rank: 2, Reason: ReasonBoughtTogether, Orders: 1},
+		}, relatedTo(got, 1))
+		assert.Equal(t, []Related{
+			{BookID: 2, RelatedBookID: 1, Rank: 1, Reason: ReasonBoughtTogether, Orders: 1},
+		}, relatedTo(got, 2))
+	})
+
+	t.Run("fill with same author then same category given few orders", func(t *testing.T) {
+		books := catalog(
+			book.Book{Title: "A", Author: "Ann and Bob", Category: "Fiction"},
+			book.Book{Title: "B", Author: "Bob", Category: "Poetry"},
+			book.Book{Title: "C", Author: "Cat", Category: "Fiction", RatingAverage: 3},
+			book.Book{Title: "D", Author: "Dan", Category: "Fiction", RatingAverage: 4.5},
+			book.Book{Title: "E", Author: "Eve", Category: "Fiction"},
+			book.Book{Title: "F", Author: "Ann", Category: "History"},
+		)
+
+		got := Compute([][]uint{{1, 5}}, books, 4)
+
+		assert.Equal(t, []Related{
+			{BookID: 1, RelatedBookID: 5, Rank: 1, Reason: ReasonBoughtTogether, Orders: 1},
+			{BookID: 1, RelatedBookID: 2, Rank: 2, Reason: ReasonSameAuthor},
+			{BookID: 1, RelatedBookID: 6, Rank: 3, Reason: ReasonSameAuthor},
+			{BookID: 1, RelatedBookID: 4, Rank: 4, Reason: ReasonSameCategory},
+		}, relatedTo(got, 1))
+	})
+
+	t.Run("ignore order given more books than a customer order", func(t *testing.T) {
+		order := []uint{}
+		for id := uint(1); id <= maxOrderBooks+1; id++ {
+			order = append(order, id)
+		}
+
+		got := Compute([][]uint{order}, catalog(book.Book{Title: "A", Author: "Ann"}, book.Book{Title: "B", Author: "Bob"}), 5)
+
+		assert.Empty(t, got)
+	})
+}
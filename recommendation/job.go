@@ -0,0 +1,121 @@
+package recommendation
+
+import (
+	"context"
+	"time"
+
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/shipping"
+	"github.com/phetployst/book-store-api/tenant"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	maxRelated  = 20
+	insertBatch = 500
+)
+
+// Job precomputes related books into the related_books table so that
+// GET /books/:id/related only has to read them.
+type Job struct {
+	db       *gorm.DB
+	logger   *zap.Logger
+	interval time.Duration
+}
+
+func NewJob(db *gorm.DB, logger *zap.Logger, interval time.Duration) *Job {
+	return &Job{db: db, logger: logger, interval: interval}
+}
+
+// Run refreshes the related books straight away and then every interval
+// until ctx is done. A failed refresh keeps the previous results.
+func (job *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+	for {
+		started := time.Now()
+		if count, err := job.Refresh(ctx); err != nil {
+			job.logger.Error("failed to refresh related books", zap.Error(err))
+		} else {
+			job.logger.Info("refreshed related books", zap.Int("related", count), zap.Duration("took", time.Since(started)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type orderBook struct {
+	OrderRef string
+	BookID   uint
+}
+
+// Refresh recomputes every book's related books from the orders shipped so
+// far, one tenant at a time, so the orders and books of one storefront never
+// inform another's. Each tenant's stored books are replaced in a single
+// transaction. It returns how many were stored.
+func (job *Job) Refresh(ctx context.Context) (int, error) {
+	tenants := []string{}
+	if err := job.db.WithContext(ctx).Model(&book.Book{}).Distinct().Pluck("tenant_id", &tenants).Error; err != nil {
+		return 0, err
+	}
+
+	stored := 0
+	for _, tenantID := range tenants {
+		count, err := job.refreshTenant(tenant.NewContext(ctx, tenantID), tenantID)
+		if err != nil {
+			return stored, err
+		}
+		stored += count
+	}
+	return stored, nil
+}
+
+// refreshTenant recomputes the related books of one tenant. Shipment lines
+// and the raw DELETE are not scoped by the tenant plugin, so they filter by
+// tenant_id themselves.
+func (job *Job) refreshTenant(ctx context.Context, tenantID string) (int, error) {
+	db := job.db.WithContext(ctx)
+
+	lines := []orderBook{}
+	err := db.Model(&shipping.ShipmentLine{}).
+		Select("DISTINCT shipments.order_ref, shipment_lines.book_id").
+		Joins("JOIN shipments ON shipments.id = shipment_lines.shipment_id AND shipments.tenant_id = ?", tenantID).
+		Where("shipments.shipped_at IS NOT NULL").
+		Order("shipments.order_ref").
+		Scan(&lines).Error
+	if err != nil {
+		return 0, err
+	}
+	orders := [][]uint{}
+	for i, line := range lines {
+		if i == 0 || line.OrderRef != lines[i-1].OrderRef {
+			orders = append(orders, []uint{})
+		}
+		orders[len(orders)-1] = append(orders[len(orders)-1], line.BookID)
+	}
+
+	books := []book.Book{}
+	if err := db.Order("id").Find(&books).Error; err != nil {
+		return 0, err
+	}
+
+	related := Compute(orders, books, maxRelated)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM related_books WHERE tenant_id = ?", tenantID).Error; err != nil {
+			return err
+		}
+		if len(related) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(related, insertBatch).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(related), nil
+}
@@ -0,0 +1,69 @@
+package recommendation
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/middleware"
+	"gorm.io/gorm"
+)
+
+const defaultLimit = 10
+
+// Recommendation is a related book as returned by GET /books/:id/related.
+type Recommendation struct {
+	BookID   uint    `json:"book_id"`
+	Title    string  `json:"title"`
+	Author   string  `json:"author"`
+	Category string  `json:"category"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency"`
+	Stock    int     `json:"stock"`
+	Reason   string  `json:"reason"`
+	Orders   int     `json:"orders,omitempty"`
+}
+
+type handler struct {
+	db *gorm.DB
+}
+
+func NewHandler(db *gorm.DB) *handler {
+	return &handler{db: db}
+}
+
+// Related returns the books precomputed by the Job for a book, best first.
+// A book added since the last refresh has none until the next one.
+func (handler *handler) Related(c echo.Context) error {
+	limit := defaultLimit
+	if value := c.QueryParam("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxRelated {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(maxRelated)})
+		}
+		limit = parsed
+	}
+
+	found := book.Book{}
+	if err := handler.db.WithContext(c.Request().Context()).First(&found, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	recommendations := []Recommendation{}
+	err := handler.db.WithContext(c.Request().Context()).Model(&Related{}).
+		Select("books.id AS book_id, books.title, books.author, books.category, books.price, books.currency, books.stock, related_books.reason, related_books.orders").
+		Joins("JOIN books ON books.id = related_books.related_book_id AND books.deleted_at IS NULL AND books.tenant_id = ?", middleware.GetTenantID(c)).
+		Where("related_books.book_id = ?", found.ID).
+		Order("related_books.rank").
+		Limit(limit).
+		Scan(&recommendations).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, recommendations)
+}
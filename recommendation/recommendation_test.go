@@ -0,0 +1,194 @@
+package recommendation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	getBookQuery        = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2`
+	getRelatedQuery     = `SELECT books.id AS book_id, books.title, books.author, books.category, books.price, books.currency, books.stock, related_books.reason, related_books.orders FROM "related_books" JOIN books ON books.id = related_books.related_book_id AND books.deleted_at IS NULL AND books.tenant_id = $1 WHERE related_books.book_id = $2 ORDER BY related_books.rank LIMIT $3`
+	getTenantsQuery     = `SELECT DISTINCT "tenant_id" FROM "books" WHERE "books"."deleted_at" IS NULL`
+	getOrderBooksQuery  = `SELECT DISTINCT shipments.order_ref, shipment_lines.book_id FROM "shipment_lines" JOIN shipments ON shipments.id = shipment_lines.shipment_id AND shipments.tenant_id = $1 WHERE shipments.shipped_at IS NOT NULL ORDER BY shipments.order_ref`
+	getBooksQuery       = `SELECT * FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY id`
+	getTenantBooksQuery = `SELECT * FROM "books" WHERE "books"."tenant_id" = $1 AND "books"."deleted_at" IS NULL ORDER BY id`
+	deleteRelatedQuery  = `DELETE FROM related_books WHERE tenant_id = $1`
+	createRelatedQuery  = `INSERT INTO "related_books" ("book_id","related_book_id","rank","reason","orders","tenant_id") VALUES ($1,$2,$3,$4,$5,$6),($7,$8,$9,$10,$11,$12)`
+)
+
+func TestRelated(t *testing.T) {
+	t.Run("return related books in rank order given precomputed results", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?limit=2", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "A"))
+		mock.ExpectQuery(getRelatedQuery).WithArgs("", 1, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"book_id", "title", "author", "category", "price", "currency", "stock", "reason", "orders"}).
+				AddRow(3, "C", "Cat", "Fiction", 250.0, "THB", 4, ReasonBoughtTogether, 2).
+				AddRow(2, "B", "Ann", "Poetry", 180.0, "THB", 0, ReasonSameAuthor, 0))
+
+		handler := NewHandler(gormDB)
+		err := handler.Related(c)
+
+		got := []Recommendation{}
+		json.Unmarshal(response.Body.Bytes(), &got)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, []Recommendation{
+			{BookID: 3, Title: "C", Author: "Cat", Category: "Fiction", Price: 250, Currency: "THB", Stock: 4, Reason: ReasonBoughtTogether, Orders: 2},
+			{BookID: 2, Title: "B", Author: "Ann", Category: "Poetry", Price: 180, Currency: "THB", Reason: ReasonSameAuthor},
+		}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return not found given unknown book", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("9")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookQuery).WithArgs("9", 1).WillReturnError(gorm.ErrRecordNotFound)
+
+		handler := NewHandler(gormDB)
+		err := handler.Related(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("return bad request given limit too large", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?limit=500", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		handler := NewHandler(nil)
+		err := handler.Related(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	t.Run("replace related books given shipped orders", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getTenantsQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"tenant_id"}).AddRow("default"))
+		mock.ExpectQuery(getOrderBooksQuery).WithArgs("default").
+			WillReturnRows(sqlmock.NewRows([]string{"order_ref", "book_id"}).
+				AddRow("ORD-1", 1).AddRow("ORD-1", 2).AddRow("ORD-2", 2))
+		mock.ExpectQuery(getBooksQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author"}).AddRow(1, "A", "Ann").AddRow(2, "B", "Bob"))
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteRelatedQuery).WithArgs("default").WillReturnResult(sqlmock.NewResult(0, 6))
+		mock.ExpectExec(createRelatedQuery).
+			WithArgs(1, 2, 1, ReasonBoughtTogether, 1, "default", 2, 1, 1, ReasonBoughtTogether, 1, "default").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		job := NewJob(gormDB, zap.NewNop(), 0)
+		got, err := job.Refresh(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("keep previous results given insert fails", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getTenantsQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"tenant_id"}).AddRow("default"))
+		mock.ExpectQuery(getOrderBooksQuery).WithArgs("default").
+			WillReturnRows(sqlmock.NewRows([]string{"order_ref", "book_id"}).AddRow("ORD-1", 1).AddRow("ORD-1", 2))
+		mock.ExpectQuery(getBooksQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author"}).AddRow(1, "A", "Ann").AddRow(2, "B", "Bob"))
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteRelatedQuery).WithArgs("default").WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(createRelatedQuery).WillReturnError(gorm.ErrInvalidDB)
+		mock.ExpectRollback()
+
+		job := NewJob(gormDB, zap.NewNop(), 0)
+		_, err := job.Refresh(context.Background())
+
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("refresh each storefront from its own orders given tenant plugin", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		assert.NoError(t, gormDB.Use(tenant.Plugin{}))
+
+		mock.ExpectQuery(getTenantsQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"tenant_id"}).AddRow("th").AddRow("uk"))
+		mock.ExpectQuery(getOrderBooksQuery).WithArgs("th").
+			WillReturnRows(sqlmock.NewRows([]string{"order_ref", "book_id"}).AddRow("ORD-1", 1).AddRow("ORD-1", 2))
+		mock.ExpectQuery(getTenantBooksQuery).WithArgs("th").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "tenant_id"}).AddRow(1, "A", "Ann", "th").AddRow(2, "B", "Bob", "th"))
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteRelatedQuery).WithArgs("th").WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(createRelatedQuery).
+			WithArgs(1, 2, 1, ReasonBoughtTogether, 1, "th", 2, 1, 1, ReasonBoughtTogether, 1, "th").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		mock.ExpectQuery(getOrderBooksQuery).WithArgs("uk").
+			WillReturnRows(sqlmock.NewRows([]string{"order_ref", "book_id"}))
+		mock.ExpectQuery(getTenantBooksQuery).WithArgs("uk").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "tenant_id"}).AddRow(3, "C", "Cat", "uk"))
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteRelatedQuery).WithArgs("uk").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		job := NewJob(gormDB, zap.NewNop(), 0)
+		got, err := job.Refresh(tenant.Unscoped(context.Background()))
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,177 @@
+package report
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/purchasing"
+)
+
+const (
+	CostLastReceived = "last_received"
+	CostSupplier     = "supplier"
+	CostUnknown      = "unknown"
+)
+
+// Valuation is the stock of a book valued at cost. UnitCost is what the
+// book last cost on a received purchase order, or the cheapest supplier
+// price when it has never been received. CostSource says which was used.
+type Valuation struct {
+	BookID     uint    `json:"book_id"`
+	Title      string  `json:"title"`
+	Stock      int     `json:"stock"`
+	UnitCost   float64 `json:"unit_cost"`
+	Value      float64 `json:"value"`
+	CostSource string  `json:"cost_source"`
+}
+
+func (valuation Valuation) record() []string {
+	return []string{
+		strconv.FormatUint(uint64(valuation.BookID), 10),
+		valuation.Title,
+		strconv.Itoa(valuation.Stock),
+		amount(valuation.UnitCost),
+		amount(valuation.Value),
+		valuation.CostSource,
+	}
+}
+
+type DeadStock struct {
+	BookID     uint       `json:"book_id"`
+	Title      string     `json:"title"`
+	Author     string     `json:"author"`
+	Stock      int        `json:"stock"`
+	LastSoldAt *time.Time `json:"last_sold_at"`
+}
+
+func (dead DeadStock) record() []string {
+	lastSold := ""
+	if dead.LastSoldAt != nil {
+		lastSold = date(*dead.LastSoldAt)
+	}
+	return []string{strconv.FormatUint(uint64(dead.BookID), 10), dead.Title, dead.Author, strconv.Itoa(dead.Stock), lastSold}
+}
+
+type NewTitles struct {
+	Period time.Time `json:"period"`
+	Titles int       `json:"titles"`
+}
+
+func (titles NewTitles) record() []string {
+	return []string{date(titles.Period), strconv.Itoa(titles.Titles)}
+}
+
+type bookCost struct {
+	BookID    uint
+	CostPrice float64
+}
+
+// value prices each book's stock. lastReceived and supplier map a book to
+// its cost price.
+func value(books []book.Book, lastReceived map[uint]float64, supplier map[uint]float64) []Valuation {
+	rows := make([]Valuation, len(books))
+	for i, found := range books {
+		row := Valuation{BookID: found.ID, Title: found.Title, Stock: found.Stock, CostSource: CostUnknown}
+		if cost, ok := lastReceived[found.ID]; ok {
+			row.UnitCost, row.CostSource = cost, CostLastReceived
+		} else if cost, ok := supplier[found.ID]; ok {
+			row.UnitCost, row.CostSource = cost, CostSupplier
+		}
+		row.Value = math.Round(row.UnitCost*float64(row.Stock)*100) / 100
+		rows[i] = row
+	}
+	return rows
+}
+
+// InventoryValuation values the books in stock now at cost. It takes no
+// date range, but accepts the format parameter like every report.
+func (handler *handler) InventoryValuation(c echo.Context) error {
+	books := []book.Book{}
+	if err := handler.db.WithContext(c.Request().Context()).Where("stock > 0").Order("id").Find(&books).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	received := []bookCost{}
+	err := handler.db.WithContext(c.Request().Context()).Model(&purchasing.OrderLine{}).
+		Select("book_id, cost_price").
+		Where("received > 0").
+		Order("id DESC").
+		Scan(&received).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	lastReceived := map[uint]float64{}
+	for _, line := range received {
+		if _, ok := lastReceived[line.BookID]; !ok {
+			lastReceived[line.BookID] = line.CostPrice
+		}
+	}
+
+	supplied := []bookCost{}
+	err = handler.db.WithContext(c.Request().Context()).Model(&purchasing.SupplierBook{}).
+		Select("book_id, MIN(cost_price) AS cost_price").
+		Group("book_id").
+		Scan(&supplied).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	supplier := map[uint]float64{}
+	for _, line := range supplied {
+		supplier[line.BookID] = line.CostPrice
+	}
+
+	today := handler.now().UTC().Truncate(24 * time.Hour)
+	dates := Range{From: today, To: today.AddDate(0, 0, 1)}
+	return respond(c, "inventory-valuation", dates,
+		[]string{"book_id", "title", "stock", "unit_cost", "value", "cost_source"},
+		value(books, lastReceived, supplier))
+}
+
+// DeadStock lists the books in stock that did not sell in the range, the
+// last 90 days by default. Books added during the range are left out, as
+// they have not had the whole range to sell.
+func (handler *handler) DeadStock(c echo.Context) error {
+	dates, err := parseRange(c, handler.now(), deadStockWindow)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	rows := []DeadStock{}
+	err = handler.db.WithContext(c.Request().Context()).Model(&book.Book{}).
+		Select("books.id AS book_id, books.title, books.author, books.stock, MAX(shipments.shipped_at) AS last_sold_at").
+		Joins("LEFT JOIN shipment_lines ON shipment_lines.book_id = books.id").
+		Joins("LEFT JOIN shipments ON shipments.id = shipment_lines.shipment_id AND shipments.shipped_at < ?", dates.To).
+		Where("books.stock > 0 AND books.created_at < ?", dates.From).
+		Group("books.id, books.title, books.author, books.stock").
+		Having("MAX(shipments.shipped_at) IS NULL OR MAX(shipments.shipped_at) < ?", dates.From).
+		Order("books.id").
+		Scan(&rows).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(c, "dead-stock", dates, []string{"book_id", "title", "author", "stock", "last_sold_at"}, rows)
+}
+
+// NewTitles counts the books added to the catalog in each period.
+func (handler *handler) NewTitles(c echo.Context) error {
+	dates, err := parseRange(c, handler.now(), defaultDays)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	rows := []NewTitles{}
+	err = handler.db.WithContext(c.Request().Context()).Model(&book.Book{}).
+		Select("date_trunc(?, created_at) AS period, COUNT(*) AS titles", dates.Period).
+		Where("created_at >= ? AND created_at < ?", dates.From, dates.To).
+		Group("period").
+		Order("period").
+		Scan(&rows).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(c, "new-titles", dates, []string{"period", "titles"}, rows)
+}
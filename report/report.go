@@ -0,0 +1,144 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+
+	dateLayout      = "2006-01-02"
+	defaultDays     = 30
+	maxRangeDays    = 3 * 366
+	defaultLimit    = 10
+	maxLimit        = 100
+	deadStockWindow = 90
+)
+
+// Range is the dates a report covers, in UTC. From is the first day and To
+// the day after the last, so a range of one day has To = From + 24h.
+type Range struct {
+	From   time.Time
+	To     time.Time
+	Period string
+}
+
+// row is one line of a report. record gives its values in the order of the
+// report's CSV columns.
+type row interface {
+	record() []string
+}
+
+type handler struct {
+	db  *gorm.DB
+	now func() time.Time
+}
+
+func NewHandler(db *gorm.DB) *handler {
+	return &handler{db: db, now: time.Now}
+}
+
+// parseRange reads the from and to dates and the period from the query.
+// Without dates the range is the last days days up to today.
+func parseRange(c echo.Context, now time.Time, days int) (Range, error) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	result := Range{From: today.AddDate(0, 0, 1-days), To: today.AddDate(0, 0, 1), Period: PeriodDay}
+
+	if value := c.QueryParam("from"); value != "" {
+		from, err := time.Parse(dateLayout, value)
+		if err != nil {
+			return Range{}, fmt.Errorf("from must be a date such as %s", dateLayout)
+		}
+		result.From = from
+	}
+	if value := c.QueryParam("to"); value != "" {
+		to, err := time.Parse(dateLayout, value)
+		if err != nil {
+			return Range{}, fmt.Errorf("to must be a date such as %s", dateLayout)
+		}
+		result.To = to.AddDate(0, 0, 1)
+	}
+	if !result.From.Before(result.To) {
+		return Range{}, fmt.Errorf("from must not be after to")
+	}
+	if result.To.Sub(result.From) > maxRangeDays*24*time.Hour {
+		return Range{}, fmt.Errorf("a report can cover at most %d days", maxRangeDays)
+	}
+
+	if period := c.QueryParam("period"); period != "" {
+		if period != PeriodDay && period != PeriodWeek && period != PeriodMonth {
+			return Range{}, fmt.Errorf("period must be day, week or month")
+		}
+		result.Period = period
+	}
+	return result, nil
+}
+
+func parseLimit(c echo.Context) (int, error) {
+	value := c.QueryParam("limit")
+	if value == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+	}
+	return limit, nil
+}
+
+// respond sends the rows as JSON, or as a CSV file named after the report
+// and its range when the format query parameter is csv.
+func respond[T row](c echo.Context, name string, dates Range, columns []string, rows []T) error {
+	switch c.QueryParam("format") {
+	case "", FormatJSON:
+		return c.JSON(http.StatusOK, rows)
+	case FormatCSV:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "format must be json or csv"})
+	}
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	response.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-%s-%s.csv"`,
+		name, dates.From.Format(dateLayout), dates.To.AddDate(0, 0, -1).Format(dateLayout)))
+	response.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(response)
+	if err := writer.Write(columns); err != nil {
+		middleware.GetLogger(c).Error("failed to write report", zap.String("report", name), zap.Error(err))
+		return nil
+	}
+	for _, line := range rows {
+		if err := writer.Write(line.record()); err != nil {
+			middleware.GetLogger(c).Error("failed to write report", zap.String("report", name), zap.Error(err))
+			return nil
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		middleware.GetLogger(c).Error("failed to write report", zap.String("report", name), zap.Error(err))
+	}
+	return nil
+}
+
+func date(value time.Time) string {
+	return value.UTC().Format(dateLayout)
+}
+
+func amount(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
@@ -0,0 +1,259 @@
+package report
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	revenueQuery       = `SELECT date_trunc($1, created_at) AS period, currency, COUNT(*) AS orders, SUM(captured) AS captured, SUM(refunded) AS refunded FROM "payments" WHERE captured > 0 AND created_at >= $2 AND created_at < $3 GROUP BY period, currency ORDER BY period, currency`
+	topBooksQuery      = `SELECT shipment_lines.book_id, books.title, books.author, SUM(shipment_lines.quantity) AS quantity FROM "shipment_lines" JOIN shipments ON shipments.id = shipment_lines.shipment_id JOIN books ON books.id = shipment_lines.book_id AND books.tenant_id = $1 WHERE shipments.shipped_at >= $2 AND shipments.shipped_at < $3 GROUP BY shipment_lines.book_id, books.title, books.author ORDER BY quantity DESC, shipment_lines.book_id LIMIT $4`
+	tenantRevenueQuery = `SELECT date_trunc($1, created_at) AS period, currency, COUNT(*) AS orders, SUM(captured) AS captured, SUM(refunded) AS refunded FROM "payments" WHERE (captured > 0 AND created_at >= $2 AND created_at < $3) AND "payments"."tenant_id" = $4 GROUP BY period, currency ORDER BY period, currency`
+)
+
+var now = time.Date(2024, 3, 15, 9, 30, 0, 0, time.UTC)
+
+func TestParseRange(t *testing.T) {
+	t.Run("cover the last days up to today given no dates", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		got, err := parseRange(c, now, 30)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Range{
+			From:   time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC),
+			To:     time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC),
+			Period: PeriodDay,
+		}, got)
+	})
+
+	t.Run("include the to date given from, to and period", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/?from=2024-01-01&to=2024-01-31&period=month", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		got, err := parseRange(c, now, 30)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Range{
+			From:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			To:     time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			Period: PeriodMonth,
+		}, got)
+	})
+
+	for _, query := range []string{
+		"from=yesterday",
+		"from=2024-02-01&to=2024-01-01",
+		"from=2020-01-01&to=2024-01-01",
+		"period=year",
+	} {
+		t.Run("return error given "+query, func(t *testing.T) {
+			e := echo.New()
+			request := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+			response := httptest.NewRecorder()
+			c := e.NewContext(request, response)
+
+			_, err := parseRange(c, now, 30)
+
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRevenue(t *testing.T) {
+	t.Run("return revenue by period given json", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?from=2024-03-01&to=2024-03-31&period=week", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(revenueQuery).
+			WithArgs(PeriodWeek, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)).
+			WillReturnRows(sqlmock.NewRows([]string{"period", "currency", "orders", "captured", "refunded"}).
+				AddRow(time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC), "THB", 3, 125000, 25000))
+
+		handler := &handler{db: gormDB, now: func() time.Time { return now }}
+		err := handler.Revenue(c)
+
+		got := []Revenue{}
+		json.Unmarshal(response.Body.Bytes(), &got)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, []Revenue{{
+			Period:   time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC),
+			Currency: "THB",
+			Orders:   3,
+			Captured: 125000,
+			Refunded: 25000,
+			Net:      100000,
+		}}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("export csv attachment given format csv", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?from=2024-03-01&to=2024-03-02&format=csv", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(revenueQuery).
+			WithArgs(PeriodDay, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)).
+			WillReturnRows(sqlmock.NewRows([]string{"period", "currency", "orders", "captured", "refunded"}).
+				AddRow(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "THB", 2, 50000, 0).
+				AddRow(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), "USD", 1, 1999, 1999))
+
+		handler := &handler{db: gormDB, now: func() time.Time { return now }}
+		err := handler.Revenue(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", response.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `attachment; filename="revenue-2024-03-01-2024-03-02.csv"`, response.Header().Get(echo.HeaderContentDisposition))
+		assert.Equal(t, "period,currency,orders,captured,refunded,net\n"+
+			"2024-03-01,THB,2,50000,0,50000\n"+
+			"2024-03-02,USD,1,1999,1999,0\n", response.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given unknown format", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?format=xlsx", nil)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(revenueQuery).WillReturnRows(sqlmock.NewRows([]string{"period"}))
+
+		handler := &handler{db: gormDB, now: func() time.Time { return now }}
+		err := handler.Revenue(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("sum payments of the request's storefront given tenant plugin", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?from=2024-03-01&to=2024-03-31&period=week", nil)
+		request = request.WithContext(tenant.NewContext(request.Context(), "th"))
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		assert.NoError(t, gormDB.Use(tenant.Plugin{}))
+
+		mock.ExpectQuery(tenantRevenueQuery).
+			WithArgs(PeriodWeek, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), "th").
+			WillReturnRows(sqlmock.NewRows([]string{"period", "currency", "orders", "captured", "refunded"}))
+
+		handler := &handler{db: gormDB, now: func() time.Time { return now }}
+		err := handler.Revenue(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestTopBooks(t *testing.T) {
+	t.Run("count books of the request's storefront given tenant plugin", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/?from=2024-03-01&to=2024-03-31&limit=5", nil)
+		request.Header.Set("X-Tenant-ID", "th")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		assert.NoError(t, gormDB.Use(tenant.Plugin{}))
+
+		mock.ExpectQuery(topBooksQuery).
+			WithArgs("th", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), 5).
+			WillReturnRows(sqlmock.NewRows([]string{"book_id", "title", "author", "quantity"}).AddRow(1, "Dune", "Frank Herbert", 7))
+
+		handler := &handler{db: gormDB, now: func() time.Time { return now }}
+		err := middleware.ResolveTenant(nil, "")(handler.TopBooks)(c)
+
+		got := []BookSales{}
+		json.Unmarshal(response.Body.Bytes(), &got)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, []BookSales{{BookID: 1, Title: "Dune", Author: "Frank Herbert", Quantity: 7}}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAuthorSales(t *testing.T) {
+	t.Run("credit every author given books with several authors", func(t *testing.T) {
+		got := authorSales([]BookSales{
+			{BookID: 1, Author: "Jane Doe; John Roe", Quantity: 5},
+			{BookID: 2, Author: "John Roe", Quantity: 4},
+			{BookID: 3, Author: "Ann Lee", Quantity: 5},
+		})
+
+		assert.Equal(t, []AuthorSales{
+			{Author: "John Roe", Titles: 2, Quantity: 9},
+			{Author: "Ann Lee", Titles: 1, Quantity: 5},
+			{Author: "Jane Doe", Titles: 1, Quantity: 5},
+		}, got)
+	})
+}
+
+func TestValue(t *testing.T) {
+	t.Run("prefer last received cost over supplier cost given both", func(t *testing.T) {
+		books := []book.Book{{Title: "A", Stock: 3}, {Title: "B", Stock: 2}, {Title: "C", Stock: 1}}
+		for i := range books {
+			books[i].ID = uint(i + 1)
+		}
+
+		got := value(books, map[uint]float64{1: 100.5}, map[uint]float64{1: 90, 2: 75.25})
+
+		assert.Equal(t, []Valuation{
+			{BookID: 1, Title: "A", Stock: 3, UnitCost: 100.5, Value: 301.5, CostSource: CostLastReceived},
+			{BookID: 2, Title: "B", Stock: 2, UnitCost: 75.25, Value: 150.5, CostSource: CostSupplier},
+			{BookID: 3, Title: "C", Stock: 1, CostSource: CostUnknown},
+		}, got)
+	})
+}
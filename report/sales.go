@@ -0,0 +1,163 @@
+package report
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"github.com/phetployst/book-store-api/shipping"
+	"gorm.io/gorm"
+)
+
+// Revenue is what was taken in a period and currency. Amounts are in the
+// smallest unit of the currency, like payments.
+type Revenue struct {
+	Period   time.Time `json:"period"`
+	Currency string    `json:"currency"`
+	Orders   int       `json:"orders"`
+	Captured int64     `json:"captured"`
+	Refunded int64     `json:"refunded"`
+	Net      int64     `json:"net"`
+}
+
+func (revenue Revenue) record() []string {
+	return []string{
+		date(revenue.Period),
+		revenue.Currency,
+		strconv.Itoa(revenue.Orders),
+		strconv.FormatInt(revenue.Captured, 10),
+		strconv.FormatInt(revenue.Refunded, 10),
+		strconv.FormatInt(revenue.Net, 10),
+	}
+}
+
+type BookSales struct {
+	BookID   uint   `json:"book_id"`
+	Title    string `json:"title"`
+	Author   string `json:"author"`
+	Quantity int    `json:"quantity"`
+}
+
+func (sales BookSales) record() []string {
+	return []string{strconv.FormatUint(uint64(sales.BookID), 10), sales.Title, sales.Author, strconv.Itoa(sales.Quantity)}
+}
+
+type AuthorSales struct {
+	Author   string `json:"author"`
+	Titles   int    `json:"titles"`
+	Quantity int    `json:"quantity"`
+}
+
+func (sales AuthorSales) record() []string {
+	return []string{sales.Author, strconv.Itoa(sales.Titles), strconv.Itoa(sales.Quantity)}
+}
+
+// Revenue sums captured and refunded payments by the period the order was
+// paid in.
+func (handler *handler) Revenue(c echo.Context) error {
+	dates, err := parseRange(c, handler.now(), defaultDays)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	rows := []Revenue{}
+	err = handler.db.WithContext(c.Request().Context()).Model(&payment.Payment{}).
+		Select("date_trunc(?, created_at) AS period, currency, COUNT(*) AS orders, SUM(captured) AS captured, SUM(refunded) AS refunded", dates.Period).
+		Where("captured > 0 AND created_at >= ? AND created_at < ?", dates.From, dates.To).
+		Group("period, currency").
+		Order("period, currency").
+		Scan(&rows).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	for i := range rows {
+		rows[i].Net = rows[i].Captured - rows[i].Refunded
+	}
+
+	return respond(c, "revenue", dates, []string{"period", "currency", "orders", "captured", "refunded", "net"}, rows)
+}
+
+// bookSales sums the books of the tenant shipped in the range, best selling
+// first.
+func bookSales(db *gorm.DB, tenantID string, dates Range) *gorm.DB {
+	return db.Model(&shipping.ShipmentLine{}).
+		Select("shipment_lines.book_id, books.title, books.author, SUM(shipment_lines.quantity) AS quantity").
+		Joins("JOIN shipments ON shipments.id = shipment_lines.shipment_id").
+		Joins("JOIN books ON books.id = shipment_lines.book_id AND books.tenant_id = ?", tenantID).
+		Where("shipments.shipped_at >= ? AND shipments.shipped_at < ?", dates.From, dates.To).
+		Group("shipment_lines.book_id, books.title, books.author").
+		Order("quantity DESC, shipment_lines.book_id")
+}
+
+// TopBooks lists the books that sold the most copies, counted when they
+// were shipped.
+func (handler *handler) TopBooks(c echo.Context) error {
+	dates, err := parseRange(c, handler.now(), defaultDays)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	limit, err := parseLimit(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	rows := []BookSales{}
+	if err := bookSales(handler.db.WithContext(c.Request().Context()), middleware.GetTenantID(c), dates).Limit(limit).Scan(&rows).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(c, "top-books", dates, []string{"book_id", "title", "author", "quantity"}, rows)
+}
+
+// authorSales credits every copy sold to each of the book's authors.
+func authorSales(books []BookSales) []AuthorSales {
+	byAuthor := map[string]*AuthorSales{}
+	for _, sold := range books {
+		for _, author := range (book.Book{Author: sold.Author}).Authors() {
+			sales, ok := byAuthor[author]
+			if !ok {
+				sales = &AuthorSales{Author: author}
+				byAuthor[author] = sales
+			}
+			sales.Titles++
+			sales.Quantity += sold.Quantity
+		}
+	}
+
+	rows := make([]AuthorSales, 0, len(byAuthor))
+	for _, sales := range byAuthor {
+		rows = append(rows, *sales)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Quantity != rows[j].Quantity {
+			return rows[i].Quantity > rows[j].Quantity
+		}
+		return rows[i].Author < rows[j].Author
+	})
+	return rows
+}
+
+func (handler *handler) TopAuthors(c echo.Context) error {
+	dates, err := parseRange(c, handler.now(), defaultDays)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	limit, err := parseLimit(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	books := []BookSales{}
+	if err := bookSales(handler.db.WithContext(c.Request().Context()), middleware.GetTenantID(c), dates).Scan(&books).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	rows := authorSales(books)
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return respond(c, "top-authors", dates, []string{"author", "titles", "quantity"}, rows)
+}
@@ -0,0 +1,226 @@
+package review
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Review is a customer's star rating of a book with an optional text. Each
+// customer can review a book once; new reviews wait for staff moderation.
+type Review struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	BookID     uint      `json:"book_id" gorm:"not null;uniqueIndex:idx_reviews_book_customer"`
+	CustomerID string    `json:"customer_id" gorm:"not null;uniqueIndex:idx_reviews_book_customer"`
+	Rating     int       `json:"rating" gorm:"not null" validate:"min=1,max=5"`
+	Text       string    `json:"text" validate:"max=5000"`
+	Status     string    `json:"status" gorm:"not null;index"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	TenantID   string    `json:"-" gorm:"not null;default:'default';index"`
+}
+
+type Page struct {
+	Reviews  []Review `json:"reviews"`
+	Page     int      `json:"page"`
+	PageSize int      `json:"page_size"`
+	Total    int64    `json:"total"`
+}
+
+type ModerationRequest struct {
+	Status string `json:"status" validate:"oneof=pending approved rejected"`
+}
+
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+func (c *CustomValidator) Validate(i interface{}) error {
+	return c.validator.Struct(i)
+}
+
+type handler struct {
+	db *gorm.DB
+}
+
+func NewHandler(db *gorm.DB) *handler {
+	return &handler{db: db}
+}
+
+func pagination(c echo.Context) (int, int, error) {
+	page, pageSize := 1, defaultPageSize
+	if value := c.QueryParam("page"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return 0, 0, errors.New("page must be a positive number")
+		}
+		page = parsed
+	}
+	if value := c.QueryParam("page_size"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			return 0, 0, errors.New("page_size must be between 1 and 100")
+		}
+		pageSize = parsed
+	}
+	return page, pageSize, nil
+}
+
+func (handler *handler) list(c echo.Context, filter func(db *gorm.DB) *gorm.DB) error {
+	page, pageSize, err := pagination(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	result := Page{Reviews: []Review{}, Page: page, PageSize: pageSize}
+	if err := handler.db.WithContext(c.Request().Context()).Model(&Review{}).Scopes(filter).Count(&result.Total).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if err := handler.db.WithContext(c.Request().Context()).Scopes(filter).Order("created_at DESC").Order("id DESC").Limit(pageSize).Offset((page - 1) * pageSize).Find(&result.Reviews).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (handler *handler) Create(c echo.Context) error {
+	review := Review{}
+	id := c.Param("id")
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&review); err != nil {
+		logger.Error("failed to bind review", zap.Error(err))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := c.Validate(review); err != nil {
+		logger.Error("failed to validate review", zap.Error(err))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	reviewed := book.Book{}
+	if err := handler.db.WithContext(c.Request().Context()).First(&reviewed, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	review.ID = 0
+	review.BookID = reviewed.ID
+	review.CustomerID = middleware.GetCustomerID(c)
+	review.Status = StatusPending
+
+	existing := Review{}
+	err := handler.db.WithContext(c.Request().Context()).Where("book_id = ? AND customer_id = ?", review.BookID, review.CustomerID).First(&existing).Error
+	if err == nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "You have already reviewed this book"})
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	if err := handler.db.WithContext(c.Request().Context()).Create(&review).Error; err != nil {
+		// Another request from the customer may have added a review since the
+		// check above; the unique index catches it.
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "You have already reviewed this book"})
+		}
+		logger.Error("failed to insert review", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	logger.Info("review created", zap.Uint("book_id", review.BookID), zap.Uint("review_id", review.ID))
+	return c.JSON(http.StatusCreated, review)
+}
+
+// GetByBook lists the approved reviews of a book, newest first.
+func (handler *handler) GetByBook(c echo.Context) error {
+	id := c.Param("id")
+	return handler.list(c, func(db *gorm.DB) *gorm.DB {
+		return db.Where("book_id = ? AND status = ?", id, StatusApproved)
+	})
+}
+
+// GetAll is the staff moderation queue, filtered by status.
+func (handler *handler) GetAll(c echo.Context) error {
+	status := c.QueryParam("status")
+	if status == "" {
+		status = StatusPending
+	}
+	if status != StatusPending && status != StatusApproved && status != StatusRejected {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "status must be pending, approved or rejected"})
+	}
+	return handler.list(c, func(db *gorm.DB) *gorm.DB {
+		return db.Where("status = ?", status)
+	})
+}
+
+// Moderate changes a review's status and recalculates the book's rating from
+// its approved reviews in the same transaction.
+func (handler *handler) Moderate(c echo.Context) error {
+	request := ModerationRequest{}
+	id := c.Param("id")
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "status must be pending, approved or rejected"})
+	}
+
+	review := Review{}
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&review, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&review).Update("status", request.Status).Error; err != nil {
+			return err
+		}
+		return updateRating(tx, review.BookID, review.TenantID)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Review not found"})
+		}
+		logger.Error("failed to moderate review", zap.String("id", id), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	logger.Info("review moderated", zap.String("id", id), zap.String("status", request.Status))
+	return c.JSON(http.StatusOK, review)
+}
+
+// updateRating recalculates a book's rating from the approved reviews of
+// the tenant. Raw SQL is not scoped by the tenant plugin, so it filters by
+// tenant_id itself.
+func updateRating(tx *gorm.DB, bookID uint, tenantID string) error {
+	return tx.Exec(`UPDATE books SET `+
+		`rating_count = (SELECT COUNT(*) FROM reviews WHERE book_id = ? AND status = ? AND tenant_id = ?), `+
+		`rating_average = (SELECT COALESCE(AVG(rating), 0) FROM reviews WHERE book_id = ? AND status = ? AND tenant_id = ?) `+
+		`WHERE id = ? AND tenant_id = ?`, bookID, StatusApproved, tenantID, bookID, StatusApproved, tenantID, bookID, tenantID).Error
+}
@@ -0,0 +1,233 @@
+package review
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	getBookByIdQuery        = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2`
+	getCustomerReviewQuery  = `SELECT * FROM "reviews" WHERE book_id = $1 AND customer_id = $2 ORDER BY "reviews"."id" LIMIT $3`
+	createReviewQuery       = `INSERT INTO "reviews" ("book_id","customer_id","rating","text","status","created_at","updated_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`
+	countBookReviewsQuery   = `SELECT count(*) FROM "reviews" WHERE book_id = $1 AND status = $2`
+	getBookReviewsQuery     = `SELECT * FROM "reviews" WHERE book_id = $1 AND status = $2 ORDER BY created_at DESC,id DESC LIMIT $3 OFFSET $4`
+	getReviewByIdQuery      = `SELECT * FROM "reviews" WHERE "reviews"."id" = $1 ORDER BY "reviews"."id" LIMIT $2`
+	updateReviewStatusQuery = `UPDATE "reviews" SET "status"=$1,"updated_at"=$2 WHERE "id" = $3`
+	updateRatingQuery       = `UPDATE books SET rating_count = (SELECT COUNT(*) FROM reviews WHERE book_id = $1 AND status = $2 AND tenant_id = $3), rating_average = (SELECT COALESCE(AVG(rating), 0) FROM reviews WHERE book_id = $4 AND status = $5 AND tenant_id = $6) WHERE id = $7 AND tenant_id = $8`
+)
+
+func newReviewContext(e *echo.Echo, method string, target string, body string, id string) (echo.Context, *httptest.ResponseRecorder) {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set("X-Customer-ID", "customer-1")
+	response := httptest.NewRecorder()
+	c := e.NewContext(request, response)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	return c, response
+}
+
+func TestCreateReview(t *testing.T) {
+	t.Run("create pending review given valid rating", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newReviewContext(e, http.MethodPost, "/books/1/reviews", `{"rating": 5, "text": "Life changing"}`, "1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("1", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(getCustomerReviewQuery).WithArgs(1, "customer-1", 1).WillReturnError(gorm.ErrRecordNotFound)
+		mock.ExpectBegin()
+		mock.ExpectQuery(createReviewQuery).
+			WithArgs(1, "customer-1", 5, "Life changing", StatusPending, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		review := Review{}
+		json.Unmarshal(response.Body.Bytes(), &review)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, uint(9), review.ID)
+		assert.Equal(t, StatusPending, review.Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given customer already reviewed the book", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newReviewContext(e, http.MethodPost, "/books/1/reviews", `{"rating": 4}`, "1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("1", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(getCustomerReviewQuery).WithArgs(1, "customer-1", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+
+		handler := NewHandler(gormDB)
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+	})
+
+	t.Run("return conflict given review added by a concurrent request", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newReviewContext(e, http.MethodPost, "/books/1/reviews", `{"rating": 4}`, "1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("1", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(getCustomerReviewQuery).WithArgs(1, "customer-1", 1).WillReturnError(gorm.ErrRecordNotFound)
+		mock.ExpectBegin()
+		mock.ExpectQuery(createReviewQuery).WillReturnError(gorm.ErrDuplicatedKey)
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB)
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given rating out of range", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newReviewContext(e, http.MethodPost, "/books/1/reviews", `{"rating": 6}`, "1")
+
+		handler := NewHandler(nil)
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestGetReviewsByBook(t *testing.T) {
+	t.Run("list approved reviews given page", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newReviewContext(e, http.MethodGet, "/books/1/reviews?page=2&page_size=1", "", "1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(countBookReviewsQuery).WithArgs("1", StatusApproved).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(getBookReviewsQuery).WithArgs("1", StatusApproved, 1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "customer_id", "rating", "status"}).AddRow(4, 1, "customer-2", 4, StatusApproved))
+
+		handler := NewHandler(gormDB)
+		err := handler.GetByBook(c)
+
+		page := Page{}
+		json.Unmarshal(response.Body.Bytes(), &page)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, int64(2), page.Total)
+		assert.Equal(t, 2, page.Page)
+		assert.Len(t, page.Reviews, 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given page size too large", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newReviewContext(e, http.MethodGet, "/books/1/reviews?page_size=500", "", "1")
+
+		handler := NewHandler(nil)
+		err := handler.GetByBook(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestModerateReview(t *testing.T) {
+	t.Run("approve review and update book rating", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newReviewContext(e, http.MethodPut, "/reviews/9/status", `{"status": "approved"}`, "9")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(getReviewByIdQuery).WithArgs("9", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "customer_id", "rating", "status", "tenant_id"}).AddRow(9, 1, "customer-1", 5, StatusPending, "default"))
+		mock.ExpectExec(updateReviewStatusQuery).WithArgs(StatusApproved, sqlmock.AnyArg(), 9).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(updateRatingQuery).WithArgs(1, StatusApproved, "default", 1, StatusApproved, "default", 1, "default").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		err := handler.Moderate(c)
+
+		review := Review{}
+		json.Unmarshal(response.Body.Bytes(), &review)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, StatusApproved, review.Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return not found given unknown review", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newReviewContext(e, http.MethodPut, "/reviews/9/status", `{"status": "rejected"}`, "9")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(getReviewByIdQuery).WithArgs("9", 1).WillReturnError(gorm.ErrRecordNotFound)
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB)
+		err := handler.Moderate(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("return bad request given unknown status", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newReviewContext(e, http.MethodPut, "/reviews/9/status", `{"status": "hidden"}`, "9")
+
+		handler := NewHandler(nil)
+		err := handler.Moderate(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
@@ -0,0 +1,459 @@
+package rma
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"github.com/phetployst/book-store-api/shipping"
+	"github.com/phetployst/book-store-api/timeline"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	StatusRequested = "requested"
+	StatusApproved  = "approved"
+	StatusRejected  = "rejected"
+	StatusReceived  = "received"
+	StatusRefunding = "refunding"
+	StatusRefunded  = "refunded"
+
+	ConditionResellable = "resellable"
+	ConditionDamaged    = "damaged"
+)
+
+// Return is a customer's request to send back delivered books of an order.
+// It is approved or rejected by staff, received with the condition of each
+// line, and finally refunded. RefundAmount is in the smallest unit of the
+// payment's currency.
+type Return struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	OrderRef     string    `json:"order_ref" gorm:"not null;index"`
+	CustomerID   string    `json:"customer_id" gorm:"not null;index"`
+	Reason       string    `json:"reason" gorm:"not null" validate:"required,max=1000"`
+	Status       string    `json:"status" gorm:"not null;index"`
+	Note         string    `json:"note,omitempty"`
+	Lines        []Line    `json:"lines" gorm:"constraint:OnDelete:CASCADE" validate:"min=1,dive"`
+	RefundAmount int64     `json:"refund_amount"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	TenantID     string    `json:"-" gorm:"not null;default:'default';index"`
+}
+
+type Line struct {
+	ID        uint   `json:"-" gorm:"primaryKey"`
+	ReturnID  uint   `json:"-" gorm:"not null;index"`
+	BookID    uint   `json:"book_id" gorm:"not null" validate:"required"`
+	Quantity  int    `json:"quantity" validate:"min=1"`
+	Condition string `json:"condition,omitempty"`
+	Restocked bool   `json:"restocked"`
+}
+
+// reference identifies the return in stock movements.
+func (r Return) reference() string {
+	return fmt.Sprintf("RMA-%d", r.ID)
+}
+
+func (Line) TableName() string {
+	return "return_lines"
+}
+
+type DecisionRequest struct {
+	Status string `json:"status" validate:"oneof=approved rejected"`
+	Note   string `json:"note" validate:"max=1000"`
+}
+
+type ReceiptLine struct {
+	BookID    uint   `json:"book_id" validate:"required"`
+	Condition string `json:"condition" validate:"oneof=resellable damaged"`
+}
+
+type ReceiptRequest struct {
+	Lines []ReceiptLine `json:"lines" validate:"min=1,dive"`
+	Note  string        `json:"note" validate:"max=1000"`
+}
+
+type RefundRequest struct {
+	Amount int64 `json:"amount" validate:"gt=0"`
+}
+
+// RefundFunc refunds amount of an order's payment.
+type RefundFunc func(c echo.Context, orderRef string, amount int64) (payment.Payment, error)
+
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+func (c *CustomValidator) Validate(i interface{}) error {
+	return c.validator.Struct(i)
+}
+
+type handler struct {
+	db        *gorm.DB
+	refund    RefundFunc
+	onRestock book.RestockFunc
+}
+
+func NewHandler(db *gorm.DB, refund RefundFunc, onRestock book.RestockFunc) *handler {
+	return &handler{db: db, refund: refund, onRestock: onRestock}
+}
+
+type bookQuantity struct {
+	BookID   uint
+	Quantity int
+}
+
+// returnable is how many of each book of the order have been delivered and
+// are not already part of a return that was not rejected. Lines are not
+// scoped by tenant, so the joins filter the shipments and returns by it.
+func returnable(db *gorm.DB, orderRef string, tenantID string) (map[uint]int, error) {
+	delivered := []bookQuantity{}
+	err := db.Model(&shipping.ShipmentLine{}).
+		Select("shipment_lines.book_id, SUM(shipment_lines.quantity) AS quantity").
+		Joins("JOIN shipments ON shipments.id = shipment_lines.shipment_id AND shipments.tenant_id = ?", tenantID).
+		Where("shipments.order_ref = ? AND shipments.status = ?", orderRef, shipping.ShipmentDelivered).
+		Group("shipment_lines.book_id").
+		Scan(&delivered).Error
+	if err != nil {
+		return nil, err
+	}
+
+	returned := []bookQuantity{}
+	err = db.Model(&Line{}).
+		Select("return_lines.book_id, SUM(return_lines.quantity) AS quantity").
+		Joins("JOIN returns ON returns.id = return_lines.return_id AND returns.tenant_id = ?", tenantID).
+		Where("returns.order_ref = ? AND returns.status <> ?", orderRef, StatusRejected).
+		Group("return_lines.book_id").
+		Scan(&returned).Error
+	if err != nil {
+		return nil, err
+	}
+
+	quantities := map[uint]int{}
+	for _, line := range delivered {
+		quantities[line.BookID] += line.Quantity
+	}
+	for _, line := range returned {
+		quantities[line.BookID] -= line.Quantity
+	}
+	return quantities, nil
+}
+
+// lock reads the return with its lines and holds it until tx ends, so only
+// one request at a time can move it on.
+func lock(tx *gorm.DB, id string) (Return, error) {
+	found := Return{}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Lines").First(&found, id).Error
+	return found, err
+}
+
+// conflictError is returned when the return is not in the status a step
+// needs.
+type conflictError string
+
+func (err conflictError) Error() string {
+	return string(err)
+}
+
+// missingConditionError is returned when a receipt leaves out a book of the
+// return.
+type missingConditionError uint
+
+func (err missingConditionError) Error() string {
+	return fmt.Sprintf("condition of book %d is required", uint(err))
+}
+
+func lookupError(c echo.Context, err error) error {
+	var conflict conflictError
+	var missing missingConditionError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Return not found"})
+	case errors.As(err, &conflict):
+		return c.JSON(http.StatusConflict, map[string]string{"error": conflict.Error()})
+	case errors.As(err, &missing):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": missing.Error()})
+	}
+	middleware.GetLogger(c).Error("failed to update return", zap.String("id", c.Param("id")), zap.Error(err))
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+// Create requests a return of delivered lines of the customer's order.
+func (handler *handler) Create(c echo.Context) error {
+	request := Return{}
+	orderRef := c.Param("id")
+	customerID := middleware.GetCustomerID(c)
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	paid := payment.Payment{}
+	if err := handler.db.WithContext(c.Request().Context()).Where("order_ref = ? AND customer_id = ?", orderRef, customerID).First(&paid).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	available, err := returnable(handler.db.WithContext(c.Request().Context()), orderRef, middleware.GetTenantID(c))
+	if err != nil {
+		logger.Error("failed to load returnable lines", zap.String("order_ref", orderRef), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	requested := map[uint]int{}
+	lines := make([]Line, len(request.Lines))
+	for i, line := range request.Lines {
+		requested[line.BookID] += line.Quantity
+		if requested[line.BookID] > available[line.BookID] {
+			return c.JSON(http.StatusUnprocessableEntity, map[string]string{
+				"error": fmt.Sprintf("Only %d of book %d can be returned", max(available[line.BookID], 0), line.BookID),
+			})
+		}
+		lines[i] = Line{BookID: line.BookID, Quantity: line.Quantity}
+	}
+
+	created := Return{OrderRef: orderRef, CustomerID: customerID, Reason: request.Reason, Status: StatusRequested, Lines: lines}
+	err = handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&created).Error; err != nil {
+			return err
+		}
+		return timeline.Record(tx, orderRef, "return.requested", fmt.Sprintf("Return %d requested: %s", created.ID, created.Reason))
+	})
+	if err != nil {
+		logger.Error("failed to insert return", zap.String("order_ref", orderRef), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	logger.Info("return requested", zap.Uint("id", created.ID), zap.String("order_ref", orderRef))
+	return c.JSON(http.StatusCreated, created)
+}
+
+// GetByOrder lists the returns of an order. Customers can only list those
+// of their own orders.
+func (handler *handler) GetByOrder(c echo.Context) error {
+	returns := []Return{}
+	db := handler.db.WithContext(c.Request().Context())
+
+	if middleware.GetStaffID(c) == "" {
+		_, err := payment.FindOrder(db, c.Param("id"), middleware.GetCustomerID(c))
+		if errors.Is(err, payment.ErrPaymentNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+
+	if result := db.Preload("Lines").Where("order_ref = ?", c.Param("id")).Order("id").Find(&returns); result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+	return c.JSON(http.StatusOK, returns)
+}
+
+// Decide approves or rejects a requested return.
+func (handler *handler) Decide(c echo.Context) error {
+	request := DecisionRequest{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	decided := Return{}
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if decided, err = lock(tx, c.Param("id")); err != nil {
+			return err
+		}
+		if decided.Status != StatusRequested {
+			return conflictError("Return has already been " + decided.Status)
+		}
+
+		decided.Status, decided.Note = request.Status, request.Note
+		if err := tx.Omit("Lines").Save(&decided).Error; err != nil {
+			return err
+		}
+		return timeline.Record(tx, decided.OrderRef, "return."+decided.Status, fmt.Sprintf("Return %d %s", decided.ID, decided.Status))
+	})
+	if err != nil {
+		return lookupError(c, err)
+	}
+
+	logger.Info("return decided", zap.Uint("id", decided.ID), zap.String("status", decided.Status))
+	return c.JSON(http.StatusOK, decided)
+}
+
+// Receive records the condition of every line of an approved return when
+// the parcel arrives. Resellable books go back into stock.
+func (handler *handler) Receive(c echo.Context) error {
+	request := ReceiptRequest{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	conditions := map[uint]string{}
+	for _, line := range request.Lines {
+		conditions[line.BookID] = line.Condition
+	}
+
+	received := Return{}
+	resellable := 0
+	restocked := []book.Book{}
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if received, err = lock(tx, c.Param("id")); err != nil {
+			return err
+		}
+		if received.Status != StatusApproved {
+			return conflictError("Only approved returns can be received")
+		}
+
+		for i := range received.Lines {
+			condition, ok := conditions[received.Lines[i].BookID]
+			if !ok {
+				return missingConditionError(received.Lines[i].BookID)
+			}
+			received.Lines[i].Condition = condition
+			if condition == ConditionResellable {
+				received.Lines[i].Restocked = true
+				resellable += received.Lines[i].Quantity
+			}
+		}
+
+		received.Status = StatusReceived
+		if request.Note != "" {
+			received.Note = request.Note
+		}
+
+		for _, line := range received.Lines {
+			if err := tx.Save(&line).Error; err != nil {
+				return err
+			}
+			if !line.Restocked {
+				continue
+			}
+			found, restock, err := book.MoveStock(tx, line.BookID, line.Quantity, book.MovementReturn, received.reference())
+			if err != nil {
+				return err
+			}
+			if restock {
+				restocked = append(restocked, found)
+			}
+		}
+		if err := tx.Omit("Lines").Save(&received).Error; err != nil {
+			return err
+		}
+		return timeline.Record(tx, received.OrderRef, "return.received",
+			fmt.Sprintf("Return %d received, %d books back in stock", received.ID, resellable))
+	})
+	if err != nil {
+		return lookupError(c, err)
+	}
+
+	if handler.onRestock != nil {
+		for _, found := range restocked {
+			handler.onRestock(found)
+		}
+	}
+
+	logger.Info("return received", zap.Uint("id", received.ID), zap.Int("restocked", resellable))
+	return c.JSON(http.StatusOK, received)
+}
+
+// Refund gives back amount of the order's payment for a received return.
+// The amount is decided by staff, so a return can be refunded in part. The
+// return is refunding while the gateway is called, so a second request for
+// the same return is turned away instead of refunding it twice.
+func (handler *handler) Refund(c echo.Context) error {
+	request := RefundRequest{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	db := handler.db.WithContext(c.Request().Context())
+	refunded := Return{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if refunded, err = lock(tx, c.Param("id")); err != nil {
+			return err
+		}
+		if refunded.Status != StatusReceived {
+			return conflictError("Only received returns can be refunded")
+		}
+		return tx.Model(&refunded).Omit("Lines").Update("status", StatusRefunding).Error
+	})
+	if err != nil {
+		return lookupError(c, err)
+	}
+
+	if _, err := handler.refund(c, refunded.OrderRef, request.Amount); err != nil {
+		if err := db.Model(&refunded).Omit("Lines").Update("status", StatusReceived).Error; err != nil {
+			logger.Error("failed to release refunding return", zap.Uint("id", refunded.ID), zap.Error(err))
+		}
+		return refundError(c, err)
+	}
+
+	refunded.Status, refunded.RefundAmount = StatusRefunded, request.Amount
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Lines").Save(&refunded).Error; err != nil {
+			return err
+		}
+		return timeline.Record(tx, refunded.OrderRef, "return.refunded", fmt.Sprintf("Return %d refunded %d", refunded.ID, request.Amount))
+	})
+	if err != nil {
+		logger.Error("failed to save refunded return", zap.Uint("id", refunded.ID), zap.Int64("amount", request.Amount), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	logger.Info("return refunded", zap.Uint("id", refunded.ID), zap.Int64("amount", request.Amount))
+	return c.JSON(http.StatusOK, refunded)
+}
+
+func refundError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, payment.ErrPaymentNotFound), errors.Is(err, payment.ErrNotPaid):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order has no payment to refund"})
+	case errors.Is(err, payment.ErrRefundTooLarge):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "amount must not exceed the amount left to refund"})
+	case errors.Is(err, payment.ErrDeclined):
+		return c.JSON(http.StatusPaymentRequired, map[string]string{"error": "Refund was declined"})
+	}
+	middleware.GetLogger(c).Error("failed to refund return", zap.Error(err))
+	return c.JSON(http.StatusBadGateway, map[string]string{"error": "Refund failed"})
+}
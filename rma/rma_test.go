@@ -0,0 +1,474 @@
+package rma
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	getOrderPaymentQuery       = `SELECT * FROM "payments" WHERE order_ref = $1 AND customer_id = $2 ORDER BY "payments"."id" LIMIT $3`
+	getTenantOrderPaymentQuery = `SELECT * FROM "payments" WHERE (order_ref = $1 AND customer_id = $2) AND "payments"."tenant_id" = $3 ORDER BY "payments"."id" LIMIT $4`
+	deliveredLinesQuery        = `SELECT shipment_lines.book_id, SUM(shipment_lines.quantity) AS quantity FROM "shipment_lines" JOIN shipments ON shipments.id = shipment_lines.shipment_id AND shipments.tenant_id = $1 WHERE shipments.order_ref = $2 AND shipments.status = $3 GROUP BY "shipment_lines"."book_id"`
+	returnedLinesQuery         = `SELECT return_lines.book_id, SUM(return_lines.quantity) AS quantity FROM "return_lines" JOIN returns ON returns.id = return_lines.return_id AND returns.tenant_id = $1 WHERE returns.order_ref = $2 AND returns.status <> $3 GROUP BY "return_lines"."book_id"`
+	createReturnQuery          = `INSERT INTO "returns" ("order_ref","customer_id","reason","status","note","refund_amount","created_at","updated_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "id"`
+	createLinesQuery           = `INSERT INTO "return_lines" ("return_id","book_id","quantity","condition","restocked") VALUES ($1,$2,$3,$4,$5) ON CONFLICT ("id") DO UPDATE SET "return_id"="excluded"."return_id" RETURNING "id"`
+	createTimelineQuery        = `INSERT INTO "order_timeline" ("order_ref","type","message","created_at","tenant_id") VALUES ($1,$2,$3,$4,$5) RETURNING "id"`
+	lockReturnQuery            = `SELECT * FROM "returns" WHERE "returns"."id" = $1 ORDER BY "returns"."id" LIMIT $2 FOR UPDATE`
+	getLinesQuery              = `SELECT * FROM "return_lines" WHERE "return_lines"."return_id" = $1`
+	updateLineQuery            = `UPDATE "return_lines" SET "return_id"=$1,"book_id"=$2,"quantity"=$3,"condition"=$4,"restocked"=$5 WHERE "id" = $6`
+	lockBookQuery              = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2 FOR UPDATE`
+	updateStockQuery           = `UPDATE "books" SET "stock"=$1,"updated_at"=$2 WHERE "books"."deleted_at" IS NULL AND "id" = $3`
+	updateStatusQuery          = `UPDATE "returns" SET "status"=$1,"updated_at"=$2 WHERE "id" = $3`
+	createMovementQuery        = `INSERT INTO "stock_movements" ("book_id","quantity","reason","reference","created_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`
+	getReturnsQuery            = `SELECT * FROM "returns" WHERE order_ref = $1 ORDER BY id`
+	updateReturnQuery          = `UPDATE "returns" SET "order_ref"=$1,"customer_id"=$2,"reason"=$3,"status"=$4,"note"=$5,"refund_amount"=$6,"created_at"=$7,"updated_at"=$8,"tenant_id"=$9 WHERE "id" = $10`
+)
+
+var returnColumns = []string{"id", "order_ref", "customer_id", "reason", "status", "refund_amount", "tenant_id"}
+
+func expectReturnable(mock sqlmock.Sqlmock, delivered int, returned int) {
+	mock.ExpectQuery(getOrderPaymentQuery).WithArgs("order-1", "customer-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "customer_id", "status"}).AddRow(1, "order-1", "customer-1", payment.StatusPaid))
+	mock.ExpectQuery(deliveredLinesQuery).WithArgs("", "order-1", "delivered").
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "quantity"}).AddRow(1, delivered))
+	rows := sqlmock.NewRows([]string{"book_id", "quantity"})
+	if returned > 0 {
+		rows.AddRow(1, returned)
+	}
+	mock.ExpectQuery(returnedLinesQuery).WithArgs("", "order-1", StatusRejected).WillReturnRows(rows)
+}
+
+func expectReturn(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockReturnQuery).WithArgs("1", 1).
+		WillReturnRows(sqlmock.NewRows(returnColumns).AddRow(1, "order-1", "customer-1", "Wrong edition", status, 0, "default"))
+	mock.ExpectQuery(getLinesQuery).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "return_id", "book_id", "quantity"}).AddRow(1, 1, 1, 2))
+}
+
+func TestCreateReturn(t *testing.T) {
+	t.Run("request return given delivered lines", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"reason": "Wrong edition", "lines": [{"book_id": 1, "quantity": 2}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectReturnable(mock, 3, 1)
+		mock.ExpectBegin()
+		mock.ExpectQuery(createReturnQuery).
+			WithArgs("order-1", "customer-1", "Wrong edition", StatusRequested, "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(createLinesQuery).WithArgs(1, 1, 2, "", false).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(createTimelineQuery).WithArgs("order-1", "return.requested", "Return 1 requested: Wrong edition", sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, nil, nil)
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		created := Return{}
+		json.Unmarshal(response.Body.Bytes(), &created)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, StatusRequested, created.Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return unprocessable entity given more than left to return", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"reason": "Wrong edition", "lines": [{"book_id": 1, "quantity": 2}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectReturnable(mock, 2, 1)
+
+		handler := NewHandler(gormDB, nil, nil)
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return not found given order of another customer", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"reason": "Wrong edition", "lines": [{"book_id": 1, "quantity": 1}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getOrderPaymentQuery).WithArgs("order-1", "customer-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		handler := NewHandler(gormDB, nil, nil)
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("request return in the request's storefront given tenant plugin", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"reason": "Wrong edition", "lines": [{"book_id": 1, "quantity": 2}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		request.Header.Set("X-Tenant-ID", "th")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		assert.NoError(t, gormDB.Use(tenant.Plugin{}))
+
+		mock.ExpectQuery(getTenantOrderPaymentQuery).WithArgs("order-1", "customer-1", "th", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "customer_id", "status", "tenant_id"}).AddRow(1, "order-1", "customer-1", payment.StatusPaid, "th"))
+		mock.ExpectQuery(deliveredLinesQuery).WithArgs("th", "order-1", "delivered").
+			WillReturnRows(sqlmock.NewRows([]string{"book_id", "quantity"}).AddRow(1, 3))
+		mock.ExpectQuery(returnedLinesQuery).WithArgs("th", "order-1", StatusRejected).
+			WillReturnRows(sqlmock.NewRows([]string{"book_id", "quantity"}))
+		mock.ExpectBegin()
+		mock.ExpectQuery(createReturnQuery).
+			WithArgs("order-1", "customer-1", "Wrong edition", StatusRequested, "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(createLinesQuery).WithArgs(1, 1, 2, "", false).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(createTimelineQuery).WithArgs("order-1", "return.requested", "Return 1 requested: Wrong edition", sqlmock.AnyArg(), "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, nil, nil)
+		err := middleware.ResolveTenant(nil, "")(middleware.RequireCustomer(handler.Create))(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetReturns(t *testing.T) {
+	t.Run("list returns given order of the customer", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(``))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getOrderPaymentQuery).WithArgs("order-1", "customer-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "customer_id"}).AddRow(1, "order-1", "customer-1"))
+		mock.ExpectQuery(getReturnsQuery).WithArgs("order-1").
+			WillReturnRows(sqlmock.NewRows(returnColumns).AddRow(1, "order-1", "customer-1", "Wrong edition", StatusRequested, 0, "default"))
+		mock.ExpectQuery(getLinesQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "return_id", "book_id", "quantity"}).AddRow(1, 1, 1, 2))
+
+		handler := NewHandler(gormDB, nil, nil)
+		err := middleware.RequireStaffOrCustomer(handler.GetByOrder)(c)
+
+		returns := []Return{}
+		json.Unmarshal(response.Body.Bytes(), &returns)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Len(t, returns, 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return not found given order of another customer", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(``))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getOrderPaymentQuery).WithArgs("order-1", "customer-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		handler := NewHandler(gormDB, nil, nil)
+		err := middleware.RequireStaffOrCustomer(handler.GetByOrder)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDecideReturn(t *testing.T) {
+	t.Run("return conflict given return already decided", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"status": "approved"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectReturn(mock, StatusRejected)
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, nil, nil)
+		err := handler.Decide(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReceiveReturn(t *testing.T) {
+	t.Run("restock resellable books given approved return", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"lines": [{"book_id": 1, "condition": "resellable"}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectReturn(mock, StatusApproved)
+		mock.ExpectExec(updateLineQuery).WithArgs(1, 1, 2, ConditionResellable, true, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(lockBookQuery).WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "stock"}).AddRow(1, "Dune", 0))
+		mock.ExpectExec(updateStockQuery).WithArgs(2, sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(createMovementQuery).WithArgs(1, 2, book.MovementReturn, "RMA-1", sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(updateReturnQuery).
+			WithArgs("order-1", "customer-1", "Wrong edition", StatusReceived, "", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "default", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(createTimelineQuery).WithArgs("order-1", "return.received", "Return 1 received, 2 books back in stock", sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		restocked := []book.Book{}
+		handler := NewHandler(gormDB, nil, func(found book.Book) { restocked = append(restocked, found) })
+		err := handler.Receive(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Len(t, restocked, 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given line without condition", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"lines": [{"book_id": 2, "condition": "damaged"}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectReturn(mock, StatusApproved)
+		mock.ExpectRollback()
+
+		handler := NewHandler(gormDB, nil, nil)
+		err := handler.Receive(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRefundReturn(t *testing.T) {
+	t.Run("refund part of order given received return", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 1200}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectReturn(mock, StatusReceived)
+		mock.ExpectExec(updateStatusQuery).WithArgs(StatusRefunding, sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(updateReturnQuery).
+			WithArgs("order-1", "customer-1", "Wrong edition", StatusRefunded, "", 1200, sqlmock.AnyArg(), sqlmock.AnyArg(), "default", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(createTimelineQuery).WithArgs("order-1", "return.refunded", "Return 1 refunded 1200", sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		refunds := []int64{}
+		handler := NewHandler(gormDB, func(c echo.Context, orderRef string, amount int64) (payment.Payment, error) {
+			refunds = append(refunds, amount)
+			return payment.Payment{OrderRef: orderRef, Refunded: amount}, nil
+		}, nil)
+		err := handler.Refund(c)
+
+		refunded := Return{}
+		json.Unmarshal(response.Body.Bytes(), &refunded)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, []int64{1200}, refunds)
+		assert.Equal(t, int64(1200), refunded.RefundAmount)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given amount above what is left", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 9000}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectReturn(mock, StatusReceived)
+		mock.ExpectExec(updateStatusQuery).WithArgs(StatusRefunding, sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(updateStatusQuery).WithArgs(StatusReceived, sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, func(c echo.Context, orderRef string, amount int64) (payment.Payment, error) {
+			return payment.Payment{}, payment.ErrRefundTooLarge
+		}, nil)
+		err := handler.Refund(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given return already being refunded", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 1200}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set("X-Customer-ID", "customer-1")
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		expectReturn(mock, StatusRefunding)
+		mock.ExpectRollback()
+
+		refunds := 0
+		handler := NewHandler(gormDB, func(c echo.Context, orderRef string, amount int64) (payment.Payment, error) {
+			refunds++
+			return payment.Payment{}, nil
+		}, nil)
+		err := handler.Refund(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.Zero(t, refunds)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
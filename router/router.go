@@ -1,17 +1,266 @@
 package router
 
 import (
+	"net/http"
+	"strings"
+	"time"
+
 	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/blob"
 	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/config"
+	"github.com/phetployst/book-store-api/credit"
+	"github.com/phetployst/book-store-api/digital"
+	"github.com/phetployst/book-store-api/invoice"
+	"github.com/phetployst/book-store-api/lending"
+	"github.com/phetployst/book-store-api/metadata"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/notification"
+	"github.com/phetployst/book-store-api/payment"
+	"github.com/phetployst/book-store-api/preorder"
+	"github.com/phetployst/book-store-api/promotion"
+	"github.com/phetployst/book-store-api/purchasing"
+	"github.com/phetployst/book-store-api/recommendation"
+	"github.com/phetployst/book-store-api/report"
+	"github.com/phetployst/book-store-api/review"
+	"github.com/phetployst/book-store-api/rma"
+	"github.com/phetployst/book-store-api/shipping"
+	"github.com/phetployst/book-store-api/tax"
+	"github.com/phetployst/book-store-api/timeline"
+	"github.com/phetployst/book-store-api/wishlist"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
-func RegisterRoutes(e *echo.Echo, db *gorm.DB) {
-	bookHandler := book.NewHandler(db)
+const (
+	metadataCacheSize        = 10000
+	metadataFailureThreshold = 5
+	metadataCooldown         = 30 * time.Second
+	notifierTimeout          = 5 * time.Second
+	paymentTimeout           = 30 * time.Second
+)
+
+// NewPaymentGateway returns the gateway the configuration chooses. The same
+// gateway is shared by the routes and the jobs that take payments.
+func NewPaymentGateway(cfg config.Config) payment.PaymentGateway {
+	if cfg.Payment.Gateway == "stripe" {
+		return payment.NewStripeGateway(payment.StripeConfig{
+			BaseURL:       cfg.Payment.StripeBaseURL,
+			SecretKey:     cfg.Payment.StripeSecretKey,
+			WebhookSecret: cfg.Payment.WebhookSecret,
+		}, &http.Client{Timeout: paymentTimeout})
+	}
+	return payment.NewFakeGateway(cfg.Payment.WebhookSecret)
+}
+
+// NewBlobStore returns the file store the configuration chooses, or nil
+// when none is configured.
+func NewBlobStore(cfg config.Config) blob.BlobStore {
+	switch cfg.Storage.Driver {
+	case "local":
+		return blob.NewLocalStore(cfg.Storage.LocalDir)
+	case "s3":
+		return blob.NewS3Store(blob.S3Config{
+			Endpoint:        cfg.Storage.S3Endpoint,
+			Region:          cfg.Storage.S3Region,
+			Bucket:          cfg.Storage.S3Bucket,
+			AccessKeyID:     cfg.Storage.S3AccessKeyID,
+			SecretAccessKey: cfg.Storage.S3SecretAccessKey,
+		}, nil)
+	}
+	return nil
+}
+
+// PaymentOptions are the options of every payment handler, whether it
+// serves the routes or the jobs: orders are charged what is left after
+// their tenders, and their digital books are delivered once they are paid.
+func PaymentOptions(db *gorm.DB, cfg config.Config, blobs blob.BlobStore) []payment.Option {
+	return []payment.Option{
+		payment.WithTenders(credit.OrderTender),
+		payment.WithPaidHandler(digital.NewHandler(db, blobs, digitalOptions(cfg), zap.L()).OrderPaid),
+	}
+}
+
+func digitalOptions(cfg config.Config) digital.Options {
+	return digital.Options{
+		SigningSecret: cfg.Digital.SigningSecret,
+		LinkTTL:       time.Duration(cfg.Digital.LinkTTLSeconds) * time.Second,
+		MaxDownloads:  cfg.Digital.MaxDownloads,
+		BaseURL:       strings.TrimSuffix(cfg.Digital.BaseURL, "/"),
+	}
+}
+
+func RegisterRoutes(e *echo.Echo, db *gorm.DB, cfg config.Config, gateway payment.PaymentGateway) {
+	options := []book.Option{}
+	if cfg.Metadata.BaseURL != "" {
+		provider := metadata.NewOpenLibraryClient(cfg.Metadata.BaseURL, time.Duration(cfg.Metadata.TimeoutMilliseconds)*time.Millisecond)
+		breaker := metadata.NewCircuitBreaker(provider, metadataFailureThreshold, metadataCooldown)
+		cache := metadata.NewCachingProvider(breaker, time.Duration(cfg.Metadata.CacheTTLSeconds)*time.Second, metadataCacheSize)
+		options = append(options, book.WithMetadataProvider(cache))
+	}
+
+	blobs := NewBlobStore(cfg)
+	if blobs != nil {
+		options = append(options, book.WithBlobStore(blobs))
+	}
+
+	var notifier notification.Notifier = notification.NewLogNotifier(zap.L())
+	if cfg.Notifier.WebhookURL != "" {
+		notifier = notification.NewWebhookNotifier(cfg.Notifier.WebhookURL, notifierTimeout)
+	}
+	wishlistHandler := wishlist.NewHandler(db, notifier, zap.L())
+	options = append(options, book.WithRestockHandler(wishlistHandler.BookRestocked))
+
+	bookHandler := book.NewHandler(db, options...)
+	e.Server.RegisterOnShutdown(bookHandler.Close)
 
 	e.POST("/books", bookHandler.Create)
+	e.POST("/books\\:batch", bookHandler.Batch)
+	e.POST("/books/enrich", bookHandler.Enrich)
 	e.GET("/books", bookHandler.GetAll)
+	e.GET("/books/events", bookHandler.Events)
+	e.GET("/books/export", bookHandler.Export)
 	e.GET("/books/:id", bookHandler.GetById)
+	e.GET("/books/:id/marc", bookHandler.GetMARC)
+	e.GET("/books/:id/cover", bookHandler.GetCover)
+	e.PUT("/books/:id/cover", bookHandler.UploadCover, middleware.RequireStaff)
 	e.PUT("/books/:id", bookHandler.Update)
 	e.DELETE("/books/:id", bookHandler.Delete)
+	e.POST("/imports", bookHandler.Import)
+	e.GET("/imports/:id", bookHandler.GetImport)
+
+	reviewHandler := review.NewHandler(db)
+	e.POST("/books/:id/reviews", reviewHandler.Create, middleware.RequireCustomer)
+	e.GET("/books/:id/reviews", reviewHandler.GetByBook)
+	e.GET("/reviews", reviewHandler.GetAll, middleware.RequireStaff)
+	e.PUT("/reviews/:id/status", reviewHandler.Moderate, middleware.RequireStaff)
+
+	e.GET("/wishlist", wishlistHandler.GetAll, middleware.RequireCustomer)
+	e.POST("/wishlist", wishlistHandler.Create, middleware.RequireCustomer)
+	e.DELETE("/wishlist/:book_id", wishlistHandler.Delete, middleware.RequireCustomer)
+	e.POST("/books/:id/stock-subscription", wishlistHandler.Subscribe, middleware.RequireCustomer)
+	e.DELETE("/books/:id/stock-subscription", wishlistHandler.Unsubscribe, middleware.RequireCustomer)
+
+	promotionHandler := promotion.NewHandler(db)
+	e.POST("/promotions", promotionHandler.Create, middleware.RequireStaff)
+	e.GET("/promotions", promotionHandler.GetAll, middleware.RequireStaff)
+	e.GET("/promotions/:id", promotionHandler.GetById, middleware.RequireStaff)
+	e.PUT("/promotions/:id", promotionHandler.Update, middleware.RequireStaff)
+	e.DELETE("/promotions/:id", promotionHandler.Delete, middleware.RequireStaff)
+	e.POST("/promotions/quote", promotionHandler.Quote, middleware.RequireCustomer)
+	e.POST("/promotions/redemptions", promotionHandler.Redeem, middleware.RequireCustomer)
+
+	taxHandler := tax.NewHandler(db)
+	e.POST("/tax/jurisdictions", taxHandler.Create, middleware.RequireStaff)
+	e.GET("/tax/jurisdictions", taxHandler.GetAll, middleware.RequireStaff)
+	e.GET("/tax/jurisdictions/:code", taxHandler.GetByCode, middleware.RequireStaff)
+	e.PUT("/tax/jurisdictions/:code", taxHandler.Update, middleware.RequireStaff)
+	e.DELETE("/tax/jurisdictions/:code", taxHandler.Delete, middleware.RequireStaff)
+
+	paymentHandler := payment.NewHandler(db, gateway, PaymentOptions(db, cfg, blobs)...)
+	e.POST("/payments", paymentHandler.Create, middleware.RequireCustomer)
+	e.POST("/payments/webhook", paymentHandler.Webhook)
+	e.GET("/payments/:id", paymentHandler.GetById, middleware.RequireCustomer)
+	e.POST("/payments/:id/capture", paymentHandler.Capture, middleware.RequireStaff)
+	e.POST("/payments/:id/refunds", paymentHandler.Refund, middleware.RequireStaff)
+	e.POST("/payments/:id/void", paymentHandler.Void, middleware.RequireStaff)
+
+	shippingHandler := shipping.NewHandler(db)
+	e.POST("/shipping/zones", shippingHandler.CreateZone, middleware.RequireStaff)
+	e.GET("/shipping/zones", shippingHandler.GetZones, middleware.RequireStaff)
+	e.PUT("/shipping/zones/:id", shippingHandler.UpdateZone, middleware.RequireStaff)
+	e.DELETE("/shipping/zones/:id", shippingHandler.DeleteZone, middleware.RequireStaff)
+	e.POST("/shipping/methods", shippingHandler.CreateMethod, middleware.RequireStaff)
+	e.GET("/shipping/methods", shippingHandler.GetMethods, middleware.RequireStaff)
+	e.PUT("/shipping/methods/:id", shippingHandler.UpdateMethod, middleware.RequireStaff)
+	e.DELETE("/shipping/methods/:id", shippingHandler.DeleteMethod, middleware.RequireStaff)
+	e.POST("/shipping/quote", shippingHandler.Quote)
+	e.POST("/orders/:id/shipments", shippingHandler.CreateShipment, middleware.RequireStaff)
+	e.GET("/orders/:id/shipments", shippingHandler.GetShipments, middleware.RequireStaffOrCustomer)
+	e.PUT("/shipments/:id", shippingHandler.UpdateShipment, middleware.RequireStaff)
+
+	returnHandler := rma.NewHandler(db, paymentHandler.RefundOrder, wishlistHandler.BookRestocked)
+	e.POST("/orders/:id/returns", returnHandler.Create, middleware.RequireCustomer)
+	e.GET("/orders/:id/returns", returnHandler.GetByOrder, middleware.RequireStaffOrCustomer)
+	e.PUT("/returns/:id/status", returnHandler.Decide, middleware.RequireStaff)
+	e.POST("/returns/:id/receipt", returnHandler.Receive, middleware.RequireStaff)
+	e.POST("/returns/:id/refund", returnHandler.Refund, middleware.RequireStaff)
+
+	timelineHandler := timeline.NewHandler(db)
+	e.GET("/orders/:id/timeline", timelineHandler.GetByOrder, middleware.RequireStaffOrCustomer)
+
+	invoiceHandler := invoice.NewHandler(db, invoice.Seller{
+		Name:         cfg.Invoice.SellerName,
+		Address:      cfg.Invoice.SellerAddress,
+		TaxID:        cfg.Invoice.SellerTaxID,
+		Jurisdiction: cfg.Invoice.TaxJurisdiction,
+		NumberPrefix: cfg.Invoice.NumberPrefix,
+	})
+	e.GET("/orders/:id/invoice.pdf", invoiceHandler.PDF, middleware.RequireCustomer)
+
+	purchasingHandler := purchasing.NewHandler(db, wishlistHandler.BookRestocked)
+	e.POST("/suppliers", purchasingHandler.CreateSupplier, middleware.RequireStaff)
+	e.GET("/suppliers", purchasingHandler.GetSuppliers, middleware.RequireStaff)
+	e.GET("/suppliers/:id", purchasingHandler.GetSupplier, middleware.RequireStaff)
+	e.PUT("/suppliers/:id", purchasingHandler.UpdateSupplier, middleware.RequireStaff)
+	e.DELETE("/suppliers/:id", purchasingHandler.DeleteSupplier, middleware.RequireStaff)
+	e.POST("/purchase-orders", purchasingHandler.CreateOrder, middleware.RequireStaff)
+	e.GET("/purchase-orders", purchasingHandler.GetOrders, middleware.RequireStaff)
+	e.GET("/purchase-orders/:id", purchasingHandler.GetOrder, middleware.RequireStaff)
+	e.PUT("/purchase-orders/:id", purchasingHandler.UpdateOrder, middleware.RequireStaff)
+	e.DELETE("/purchase-orders/:id", purchasingHandler.DeleteOrder, middleware.RequireStaff)
+	e.POST("/purchase-orders/:id/send", purchasingHandler.Send, middleware.RequireStaff)
+	e.POST("/purchase-orders/:id/receipts", purchasingHandler.Receive, middleware.RequireStaff)
+	e.GET("/reorder-suggestions", purchasingHandler.ReorderSuggestions, middleware.RequireStaff)
+	e.GET("/books/:id/stock-movements", purchasingHandler.GetStockMovements, middleware.RequireStaff)
+
+	recommendationHandler := recommendation.NewHandler(db)
+	e.GET("/books/:id/related", recommendationHandler.Related)
+
+	lendingHandler := lending.NewHandler(db, lending.Policy{
+		LoanPeriod:   time.Duration(cfg.Lending.LoanDays) * 24 * time.Hour,
+		MaxRenewals:  cfg.Lending.MaxRenewals,
+		PickupPeriod: time.Duration(cfg.Lending.HoldPickupDays) * 24 * time.Hour,
+		FinePerDay:   int64(cfg.Lending.FinePerDay),
+		Currency:     cfg.Lending.FineCurrency,
+	}, notifier, zap.L())
+	e.POST("/books/:id/copies", lendingHandler.CreateCopy, middleware.RequireStaff)
+	e.GET("/books/:id/copies", lendingHandler.GetCopies, middleware.RequireStaff)
+	e.POST("/books/:id/holds", lendingHandler.PlaceHold)
+	e.DELETE("/holds/:id", lendingHandler.CancelHold)
+	e.POST("/loans", lendingHandler.Checkout, middleware.RequireStaff)
+	e.GET("/loans/overdue", lendingHandler.GetOverdue, middleware.RequireStaff)
+	e.POST("/loans/:id/renewal", lendingHandler.Renew)
+	e.POST("/checkins", lendingHandler.Checkin, middleware.RequireStaff)
+	e.GET("/members/:id/loans", lendingHandler.GetMemberLoans, middleware.RequireStaffOrCustomer)
+	e.GET("/members/:id/holds", lendingHandler.GetMemberHolds, middleware.RequireStaffOrCustomer)
+
+	digitalHandler := digital.NewHandler(db, blobs, digitalOptions(cfg), zap.L())
+	e.PUT("/books/:id/file", digitalHandler.UploadFile, middleware.RequireStaff)
+	e.POST("/orders/:id/entitlements", digitalHandler.Grant, middleware.RequireStaff)
+	e.GET("/library", digitalHandler.Library, middleware.RequireCustomer)
+	e.GET("/downloads/:id", digitalHandler.Download)
+
+	creditHandler := credit.NewHandler(db)
+	e.POST("/gift-cards", creditHandler.IssueGiftCard, middleware.RequireStaff)
+	e.GET("/gift-cards/:code", creditHandler.GetGiftCard)
+	e.POST("/customers/:id/store-credit", creditHandler.GrantCredit, middleware.RequireStaff)
+	e.GET("/customers/:id/store-credit", creditHandler.GetCustomerCredit, middleware.RequireStaff)
+	e.GET("/store-credit", creditHandler.GetCredit, middleware.RequireCustomer)
+	e.POST("/orders/:id/tender", creditHandler.ApplyTender, middleware.RequireCustomer)
+	e.GET("/orders/:id/tender", creditHandler.GetTender, middleware.RequireCustomer)
+	e.DELETE("/orders/:id/tender", creditHandler.ReverseTender, middleware.RequireCustomer)
+
+	reportHandler := report.NewHandler(db)
+	e.GET("/reports/revenue", reportHandler.Revenue, middleware.RequireStaff)
+	e.GET("/reports/top-books", reportHandler.TopBooks, middleware.RequireStaff)
+	e.GET("/reports/top-authors", reportHandler.TopAuthors, middleware.RequireStaff)
+	e.GET("/reports/inventory-valuation", reportHandler.InventoryValuation, middleware.RequireStaff)
+	e.GET("/reports/dead-stock", reportHandler.DeadStock, middleware.RequireStaff)
+	e.GET("/reports/new-titles", reportHandler.NewTitles, middleware.RequireStaff)
+
+	preorderHandler := preorder.NewHandler(db, paymentHandler.VoidOrder)
+	e.POST("/books/:id/preorders", preorderHandler.Create, middleware.RequireCustomer)
+	e.GET("/preorders", preorderHandler.GetAll, middleware.RequireCustomer)
+	e.DELETE("/preorders/:id", preorderHandler.Cancel, middleware.RequireCustomer)
 }
@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/config"
+	"github.com/phetployst/book-store-api/payment"
 )
 
 type Route struct {
@@ -19,7 +21,7 @@ func TestRegisterRoutes(t *testing.T) {
 	e := echo.New()
 	defer e.Close()
 
-	RegisterRoutes(e, nil)
+	RegisterRoutes(e, nil, config.Config{}, payment.NewFakeGateway(""))
 
 	request := httptest.NewRequest(http.MethodGet, "/", nil)
 	response := httptest.NewRecorder()
@@ -37,10 +39,111 @@ func TestRegisterRoutes(t *testing.T) {
 
 	want := []Route{
 		{"/books", http.MethodPost},
+		{"/books\\:batch", http.MethodPost},
+		{"/books/enrich", http.MethodPost},
 		{"/books", http.MethodGet},
+		{"/books/events", http.MethodGet},
+		{"/books/export", http.MethodGet},
 		{"/books/:id", http.MethodGet},
+		{"/books/:id/marc", http.MethodGet},
+		{"/books/:id/cover", http.MethodGet},
+		{"/books/:id/cover", http.MethodPut},
 		{"/books/:id", http.MethodPut},
 		{"/books/:id", http.MethodDelete},
+		{"/imports", http.MethodPost},
+		{"/imports/:id", http.MethodGet},
+		{"/books/:id/reviews", http.MethodPost},
+		{"/books/:id/reviews", http.MethodGet},
+		{"/reviews", http.MethodGet},
+		{"/reviews/:id/status", http.MethodPut},
+		{"/wishlist", http.MethodGet},
+		{"/wishlist", http.MethodPost},
+		{"/wishlist/:book_id", http.MethodDelete},
+		{"/books/:id/stock-subscription", http.MethodPost},
+		{"/books/:id/stock-subscription", http.MethodDelete},
+		{"/promotions", http.MethodPost},
+		{"/promotions", http.MethodGet},
+		{"/promotions/:id", http.MethodGet},
+		{"/promotions/:id", http.MethodPut},
+		{"/promotions/:id", http.MethodDelete},
+		{"/promotions/quote", http.MethodPost},
+		{"/promotions/redemptions", http.MethodPost},
+		{"/tax/jurisdictions", http.MethodPost},
+		{"/tax/jurisdictions", http.MethodGet},
+		{"/tax/jurisdictions/:code", http.MethodGet},
+		{"/tax/jurisdictions/:code", http.MethodPut},
+		{"/tax/jurisdictions/:code", http.MethodDelete},
+		{"/payments", http.MethodPost},
+		{"/payments/webhook", http.MethodPost},
+		{"/payments/:id", http.MethodGet},
+		{"/payments/:id/capture", http.MethodPost},
+		{"/payments/:id/refunds", http.MethodPost},
+		{"/payments/:id/void", http.MethodPost},
+		{"/shipping/zones", http.MethodPost},
+		{"/shipping/zones", http.MethodGet},
+		{"/shipping/zones/:id", http.MethodPut},
+		{"/shipping/zones/:id", http.MethodDelete},
+		{"/shipping/methods", http.MethodPost},
+		{"/shipping/methods", http.MethodGet},
+		{"/shipping/methods/:id", http.MethodPut},
+		{"/shipping/methods/:id", http.MethodDelete},
+		{"/shipping/quote", http.MethodPost},
+		{"/orders/:id/shipments", http.MethodPost},
+		{"/orders/:id/shipments", http.MethodGet},
+		{"/orders/:id/returns", http.MethodPost},
+		{"/orders/:id/returns", http.MethodGet},
+		{"/returns/:id/status", http.MethodPut},
+		{"/returns/:id/receipt", http.MethodPost},
+		{"/returns/:id/refund", http.MethodPost},
+		{"/orders/:id/timeline", http.MethodGet},
+		{"/orders/:id/invoice.pdf", http.MethodGet},
+		{"/suppliers", http.MethodPost},
+		{"/suppliers", http.MethodGet},
+		{"/suppliers/:id", http.MethodGet},
+		{"/suppliers/:id", http.MethodPut},
+		{"/suppliers/:id", http.MethodDelete},
+		{"/purchase-orders", http.MethodPost},
+		{"/purchase-orders", http.MethodGet},
+		{"/purchase-orders/:id", http.MethodGet},
+		{"/purchase-orders/:id", http.MethodPut},
+		{"/purchase-orders/:id", http.MethodDelete},
+		{"/purchase-orders/:id/send", http.MethodPost},
+		{"/purchase-orders/:id/receipts", http.MethodPost},
+		{"/reorder-suggestions", http.MethodGet},
+		{"/books/:id/stock-movements", http.MethodGet},
+		{"/books/:id/related", http.MethodGet},
+		{"/books/:id/copies", http.MethodPost},
+		{"/books/:id/copies", http.MethodGet},
+		{"/books/:id/holds", http.MethodPost},
+		{"/holds/:id", http.MethodDelete},
+		{"/loans", http.MethodPost},
+		{"/loans/overdue", http.MethodGet},
+		{"/loans/:id/renewal", http.MethodPost},
+		{"/checkins", http.MethodPost},
+		{"/members/:id/loans", http.MethodGet},
+		{"/members/:id/holds", http.MethodGet},
+		{"/books/:id/file", http.MethodPut},
+		{"/orders/:id/entitlements", http.MethodPost},
+		{"/library", http.MethodGet},
+		{"/downloads/:id", http.MethodGet},
+		{"/gift-cards", http.MethodPost},
+		{"/gift-cards/:code", http.MethodGet},
+		{"/customers/:id/store-credit", http.MethodPost},
+		{"/customers/:id/store-credit", http.MethodGet},
+		{"/store-credit", http.MethodGet},
+		{"/orders/:id/tender", http.MethodPost},
+		{"/orders/:id/tender", http.MethodGet},
+		{"/orders/:id/tender", http.MethodDelete},
+		{"/reports/revenue", http.MethodGet},
+		{"/reports/top-books", http.MethodGet},
+		{"/reports/top-authors", http.MethodGet},
+		{"/reports/inventory-valuation", http.MethodGet},
+		{"/reports/dead-stock", http.MethodGet},
+		{"/reports/new-titles", http.MethodGet},
+		{"/books/:id/preorders", http.MethodPost},
+		{"/preorders", http.MethodGet},
+		{"/preorders/:id", http.MethodDelete},
+		{"/shipments/:id", http.MethodPut},
 	}
 
 	sort.Slice(got, func(i, j int) bool {
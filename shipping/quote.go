@@ -0,0 +1,192 @@
+package shipping
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type CartItem struct {
+	BookID   uint `json:"book_id"`
+	Quantity int  `json:"quantity"`
+}
+
+type QuoteRequest struct {
+	Items   []CartItem `json:"items"`
+	Country string     `json:"country"`
+}
+
+type Option struct {
+	MethodID uint    `json:"method_id"`
+	Code     string  `json:"code"`
+	Name     string  `json:"name"`
+	Carrier  string  `json:"carrier,omitempty"`
+	Price    float64 `json:"price"`
+}
+
+type Quote struct {
+	Zone        string   `json:"zone"`
+	WeightGrams int      `json:"weight_grams"`
+	Items       int      `json:"items"`
+	Options     []Option `json:"options"`
+}
+
+// ZoneFor returns the zone that lists country, or else the zone that lists
+// RestOfWorld.
+func ZoneFor(zones []Zone, country string) (Zone, bool) {
+	fallback, found := Zone{}, false
+	for _, zone := range zones {
+		for _, listed := range zone.Countries {
+			if listed == country {
+				return zone, true
+			}
+			if listed == RestOfWorld && !found {
+				fallback, found = zone, true
+			}
+		}
+	}
+	return fallback, found
+}
+
+// rateFor picks the rate of the zone with the lowest limit that still
+// covers value.
+func rateFor(rates []Rate, zoneID uint, value int) (Rate, bool) {
+	best, found := Rate{}, false
+	for _, rate := range rates {
+		if rate.ZoneID != zoneID || (rate.UpTo != 0 && rate.UpTo < value) {
+			continue
+		}
+		if !found || (best.UpTo == 0 && rate.UpTo != 0) || (rate.UpTo != 0 && rate.UpTo < best.UpTo) {
+			best, found = rate, true
+		}
+	}
+	return best, found
+}
+
+// Options lists the methods that can ship a parcel of weight grams and
+// items books to zone, cheapest first. Methods without a rate for the zone,
+// or whose rates stop below the parcel, are left out.
+func Options(methods []Method, zone Zone, weight, items int) []Option {
+	options := []Option{}
+	for _, method := range methods {
+		value := weight
+		if method.Basis == BasisItems {
+			value = items
+		}
+		rate, ok := rateFor(method.Rates, zone.ID, value)
+		if !ok {
+			continue
+		}
+		options = append(options, Option{
+			MethodID: method.ID,
+			Code:     method.Code,
+			Name:     method.Name,
+			Carrier:  method.Carrier,
+			Price:    rate.Price,
+		})
+	}
+	sort.SliceStable(options, func(i, j int) bool {
+		if options[i].Price != options[j].Price {
+			return options[i].Price < options[j].Price
+		}
+		return options[i].Code < options[j].Code
+	})
+	return options
+}
+
+// parcel loads the books of the items and returns their total weight in
+// grams and number of items.
+func parcel(db *gorm.DB, items []CartItem) (int, int, error) {
+	ids := make([]uint, 0, len(items))
+	for _, item := range items {
+		if item.Quantity < 1 {
+			return 0, 0, errInvalidCart{fmt.Sprintf("quantity of book %d must be at least 1", item.BookID)}
+		}
+		ids = append(ids, item.BookID)
+	}
+
+	books := []book.Book{}
+	if err := db.Find(&books, ids).Error; err != nil {
+		return 0, 0, err
+	}
+	weights := make(map[uint]int, len(books))
+	for _, found := range books {
+		weights[found.ID] = found.WeightGrams
+	}
+
+	weight, count := 0, 0
+	for _, item := range items {
+		grams, ok := weights[item.BookID]
+		if !ok {
+			return 0, 0, errInvalidCart{fmt.Sprintf("book %d not found", item.BookID)}
+		}
+		weight += grams * item.Quantity
+		count += item.Quantity
+	}
+	return weight, count, nil
+}
+
+type errInvalidCart struct {
+	message string
+}
+
+func (err errInvalidCart) Error() string {
+	return err.message
+}
+
+// Quote lists the shipping options and prices for a cart sent to a
+// country.
+func (handler *handler) Quote(c echo.Context) error {
+	request := QuoteRequest{}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if len(request.Items) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "items must not be empty"})
+	}
+	country := strings.ToUpper(strings.TrimSpace(request.Country))
+	if !countryPattern.MatchString(country) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "country must be a two letter code"})
+	}
+
+	zones := []Zone{}
+	if err := handler.db.WithContext(c.Request().Context()).Find(&zones).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	zone, ok := ZoneFor(zones, country)
+	if !ok {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "We do not ship to " + country})
+	}
+
+	weight, count, err := parcel(handler.db.WithContext(c.Request().Context()), request.Items)
+	if err != nil {
+		var invalid errInvalidCart
+		if errors.As(err, &invalid) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		logger.Error("failed to load books to ship", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	methods := []Method{}
+	if err := handler.db.WithContext(c.Request().Context()).Preload("Rates").Find(&methods).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, Quote{
+		Zone:        zone.Name,
+		WeightGrams: weight,
+		Items:       count,
+		Options:     Options(methods, zone, weight, count),
+	})
+}
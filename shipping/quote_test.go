@@ -0,0 +1,123 @@
+package shipping
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestZoneFor(t *testing.T) {
+	zones := []Zone{
+		{ID: 1, Name: "Rest of world", Countries: []string{RestOfWorld}},
+		{ID: 2, Name: "Domestic", Countries: []string{"TH"}},
+	}
+
+	t.Run("return listed zone given listed country", func(t *testing.T) {
+		zone, ok := ZoneFor(zones, "TH")
+
+		assert.True(t, ok)
+		assert.Equal(t, "Domestic", zone.Name)
+	})
+
+	t.Run("return rest of world given unlisted country", func(t *testing.T) {
+		zone, ok := ZoneFor(zones, "FR")
+
+		assert.True(t, ok)
+		assert.Equal(t, "Rest of world", zone.Name)
+	})
+
+	t.Run("return nothing given unlisted country and no rest of world", func(t *testing.T) {
+		_, ok := ZoneFor(zones[1:], "FR")
+
+		assert.False(t, ok)
+	})
+}
+
+func TestOptions(t *testing.T) {
+	methods := []Method{
+		{ID: 1, Code: "standard", Name: "Standard", Basis: BasisWeight, Rates: []Rate{
+			{ZoneID: 1, UpTo: 500, Price: 3},
+			{ZoneID: 1, UpTo: 2000, Price: 5},
+			{ZoneID: 1, UpTo: 0, Price: 9},
+			{ZoneID: 2, UpTo: 0, Price: 20},
+		}},
+		{ID: 2, Code: "express", Name: "Express", Basis: BasisItems, Rates: []Rate{
+			{ZoneID: 1, UpTo: 3, Price: 12},
+		}},
+		{ID: 3, Code: "pickup", Name: "Pick up in store", Basis: BasisItems, Rates: []Rate{
+			{ZoneID: 1, UpTo: 0, Price: 0},
+		}},
+	}
+
+	t.Run("pick the lowest covering rate given parcel weight", func(t *testing.T) {
+		options := Options(methods, Zone{ID: 1}, 1200, 2)
+
+		assert.Equal(t, []string{"pickup", "standard", "express"}, []string{options[0].Code, options[1].Code, options[2].Code})
+		assert.Equal(t, 5.0, options[1].Price)
+	})
+
+	t.Run("use the unlimited rate given parcel above every limit", func(t *testing.T) {
+		options := Options(methods, Zone{ID: 1}, 4000, 2)
+
+		assert.Equal(t, 9.0, options[1].Price)
+	})
+
+	t.Run("leave out methods whose rates stop below the parcel", func(t *testing.T) {
+		options := Options(methods, Zone{ID: 1}, 400, 5)
+
+		assert.Len(t, options, 2)
+	})
+
+	t.Run("leave out methods without a rate for the zone", func(t *testing.T) {
+		options := Options(methods, Zone{ID: 2}, 400, 1)
+
+		assert.Equal(t, []Option{{MethodID: 1, Code: "standard", Name: "Standard", Price: 20}}, options)
+	})
+}
+
+func TestQuote(t *testing.T) {
+	t.Run("return options given cart and country", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"items": [{"book_id": 1, "quantity": 3}], "country": "th"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(`SELECT * FROM "shipping_zones"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "countries"}).AddRow(1, "Domestic", `["TH"]`))
+		mock.ExpectQuery(`SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL`).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "weight_grams"}).AddRow(1, 300))
+		mock.ExpectQuery(`SELECT * FROM "shipping_methods"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "code", "name", "basis"}).AddRow(1, "standard", "Standard", BasisWeight))
+		mock.ExpectQuery(`SELECT * FROM "shipping_rates" WHERE "shipping_rates"."method_id" = $1`).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "method_id", "zone_id", "up_to", "price"}).
+				AddRow(1, 1, 1, 500, 3.0).
+				AddRow(2, 1, 1, 0, 6.0))
+
+		handler := NewHandler(gormDB)
+		err := handler.Quote(c)
+
+		quote := Quote{}
+		json.Unmarshal(response.Body.Bytes(), &quote)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, 900, quote.WeightGrams)
+		assert.Equal(t, 6.0, quote.Options[0].Price)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,200 @@
+package shipping
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	ShipmentPending   = "pending"
+	ShipmentShipped   = "shipped"
+	ShipmentDelivered = "delivered"
+)
+
+var shipmentProgress = map[string]int{ShipmentPending: 0, ShipmentShipped: 1, ShipmentDelivered: 2}
+
+// Shipment is a parcel sent for an order. An order whose books leave in
+// more than one parcel has a shipment for each, listing the lines in it.
+type Shipment struct {
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	OrderRef       string         `json:"order_ref" gorm:"not null;index"`
+	Method         string         `json:"method" gorm:"not null" validate:"required"`
+	Carrier        string         `json:"carrier"`
+	TrackingNumber string         `json:"tracking_number"`
+	Status         string         `json:"status" gorm:"not null"`
+	Lines          []ShipmentLine `json:"lines" gorm:"constraint:OnDelete:CASCADE" validate:"min=1,dive"`
+	ShippedAt      *time.Time     `json:"shipped_at"`
+	DeliveredAt    *time.Time     `json:"delivered_at"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	TenantID       string         `json:"-" gorm:"not null;default:'default';index"`
+}
+
+type ShipmentLine struct {
+	ID         uint `json:"-" gorm:"primaryKey"`
+	ShipmentID uint `json:"-" gorm:"not null;index"`
+	BookID     uint `json:"book_id" gorm:"not null" validate:"required"`
+	Quantity   int  `json:"quantity" validate:"min=1"`
+}
+
+type ShipmentUpdate struct {
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"tracking_number"`
+	Status         string `json:"status" validate:"omitempty,oneof=pending shipped delivered"`
+}
+
+// advance moves the shipment to status and stamps when it happened.
+// Shipments never move back.
+func (shipment *Shipment) advance(status string, now time.Time) error {
+	if shipmentProgress[status] < shipmentProgress[shipment.Status] {
+		return errors.New("shipment status cannot go back to " + status)
+	}
+	if status != ShipmentPending && shipment.ShippedAt == nil {
+		shipment.ShippedAt = &now
+	}
+	if status == ShipmentDelivered && shipment.DeliveredAt == nil {
+		shipment.DeliveredAt = &now
+	}
+	shipment.Status = status
+	return nil
+}
+
+// CreateShipment records a parcel for the order in the path. It starts as
+// shipped when it already has a tracking number.
+func (handler *handler) CreateShipment(c echo.Context) error {
+	shipment := Shipment{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&shipment); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	shipment.ID, shipment.OrderRef = 0, c.Param("id")
+	shipment.Status, shipment.ShippedAt, shipment.DeliveredAt = ShipmentPending, nil, nil
+	if err := c.Validate(shipment); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	method := Method{}
+	if err := handler.db.WithContext(c.Request().Context()).Where("code = ?", shipment.Method).First(&method).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown shipping method " + shipment.Method})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if shipment.Carrier == "" {
+		shipment.Carrier = method.Carrier
+	}
+
+	ids := map[uint]bool{}
+	for i := range shipment.Lines {
+		shipment.Lines[i].ID = 0
+		ids[shipment.Lines[i].BookID] = true
+	}
+	var found int64
+	if err := handler.db.WithContext(c.Request().Context()).Model(&book.Book{}).Where("id IN ?", keys(ids)).Count(&found).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if int(found) != len(ids) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Shipment lists a book that does not exist"})
+	}
+
+	if shipment.TrackingNumber != "" {
+		shipment.advance(ShipmentShipped, handler.now())
+	}
+
+	if result := handler.db.WithContext(c.Request().Context()).Create(&shipment); result.Error != nil {
+		logger.Error("failed to insert shipment", zap.String("order_ref", shipment.OrderRef), zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+
+	logger.Info("shipment created", zap.Uint("id", shipment.ID), zap.String("order_ref", shipment.OrderRef))
+	return c.JSON(http.StatusCreated, shipment)
+}
+
+// GetShipments lists the shipments of an order. Customers can only list
+// those of their own orders.
+func (handler *handler) GetShipments(c echo.Context) error {
+	shipments := []Shipment{}
+	db := handler.db.WithContext(c.Request().Context())
+
+	if middleware.GetStaffID(c) == "" {
+		_, err := payment.FindOrder(db, c.Param("id"), middleware.GetCustomerID(c))
+		if errors.Is(err, payment.ErrPaymentNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+
+	if result := db.Preload("Lines").Where("order_ref = ?", c.Param("id")).Order("id").Find(&shipments); result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+	return c.JSON(http.StatusOK, shipments)
+}
+
+// UpdateShipment sets the carrier, tracking number or status of a shipment.
+func (handler *handler) UpdateShipment(c echo.Context) error {
+	request := ShipmentUpdate{}
+	shipment := Shipment{}
+	id := c.Param("id")
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	if err := handler.db.WithContext(c.Request().Context()).Preload("Lines").First(&shipment, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Shipment not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	if request.Carrier != "" {
+		shipment.Carrier = request.Carrier
+	}
+	if request.TrackingNumber != "" {
+		shipment.TrackingNumber = request.TrackingNumber
+	}
+	if request.Status != "" {
+		if err := shipment.advance(request.Status, handler.now()); err != nil {
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+	}
+
+	if result := handler.db.WithContext(c.Request().Context()).Omit("Lines").Save(&shipment); result.Error != nil {
+		logger.Error("failed to update shipment", zap.String("id", id), zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update shipment"})
+	}
+
+	logger.Info("shipment updated", zap.String("id", id), zap.String("status", shipment.Status))
+	return c.JSON(http.StatusOK, shipment)
+}
+
+func keys(values map[uint]bool) []uint {
+	result := make([]uint, 0, len(values))
+	for value := range values {
+		result = append(result, value)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
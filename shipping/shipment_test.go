@@ -0,0 +1,213 @@
+package shipping
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/tenant"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	getMethodByCodeQuery    = `SELECT * FROM "shipping_methods" WHERE code = $1 ORDER BY "shipping_methods"."id" LIMIT $2`
+	countBooksQuery         = `SELECT count(*) FROM "books" WHERE id IN ($1,$2) AND "books"."deleted_at" IS NULL`
+	createShipmentQuery     = `INSERT INTO "shipments" ("order_ref","method","carrier","tracking_number","status","shipped_at","delivered_at","created_at","updated_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING "id"`
+	createLinesQuery        = `INSERT INTO "shipment_lines" ("shipment_id","book_id","quantity") VALUES ($1,$2,$3),($4,$5,$6) ON CONFLICT ("id") DO UPDATE SET "shipment_id"="excluded"."shipment_id" RETURNING "id"`
+	getShipmentQuery        = `SELECT * FROM "shipments" WHERE "shipments"."id" = $1 ORDER BY "shipments"."id" LIMIT $2`
+	getLinesQuery           = `SELECT * FROM "shipment_lines" WHERE "shipment_lines"."shipment_id" = $1`
+	getOrderPaymentQuery    = `SELECT * FROM "payments" WHERE order_ref = $1 AND customer_id = $2 ORDER BY "payments"."id" LIMIT $3`
+	getShipmentsQuery       = `SELECT * FROM "shipments" WHERE order_ref = $1 ORDER BY id`
+	getTenantShipmentsQuery = `SELECT * FROM "shipments" WHERE order_ref = $1 AND "shipments"."tenant_id" = $2 ORDER BY id`
+)
+
+func TestCreateShipment(t *testing.T) {
+	t.Run("create shipped partial shipment given tracking number", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"method": "standard", "tracking_number": "EB123456789TH", "lines": [{"book_id": 2, "quantity": 1}, {"book_id": 1, "quantity": 2}]}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		now := time.Date(2024, 10, 1, 9, 0, 0, 0, time.UTC)
+
+		mock.ExpectQuery(getMethodByCodeQuery).WithArgs("standard", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "code", "carrier"}).AddRow(1, "standard", "Thailand Post"))
+		mock.ExpectQuery(countBooksQuery).WithArgs(1, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectBegin()
+		mock.ExpectQuery(createShipmentQuery).
+			WithArgs("order-1", "standard", "Thailand Post", "EB123456789TH", ShipmentShipped, now, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(createLinesQuery).WithArgs(1, 2, 1, 1, 1, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		handler.now = func() time.Time { return now }
+		err := handler.CreateShipment(c)
+
+		shipment := Shipment{}
+		json.Unmarshal(response.Body.Bytes(), &shipment)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, ShipmentShipped, shipment.Status)
+		assert.Len(t, shipment.Lines, 2)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given no lines", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"method": "standard", "lines": []}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		handler := NewHandler(nil)
+		err := handler.CreateShipment(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestUpdateShipment(t *testing.T) {
+	t.Run("return conflict given status going back", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"status": "pending"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getShipmentQuery).WithArgs("1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "method", "status"}).AddRow(1, "order-1", "standard", ShipmentDelivered))
+		mock.ExpectQuery(getLinesQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "shipment_id", "book_id", "quantity"}).AddRow(1, 1, 1, 2))
+
+		handler := NewHandler(gormDB)
+		err := handler.UpdateShipment(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetShipments(t *testing.T) {
+	t.Run("return not found given order of another customer", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(``))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+		c.Request().Header.Set("X-Customer-ID", "customer-2")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getOrderPaymentQuery).WithArgs("order-1", "customer-2", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		handler := NewHandler(gormDB)
+		err := middleware.RequireStaffOrCustomer(handler.GetShipments)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("list shipments of any order given staff", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(``))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+		c.Request().Header.Set("X-Staff-ID", "staff-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getShipmentsQuery).WithArgs("order-1").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "status"}).AddRow(1, "order-1", ShipmentShipped))
+		mock.ExpectQuery(getLinesQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "shipment_id", "book_id", "quantity"}).AddRow(1, 1, 1, 1))
+
+		handler := NewHandler(gormDB)
+		err := middleware.RequireStaffOrCustomer(handler.GetShipments)(c)
+
+		shipments := []Shipment{}
+		json.Unmarshal(response.Body.Bytes(), &shipments)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Len(t, shipments, 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("list shipments of the request's storefront given tenant plugin", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Staff-ID", "staff-1")
+		request = request.WithContext(tenant.NewContext(request.Context(), "th"))
+		response := httptest.NewRecorder()
+		c := e.NewContext(request, response)
+		c.SetParamNames("id")
+		c.SetParamValues("order-1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+		assert.NoError(t, gormDB.Use(tenant.Plugin{}))
+
+		mock.ExpectQuery(getTenantShipmentsQuery).WithArgs("order-1", "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "order_ref", "status", "tenant_id"}).AddRow(1, "order-1", ShipmentShipped, "th"))
+		mock.ExpectQuery(getLinesQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "shipment_id", "book_id", "quantity"}).AddRow(1, 1, 1, 1))
+
+		handler := NewHandler(gormDB)
+		err := middleware.RequireStaffOrCustomer(handler.GetShipments)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,283 @@
+package shipping
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	BasisWeight = "weight"
+	BasisItems  = "items"
+
+	// RestOfWorld in a zone's countries matches every country that no other
+	// zone lists.
+	RestOfWorld = "*"
+)
+
+var countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// Zone groups the countries that share shipping rates.
+type Zone struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"not null" validate:"required"`
+	Countries []string  `json:"countries" gorm:"serializer:json" validate:"min=1"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	TenantID  string    `json:"-" gorm:"not null;default:'default';index"`
+}
+
+func (Zone) TableName() string {
+	return "shipping_zones"
+}
+
+// Method is a way of shipping, such as standard, express or pickup. Its
+// rates are priced by the cart's total weight in grams or by its number of
+// items, depending on Basis.
+type Method struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Code      string    `json:"code" gorm:"not null;uniqueIndex:idx_shipping_methods_tenant_code" validate:"required,max=50"`
+	Name      string    `json:"name" gorm:"not null" validate:"required"`
+	Carrier   string    `json:"carrier"`
+	Basis     string    `json:"basis" gorm:"not null" validate:"oneof=weight items"`
+	Rates     []Rate    `json:"rates" gorm:"constraint:OnDelete:CASCADE" validate:"dive"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	TenantID  string    `json:"-" gorm:"not null;default:'default';uniqueIndex:idx_shipping_methods_tenant_code"`
+}
+
+func (Method) TableName() string {
+	return "shipping_methods"
+}
+
+// Rate is the price of a method in a zone for carts up to UpTo grams or
+// items. A rate with UpTo 0 has no limit.
+type Rate struct {
+	ID       uint    `json:"-" gorm:"primaryKey"`
+	MethodID uint    `json:"-" gorm:"not null;index"`
+	ZoneID   uint    `json:"zone_id" gorm:"not null" validate:"required"`
+	UpTo     int     `json:"up_to" validate:"gte=0"`
+	Price    float64 `json:"price" validate:"gte=0"`
+	TenantID string  `json:"-" gorm:"not null;default:'default';index"`
+}
+
+func (Rate) TableName() string {
+	return "shipping_rates"
+}
+
+// validateCountries accepts ISO 3166 alpha-2 codes such as "TH" and
+// RestOfWorld.
+func (zone Zone) validateCountries() error {
+	for _, country := range zone.Countries {
+		if country != RestOfWorld && !countryPattern.MatchString(country) {
+			return fmt.Errorf("country %q must be a two letter code or %s", country, RestOfWorld)
+		}
+	}
+	return nil
+}
+
+func (method Method) validateRates() error {
+	seen := map[string]bool{}
+	for _, rate := range method.Rates {
+		key := fmt.Sprintf("%d/%d", rate.ZoneID, rate.UpTo)
+		if seen[key] {
+			return fmt.Errorf("zone %d has more than one rate up to %d", rate.ZoneID, rate.UpTo)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+func (c *CustomValidator) Validate(i interface{}) error {
+	if err := c.validator.Struct(i); err != nil {
+		return err
+	}
+	switch value := i.(type) {
+	case Zone:
+		return value.validateCountries()
+	case Method:
+		return value.validateRates()
+	}
+	return nil
+}
+
+type handler struct {
+	db  *gorm.DB
+	now func() time.Time
+}
+
+func NewHandler(db *gorm.DB) *handler {
+	return &handler{db: db, now: time.Now}
+}
+
+func (handler *handler) CreateZone(c echo.Context) error {
+	zone := Zone{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&zone); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	zone.ID = 0
+	if err := c.Validate(zone); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	if result := handler.db.WithContext(c.Request().Context()).Create(&zone); result.Error != nil {
+		logger.Error("failed to insert shipping zone", zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+
+	logger.Info("shipping zone created", zap.Uint("id", zone.ID))
+	return c.JSON(http.StatusCreated, zone)
+}
+
+func (handler *handler) GetZones(c echo.Context) error {
+	zones := []Zone{}
+	if result := handler.db.WithContext(c.Request().Context()).Order("id").Find(&zones); result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+	return c.JSON(http.StatusOK, zones)
+}
+
+func (handler *handler) UpdateZone(c echo.Context) error {
+	zone := Zone{}
+	id := c.Param("id")
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := handler.db.WithContext(c.Request().Context()).First(&zone, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Shipping zone not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	zoneID := zone.ID
+	if err := c.Bind(&zone); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	zone.ID = zoneID
+	if err := c.Validate(zone); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	if result := handler.db.WithContext(c.Request().Context()).Save(&zone); result.Error != nil {
+		logger.Error("failed to update shipping zone", zap.String("id", id), zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update shipping zone"})
+	}
+	return c.JSON(http.StatusOK, zone)
+}
+
+func (handler *handler) DeleteZone(c echo.Context) error {
+	result := handler.db.WithContext(c.Request().Context()).Delete(&Zone{}, c.Param("id"))
+	if result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Shipping zone not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Shipping zone successfully deleted"})
+}
+
+func (handler *handler) CreateMethod(c echo.Context) error {
+	method := Method{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&method); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	method.ID = 0
+	if err := c.Validate(method); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	if result := handler.db.WithContext(c.Request().Context()).Create(&method); result.Error != nil {
+		logger.Error("failed to insert shipping method", zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+
+	logger.Info("shipping method created", zap.String("code", method.Code))
+	return c.JSON(http.StatusCreated, method)
+}
+
+func (handler *handler) GetMethods(c echo.Context) error {
+	methods := []Method{}
+	if result := handler.db.WithContext(c.Request().Context()).Preload("Rates").Order("id").Find(&methods); result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+	return c.JSON(http.StatusOK, methods)
+}
+
+// UpdateMethod replaces a method's details and its whole rate table.
+func (handler *handler) UpdateMethod(c echo.Context) error {
+	method := Method{}
+	id := c.Param("id")
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := handler.db.WithContext(c.Request().Context()).First(&method, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Shipping method not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	methodID := method.ID
+	if err := c.Bind(&method); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	method.ID = methodID
+	if err := c.Validate(method); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	err := handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("method_id = ?", method.ID).Delete(&Rate{}).Error; err != nil {
+			return err
+		}
+		for i := range method.Rates {
+			method.Rates[i].ID = 0
+			method.Rates[i].MethodID = method.ID
+		}
+		return tx.Save(&method).Error
+	})
+	if err != nil {
+		logger.Error("failed to update shipping method", zap.String("id", id), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update shipping method"})
+	}
+	return c.JSON(http.StatusOK, method)
+}
+
+func (handler *handler) DeleteMethod(c echo.Context) error {
+	result := handler.db.WithContext(c.Request().Context()).Delete(&Method{}, c.Param("id"))
+	if result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Shipping method not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Shipping method successfully deleted"})
+}
@@ -0,0 +1,83 @@
+package tax
+
+// Line is one order line to tax. Amount is the line total in cents after
+// discounts, with or without tax depending on the jurisdiction.
+type Line struct {
+	BookID uint
+	Class  string
+	Amount int64
+}
+
+type LineTax struct {
+	BookID uint    `json:"book_id"`
+	Class  string  `json:"tax_class"`
+	Rate   float64 `json:"rate"`
+	Net    int64   `json:"net"`
+	Tax    int64   `json:"tax"`
+	Gross  int64   `json:"gross"`
+}
+
+// Result holds per line and total amounts in cents. Totals are the sums of
+// the rounded line amounts, so they always match an itemised invoice.
+type Result struct {
+	Jurisdiction     string    `json:"jurisdiction"`
+	PricesIncludeTax bool      `json:"prices_include_tax"`
+	Lines            []LineTax `json:"lines"`
+	Net              int64     `json:"net"`
+	Tax              int64     `json:"tax"`
+	Gross            int64     `json:"gross"`
+}
+
+// rateScale turns a percentage into parts per million so that rates such as
+// 8.875% are exact integers.
+const rateScale = 10_000
+
+// Calculate taxes every line at the jurisdiction's rate for its class. Tax
+// is rounded per line to the nearest cent, halves to even. Classes without
+// a rate in the jurisdiction are not taxed.
+func Calculate(jurisdiction Jurisdiction, lines []Line) Result {
+	result := Result{
+		Jurisdiction:     jurisdiction.Code,
+		PricesIncludeTax: jurisdiction.PricesIncludeTax,
+		Lines:            make([]LineTax, 0, len(lines)),
+	}
+
+	for _, line := range lines {
+		class := ClassOf(line.Class)
+		rate := jurisdiction.rate(class)
+		ppm := int64(rate*rateScale + 0.5)
+
+		taxed := LineTax{BookID: line.BookID, Class: class, Rate: rate}
+		if jurisdiction.PricesIncludeTax {
+			taxed.Gross = line.Amount
+			taxed.Tax = divideHalfEven(line.Amount*ppm, 100*rateScale+ppm)
+			taxed.Net = taxed.Gross - taxed.Tax
+		} else {
+			taxed.Net = line.Amount
+			taxed.Tax = divideHalfEven(line.Amount*ppm, 100*rateScale)
+			taxed.Gross = taxed.Net + taxed.Tax
+		}
+
+		result.Lines = append(result.Lines, taxed)
+		result.Net += taxed.Net
+		result.Tax += taxed.Tax
+		result.Gross += taxed.Gross
+	}
+	return result
+}
+
+// divideHalfEven divides and rounds to the nearest integer, halves to even
+// (banker's rounding), so rounding errors do not drift in one direction.
+func divideHalfEven(numerator, denominator int64) int64 {
+	if numerator < 0 {
+		return -divideHalfEven(-numerator, denominator)
+	}
+	quotient, remainder := numerator/denominator, numerator%denominator
+	switch {
+	case 2*remainder > denominator:
+		quotient++
+	case 2*remainder == denominator && quotient%2 == 1:
+		quotient++
+	}
+	return quotient
+}
@@ -0,0 +1,54 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculate(t *testing.T) {
+	unitedStates := Jurisdiction{Code: "US-NY", Rates: []Rate{{Class: ClassPrint, Rate: 8.875}, {Class: ClassEbook, Rate: 5}}}
+	unitedKingdom := Jurisdiction{Code: "GB", PricesIncludeTax: true, Rates: []Rate{{Class: ClassPrint, Rate: 0}, {Class: ClassEbook, Rate: 0}, {Class: ClassAudiobook, Rate: 20}}}
+
+	t.Run("add tax to every line given prices exclude tax", func(t *testing.T) {
+		result := Calculate(unitedStates, []Line{{BookID: 1, Class: ClassPrint, Amount: 4999}, {BookID: 2, Class: ClassEbook, Amount: 1000}})
+
+		assert.Equal(t, int64(444), result.Lines[0].Tax)
+		assert.Equal(t, int64(5443), result.Lines[0].Gross)
+		assert.Equal(t, int64(50), result.Lines[1].Tax)
+		assert.Equal(t, int64(5999), result.Net)
+		assert.Equal(t, int64(494), result.Tax)
+		assert.Equal(t, int64(6493), result.Gross)
+	})
+
+	t.Run("extract tax from the price given prices include tax", func(t *testing.T) {
+		result := Calculate(unitedKingdom, []Line{{BookID: 1, Class: ClassPrint, Amount: 1299}, {BookID: 2, Class: ClassAudiobook, Amount: 1000}})
+
+		assert.Equal(t, int64(0), result.Lines[0].Tax)
+		assert.Equal(t, int64(167), result.Lines[1].Tax)
+		assert.Equal(t, int64(833), result.Lines[1].Net)
+		assert.Equal(t, int64(2299), result.Gross)
+		assert.True(t, result.PricesIncludeTax)
+	})
+
+	t.Run("round halves to even given tax of exactly half a cent", func(t *testing.T) {
+		result := Calculate(unitedStates, []Line{{Class: ClassEbook, Amount: 10}, {Class: ClassEbook, Amount: 30}})
+
+		assert.Equal(t, int64(0), result.Lines[0].Tax)
+		assert.Equal(t, int64(2), result.Lines[1].Tax)
+	})
+
+	t.Run("tax as print given line without class", func(t *testing.T) {
+		result := Calculate(unitedStates, []Line{{Amount: 1000}})
+
+		assert.Equal(t, ClassPrint, result.Lines[0].Class)
+		assert.Equal(t, 8.875, result.Lines[0].Rate)
+	})
+
+	t.Run("do not tax given class without rate", func(t *testing.T) {
+		result := Calculate(unitedStates, []Line{{Class: ClassAudiobook, Amount: 1000}})
+
+		assert.Equal(t, int64(0), result.Tax)
+		assert.Equal(t, int64(1000), result.Gross)
+	})
+}
@@ -0,0 +1,231 @@
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/i18n"
+	"github.com/phetployst/book-store-api/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Tax classes follow the book formats, which many countries tax at
+// different rates.
+const (
+	ClassPrint     = "print"
+	ClassEbook     = "ebook"
+	ClassAudiobook = "audiobook"
+)
+
+var ErrUnknownJurisdiction = errors.New("unknown tax jurisdiction")
+
+// Jurisdiction is a country or region with its own rate table, identified by
+// a code such as "GB" or "US-NY".
+type Jurisdiction struct {
+	ID               uint      `json:"id" gorm:"primaryKey"`
+	Code             string    `json:"code" gorm:"not null;uniqueIndex:idx_tax_jurisdictions_tenant_code" validate:"required,max=10,uppercase"`
+	Name             string    `json:"name" validate:"required"`
+	PricesIncludeTax bool      `json:"prices_include_tax"`
+	Rates            []Rate    `json:"rates" gorm:"constraint:OnDelete:CASCADE" validate:"dive"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	TenantID         string    `json:"-" gorm:"not null;default:'default';uniqueIndex:idx_tax_jurisdictions_tenant_code"`
+}
+
+func (Jurisdiction) TableName() string {
+	return "tax_jurisdictions"
+}
+
+// Rate is the percentage charged on a tax class within a jurisdiction.
+type Rate struct {
+	ID             uint    `json:"-" gorm:"primaryKey"`
+	JurisdictionID uint    `json:"-" gorm:"not null;uniqueIndex:idx_tax_rates_class"`
+	Class          string  `json:"tax_class" gorm:"not null;uniqueIndex:idx_tax_rates_class" validate:"oneof=print ebook audiobook"`
+	Rate           float64 `json:"rate" validate:"gte=0,lte=100"`
+	TenantID       string  `json:"-" gorm:"not null;default:'default';index"`
+}
+
+func (Rate) TableName() string {
+	return "tax_rates"
+}
+
+// ClassOf returns the tax class of a book format. Books without a format are
+// print books.
+func ClassOf(format string) string {
+	if format == "" {
+		return ClassPrint
+	}
+	return format
+}
+
+func (jurisdiction Jurisdiction) rate(class string) float64 {
+	for _, rate := range jurisdiction.Rates {
+		if rate.Class == class {
+			return rate.Rate
+		}
+	}
+	return 0
+}
+
+func (jurisdiction Jurisdiction) validateRates() error {
+	seen := map[string]bool{}
+	for _, rate := range jurisdiction.Rates {
+		if seen[rate.Class] {
+			return fmt.Errorf("tax class %s has more than one rate", rate.Class)
+		}
+		seen[rate.Class] = true
+	}
+	return nil
+}
+
+// Lookup loads a jurisdiction and its rates by code.
+func Lookup(db *gorm.DB, code string) (Jurisdiction, error) {
+	jurisdiction := Jurisdiction{}
+	err := db.Preload("Rates").Where("code = ?", normalizeCode(code)).First(&jurisdiction).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Jurisdiction{}, ErrUnknownJurisdiction
+	}
+	return jurisdiction, err
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+func (c *CustomValidator) Validate(i interface{}) error {
+	if err := c.validator.Struct(i); err != nil {
+		return err
+	}
+	if jurisdiction, ok := i.(Jurisdiction); ok {
+		return jurisdiction.validateRates()
+	}
+	return nil
+}
+
+type handler struct {
+	db *gorm.DB
+}
+
+func NewHandler(db *gorm.DB) *handler {
+	return &handler{db: db}
+}
+
+func (handler *handler) Create(c echo.Context) error {
+	jurisdiction := Jurisdiction{}
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&jurisdiction); err != nil {
+		logger.Error("failed to bind tax jurisdiction", zap.Error(err))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	jurisdiction.ID = 0
+	jurisdiction.Code = normalizeCode(jurisdiction.Code)
+	if err := c.Validate(jurisdiction); err != nil {
+		logger.Error("failed to validate tax jurisdiction", zap.Error(err))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	_, err := Lookup(handler.db.WithContext(c.Request().Context()), jurisdiction.Code)
+	if err == nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Tax jurisdiction already exists"})
+	}
+	if !errors.Is(err, ErrUnknownJurisdiction) {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	if result := handler.db.WithContext(c.Request().Context()).Create(&jurisdiction); result.Error != nil {
+		logger.Error("failed to insert tax jurisdiction", zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+
+	logger.Info("tax jurisdiction created", zap.String("code", jurisdiction.Code))
+	return c.JSON(http.StatusCreated, jurisdiction)
+}
+
+func (handler *handler) GetAll(c echo.Context) error {
+	jurisdictions := []Jurisdiction{}
+	if result := handler.db.WithContext(c.Request().Context()).Preload("Rates").Order("code").Find(&jurisdictions); result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+	return c.JSON(http.StatusOK, jurisdictions)
+}
+
+func (handler *handler) GetByCode(c echo.Context) error {
+	jurisdiction, err := Lookup(handler.db.WithContext(c.Request().Context()), c.Param("code"))
+	if err != nil {
+		if errors.Is(err, ErrUnknownJurisdiction) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Tax jurisdiction not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, jurisdiction)
+}
+
+// Update replaces a jurisdiction's name, pricing and whole rate table.
+func (handler *handler) Update(c echo.Context) error {
+	code := normalizeCode(c.Param("code"))
+
+	c.Echo().Validator = &CustomValidator{validator: i18n.MustValidator()}
+	logger := middleware.GetLogger(c)
+
+	jurisdiction, err := Lookup(handler.db.WithContext(c.Request().Context()), code)
+	if err != nil {
+		if errors.Is(err, ErrUnknownJurisdiction) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Tax jurisdiction not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	jurisdictionID := jurisdiction.ID
+	jurisdiction.Rates = nil
+	if err := c.Bind(&jurisdiction); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	jurisdiction.ID, jurisdiction.Code = jurisdictionID, code
+	if err := c.Validate(jurisdiction); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": i18n.Message(err, middleware.GetLocales(c))})
+	}
+
+	err = handler.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("jurisdiction_id = ?", jurisdiction.ID).Delete(&Rate{}).Error; err != nil {
+			return err
+		}
+		for i := range jurisdiction.Rates {
+			jurisdiction.Rates[i].ID = 0
+			jurisdiction.Rates[i].JurisdictionID = jurisdiction.ID
+		}
+		return tx.Save(&jurisdiction).Error
+	})
+	if err != nil {
+		logger.Error("failed to update tax jurisdiction", zap.String("code", code), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update tax jurisdiction"})
+	}
+
+	logger.Info("tax jurisdiction updated", zap.String("code", code))
+	return c.JSON(http.StatusOK, jurisdiction)
+}
+
+func (handler *handler) Delete(c echo.Context) error {
+	result := handler.db.WithContext(c.Request().Context()).Where("code = ?", normalizeCode(c.Param("code"))).Delete(&Jurisdiction{})
+	if result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Tax jurisdiction not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Tax jurisdiction successfully deleted"})
+}
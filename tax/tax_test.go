@@ -0,0 +1,107 @@
+package tax
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	getJurisdictionQuery    = `SELECT * FROM "tax_jurisdictions" WHERE code = $1 ORDER BY "tax_jurisdictions"."id" LIMIT $2`
+	createJurisdictionQuery = `INSERT INTO "tax_jurisdictions" ("code","name","prices_include_tax","created_at","updated_at","tenant_id") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`
+	createRatesQuery        = `INSERT INTO "tax_rates" ("jurisdiction_id","class","rate","tenant_id") VALUES ($1,$2,$3,$4),($5,$6,$7,$8) ON CONFLICT ("id") DO UPDATE SET "jurisdiction_id"="excluded"."jurisdiction_id" RETURNING "id"`
+)
+
+func newJurisdictionContext(e *echo.Echo, body string) (echo.Context, *httptest.ResponseRecorder) {
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	response := httptest.NewRecorder()
+	return e.NewContext(request, response), response
+}
+
+func TestCreateJurisdiction(t *testing.T) {
+	body := `{"code": "gb", "name": "United Kingdom", "prices_include_tax": true, "rates": [{"tax_class": "print", "rate": 0}, {"tax_class": "audiobook", "rate": 20}]}`
+
+	t.Run("create jurisdiction given valid rate table", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newJurisdictionContext(e, body)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getJurisdictionQuery).WithArgs("GB", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectBegin()
+		mock.ExpectQuery(createJurisdictionQuery).
+			WithArgs("GB", "United Kingdom", true, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(createRatesQuery).
+			WithArgs(1, ClassPrint, 0.0, "default", 1, ClassAudiobook, 20.0, "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB)
+		err := handler.Create(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given existing code", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newJurisdictionContext(e, body)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getJurisdictionQuery).WithArgs("GB", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "code"}).AddRow(1, "GB"))
+		mock.ExpectQuery(`SELECT * FROM "tax_rates" WHERE "tax_rates"."jurisdiction_id" = $1`).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		handler := NewHandler(gormDB)
+		err := handler.Create(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return bad request given unknown tax class", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newJurisdictionContext(e, `{"code": "TH", "name": "Thailand", "rates": [{"tax_class": "magazine", "rate": 7}]}`)
+
+		handler := NewHandler(nil)
+		err := handler.Create(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("return bad request given two rates for one class", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newJurisdictionContext(e, `{"code": "TH", "name": "Thailand", "rates": [{"tax_class": "print", "rate": 0}, {"tax_class": "print", "rate": 7}]}`)
+
+		handler := NewHandler(nil)
+		err := handler.Create(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
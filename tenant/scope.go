@@ -0,0 +1,104 @@
+package tenant
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const fieldName = "TenantID"
+
+// Plugin isolates the rows of models with a TenantID field. Queries,
+// updates and deletes of those models only see the rows of the tenant in the
+// statement's context, and the tenant is stamped on the rows that are
+// created, saved or deleted. A
+// statement without a tenant fails with ErrMissing unless its context is
+// Unscoped, so a query that forgets its context cannot read every tenant.
+//
+// Only the model of the statement is scoped. Joined tables and raw SQL have
+// to filter by tenant_id themselves.
+type Plugin struct{}
+
+func (Plugin) Name() string {
+	return "tenant"
+}
+
+func (Plugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("tenant:create", stamp); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register("tenant:query", scope); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("tenant:update", func(db *gorm.DB) {
+		stamp(db)
+		scope(db)
+	}); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("tenant:delete", func(db *gorm.DB) {
+		stamp(db)
+		scope(db)
+	}); err != nil {
+		return err
+	}
+	return callbacks.Row().Before("gorm:row").Register("tenant:row", scope)
+}
+
+// tenantOf returns the tenant field of the statement's model and the tenant
+// to scope it to. ok is false when the statement is not scoped.
+func tenantOf(db *gorm.DB) (field *schema.Field, tenantID string, ok bool) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return nil, "", false
+	}
+	field = db.Statement.Schema.LookUpField(fieldName)
+	if field == nil || isUnscoped(db.Statement.Context) {
+		return nil, "", false
+	}
+	tenantID, found := FromContext(db.Statement.Context)
+	if !found {
+		db.AddError(ErrMissing)
+		return nil, "", false
+	}
+	return field, tenantID, true
+}
+
+func scope(db *gorm.DB) {
+	field, tenantID, ok := tenantOf(db)
+	if !ok {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: tenantID},
+	}})
+}
+
+func stamp(db *gorm.DB) {
+	field, tenantID, ok := tenantOf(db)
+	if !ok {
+		return
+	}
+	set := func(row reflect.Value) {
+		current, zero := field.ValueOf(db.Statement.Context, row)
+		if !zero && current != tenantID {
+			db.AddError(ErrMismatch)
+			return
+		}
+		db.AddError(field.Set(db.Statement.Context, row, tenantID))
+	}
+
+	switch db.Statement.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
+			row := reflect.Indirect(db.Statement.ReflectValue.Index(i))
+			if row.Kind() == reflect.Struct {
+				set(row)
+			}
+		}
+	case reflect.Struct:
+		set(db.Statement.ReflectValue)
+	}
+}
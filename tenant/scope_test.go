@@ -0,0 +1,143 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type shelf struct {
+	ID       uint
+	Name     string
+	TenantID string `gorm:"not null;default:'default'"`
+}
+
+type note struct {
+	ID   uint
+	Text string
+}
+
+const (
+	findShelvesQuery    = `SELECT * FROM "shelves" WHERE name = $1 AND "shelves"."tenant_id" = $2`
+	createShelfQuery    = `INSERT INTO "shelves" ("name","tenant_id") VALUES ($1,$2) RETURNING "id"`
+	updateShelfQuery    = `UPDATE "shelves" SET "name"=$1 WHERE "shelves"."tenant_id" = $2 AND "id" = $3`
+	deleteShelfQuery    = `DELETE FROM "shelves" WHERE "shelves"."id" = $1 AND "shelves"."tenant_id" = $2`
+	findAllNotesQuery   = `SELECT * FROM "notes"`
+	findAllShelvesQuery = `SELECT * FROM "shelves"`
+)
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock, func()) {
+	db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{SkipDefaultTransaction: true})
+	assert.NoError(t, gormDB.Use(Plugin{}))
+	return gormDB, mock, func() { db.Close() }
+}
+
+func TestPlugin(t *testing.T) {
+	ctx := NewContext(context.Background(), "th")
+
+	t.Run("scope query to the tenant given tenant in context", func(t *testing.T) {
+		gormDB, mock, closeDB := newMockDB(t)
+		defer closeDB()
+
+		mock.ExpectQuery(findShelvesQuery).WithArgs("fiction", "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "tenant_id"}).AddRow(1, "fiction", "th"))
+
+		shelves := []shelf{}
+		err := gormDB.WithContext(ctx).Where("name = ?", "fiction").Find(&shelves).Error
+
+		assert.NoError(t, err)
+		assert.Len(t, shelves, 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("stamp the tenant given create", func(t *testing.T) {
+		gormDB, mock, closeDB := newMockDB(t)
+		defer closeDB()
+
+		mock.ExpectQuery(createShelfQuery).WithArgs("fiction", "th").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+		created := shelf{Name: "fiction"}
+		err := gormDB.WithContext(ctx).Create(&created).Error
+
+		assert.NoError(t, err)
+		assert.Equal(t, "th", created.TenantID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return error given row of another tenant", func(t *testing.T) {
+		gormDB, mock, closeDB := newMockDB(t)
+		defer closeDB()
+
+		err := gormDB.WithContext(ctx).Create(&shelf{Name: "fiction", TenantID: "uk"}).Error
+
+		assert.ErrorIs(t, err, ErrMismatch)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("scope update and delete to the tenant given tenant in context", func(t *testing.T) {
+		gormDB, mock, closeDB := newMockDB(t)
+		defer closeDB()
+
+		mock.ExpectExec(updateShelfQuery).WithArgs("poetry", "th", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(deleteShelfQuery).WithArgs(1, "th").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		updated := gormDB.WithContext(ctx).Model(&shelf{ID: 1}).Update("name", "poetry").Error
+		deleted := gormDB.WithContext(ctx).Delete(&shelf{}, 1).Error
+
+		assert.NoError(t, updated)
+		assert.NoError(t, deleted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return error given no tenant in context", func(t *testing.T) {
+		gormDB, mock, closeDB := newMockDB(t)
+		defer closeDB()
+
+		err := gormDB.Find(&[]shelf{}).Error
+
+		assert.ErrorIs(t, err, ErrMissing)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("read every tenant given unscoped context", func(t *testing.T) {
+		gormDB, mock, closeDB := newMockDB(t)
+		defer closeDB()
+
+		mock.ExpectQuery(findAllShelvesQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "tenant_id"}))
+
+		err := gormDB.WithContext(Unscoped(context.Background())).Find(&[]shelf{}).Error
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("scope query to the tenant given tenant set inside unscoped context", func(t *testing.T) {
+		gormDB, mock, closeDB := newMockDB(t)
+		defer closeDB()
+
+		mock.ExpectQuery(findShelvesQuery).WithArgs("fiction", "th").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "tenant_id"}))
+
+		err := gormDB.WithContext(NewContext(Unscoped(context.Background()), "th")).Where("name = ?", "fiction").Find(&[]shelf{}).Error
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("leave model without tenant as it is given no tenant in context", func(t *testing.T) {
+		gormDB, mock, closeDB := newMockDB(t)
+		defer closeDB()
+
+		mock.ExpectQuery(findAllNotesQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "text"}))
+
+		err := gormDB.Find(&[]note{}).Error
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
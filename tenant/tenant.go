@@ -0,0 +1,48 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+)
+
+// Default is the tenant of requests no storefront claims, and of the rows
+// that were created before there were tenants.
+const Default = "default"
+
+// ErrMissing is returned for queries on tenant rows whose context has
+// neither a tenant nor is unscoped.
+var ErrMissing = errors.New("tenant is required")
+
+// ErrMismatch is returned for creating a row that already belongs to
+// another tenant.
+var ErrMismatch = errors.New("row belongs to another tenant")
+
+type contextKey int
+
+const (
+	tenantKey contextKey = iota
+	unscopedKey
+)
+
+// NewContext returns a copy of ctx that queries the rows of tenantID only,
+// even when ctx is Unscoped, so a job can work for one tenant at a time.
+func NewContext(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(context.WithValue(ctx, unscopedKey, false), tenantKey, tenantID)
+}
+
+// FromContext returns the tenant of ctx.
+func FromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantKey).(string)
+	return tenantID, ok && tenantID != ""
+}
+
+// Unscoped returns a copy of ctx that queries the rows of every tenant. It
+// is meant for jobs and webhooks that work for the whole deployment.
+func Unscoped(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unscopedKey, true)
+}
+
+func isUnscoped(ctx context.Context) bool {
+	unscoped, _ := ctx.Value(unscopedKey).(bool)
+	return unscoped
+}
@@ -0,0 +1,63 @@
+package timeline
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/payment"
+	"gorm.io/gorm"
+)
+
+// Entry is one step in the history of an order, such as a return being
+// requested or refunded. Orders are identified by their reference.
+type Entry struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	OrderRef  string    `json:"order_ref" gorm:"not null;index"`
+	Type      string    `json:"type" gorm:"not null"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+	TenantID  string    `json:"-" gorm:"not null;default:'default';index"`
+}
+
+func (Entry) TableName() string {
+	return "order_timeline"
+}
+
+// Record adds an entry to an order's timeline. Pass the transaction that
+// makes the change so the entry is only kept if the change is.
+func Record(db *gorm.DB, orderRef string, entryType string, message string) error {
+	return db.Create(&Entry{OrderRef: orderRef, Type: entryType, Message: message}).Error
+}
+
+type handler struct {
+	db *gorm.DB
+}
+
+func NewHandler(db *gorm.DB) *handler {
+	return &handler{db: db}
+}
+
+// GetByOrder returns the timeline of an order. Customers can only see that
+// of their own orders.
+func (handler *handler) GetByOrder(c echo.Context) error {
+	entries := []Entry{}
+	db := handler.db.WithContext(c.Request().Context())
+
+	if middleware.GetStaffID(c) == "" {
+		_, err := payment.FindOrder(db, c.Param("id"), middleware.GetCustomerID(c))
+		if errors.Is(err, payment.ErrPaymentNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+
+	if result := db.Where("order_ref = ?", c.Param("id")).Order("created_at").Order("id").Find(&entries); result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+	return c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,183 @@
+package wishlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/notification"
+	"github.com/phetployst/book-store-api/tenant"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Item is a book a customer saved for later.
+type Item struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CustomerID string    `json:"customer_id" gorm:"not null;uniqueIndex:idx_wishlist_items_customer_book"`
+	BookID     uint      `json:"book_id" gorm:"not null;uniqueIndex:idx_wishlist_items_customer_book"`
+	CreatedAt  time.Time `json:"created_at"`
+	TenantID   string    `json:"-" gorm:"not null;default:'default';index"`
+}
+
+func (Item) TableName() string {
+	return "wishlist_items"
+}
+
+// StockSubscription asks to notify a customer once when a sold out book is
+// back in stock. It is removed after the notification has been sent.
+type StockSubscription struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CustomerID string    `json:"customer_id" gorm:"not null;uniqueIndex:idx_stock_subscriptions_customer_book"`
+	BookID     uint      `json:"book_id" gorm:"not null;uniqueIndex:idx_stock_subscriptions_customer_book;index"`
+	CreatedAt  time.Time `json:"created_at"`
+	TenantID   string    `json:"-" gorm:"not null;default:'default';index"`
+}
+
+type handler struct {
+	db       *gorm.DB
+	notifier notification.Notifier
+	logger   *zap.Logger
+}
+
+func NewHandler(db *gorm.DB, notifier notification.Notifier, logger *zap.Logger) *handler {
+	return &handler{db: db, notifier: notifier, logger: logger}
+}
+
+func (handler *handler) GetAll(c echo.Context) error {
+	items := []Item{}
+	if err := handler.db.WithContext(c.Request().Context()).Where("customer_id = ?", middleware.GetCustomerID(c)).Order("created_at DESC").Find(&items).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+func (handler *handler) Create(c echo.Context) error {
+	item := Item{}
+	logger := middleware.GetLogger(c)
+
+	if err := c.Bind(&item); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if item.BookID == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "book_id is required"})
+	}
+
+	found := book.Book{}
+	if err := handler.db.WithContext(c.Request().Context()).First(&found, item.BookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	item.ID = 0
+	item.CustomerID = middleware.GetCustomerID(c)
+	result := handler.db.WithContext(c.Request().Context()).Where(Item{CustomerID: item.CustomerID, BookID: item.BookID}).FirstOrCreate(&item)
+	if result.Error != nil {
+		logger.Error("failed to add wishlist item", zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusOK, item)
+	}
+	return c.JSON(http.StatusCreated, item)
+}
+
+func (handler *handler) Delete(c echo.Context) error {
+	result := handler.db.WithContext(c.Request().Context()).Where("customer_id = ? AND book_id = ?", middleware.GetCustomerID(c), c.Param("book_id")).Delete(&Item{})
+	if result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book is not in the wishlist"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Book successfully removed from the wishlist"})
+}
+
+func (handler *handler) Subscribe(c echo.Context) error {
+	logger := middleware.GetLogger(c)
+
+	found := book.Book{}
+	if err := handler.db.WithContext(c.Request().Context()).First(&found, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if found.Stock > 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Book is in stock"})
+	}
+
+	subscription := StockSubscription{CustomerID: middleware.GetCustomerID(c), BookID: found.ID}
+	result := handler.db.WithContext(c.Request().Context()).Where(StockSubscription{CustomerID: subscription.CustomerID, BookID: subscription.BookID}).FirstOrCreate(&subscription)
+	if result.Error != nil {
+		logger.Error("failed to subscribe to stock", zap.Error(result.Error))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusOK, subscription)
+	}
+	return c.JSON(http.StatusCreated, subscription)
+}
+
+func (handler *handler) Unsubscribe(c echo.Context) error {
+	result := handler.db.WithContext(c.Request().Context()).Where("customer_id = ? AND book_id = ?", middleware.GetCustomerID(c), c.Param("id")).Delete(&StockSubscription{})
+	if result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Subscription not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Subscription successfully deleted"})
+}
+
+// BookRestocked is the book handler's restock hook. Subscribers are notified
+// in the background so that the stock update is not held up.
+func (handler *handler) BookRestocked(restocked book.Book) {
+	go func() {
+		ctx := tenant.NewContext(context.Background(), restocked.TenantID)
+		if err := handler.notifyRestocked(ctx, restocked); err != nil {
+			handler.logger.Error("failed to notify stock subscribers", zap.Uint("book_id", restocked.ID), zap.Error(err))
+		}
+	}()
+}
+
+// notifyRestocked notifies every subscriber of the book and removes their
+// subscriptions. Subscriptions whose notification failed are kept and tried
+// again on the next restock.
+func (handler *handler) notifyRestocked(ctx context.Context, restocked book.Book) error {
+	subscriptions := []StockSubscription{}
+	if err := handler.db.WithContext(ctx).Where("book_id = ?", restocked.ID).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+
+	var failed error
+	for _, subscription := range subscriptions {
+		err := handler.notifier.Notify(ctx, notification.Notification{
+			Type:       notification.TypeBackInStock,
+			CustomerID: subscription.CustomerID,
+			Subject:    fmt.Sprintf("%s is back in stock", restocked.Title),
+			Data: map[string]any{
+				"book_id": restocked.ID,
+				"title":   restocked.Title,
+				"isbn":    restocked.ISBN,
+				"stock":   restocked.Stock,
+			},
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			failed = errors.Join(failed, err)
+			continue
+		}
+		if err := handler.db.WithContext(ctx).Delete(&subscription).Error; err != nil {
+			failed = errors.Join(failed, err)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,219 @@
+package wishlist
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/phetployst/book-store-api/book"
+	"github.com/phetployst/book-store-api/middleware"
+	"github.com/phetployst/book-store-api/notification"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	getBookByIdQuery            = `SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT $2`
+	getWishlistItemQuery        = `SELECT * FROM "wishlist_items" WHERE "wishlist_items"."customer_id" = $1 AND "wishlist_items"."book_id" = $2 ORDER BY "wishlist_items"."id" LIMIT $3`
+	createWishlistItemQuery     = `INSERT INTO "wishlist_items" ("customer_id","book_id","created_at","tenant_id") VALUES ($1,$2,$3,$4) RETURNING "id"`
+	deleteWishlistItemQuery     = `DELETE FROM "wishlist_items" WHERE customer_id = $1 AND book_id = $2`
+	getSubscriptionQuery        = `SELECT * FROM "stock_subscriptions" WHERE "stock_subscriptions"."customer_id" = $1 AND "stock_subscriptions"."book_id" = $2 ORDER BY "stock_subscriptions"."id" LIMIT $3`
+	createSubscriptionQuery     = `INSERT INTO "stock_subscriptions" ("customer_id","book_id","created_at","tenant_id") VALUES ($1,$2,$3,$4) RETURNING "id"`
+	getBookSubscriptionsQuery   = `SELECT * FROM "stock_subscriptions" WHERE book_id = $1`
+	deleteSubscriptionByIdQuery = `DELETE FROM "stock_subscriptions" WHERE "stock_subscriptions"."id" = $1`
+)
+
+type recordingNotifier struct {
+	notifications []notification.Notification
+	err           error
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, notification notification.Notification) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.notifications = append(n.notifications, notification)
+	return nil
+}
+
+func newWishlistContext(e *echo.Echo, method string, body string) (echo.Context, *httptest.ResponseRecorder) {
+	request := httptest.NewRequest(method, "/", strings.NewReader(body))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set("X-Customer-ID", "customer-1")
+	response := httptest.NewRecorder()
+	return e.NewContext(request, response), response
+}
+
+func TestCreateWishlistItem(t *testing.T) {
+	t.Run("add book given book exists", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newWishlistContext(e, http.MethodPost, `{"book_id": 1}`)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(getWishlistItemQuery).WithArgs("customer-1", 1, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectBegin()
+		mock.ExpectQuery(createWishlistItemQuery).WithArgs("customer-1", 1, sqlmock.AnyArg(), "default").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, &recordingNotifier{}, zap.NewNop())
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return not found given unknown book", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newWishlistContext(e, http.MethodPost, `{"book_id": 38}`)
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs(38, 1).WillReturnError(gorm.ErrRecordNotFound)
+
+		handler := NewHandler(gormDB, &recordingNotifier{}, zap.NewNop())
+		err := middleware.RequireCustomer(handler.Create)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
+
+func TestDeleteWishlistItem(t *testing.T) {
+	t.Run("return not found given book is not in the wishlist", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newWishlistContext(e, http.MethodDelete, "")
+		c.SetParamNames("book_id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteWishlistItemQuery).WithArgs("customer-1", "1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, &recordingNotifier{}, zap.NewNop())
+		err := middleware.RequireCustomer(handler.Delete)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Run("subscribe given book is sold out", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newWishlistContext(e, http.MethodPost, "")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("1", 1).WillReturnRows(sqlmock.NewRows([]string{"id", "stock"}).AddRow(1, 0))
+		mock.ExpectQuery(getSubscriptionQuery).WithArgs("customer-1", 1, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectBegin()
+		mock.ExpectQuery(createSubscriptionQuery).WithArgs("customer-1", 1, sqlmock.AnyArg(), "default").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		mock.ExpectCommit()
+
+		handler := NewHandler(gormDB, &recordingNotifier{}, zap.NewNop())
+		err := middleware.RequireCustomer(handler.Subscribe)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("return conflict given book is in stock", func(t *testing.T) {
+		e := echo.New()
+		defer e.Close()
+		c, response := newWishlistContext(e, http.MethodPost, "")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookByIdQuery).WithArgs("1", 1).WillReturnRows(sqlmock.NewRows([]string{"id", "stock"}).AddRow(1, 3))
+
+		handler := NewHandler(gormDB, &recordingNotifier{}, zap.NewNop())
+		err := middleware.RequireCustomer(handler.Subscribe)(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, response.Code)
+	})
+}
+
+func TestNotifyRestocked(t *testing.T) {
+	restocked := book.Book{Title: "Atomic Habits", ISBN: "9781847941831", Stock: 5}
+	restocked.ID = 1
+
+	t.Run("notify subscribers and remove their subscriptions", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookSubscriptionsQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "book_id"}).AddRow(2, "customer-1", 1).AddRow(3, "customer-2", 1))
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteSubscriptionByIdQuery).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(deleteSubscriptionByIdQuery).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		notifier := &recordingNotifier{}
+		handler := NewHandler(gormDB, notifier, zap.NewNop())
+		err := handler.notifyRestocked(context.Background(), restocked)
+
+		assert.NoError(t, err)
+		assert.Len(t, notifier.notifications, 2)
+		assert.Equal(t, notification.TypeBackInStock, notifier.notifications[0].Type)
+		assert.Equal(t, "customer-2", notifier.notifications[1].CustomerID)
+		assert.Equal(t, "Atomic Habits is back in stock", notifier.notifications[0].Subject)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("keep subscriptions given notifier fails", func(t *testing.T) {
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		defer db.Close()
+
+		gormDB, _ := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+
+		mock.ExpectQuery(getBookSubscriptionsQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "book_id"}).AddRow(2, "customer-1", 1))
+
+		handler := NewHandler(gormDB, &recordingNotifier{err: errors.New("smtp down")}, zap.NewNop())
+		err := handler.notifyRestocked(context.Background(), restocked)
+
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}